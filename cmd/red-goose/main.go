@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/cli"
+)
+
+// Set by the Makefile through -ldflags
+var (
+	version   = "dev"
+	buildTime = "unknown"
+)
+
+func main() {
+	cli.SetVersionInfo(version, buildTime)
+	os.Exit(cli.ExitCode(cli.Execute()))
+}
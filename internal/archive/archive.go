@@ -0,0 +1,88 @@
+// Package archive records the videos that have been downloaded, so that
+// later runs can skip them. The file format is the one yt-dlp uses for
+// --download-archive: one "youtube <video ID>" line per video.
+package archive
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// extractor is the first field of every line
+const extractor = "youtube"
+
+// Archive is a set of downloaded video IDs backed by a file
+type Archive struct {
+	path string
+	mu   sync.Mutex
+	ids  map[string]bool
+}
+
+// Open loads the archive at path. A missing file is an empty archive.
+func Open(path string) (*Archive, error) {
+	a := &Archive{path: path, ids: make(map[string]bool)}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return nil, errors.NewFileSystemError(fmt.Sprintf("failed to open download archive %s", path), err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == extractor {
+			a.ids[fields[1]] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.NewFileSystemError(fmt.Sprintf("failed to read download archive %s", path), err)
+	}
+	return a, nil
+}
+
+// Has reports whether a video is in the archive
+func (a *Archive) Has(videoID string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.ids[videoID]
+}
+
+// Len returns the number of videos in the archive
+func (a *Archive) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.ids)
+}
+
+// Add records a video, appending it to the file unless it is already there
+func (a *Archive) Add(videoID string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.ids[videoID] {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return errors.NewFileSystemError("failed to create download archive directory", err)
+	}
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to open download archive %s", a.path), err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s\n", extractor, videoID); err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write download archive %s", a.path), err)
+	}
+	a.ids[videoID] = true
+	return nil
+}
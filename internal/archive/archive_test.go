@@ -0,0 +1,49 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestArchive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "archive.txt")
+
+	a, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open missing file: %v", err)
+	}
+	if a.Len() != 0 {
+		t.Errorf("new archive has %d videos", a.Len())
+	}
+
+	for _, id := range []string{"abc", "def", "abc"} {
+		if err := a.Add(id); err != nil {
+			t.Fatalf("Add(%s): %v", id, err)
+		}
+	}
+	if !a.Has("abc") || a.Has("xyz") {
+		t.Errorf("Has reports the wrong videos")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "youtube abc\nyoutube def\n"; got != want {
+		t.Errorf("archive file = %q, want %q", got, want)
+	}
+
+	// Lines from other extractors and junk are ignored
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("vimeo 123\n\ngarbage\nyoutube ghi\n")
+	f.Close()
+
+	a, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Len() != 3 || !a.Has("ghi") || a.Has("123") {
+		t.Errorf("reopened archive has %d videos", a.Len())
+	}
+}
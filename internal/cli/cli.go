@@ -2,14 +2,35 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
+	stderrors "errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"github.com/MaVeN-13TTN/red_goose/internal/archive"
 	"github.com/MaVeN-13TTN/red_goose/internal/config"
 	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
 	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/feed"
+	"github.com/MaVeN-13TTN/red_goose/internal/hooks"
+	"github.com/MaVeN-13TTN/red_goose/internal/logging"
+	"github.com/MaVeN-13TTN/red_goose/internal/mirror"
+	"github.com/MaVeN-13TTN/red_goose/internal/pipeline"
+	"github.com/MaVeN-13TTN/red_goose/internal/playlistfile"
+	"github.com/MaVeN-13TTN/red_goose/internal/progress"
+	"github.com/MaVeN-13TTN/red_goose/internal/queue"
+	"github.com/MaVeN-13TTN/red_goose/internal/retry"
+	"github.com/MaVeN-13TTN/red_goose/internal/server"
+	"github.com/MaVeN-13TTN/red_goose/internal/sponsorblock"
+	"github.com/MaVeN-13TTN/red_goose/internal/subscriptions"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
 	"github.com/MaVeN-13TTN/red_goose/pkg/youtube"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -25,6 +46,94 @@ var (
 	maxWorkers int
 	skipErrors bool
 
+	// Live recording flags
+	fromStart       bool
+	recordDuration  time.Duration
+	waitForLive     bool
+	waitInterval    time.Duration
+	waitMaxInterval time.Duration
+
+	// Subtitle flags
+	writeSubs     bool
+	writeAutoSubs bool
+	subLangs      []string
+	subFormat     string
+
+	// Thumbnail flags
+	writeThumbnail     bool
+	writeAllThumbnails bool
+	convertThumbnails  string
+
+	// Sidecar flags
+	writeInfoJSON    bool
+	writeDescription bool
+	writeNFO         bool
+	nfoSeason        int
+
+	// Embedding flags
+	embedMetadata bool
+	embedChapters bool
+	embedSubs     bool
+
+	splitChapters    bool
+	downloadSections []string
+
+	// SponsorBlock flags
+	sponsorBlockMark   []string
+	sponsorBlockRemove []string
+	sponsorBlockAPI    string
+
+	// Exec hook flags
+	execCommands  []string
+	execTimeout   time.Duration
+	execOnFailure string
+
+	infoJSON bool
+
+	// Queue flags
+	queueState string
+	clearAll   bool
+
+	// Serve flags
+	listenAddr  string
+	serveOutput string
+
+	downloadArchive string
+
+	// Playlist mirror flags
+	playlistSync  bool
+	removedPolicy string
+	playlistFiles []string
+
+	// Subscription flags
+	subQuality   string
+	subAudioOnly bool
+	subOutput    string
+	subFilter    subscriptions.Filter
+	syncDryRun   bool
+	syncFull     bool
+
+	// Report flags
+	reportFile string
+
+	// Logging flags
+	logLevel  string
+	logFormat string
+	logFile   string
+	// logCloser closes the --log-file once the command is done; it is nil
+	// until setupLogging runs
+	logCloser io.Closer
+
+	// Progress flags
+	progressFormat string
+	// stdout receives the messages of the commands. JSON progress events
+	// take over the real stdout and move them to stderr.
+	stdout io.Writer = os.Stdout
+	// events is set when --progress-format json is given
+	events *progress.JSON
+	// display shows the bars of the downloads run by runQueue
+	display *progress.Display
+
 	// Version information
 	version   string = "dev"
 	buildTime string = "unknown"
@@ -36,15 +145,62 @@ var rootCmd = &cobra.Command{
 	Long: `Red-Goose is a command-line tool built in Go for downloading 
 YouTube videos and playlists efficiently and reliably.`,
 	Args: cobra.MinimumNArgs(1),
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		initConfig(cmd)
+		if err := setupLogging(cmd); err != nil {
+			return err
+		}
+		return setupProgress()
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		return downloadVideo(args[0])
 	},
 }
 
+// Execute runs the command line. ExitCode turns its error into the exit
+// code of the process.
 func Execute() error {
+	defer func() {
+		if logCloser != nil {
+			logCloser.Close()
+		}
+	}()
 	return rootCmd.Execute()
 }
 
+// setupLogging makes the logger of the --log-* flags the default one.
+// --verbose turns on debug records unless --log-level is given.
+func setupLogging(cmd *cobra.Command) error {
+	level := logLevel
+	if verbose && !cmd.Flags().Changed("log-level") {
+		level = "debug"
+	}
+	logger, closer, err := logging.New(logging.Options{
+		Level:  level,
+		Format: logFormat,
+		File:   logFile,
+	}, os.Stderr)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	logCloser = closer
+	return nil
+}
+
+// setupProgress prepares the --progress-format output. JSON events take
+// over stdout, so every other message goes to stderr instead.
+func setupProgress() error {
+	if err := progress.ValidateFormat(progressFormat); err != nil {
+		return err
+	}
+	if progressFormat == progress.FormatJSON {
+		events = progress.NewJSON(os.Stdout)
+		stdout = os.Stderr
+	}
+	return nil
+}
+
 var (
 	configCmd = &cobra.Command{
 		Use:   "config",
@@ -79,27 +235,161 @@ var (
 		Use:   "version",
 		Short: "Show version information",
 		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("Red-Goose version %s\n", version)
-			fmt.Printf("Build time: %s\n", buildTime)
+			fmt.Fprintf(stdout, "Red-Goose version %s\n", version)
+			fmt.Fprintf(stdout, "Build time: %s\n", buildTime)
 		},
 	}
 
 	playlistCmd = &cobra.Command{
 		Use:   "playlist [URL]",
 		Short: "Download entire YouTube playlist",
+		Long: `Download entire YouTube playlist through the download queue.
+
+While "red-goose serve" or another download uses the queue, this command
+stops with "queue in use by another red-goose process"; submit the URL to
+the daemon's API instead.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return downloadPlaylist(args[0], workerCount(cmd))
+		},
+	}
+
+	infoCmd = &cobra.Command{
+		Use:   "info [URL]",
+		Short: "Show video information without downloading",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return showInfo(args[0])
+		},
+	}
+
+	queueCmd = &cobra.Command{
+		Use:   "queue",
+		Short: "Manage queued playlist downloads",
+		Long: `Manage queued playlist downloads.
+
+Only one red-goose process uses the queue at a time. While "red-goose serve"
+or another download runs, "queue list" still shows the queue, but the other
+commands stop with "queue in use by another red-goose process"; use the
+daemon's API for them instead.`,
+	}
+
+	queueListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List queued downloads and their state",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listQueue()
+		},
+	}
+
+	queueResumeCmd = &cobra.Command{
+		Use:   "resume",
+		Short: "Continue pending downloads where the last run stopped",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return resumeQueue(workerCount(cmd))
+		},
+	}
+
+	queueRetryCmd = &cobra.Command{
+		Use:   "retry [ID...]",
+		Short: "Download failed videos again (all of them if no IDs are given)",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return retryQueue(args, workerCount(cmd))
+		},
+	}
+
+	queueClearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Remove finished downloads from the queue",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return clearQueue()
+		},
+	}
+
+	serveCmd = &cobra.Command{
+		Use:   "serve",
+		Short: "Run as a daemon that takes download jobs over a local REST API",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return serve(workerCount(cmd))
+		},
+	}
+
+	subscribeCmd = &cobra.Command{
+		Use:   "subscribe",
+		Short: "Manage channel and playlist subscriptions",
+	}
+
+	subscribeAddCmd = &cobra.Command{
+		Use:   "add [URL]",
+		Short: "Subscribe to a channel or playlist",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return addSubscription(args[0])
+		},
+	}
+
+	subscribeListCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List subscriptions",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return listSubscriptions()
+		},
+	}
+
+	subscribeRemoveCmd = &cobra.Command{
+		Use:   "remove [ID|URL]",
+		Short: "Unsubscribe from a channel or playlist",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return downloadPlaylist(args[0])
+			return removeSubscription(args[0])
+		},
+	}
+
+	syncCmd = &cobra.Command{
+		Use:   "sync [ID|URL...]",
+		Short: "Download new videos of subscriptions (all of them if none are given)",
+		Long: `Download new videos of subscriptions (all of them if none are given)
+through the download queue.
+
+While "red-goose serve" or another download uses the queue, this command
+stops with "queue in use by another red-goose process".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return syncSubscriptions(args, workerCount(cmd))
+		},
+	}
+
+	liveCmd = &cobra.Command{
+		Use:   "live [URL]",
+		Short: "Record a YouTube live stream",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return recordLive(args[0])
 		},
 	}
 )
 
 func init() {
-	cobra.OnInitialize(initConfig)
+	// Bad flags exit like other invalid input
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return errors.NewValidationError(err.Error(), nil)
+	})
 
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "",
 		"config file (default is $HOME/.red-goose.yaml)")
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "warn",
+		"log records at this level and above: debug, info, warn or error")
+	rootCmd.PersistentFlags().StringVar(&logFormat, "log-format", logging.FormatText,
+		"log record format: text or json")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "",
+		"append log records to this file instead of stderr")
+	rootCmd.PersistentFlags().StringVar(&progressFormat, "progress-format", progress.FormatBar,
+		"how to show progress: bar, or json for one event per line on stdout")
 
 	// Root command flags
 	rootCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads",
@@ -112,12 +402,38 @@ func init() {
 		"download entire playlist")
 	rootCmd.Flags().BoolVarP(&verbose, "verbose", "v", false,
 		"verbose output")
+	addSubtitleFlags(rootCmd)
+	addThumbnailFlags(rootCmd)
+	addSidecarFlags(rootCmd)
+	addEmbedFlags(rootCmd)
+	addSponsorBlockFlags(rootCmd)
+	addExecFlags(rootCmd)
+	rootCmd.Flags().StringArrayVar(&downloadSections, "download-sections", nil,
+		"download only a time range, e.g. \"*10:00-15:30\" (repeatable); only live and HLS-only streams are fetched by segment, other videos by byte range")
+	addArchiveFlag(rootCmd)
 
 	// Add subcommands
 	rootCmd.AddCommand(playlistCmd)
+	rootCmd.AddCommand(liveCmd)
+	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(queueCmd)
+	rootCmd.AddCommand(serveCmd)
+	rootCmd.AddCommand(subscribeCmd)
+	rootCmd.AddCommand(syncCmd)
 	rootCmd.AddCommand(configCmd)
 	rootCmd.AddCommand(versionCmd)
 
+	// Queue subcommands
+	queueCmd.AddCommand(queueListCmd)
+	queueCmd.AddCommand(queueResumeCmd)
+	queueCmd.AddCommand(queueRetryCmd)
+	queueCmd.AddCommand(queueClearCmd)
+
+	// Subscribe subcommands
+	subscribeCmd.AddCommand(subscribeAddCmd)
+	subscribeCmd.AddCommand(subscribeListCmd)
+	subscribeCmd.AddCommand(subscribeRemoveCmd)
+
 	// Config subcommands
 	configCmd.AddCommand(configShowCmd)
 	configCmd.AddCommand(configSaveCmd)
@@ -134,11 +450,190 @@ func init() {
 		"number of concurrent downloads")
 	playlistCmd.Flags().BoolVar(&skipErrors, "skip-errors", false,
 		"continue downloading even if some videos fail")
+	addSubtitleFlags(playlistCmd)
+	addThumbnailFlags(playlistCmd)
+	addSidecarFlags(playlistCmd)
+	addEmbedFlags(playlistCmd)
+	addSponsorBlockFlags(playlistCmd)
+	addExecFlags(playlistCmd)
+	addArchiveFlag(playlistCmd)
+	addReportFlag(playlistCmd)
+	playlistCmd.Flags().StringSliceVar(&playlistFiles, "playlist-files", []string{playlistfile.FormatM3U8},
+		"playlist files to write to the playlist folder (m3u8, xspf, comma separated, or none)")
+	playlistCmd.Flags().BoolVar(&playlistSync, "sync", false,
+		"make the playlist folder mirror the playlist: download new videos, renumber moved ones and set aside removed ones")
+	playlistCmd.Flags().StringVar(&removedPolicy, "removed", mirror.PolicyMove,
+		"with --sync, what to do with videos no longer in the playlist: move (to _removed) or delete")
+
+	// Queue command flags
+	queueListCmd.Flags().StringVar(&queueState, "state", "",
+		"only list downloads in this state (pending, done, failed, ...)")
+	for _, cmd := range []*cobra.Command{queueResumeCmd, queueRetryCmd} {
+		cmd.Flags().IntVarP(&maxWorkers, "workers", "w", 3,
+			"number of concurrent downloads")
+		cmd.Flags().BoolVar(&skipErrors, "skip-errors", false,
+			"continue downloading even if some videos fail")
+		addReportFlag(cmd)
+	}
+	queueClearCmd.Flags().BoolVar(&clearAll, "all", false,
+		"remove every download, including pending ones")
+
+	// Serve command flags
+	serveCmd.Flags().StringVar(&listenAddr, "listen", "127.0.0.1:8080",
+		"address to serve the API on")
+	serveCmd.Flags().IntVarP(&maxWorkers, "workers", "w", 3,
+		"number of concurrent downloads")
+	serveCmd.Flags().StringVarP(&serveOutput, "output", "o", "",
+		"default output directory for jobs (default is the configured directory)")
+
+	// Subscribe command flags
+	subscribeAddCmd.Flags().StringVarP(&subQuality, "quality", "q", "",
+		"video quality for this subscription (default is the configured quality)")
+	subscribeAddCmd.Flags().BoolVarP(&subAudioOnly, "audio-only", "a", false,
+		"download audio only")
+	subscribeAddCmd.Flags().StringVarP(&subOutput, "output", "o", "",
+		"output directory (default is a folder named after the channel or playlist)")
+	subscribeAddCmd.Flags().StringVar(&subFilter.MatchTitle, "match-title", "",
+		"only download videos whose title matches this regular expression")
+	subscribeAddCmd.Flags().StringVar(&subFilter.RejectTitle, "reject-title", "",
+		"skip videos whose title matches this regular expression")
+	subscribeAddCmd.Flags().DurationVar(&subFilter.MinDuration, "min-duration", 0,
+		"skip videos shorter than this (e.g. 2m)")
+	subscribeAddCmd.Flags().DurationVar(&subFilter.MaxDuration, "max-duration", 0,
+		"skip videos longer than this (e.g. 1h)")
+
+	// Sync command flags
+	addSidecarFlags(syncCmd)
+	syncCmd.Flags().BoolVar(&syncDryRun, "dry-run", false,
+		"list new videos without downloading them")
+	syncCmd.Flags().BoolVar(&syncFull, "full", false,
+		"list every video of each subscription instead of checking its feed")
+	syncCmd.Flags().IntVarP(&maxWorkers, "workers", "w", 3,
+		"number of concurrent downloads")
+	syncCmd.Flags().BoolVar(&skipErrors, "skip-errors", false,
+		"continue downloading even if some videos fail")
+	syncCmd.Flags().StringVar(&downloadArchive, "download-archive", "",
+		"file of downloaded video IDs (default is archive.txt in the data directory)")
+	addReportFlag(syncCmd)
+
+	// Info command flags
+	infoCmd.Flags().BoolVar(&infoJSON, "json", false,
+		"print video information as JSON")
+
+	// Live command flags
+	liveCmd.Flags().StringVarP(&outputDir, "output", "o", "./downloads",
+		"output directory for recordings")
+	liveCmd.Flags().StringVarP(&quality, "quality", "q", "best",
+		"stream quality (best, worst, 720p, 1080p, etc.)")
+	liveCmd.Flags().BoolVar(&fromStart, "from-start", false,
+		"record from the earliest available segment instead of the live edge")
+	liveCmd.Flags().DurationVar(&recordDuration, "duration", 0,
+		"stop recording after this long (e.g. 90m, 2h); 0 records until the stream ends")
+	liveCmd.Flags().BoolVar(&waitForLive, "wait-for-live", false,
+		"wait for a scheduled stream to start")
+	liveCmd.Flags().DurationVar(&waitInterval, "wait-interval", 30*time.Second,
+		"initial delay between checks while waiting for a stream")
+	liveCmd.Flags().DurationVar(&waitMaxInterval, "wait-max-interval", 10*time.Minute,
+		"maximum delay between checks while waiting for a stream")
+}
+
+func addSubtitleFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&writeSubs, "write-subs", false,
+		"download subtitles uploaded by the author")
+	cmd.Flags().BoolVar(&writeAutoSubs, "write-auto-subs", false,
+		"download automatically generated subtitles")
+	cmd.Flags().StringSliceVar(&subLangs, "sub-langs", []string{"en"},
+		"subtitle languages to download (comma separated, or \"all\")")
+	cmd.Flags().StringVar(&subFormat, "sub-format", "srt",
+		"subtitle format (srt, vtt, ttml, json3)")
+}
+
+func addThumbnailFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&writeThumbnail, "write-thumbnail", false,
+		"save the highest resolution thumbnail next to the video")
+	cmd.Flags().BoolVar(&writeAllThumbnails, "write-all-thumbnails", false,
+		"save every available thumbnail next to the video")
+	cmd.Flags().StringVar(&convertThumbnails, "convert-thumbnails", "",
+		"convert saved thumbnails to this format (jpg)")
+}
+
+func addSidecarFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&writeInfoJSON, "write-info-json", false,
+		"save the video details as JSON next to the video")
+	cmd.Flags().BoolVar(&writeDescription, "write-description", false,
+		"save the video description as a .description file")
+	cmd.Flags().BoolVar(&writeNFO, "write-nfo", false,
+		"write Kodi/Jellyfin NFO files: the channel as a show, a playlist as a season")
+	cmd.Flags().IntVar(&nfoSeason, "nfo-season", 1,
+		"season number of playlist videos in NFO files")
+}
+
+func addEmbedFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&embedMetadata, "embed-metadata", false,
+		"write title, author, upload date, description and URL into the file")
+	cmd.Flags().BoolVar(&embedChapters, "embed-chapters", false,
+		"write chapters from the video description into the file")
+	cmd.Flags().BoolVar(&embedSubs, "embed-subs", false,
+		"embed subtitles as text tracks (MP4 only)")
+	cmd.Flags().BoolVar(&splitChapters, "split-chapters", false,
+		"also write each chapter to its own file, named by output.chapter_pattern")
+}
+
+func addExecFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&execCommands, "exec", nil,
+		"run a command after each file is finished, e.g. \"cp {filepath} /backup\" (repeatable)")
+	cmd.Flags().DurationVar(&execTimeout, "exec-timeout", 0,
+		"kill --exec commands that run longer than this (e.g. 10m)")
+	cmd.Flags().StringVar(&execOnFailure, "exec-on-failure", hooks.OnFailureFail,
+		"what a failing --exec command does: ignore, fail (the video) or abort (the batch)")
+}
+
+// hookList builds the hooks from the configuration file and --exec
+func hookList() []hooks.Hook {
+	var list []hooks.Hook
+	for _, h := range appConfig.Hooks {
+		list = append(list, hooks.Hook{
+			Stage:     h.Stage,
+			Command:   h.Command,
+			Timeout:   time.Duration(h.Timeout) * time.Second,
+			OnFailure: h.OnFailure,
+		})
+	}
+	for _, command := range execCommands {
+		list = append(list, hooks.Hook{
+			Stage:     hooks.StageAfterPostprocess,
+			Command:   command,
+			Timeout:   execTimeout,
+			OnFailure: execOnFailure,
+		})
+	}
+	return list
+}
+
+func addReportFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&reportFile, "report", "",
+		"write how each download went to this file, as CSV if it ends in .csv and JSON otherwise")
+}
+
+func addArchiveFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&downloadArchive, "download-archive", "",
+		"skip videos listed in this file and add downloaded ones to it")
+}
+
+func addSponsorBlockFlags(cmd *cobra.Command) {
+	cmd.Flags().StringSliceVar(&sponsorBlockMark, "sponsorblock-mark", nil,
+		"SponsorBlock categories to mark as chapters (comma separated, or \"all\")")
+	cmd.Flags().StringSliceVar(&sponsorBlockRemove, "sponsorblock-remove", nil,
+		"SponsorBlock categories to cut out of the file (comma separated, or \"all\")")
+	cmd.Flags().StringVar(&sponsorBlockAPI, "sponsorblock-api", sponsorblock.DefaultAPIURL,
+		"SponsorBlock API server")
 }
 
 var appConfig *config.Config
 
-func initConfig() {
+// initConfig loads the config file, then lets the flags given to the
+// command being run override it
+func initConfig(cmd *cobra.Command) {
 	var err error
 	appConfig, err = config.LoadConfig(cfgFile)
 	if err != nil {
@@ -147,25 +642,25 @@ func initConfig() {
 	}
 
 	// Override config with command line flags if provided
-	if rootCmd.Flags().Changed("output") {
+	if cmd.Flags().Changed("output") {
 		appConfig.Output.Directory = outputDir
 	} else {
 		outputDir = appConfig.Output.Directory
 	}
 
-	if rootCmd.Flags().Changed("quality") {
+	if cmd.Flags().Changed("quality") {
 		appConfig.Download.DefaultQuality = quality
 	} else {
 		quality = appConfig.Download.DefaultQuality
 	}
 
-	if rootCmd.Flags().Changed("audio-only") {
+	if cmd.Flags().Changed("audio-only") {
 		appConfig.Download.AudioOnly = audioOnly
 	} else {
 		audioOnly = appConfig.Download.AudioOnly
 	}
 
-	if rootCmd.Flags().Changed("verbose") {
+	if cmd.Flags().Changed("verbose") {
 		// Verbose is not part of the new config structure
 		// We'll keep it as a command-line flag only
 	}
@@ -178,67 +673,130 @@ func initConfig() {
 func downloadVideo(url string) error {
 	video, err := youtube.ParseURL(url)
 	if err != nil {
-		return fmt.Errorf("failed to parse URL: %w", err)
+		return errors.NewValidationError("failed to parse URL", err)
 	}
-
-	ext := extractor.New()
-	details, err := ext.GetVideoDetails(video.ID)
+	sections, err := downloader.ParseSections(downloadSections)
 	if err != nil {
-		return fmt.Errorf("failed to extract video info: %w", err)
+		return err
 	}
-
-	fmt.Printf("Title: %s\n", details.Title)
-	fmt.Printf("Author: %s\n", details.Author)
-	fmt.Printf("Duration: %s\n", details.Duration)
-	fmt.Printf("Available formats: %d\n", len(details.Formats))
-
-	selectedFormat, err := ext.SelectFormat(details.Formats, quality, audioOnly)
-	if err != nil {
-		return fmt.Errorf("failed to select format: %w", err)
-	}
-
-	fmt.Printf("Selected quality: %s\n", selectedFormat.Quality)
-	fmt.Printf("File size: %d bytes\n", selectedFormat.Filesize)
-
-	// Create filename
-	var filename string
-	if appConfig.Output.NamingPattern == "{title}" {
-		filename = downloader.SanitizeFilename(details.Title) +
-			downloader.GetFileExtension(selectedFormat.MimeType)
-	} else {
-		// Support for other naming patterns could be added here
-		filename = downloader.SanitizeFilename(details.Title) +
-			downloader.GetFileExtension(selectedFormat.MimeType)
+	// A link to a moment downloads from there on
+	if len(sections) == 0 && video.StartTime > 0 {
+		sections = []downloader.Section{{Start: video.StartTime}}
 	}
 
-	// Download
-	dl := downloader.New()
-	opts := downloader.DownloadOptions{
-		URL:          selectedFormat.URL,
-		OutputDir:    outputDir,
-		Filename:     filename,
-		ShowProgress: true,
+	opts := pipelineOptions()
+	opts.Sections = sections
+	opts.ShowProgress = events == nil
+	p, err := pipeline.New(opts)
+	if err != nil {
+		return err
 	}
+	p.SetOutput(stdout, os.Stderr)
 
 	// Create context with timeout from config
 	ctx, cancel := context.WithTimeout(context.Background(),
 		time.Duration(appConfig.Network.Timeout)*time.Second)
 	defer cancel()
+	if ctx, err = withRetries(ctx); err != nil {
+		return err
+	}
 
-	// Note: The downloader doesn't currently support setting user agent, retries, or rate limit
-	// These would need to be added to the Downloader struct if needed
+	var onStage func(pipeline.Stage)
+	var te *progress.TaskEvents
+	if events != nil {
+		te = events.Task(0, video.ID, "")
+		ctx, onStage = reportEvents(ctx, p, te, nil)
+		te.Started(1)
+	}
+	result, err := p.Run(ctx, pipeline.Item{VideoID: video.ID}, onStage)
+	if te != nil {
+		if err != nil {
+			te.Failed(err)
+		} else {
+			te.Completed(result.Path)
+		}
+	}
+	return err
+}
 
-	return dl.Download(ctx, opts)
+// withRetries returns ctx with the retry policy and limits of the network
+// configuration
+func withRetries(ctx context.Context) (context.Context, error) {
+	network := appConfig.Network
+	policy, err := retry.ParsePolicy(network.RetryPolicy, time.Duration(network.RetryDelay)*time.Millisecond, 30*time.Second)
+	if err != nil {
+		return ctx, err
+	}
+	return retry.WithOptions(ctx, retry.Options{
+		Policy:      policy,
+		MaxAttempts: network.Retries,
+		MaxElapsed:  time.Duration(network.RetryMaxElapsed) * time.Second,
+	}), nil
+}
+
+// reportEvents sends the progress, stages and retries of a pipeline run to
+// te. It returns the context to run with and the stage callback, which
+// also calls next if that is not nil.
+func reportEvents(ctx context.Context, p *pipeline.Pipeline, te *progress.TaskEvents, next func(pipeline.Stage)) (context.Context, func(pipeline.Stage)) {
+	p.Progress = te.Progress
+	ctx = downloader.WithRetryFunc(ctx, te.Retry)
+	return ctx, func(stage pipeline.Stage) {
+		te.Stage(string(stage))
+		if next != nil {
+			next(stage)
+		}
+	}
+}
+
+// pipelineOptions collects the download settings of the command line flags
+// and the configuration file
+func pipelineOptions() pipeline.Options {
+	return pipeline.Options{
+		OutputDir:          outputDir,
+		Quality:            quality,
+		AudioOnly:          audioOnly,
+		NamingPattern:      appConfig.Output.NamingPattern,
+		ChapterPattern:     appConfig.Output.ChapterPattern,
+		WriteSubs:          writeSubs,
+		WriteAutoSubs:      writeAutoSubs,
+		SubLangs:           subLangs,
+		SubFormat:          subFormat,
+		WriteThumbnail:     writeThumbnail,
+		WriteAllThumbnails: writeAllThumbnails,
+		ConvertThumbnails:  convertThumbnails,
+		WriteInfoJSON:      writeInfoJSON,
+		WriteDescription:   writeDescription,
+		WriteNFO:           writeNFO,
+		NFOSeason:          nfoSeason,
+		EmbedMetadata:      embedMetadata,
+		EmbedChapters:      embedChapters,
+		EmbedSubs:          embedSubs,
+		SplitChapters:      splitChapters,
+		SponsorBlockMark:   sponsorBlockMark,
+		SponsorBlockRemove: sponsorBlockRemove,
+		SponsorBlockAPI:    sponsorBlockAPI,
+		Hooks:              hookList(),
+		DownloadArchive:    downloadArchive,
+	}
 }
 
-func downloadPlaylist(url string) error {
+func downloadPlaylist(url string, workers int) error {
 	if !youtube.IsPlaylistURL(url) {
-		return fmt.Errorf("not a playlist URL")
+		return errors.NewValidationError("not a playlist URL", nil)
 	}
 
 	playlistID := youtube.ExtractPlaylistID(url)
 	if playlistID == "" {
-		return fmt.Errorf("could not extract playlist ID")
+		return errors.NewValidationError("could not extract playlist ID", nil)
+	}
+
+	// Check the options before anything is queued
+	opts := pipelineOptions()
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if _, err := playlistfile.ParseFormats(playlistFiles); err != nil {
+		return err
 	}
 
 	ext := extractor.New()
@@ -247,81 +805,1026 @@ func downloadPlaylist(url string) error {
 		return fmt.Errorf("failed to get playlist info: %w", err)
 	}
 
-	fmt.Printf("Playlist: %s\n", playlist.Title)
-	fmt.Printf("Videos: %d\n", len(playlist.Videos))
-	fmt.Printf("Author: %s\n", playlist.Author)
+	fmt.Fprintf(stdout, "Playlist: %s\n", playlist.Title)
+	fmt.Fprintf(stdout, "Videos: %d\n", len(playlist.Videos))
+	fmt.Fprintf(stdout, "Author: %s\n", playlist.Author)
 
-	// Create download tasks
-	var tasks []downloader.DownloadOptions
+	var videos []extractor.Video
+	for _, v := range playlist.Videos {
+		videos = append(videos, extractor.Video{ID: v.ID, Title: v.Title, Duration: v.Duration})
+	}
+	if playlistSync {
+		return mirrorPlaylist(playlist.ID, playlist.Title, videos, opts, workers)
+	}
+
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
 
+	var ids []int
+	earlier := playlistTasks(q, playlist.ID)
 	for i, video := range playlist.Videos {
-		fmt.Printf("Processing video %d/%d: %s\n", i+1, len(playlist.Videos), video.Title)
+		id, err := queuePlaylistVideo(q, earlier, pipeline.Item{
+			VideoID: video.ID,
+			Playlist: &pipeline.PlaylistRef{
+				ID:    playlist.ID,
+				Title: playlist.Title,
+				Index: i + 1,
+				Total: len(playlist.Videos),
+			},
+		}, opts, video.Title)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	// runQueue takes no IDs to mean the whole queue
+	if len(ids) == 0 {
+		fmt.Fprintln(stdout, "The playlist has no videos to download")
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "Starting download of %d videos with %d workers...\n", len(ids), workers)
+	runErr := runQueue(q, ids, workers)
+	if err := writePlaylistFiles(playlist.Title, videos, opts); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// playlistTasks finds the tasks of a playlist left pending or failed by
+// earlier runs, by video ID
+func playlistTasks(q *queue.Queue, playlistID string) map[string]queue.Task {
+	tasks := make(map[string]queue.Task)
+	for _, t := range q.Tasks() {
+		if t.Item.Playlist == nil || t.Item.Playlist.ID != playlistID {
+			continue
+		}
+		if t.State == queue.StatePending || t.State == queue.StateFailed {
+			tasks[t.Item.VideoID] = t
+		}
+	}
+	return tasks
+}
 
-		details, err := ext.GetVideoDetails(video.ID)
+// queuePlaylistVideo returns the ID of the task that downloads a video of
+// a playlist. A task from an earlier run is queued again with the video's
+// current position and options, so that the video is not queued twice;
+// otherwise a task is added.
+func queuePlaylistVideo(q *queue.Queue, earlier map[string]queue.Task, item pipeline.Item, opts pipeline.Options, title string) (int, error) {
+	if t, ok := earlier[item.VideoID]; ok {
+		// A video listed twice gets a task for each listing
+		delete(earlier, item.VideoID)
+		_, err := q.Requeue(t.ID, item, opts, title)
+		return t.ID, err
+	}
+	task, err := q.Add(item, opts, title)
+	return task.ID, err
+}
+
+// writePlaylistFiles writes the --playlist-files of a playlist to its
+// folder, listing every video there is a file for, including those
+// downloaded by earlier runs
+func writePlaylistFiles(title string, videos []extractor.Video, opts pipeline.Options) error {
+	formats, err := playlistfile.ParseFormats(playlistFiles)
+	if err != nil || len(formats) == 0 {
+		return err
+	}
+	dir := (&pipeline.PlaylistRef{Title: title}).Dir(opts.OutputDir)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil
+	}
+
+	var entries []playlistfile.Entry
+	for i, v := range videos {
+		entries = append(entries, playlistfile.Entry{Index: i + 1, Title: v.Title, Duration: v.Duration})
+	}
+	tracks, err := playlistfile.FindTracks(dir, entries)
+	if err != nil {
+		return err
+	}
+	paths, err := playlistfile.Write(dir, title, tracks, formats)
+	for _, path := range paths {
+		fmt.Fprintf(stdout, "Wrote %s\n", path)
+	}
+	return err
+}
+
+// mirrorPlaylist brings a playlist's folder in step with the playlist: files
+// are renumbered and removed videos moved aside as the folder's manifest
+// says, and then the missing videos are downloaded
+func mirrorPlaylist(id, title string, videos []extractor.Video, opts pipeline.Options, workers int) error {
+	if err := mirror.ValidatePolicy(removedPolicy); err != nil {
+		return err
+	}
+	if opts.DownloadArchive != "" {
+		return errors.NewValidationError("--sync cannot be combined with --download-archive", nil)
+	}
+
+	dir := (&pipeline.PlaylistRef{Title: title}).Dir(opts.OutputDir)
+	m, err := mirror.Load(dir)
+	if err != nil {
+		return err
+	}
+	m.PlaylistID, m.Title = id, title
+
+	plan := m.Plan(videos)
+	if err := m.Apply(plan, removedPolicy); err != nil {
+		return err
+	}
+	if err := m.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Renumbered %d, removed %d, %d to download\n", len(plan.Moves), len(plan.Removed), len(plan.New))
+	if len(plan.New) == 0 {
+		return writePlaylistFiles(title, videos, opts)
+	}
+
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	var ids []int
+	entries := make(map[int]mirror.Entry)
+	earlier := playlistTasks(q, id)
+	for _, e := range plan.New {
+		taskID, err := queuePlaylistVideo(q, earlier, pipeline.Item{
+			VideoID: e.VideoID,
+			Playlist: &pipeline.PlaylistRef{
+				ID:    id,
+				Title: title,
+				Index: e.Index,
+				Total: len(videos),
+			},
+		}, opts, e.Title)
 		if err != nil {
-			if skipErrors {
-				fmt.Printf("Skipping video %s: %v\n", video.ID, err)
-				continue
+			return err
+		}
+		ids = append(ids, taskID)
+		entries[taskID] = e
+	}
+
+	fmt.Fprintf(stdout, "Starting download of %d videos with %d workers...\n", len(ids), workers)
+	runErr := runQueue(q, ids, workers)
+
+	// Record what was downloaded, even if some videos failed
+	for _, taskID := range ids {
+		if t, ok := q.Get(taskID); ok && t.State == queue.StateDone && t.Path != "" {
+			e := entries[taskID]
+			e.File = filepath.Base(t.Path)
+			for _, part := range t.Parts {
+				e.Parts = append(e.Parts, filepath.Base(part))
 			}
-			return fmt.Errorf("failed to get video details for %s: %w", video.ID, err)
+			m.Add(e)
 		}
+	}
+	if err := m.Save(); err != nil {
+		return err
+	}
+	if err := writePlaylistFiles(title, videos, opts); err != nil {
+		return err
+	}
+	return runErr
+}
 
-		selectedFormat, err := ext.SelectFormat(details.Formats, quality, audioOnly)
-		if err != nil {
-			if skipErrors {
-				fmt.Printf("Skipping video %s: %v\n", video.ID, err)
-				continue
+// workerCount returns the --workers flag of a command if it was given, or
+// the configured number of workers
+func workerCount(cmd *cobra.Command) int {
+	if cmd.Flags().Changed("workers") {
+		return maxWorkers
+	}
+	return appConfig.Download.MaxWorkers
+}
+
+// dataFile returns the path of a file in the data directory
+func dataFile(name string) (string, error) {
+	dir, err := config.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// openQueue opens the download queue in the data directory
+func openQueue() (*queue.Queue, error) {
+	path, err := dataFile("queue.jsonl")
+	if err != nil {
+		return nil, err
+	}
+	return queue.Open(path)
+}
+
+// runQueue downloads the pending tasks with the given IDs, or all pending
+// tasks if ids is nil, until they finish or Ctrl-C stops them, and then
+// runs the after-playlist hooks of the playlists it completed
+func runQueue(q *queue.Queue, ids []int, workers int) error {
+	if ids == nil {
+		for _, t := range q.Tasks() {
+			if t.State == queue.StatePending {
+				ids = append(ids, t.ID)
 			}
-			return fmt.Errorf("failed to select format for %s: %w", video.ID, err)
 		}
+	}
+
+	ctx, cancel := utils.SignalContext(context.Background())
+	defer cancel()
+	// The downloads share a retry budget, so that a dead network fails the
+	// batch instead of retrying every video
+	ctx, err := withRetries(ctx)
+	if err != nil {
+		return err
+	}
+	if appConfig.Network.RetryBudget > 0 {
+		ctx = retry.WithBudget(ctx, retry.NewBudget(appConfig.Network.RetryBudget))
+	}
+
+	if events == nil && len(ids) > 0 {
+		display = progress.NewDisplay(stdout, len(ids), progress.IsTerminal(os.Stdout))
+		display.Start()
+	}
+	summary := downloader.NewBatchResult(len(ids))
+	runErr := q.Run(ctx, ids, queue.RunOptions{
+		Workers: workers,
+		Stop: func(err error) bool {
+			return !skipErrors || stderrors.Is(err, hooks.ErrAbort)
+		},
+	}, func(ctx context.Context, task queue.Task, state func(queue.State)) (queue.Output, error) {
+		return downloadTask(ctx, task, state, summary)
+	})
+	summary.Finish()
+	if display != nil {
+		display.Stop()
+		display = nil
+	}
+	if len(ids) > 0 {
+		fmt.Fprintln(stdout)
+		summary.WriteTable(stdout)
+	}
+	if reportFile != "" {
+		if err := summary.WriteReport(reportFile); err != nil {
+			return err
+		}
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(stdout, "Downloads stopped; run \"red-goose queue resume\" to continue")
+		return runErr
+	}
+
+	if err := finishPlaylists(ctx, q, ids); err != nil {
+		return err
+	}
+	// The summary names the failures better than the queue, which only
+	// knows the first one when a failure stops the run
+	if err := summary.Err(); err != nil {
+		return err
+	}
+	return runErr
+}
+
+// downloadTask downloads a queued video, each bounded by the network
+// timeout, and adds how it went to summary
+func downloadTask(ctx context.Context, task queue.Task, state func(queue.State), summary *downloader.BatchResult) (output queue.Output, err error) {
+	runCtx, start := ctx, time.Now()
+	retries := 0
+	var result *pipeline.Result
+	defer func() {
+		tr := downloader.TaskResult{
+			Task:     task.ID,
+			VideoID:  task.Item.VideoID,
+			Title:    task.Title,
+			Duration: time.Since(start),
+			Retries:  retries,
+		}
+		tr.Outcome, tr.Err = downloader.TaskOutcome(err, result != nil && result.Skipped)
+		if runCtx.Err() != nil {
+			tr.Outcome, tr.Err = downloader.OutcomeInterrupted, nil
+		}
+		if tr.Outcome == downloader.OutcomeSuccess {
+			tr.Path = output.Path
+			if info, statErr := os.Stat(output.Path); statErr == nil {
+				tr.Bytes = info.Size()
+			}
+		}
+		summary.Add(tr)
+	}()
+
+	p, err := pipeline.New(task.Options)
+	if err != nil {
+		return queue.Output{}, err
+	}
+
+	ctx = downloader.WithRetryFunc(ctx, func(int, error) { retries++ })
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(appConfig.Network.Timeout)*time.Second)
+	defer cancel()
+
+	onStage := func(stage pipeline.Stage) {
+		state(queue.State(stage))
+	}
+	var te *progress.TaskEvents
+	if events != nil {
+		te = events.Task(task.ID, task.Item.VideoID, task.Title)
+		ctx, onStage = reportEvents(ctx, p, te, onStage)
+		te.Started(task.Attempts)
+	}
+	out, errOut := stdout, io.Writer(os.Stderr)
+	var bar *progress.Bar
+	if display != nil {
+		out, errOut = display.Writer(stdout), display.Writer(os.Stderr)
+		bar = display.Add(taskName(task))
+		p.Progress = bar.Progress
+	}
+	p.SetOutput(out, errOut)
+
+	fmt.Fprintf(out, "Downloading %s\n", taskName(task))
+	result, err = p.Run(ctx, task.Item, onStage)
+	if bar != nil {
+		bar.Done(err == nil)
+	}
+	if err != nil {
+		if te != nil {
+			te.Failed(err)
+		}
+		fmt.Fprintf(errOut, "Failed %s: %v\n", taskName(task), err)
+		return queue.Output{}, err
+	}
+	if te != nil {
+		te.Completed(result.Path)
+	}
+	fmt.Fprintf(out, "Finished %s\n", taskName(task))
+	return queue.Output{Path: result.Path, Parts: result.Parts}, nil
+}
+
+// taskName describes a task in progress messages
+func taskName(task queue.Task) string {
+	name := task.Title
+	if name == "" {
+		name = task.Item.VideoID
+	}
+	if pl := task.Item.Playlist; pl != nil {
+		return fmt.Sprintf("%d/%d: %s", pl.Index, pl.Total, name)
+	}
+	return name
+}
 
-		filename := fmt.Sprintf("%03d - %s%s",
-			i+1,
-			downloader.SanitizeFilename(details.Title),
-			downloader.GetFileExtension(selectedFormat.MimeType))
+// finishPlaylists runs the after-playlist hooks of the playlists of the
+// given tasks that have no pending videos left
+func finishPlaylists(ctx context.Context, q *queue.Queue, ids []int) error {
+	touched := make(map[string]bool)
+	for _, id := range ids {
+		if t, ok := q.Get(id); ok && t.Item.Playlist != nil {
+			touched[t.Item.Playlist.ID] = true
+		}
+	}
 
-		tasks = append(tasks, downloader.DownloadOptions{
-			URL:          selectedFormat.URL,
-			OutputDir:    filepath.Join(outputDir, downloader.SanitizeFilename(playlist.Title)),
-			Filename:     filename,
-			ShowProgress: false, // Disable individual progress for batch
+	type playlistState struct {
+		task    queue.Task
+		pending bool
+		done    map[string]bool
+	}
+	var order []string
+	playlists := make(map[string]*playlistState)
+	for _, t := range q.Tasks() {
+		if t.Item.Playlist == nil || !touched[t.Item.Playlist.ID] {
+			continue
+		}
+		ps, ok := playlists[t.Item.Playlist.ID]
+		if !ok {
+			ps = &playlistState{task: t, done: make(map[string]bool)}
+			playlists[t.Item.Playlist.ID] = ps
+			order = append(order, t.Item.Playlist.ID)
+		}
+		switch t.State {
+		case queue.StateDone:
+			ps.done[t.Item.VideoID] = true
+		case queue.StateFailed:
+		default:
+			ps.pending = true
+		}
+	}
+
+	for _, id := range order {
+		ps := playlists[id]
+		if ps.pending {
+			continue
+		}
+		runner, err := hooks.NewRunner(ps.task.Options.Hooks)
+		if err != nil {
+			return err
+		}
+		runner.Stdout = stdout
+		pl := ps.task.Item.Playlist
+		err = runner.Run(ctx, hooks.StageAfterPlaylist, hooks.Vars{
+			"playlist_id":    pl.ID,
+			"playlist_title": pl.Title,
+			"dir":            pl.Dir(ps.task.Options.OutputDir),
+			"count":          strconv.Itoa(len(ps.done)),
 		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listQueue() error {
+	// Reading leaves the queue to a daemon or download that has it open
+	path, err := dataFile("queue.jsonl")
+	if err != nil {
+		return err
+	}
+	tasks, err := queue.Read(path)
+	if err != nil {
+		return err
 	}
 
-	// Download all videos
-	batchDownloader := downloader.NewBatchDownloader(appConfig.Download.MaxWorkers)
+	var filter queue.State
+	if queueState != "" {
+		if filter, err = queue.ParseState(queueState); err != nil {
+			return err
+		}
+	}
+
+	count := 0
+	for _, t := range tasks {
+		if filter != "" && t.State != filter {
+			continue
+		}
+		if count == 0 {
+			fmt.Fprintf(stdout, "%-5s %-15s %-8s %s\n", "ID", "STATE", "ATTEMPTS", "VIDEO")
+		}
+		count++
+		fmt.Fprintf(stdout, "%-5d %-15s %-8d %s\n", t.ID, t.State, t.Attempts, taskName(t))
+		if t.State == queue.StateFailed && t.Error != "" {
+			fmt.Fprintf(stdout, "      error: %s\n", t.Error)
+		}
+	}
+	if count == 0 {
+		fmt.Fprintln(stdout, "The queue is empty")
+	}
+	return nil
+}
+
+func resumeQueue(workers int) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	return runQueue(q, nil, workers)
+}
+
+func retryQueue(args []string, workers int) error {
+	var ids []int
+	for _, arg := range args {
+		id, err := strconv.Atoi(arg)
+		if err != nil {
+			return fmt.Errorf("invalid task ID %q", arg)
+		}
+		ids = append(ids, id)
+	}
+
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	reset, err := q.Retry(ids...)
+	if err != nil {
+		return err
+	}
+	if len(reset) == 0 {
+		fmt.Fprintln(stdout, "No failed downloads to retry")
+		return nil
+	}
+	fmt.Fprintf(stdout, "Retrying %d downloads...\n", len(reset))
+	return runQueue(q, reset, workers)
+}
+
+func clearQueue() error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	removed, err := q.Clear(clearAll)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Removed %d downloads from the queue\n", removed)
+	return nil
+}
+
+// loadSubscriptions reads the subscriptions in the data directory
+func loadSubscriptions() (*subscriptions.List, error) {
+	path, err := dataFile("subscriptions.json")
+	if err != nil {
+		return nil, err
+	}
+	return subscriptions.Load(path)
+}
+
+func addSubscription(url string) error {
+	if err := subFilter.Validate(); err != nil {
+		return err
+	}
+	list, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	sub := subscriptions.Subscription{
+		URL:       url,
+		Quality:   subQuality,
+		AudioOnly: subAudioOnly,
+		OutputDir: subOutput,
+		Filter:    subFilter,
+	}
+	ext := extractor.New()
+	switch {
+	case youtube.IsPlaylistURL(url):
+		sub.PlaylistID = youtube.ExtractPlaylistID(url)
+	case youtube.IsChannelURL(url):
+		ctx, cancel := context.WithTimeout(context.Background(),
+			time.Duration(appConfig.Network.Timeout)*time.Second)
+		defer cancel()
+		if sub.ChannelID, err = ext.ResolveChannelID(ctx, url); err != nil {
+			return err
+		}
+		sub.PlaylistID = youtube.UploadsPlaylistID(sub.ChannelID)
+	default:
+		return errors.NewValidationError("not a channel or playlist URL", nil)
+	}
+
+	title, videos, err := ext.GetPlaylistVideos(sub.PlaylistID)
+	if err != nil {
+		return fmt.Errorf("failed to get playlist info: %w", err)
+	}
+	sub.Title = title
+
+	if sub, err = list.Add(sub); err != nil {
+		return err
+	}
+	if err := list.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Subscribed to %s (%d videos); run \"red-goose sync\" to download new ones\n", sub.Title, len(videos))
+	return nil
+}
+
+func listSubscriptions() error {
+	list, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+
+	subs := list.All()
+	if len(subs) == 0 {
+		fmt.Fprintln(stdout, "No subscriptions")
+		return nil
+	}
+	fmt.Fprintf(stdout, "%-4s %-20s %s\n", "ID", "LAST SYNC", "TITLE")
+	for _, sub := range subs {
+		lastSync := "never"
+		if !sub.LastSync.IsZero() {
+			lastSync = sub.LastSync.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(stdout, "%-4d %-20s %s\n", sub.ID, lastSync, sub.Title)
+		fmt.Fprintf(stdout, "     %s\n", sub.URL)
+	}
+	return nil
+}
+
+func removeSubscription(ref string) error {
+	list, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+	sub, err := list.Remove(ref)
+	if err != nil {
+		return err
+	}
+	if err := list.Save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "Unsubscribed from %s\n", sub.Title)
+	return nil
+}
+
+// syncSubscriptions queues the videos of the given subscriptions that are
+// neither in the download archive nor already queued, and downloads them.
+// A subscription's last sync only moves forward once all of its videos
+// are downloaded, so that the feed is not trusted past a failed one.
+func syncSubscriptions(refs []string, workers int) error {
+	list, err := loadSubscriptions()
+	if err != nil {
+		return err
+	}
+	subs := list.All()
+	if len(refs) > 0 {
+		subs = nil
+		for _, ref := range refs {
+			sub, ok := list.Find(ref)
+			if !ok {
+				return fmt.Errorf("no subscription %q", ref)
+			}
+			subs = append(subs, sub)
+		}
+	}
+	if len(subs) == 0 {
+		fmt.Fprintln(stdout, "No subscriptions; add one with \"red-goose subscribe add\"")
+		return nil
+	}
+
+	archivePath := downloadArchive
+	if archivePath == "" {
+		if archivePath, err = dataFile("archive.txt"); err != nil {
+			return err
+		}
+	}
+	downloaded, err := archive.Open(archivePath)
+	if err != nil {
+		return err
+	}
+
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	// Videos waiting in the queue from an earlier sync are not added again
+	queued := make(map[string]bool)
+	for _, t := range q.Tasks() {
+		if t.State == queue.StatePending || t.State == queue.StatePaused || t.State.Working() {
+			queued[t.Item.VideoID] = true
+		}
+	}
+	skip := func(videoID string) bool {
+		return downloaded.Has(videoID) || queued[videoID]
+	}
 
-	// Create context with timeout from config
 	ctx, cancel := context.WithTimeout(context.Background(),
 		time.Duration(appConfig.Network.Timeout)*time.Second)
 	defer cancel()
 
-	fmt.Printf("Starting download of %d videos with %d workers...\n",
-		len(tasks), appConfig.Download.MaxWorkers)
-	return batchDownloader.DownloadAll(ctx, tasks)
+	ext := extractor.New()
+	feeds := feed.NewClient(appConfig.Subscriptions.FeedURL)
+	var ids []int
+	var checkErr error
+	// tasks are the IDs queued for each checked subscription
+	checked := make([]subscriptions.Subscription, 0, len(subs))
+	tasks := make(map[int][]int)
+	for _, sub := range subs {
+		opts := subscriptionOptions(sub, archivePath)
+		if err := opts.Validate(); err != nil {
+			return err
+		}
+		if err := sub.Filter.Validate(); err != nil {
+			return err
+		}
+
+		started := time.Now()
+		videos, full, err := subscriptionVideos(ctx, ext, feeds, &sub, skip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to check %s: %v\n", sub.Title, err)
+			checkErr = fmt.Errorf("failed to check some subscriptions: %w", err)
+			continue
+		}
+		fmt.Fprintf(stdout, "%s: %d new videos\n", sub.Title, len(videos))
+
+		for _, video := range videos {
+			if syncDryRun {
+				fmt.Fprintf(stdout, "  %s  %s\n", video.ID, video.Title)
+				continue
+			}
+			task, err := q.Add(pipeline.Item{VideoID: video.ID}, opts, video.Title)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, task.ID)
+			tasks[sub.ID] = append(tasks[sub.ID], task.ID)
+			queued[video.ID] = true
+		}
+		sub.LastSync = started
+		if full {
+			sub.LastFullSync = started
+		}
+		checked = append(checked, sub)
+	}
+	if syncDryRun {
+		return checkErr
+	}
+
+	var runErr error
+	if len(ids) > 0 {
+		fmt.Fprintf(stdout, "Starting download of %d videos with %d workers...\n", len(ids), workers)
+		runErr = runQueue(q, ids, workers)
+	}
+
+	for _, sub := range checked {
+		if prev, ok := list.Find(strconv.Itoa(sub.ID)); ok && !allDone(q, tasks[sub.ID]) {
+			sub.LastSync = prev.LastSync
+		}
+		list.Update(sub)
+	}
+	if err := list.Save(); err != nil {
+		return err
+	}
+	if runErr != nil {
+		return runErr
+	}
+	return checkErr
+}
+
+// allDone reports whether the tasks with the given IDs are all done
+func allDone(q *queue.Queue, ids []int) bool {
+	for _, id := range ids {
+		if t, ok := q.Get(id); !ok || t.State != queue.StateDone {
+			return false
+		}
+	}
+	return true
+}
+
+// subscriptionVideos returns the new videos of a subscription. They come
+// from its feed when that can be trusted, and otherwise from a full listing
+// of the channel or playlist, which full reports. A channel's first listing
+// is cut down to its latest uploads, which is recorded in sub.
+func subscriptionVideos(ctx context.Context, ext *extractor.Extractor, feeds *feed.Client, sub *subscriptions.Subscription, skip func(string) bool) (videos []extractor.Video, full bool, err error) {
+	interval := time.Duration(appConfig.Subscriptions.FullSyncHours) * time.Hour
+	if !syncFull && sub.UseFeed(time.Now(), interval) {
+		var entries []feed.Entry
+		if sub.ChannelID != "" {
+			entries, err = feeds.Channel(ctx, sub.ChannelID)
+		} else {
+			entries, err = feeds.Playlist(ctx, sub.PlaylistID)
+		}
+		switch {
+		case err != nil:
+			fmt.Fprintf(os.Stderr, "Failed to read the feed of %s, listing every video: %v\n", sub.Title, err)
+		case !sub.FeedCovers(entries):
+			fmt.Fprintf(stdout, "%s: the feed does not reach back to the last sync, listing every video\n", sub.Title)
+		default:
+			for _, e := range entries {
+				videos = append(videos, extractor.Video{ID: e.VideoID, Title: e.Title})
+			}
+			return withDurations(ext, *sub, sub.Select(videos, skip)), false, nil
+		}
+	}
+
+	_, videos, err = ext.GetPlaylistVideos(sub.PlaylistID)
+	if err != nil {
+		return nil, false, err
+	}
+	videos = sub.Latest(videos, appConfig.Subscriptions.FirstSyncVideos)
+	return sub.Select(videos, skip), true, nil
+}
+
+// withDurations applies the duration limits of a subscription to videos
+// found in a feed, which does not list durations
+func withDurations(ext *extractor.Extractor, sub subscriptions.Subscription, videos []extractor.Video) []extractor.Video {
+	if sub.Filter.MinDuration == 0 && sub.Filter.MaxDuration == 0 {
+		return videos
+	}
+	var kept []extractor.Video
+	for _, v := range videos {
+		// A video that cannot be looked up now is left for the download
+		// to report
+		if details, err := ext.GetVideoDetails(v.ID); err == nil {
+			v.Duration = time.Duration(details.DurationSeconds) * time.Second
+		}
+		if sub.Filter.Allows(v.Title, v.Duration) {
+			kept = append(kept, v)
+		}
+	}
+	return kept
+}
+
+// subscriptionOptions applies the settings of a subscription to the
+// download options
+func subscriptionOptions(sub subscriptions.Subscription, archivePath string) pipeline.Options {
+	opts := pipelineOptions()
+	if sub.Quality != "" {
+		opts.Quality = sub.Quality
+	}
+	if sub.AudioOnly {
+		opts.AudioOnly = true
+	}
+	opts.OutputDir = sub.OutputDir
+	if opts.OutputDir == "" {
+		opts.OutputDir = filepath.Join(outputDir, downloader.SanitizeFilename(sub.Title))
+	}
+	opts.DownloadArchive = archivePath
+	return opts
+}
+
+func serve(workers int) error {
+	q, err := openQueue()
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	defaults := pipelineOptions()
+	if serveOutput != "" {
+		defaults.OutputDir = serveOutput
+	}
+	if err := defaults.Validate(); err != nil {
+		return err
+	}
+	srv := server.New(q, server.Options{Workers: workers, Defaults: defaults, Addr: listenAddr})
+
+	ctx, cancel := utils.SignalContext(context.Background())
+	defer cancel()
+
+	httpServer := &http.Server{Addr: listenAddr, Handler: srv.Handler()}
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- httpServer.ListenAndServe()
+	}()
+
+	scheduled := make(chan struct{})
+	go func() {
+		srv.Run(ctx)
+		close(scheduled)
+	}()
+
+	fmt.Fprintf(stdout, "Serving the API on http://%s with %d workers\n", listenAddr, workers)
+	select {
+	case err = <-listenErr:
+		cancel()
+	case <-ctx.Done():
+	}
+
+	// Running jobs stop and stay pending for the next start
+	<-scheduled
+	shutdownCtx, stop := context.WithTimeout(context.Background(), 5*time.Second)
+	defer stop()
+	httpServer.Shutdown(shutdownCtx)
+
+	if err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("server failed: %w", err)
+	}
+	return nil
+}
+
+func recordLive(url string) error {
+	video, err := youtube.ParseURL(url)
+	if err != nil {
+		return errors.NewValidationError("failed to parse URL", err)
+	}
+
+	// Recording runs until the stream ends, so it is bounded by --duration
+	// and Ctrl-C rather than the network timeout
+	ctx, cancel := utils.SignalContext(context.Background())
+	defer cancel()
+	ctx, err = withRetries(ctx)
+	if err != nil {
+		return err
+	}
+
+	ext := extractor.New()
+	var details *extractor.VideoDetails
+	if waitForLive {
+		details, err = ext.WaitForLive(ctx, video.ID, waitInterval, waitMaxInterval, func(delay time.Duration) {
+			fmt.Fprintf(stdout, "Stream has not started yet, checking again in %s\n", delay)
+		})
+	} else {
+		details, err = ext.GetVideoDetails(video.ID)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("stopped waiting for stream: %w", err)
+		}
+		if extractor.IsLiveOffline(err) {
+			return fmt.Errorf("stream has not started yet (use --wait-for-live to wait for it): %w", err)
+		}
+		return fmt.Errorf("failed to extract video info: %w", err)
+	}
+
+	if !details.IsLive {
+		return fmt.Errorf("video %s is not a live stream", details.ID)
+	}
+
+	fmt.Fprintf(stdout, "Title: %s\n", details.Title)
+	fmt.Fprintf(stdout, "Author: %s\n", details.Author)
+	if recordDuration > 0 {
+		fmt.Fprintf(stdout, "Recording for up to %s\n", recordDuration)
+	}
+
+	recorder := downloader.NewLiveRecorder()
+	result, err := recorder.Record(ctx, downloader.LiveOptions{
+		ManifestURL:  details.HLSManifestURL,
+		Quality:      quality,
+		OutputDir:    outputDir,
+		Filename:     downloader.SanitizeFilename(details.Title) + ".ts",
+		FromStart:    fromStart,
+		Duration:     recordDuration,
+		ShowProgress: true,
+	})
+	if err != nil {
+		return fmt.Errorf("recording failed: %w", err)
+	}
+
+	if result.Interrupted {
+		fmt.Fprintf(stdout, "\nRecording interrupted after %s\n", result.Duration.Round(time.Second))
+	}
+	fmt.Fprintf(stdout, "\nRecording saved: %s (%d segments, %s)\n",
+		result.Path, result.Segments, result.Duration.Round(time.Second))
+	return nil
+}
+
+func showInfo(url string) error {
+	video, err := youtube.ParseURL(url)
+	if err != nil {
+		return errors.NewValidationError("failed to parse URL", err)
+	}
+
+	details, err := extractor.New().GetVideoDetails(video.ID)
+	if err != nil {
+		return fmt.Errorf("failed to extract video info: %w", err)
+	}
+
+	if infoJSON {
+		data, err := json.MarshalIndent(details, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode video info: %w", err)
+		}
+		fmt.Fprintln(stdout, string(data))
+		return nil
+	}
+
+	fmt.Fprintf(stdout, "ID: %s\n", details.ID)
+	fmt.Fprintf(stdout, "Title: %s\n", details.Title)
+	fmt.Fprintf(stdout, "Author: %s\n", details.Author)
+	fmt.Fprintf(stdout, "Duration: %s\n", details.Duration)
+	fmt.Fprintf(stdout, "Live: %t\n", details.IsLive)
+	fmt.Fprintf(stdout, "Available formats: %d\n", len(details.Formats))
+	for _, format := range details.Formats {
+		fmt.Fprintf(stdout, "  %-10s %-40s %d bytes\n", format.Quality, format.MimeType, format.Filesize)
+	}
+	fmt.Fprintf(stdout, "Subtitles: %d\n", len(details.Captions))
+	for _, track := range details.Captions {
+		kind := "uploaded"
+		if track.Automatic {
+			kind = "automatic"
+		}
+		fmt.Fprintf(stdout, "  %-10s %-30s %s\n", track.LanguageCode, track.Name, kind)
+	}
+	fmt.Fprintf(stdout, "Chapters: %d\n", len(details.Chapters))
+	for _, c := range details.Chapters {
+		fmt.Fprintf(stdout, "  %-10s %s\n", c.Start, c.Title)
+	}
+	fmt.Fprintf(stdout, "Thumbnails: %d\n", len(details.Thumbnails))
+	for _, thumb := range details.Thumbnails {
+		fmt.Fprintf(stdout, "  %4dx%-4d %s\n", thumb.Width, thumb.Height, thumb.URL)
+	}
+
+	return nil
 }
 
 func showConfig() error {
-	fmt.Println("Current configuration:")
-	fmt.Printf("  Download:\n")
-	fmt.Printf("    Default Quality: %s\n", appConfig.Download.DefaultQuality)
-	fmt.Printf("    Max Workers: %d\n", appConfig.Download.MaxWorkers)
-	fmt.Printf("    Skip Errors: %t\n", appConfig.Download.SkipErrors)
-	fmt.Printf("    Audio Only: %t\n", appConfig.Download.AudioOnly)
-	fmt.Printf("  Output:\n")
-	fmt.Printf("    Directory: %s\n", appConfig.Output.Directory)
-	fmt.Printf("    Create Subfolders: %t\n", appConfig.Output.CreateSubfolders)
-	fmt.Printf("    Naming Pattern: %s\n", appConfig.Output.NamingPattern)
-	fmt.Printf("  Network:\n")
-	fmt.Printf("    Timeout: %d seconds\n", appConfig.Network.Timeout)
-	fmt.Printf("    Retries: %d\n", appConfig.Network.Retries)
-	fmt.Printf("    User Agent: %s\n", appConfig.Network.UserAgent)
-	fmt.Printf("    Rate Limit: %d ms\n", appConfig.Network.RateLimit)
+	fmt.Fprintln(stdout, "Current configuration:")
+	fmt.Fprintf(stdout, "  Download:\n")
+	fmt.Fprintf(stdout, "    Default Quality: %s\n", appConfig.Download.DefaultQuality)
+	fmt.Fprintf(stdout, "    Max Workers: %d\n", appConfig.Download.MaxWorkers)
+	fmt.Fprintf(stdout, "    Skip Errors: %t\n", appConfig.Download.SkipErrors)
+	fmt.Fprintf(stdout, "    Audio Only: %t\n", appConfig.Download.AudioOnly)
+	fmt.Fprintf(stdout, "  Output:\n")
+	fmt.Fprintf(stdout, "    Directory: %s\n", appConfig.Output.Directory)
+	fmt.Fprintf(stdout, "    Create Subfolders: %t\n", appConfig.Output.CreateSubfolders)
+	fmt.Fprintf(stdout, "    Naming Pattern: %s\n", appConfig.Output.NamingPattern)
+	fmt.Fprintf(stdout, "    Chapter Pattern: %s\n", appConfig.Output.ChapterPattern)
+	fmt.Fprintf(stdout, "  Network:\n")
+	fmt.Fprintf(stdout, "    Timeout: %d seconds\n", appConfig.Network.Timeout)
+	fmt.Fprintf(stdout, "    Retries: %d\n", appConfig.Network.Retries)
+	fmt.Fprintf(stdout, "    Retry Policy: %s, from %d ms\n", appConfig.Network.RetryPolicy, appConfig.Network.RetryDelay)
+	fmt.Fprintf(stdout, "    Retry Max Elapsed: %d seconds\n", appConfig.Network.RetryMaxElapsed)
+	fmt.Fprintf(stdout, "    Retry Budget: %d\n", appConfig.Network.RetryBudget)
+	fmt.Fprintf(stdout, "    User Agent: %s\n", appConfig.Network.UserAgent)
+	fmt.Fprintf(stdout, "    Rate Limit: %d ms\n", appConfig.Network.RateLimit)
+	fmt.Fprintf(stdout, "  Subscriptions:\n")
+	fmt.Fprintf(stdout, "    Feed URL: %s\n", appConfig.Subscriptions.FeedURL)
+	fmt.Fprintf(stdout, "    Full Sync: every %d hours\n", appConfig.Subscriptions.FullSyncHours)
+	fmt.Fprintf(stdout, "    First Sync: %d videos\n", appConfig.Subscriptions.FirstSyncVideos)
+	if len(appConfig.Hooks) > 0 {
+		fmt.Fprintf(stdout, "  Hooks:\n")
+		for _, h := range appConfig.Hooks {
+			fmt.Fprintf(stdout, "    %s: %s\n", h.Stage, h.Command)
+		}
+	}
 
 	if viper.ConfigFileUsed() != "" {
-		fmt.Printf("Config file: %s\n", viper.ConfigFileUsed())
+		fmt.Fprintf(stdout, "Config file: %s\n", viper.ConfigFileUsed())
 	} else {
-		fmt.Println("No config file in use (using defaults)")
+		fmt.Fprintln(stdout, "No config file in use (using defaults)")
 	}
 
 	return nil
@@ -339,12 +1842,12 @@ func saveConfig() error {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
-	fmt.Println("Configuration saved successfully")
+	fmt.Fprintln(stdout, "Configuration saved successfully")
 	if cfgFile != "" {
-		fmt.Printf("Config file: %s\n", cfgFile)
+		fmt.Fprintf(stdout, "Config file: %s\n", cfgFile)
 	} else {
 		home, _ := os.UserHomeDir()
-		fmt.Printf("Config file: %s\n", filepath.Join(home, ".red-goose.yaml"))
+		fmt.Fprintf(stdout, "Config file: %s\n", filepath.Join(home, ".red-goose.yaml"))
 	}
 
 	return nil
@@ -371,7 +1874,7 @@ func initDefaultConfig() error {
 	configPath := filepath.Join(home, ".red-goose.yaml")
 
 	if _, err := os.Stat(configPath); err == nil {
-		fmt.Printf("Config file already exists at %s\n", configPath)
+		fmt.Fprintf(stdout, "Config file already exists at %s\n", configPath)
 		return nil
 	}
 
@@ -379,6 +1882,6 @@ func initDefaultConfig() error {
 		return fmt.Errorf("failed to create config: %w", err)
 	}
 
-	fmt.Printf("Created default configuration at %s\n", configPath)
+	fmt.Fprintf(stdout, "Created default configuration at %s\n", configPath)
 	return nil
 }
@@ -0,0 +1,71 @@
+package cli
+
+import (
+	"context"
+	stderrors "errors"
+	"net"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// Exit codes of the red-goose command
+const (
+	ExitOK = 0
+	// ExitError is for failures of no particular kind
+	ExitError         = 1
+	ExitValidation    = 2
+	ExitNetwork       = 3
+	ExitExtraction    = 4
+	ExitDownload      = 5
+	ExitFileSystem    = 6
+	ExitConfiguration = 7
+	// ExitPartial means some downloads of a batch failed and others
+	// succeeded
+	ExitPartial = 8
+	// ExitInterrupted means the command was stopped by a signal, as a shell
+	// reports for SIGINT
+	ExitInterrupted = 130
+)
+
+// ExitCode maps the error of Execute to the exit code of the command. A
+// batch in which no download succeeded, including one that stopped at its
+// first failure, exits with the code of that failure.
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	if stderrors.Is(err, context.Canceled) {
+		return ExitInterrupted
+	}
+	var batchErr *downloader.BatchError
+	if stderrors.As(err, &batchErr) && batchErr.Partial() {
+		return ExitPartial
+	}
+	// Connection failures and timeouts are network trouble, even when a
+	// step such as the extraction reports them as its own failure
+	var netErr net.Error
+	if stderrors.As(err, &netErr) {
+		return ExitNetwork
+	}
+
+	var rgErr *errors.RedGooseError
+	if !stderrors.As(err, &rgErr) {
+		return ExitError
+	}
+	switch rgErr.Type {
+	case errors.ErrorTypeValidation:
+		return ExitValidation
+	case errors.ErrorTypeNetwork:
+		return ExitNetwork
+	case errors.ErrorTypeExtraction:
+		return ExitExtraction
+	case errors.ErrorTypeDownload:
+		return ExitDownload
+	case errors.ErrorTypeFileSystem:
+		return ExitFileSystem
+	case errors.ErrorTypeConfiguration:
+		return ExitConfiguration
+	}
+	return ExitError
+}
@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+func TestExitCode(t *testing.T) {
+	network := errors.NewNetworkError("failed to download", nil)
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, ExitOK},
+		{"untyped", fmt.Errorf("something broke"), ExitError},
+		{"validation", errors.NewValidationError("failed to parse URL", nil), ExitValidation},
+		{"network", network, ExitNetwork},
+		{"wrapped", fmt.Errorf("video abc: %w", network), ExitNetwork},
+		{"extraction", errors.NewExtractionError("failed to extract video info", network), ExitExtraction},
+		{"download", errors.NewDownloadError("failed to save file", nil), ExitDownload},
+		{"filesystem", errors.NewFileSystemError("failed to create file", nil), ExitFileSystem},
+		{"configuration", errors.NewConfigurationError("bad config", nil), ExitConfiguration},
+		{"timeout", fmt.Errorf("download: %w", context.DeadlineExceeded), ExitNetwork},
+		{"connection failure", errors.NewExtractionError("failed to get video info",
+			&url.Error{Op: "Get", URL: "https://www.youtube.com", Err: fmt.Errorf("no such host")}), ExitNetwork},
+		{"interrupted", context.Canceled, ExitInterrupted},
+		{"partial batch", &downloader.BatchError{Failed: 1, Succeeded: 2, Total: 3, First: network}, ExitPartial},
+		{"failed batch", &downloader.BatchError{Failed: 3, Total: 3, First: network}, ExitNetwork},
+		{"batch stopped at first failure", &downloader.BatchError{Failed: 1, Total: 3, First: network}, ExitNetwork},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ExitCode(tt.err); got != tt.want {
+				t.Errorf("ExitCode(%v) = %d, want %d", tt.err, got, tt.want)
+			}
+		})
+	}
+}
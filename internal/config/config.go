@@ -12,6 +12,9 @@ type Config struct {
     Download DownloadConfig `mapstructure:"download"`
     Output   OutputConfig   `mapstructure:"output"`
     Network  NetworkConfig  `mapstructure:"network"`
+    Hooks    []HookConfig   `mapstructure:"hooks"`
+
+    Subscriptions SubscriptionsConfig `mapstructure:"subscriptions"`
 }
 
 type DownloadConfig struct {
@@ -25,6 +28,7 @@ type OutputConfig struct {
     Directory        string `mapstructure:"directory"`
     CreateSubfolders bool   `mapstructure:"create_subfolders"`
     NamingPattern    string `mapstructure:"naming_pattern"`
+    ChapterPattern   string `mapstructure:"chapter_pattern"`
 }
 
 type NetworkConfig struct {
@@ -32,6 +36,36 @@ type NetworkConfig struct {
     Retries       int    `mapstructure:"retries"`
     UserAgent     string `mapstructure:"user_agent"`
     RateLimit     int    `mapstructure:"rate_limit_ms"`
+
+    // RetryPolicy is constant, exponential or decorrelated backoff
+    RetryPolicy string `mapstructure:"retry_policy"`
+    // RetryDelay is the first wait between attempts, in milliseconds
+    RetryDelay int `mapstructure:"retry_delay_ms"`
+    // RetryMaxElapsed stops retrying a request after this many seconds
+    RetryMaxElapsed int `mapstructure:"retry_max_elapsed_seconds"`
+    // RetryBudget bounds the retries of all downloads of a batch together;
+    // 0 leaves them unbounded
+    RetryBudget int `mapstructure:"retry_budget"`
+}
+
+// SubscriptionsConfig controls how sync looks for new videos
+type SubscriptionsConfig struct {
+    // FeedURL is the Atom feed endpoint checked for new uploads
+    FeedURL string `mapstructure:"feed_url"`
+    // FullSyncHours is how often every video is listed instead of only
+    // the feed; 0 relies on the feed whenever it covers the last sync
+    FullSyncHours int `mapstructure:"full_sync_hours"`
+    // FirstSyncVideos is how many of a channel's latest uploads its first
+    // sync downloads; 0 downloads every upload
+    FirstSyncVideos int `mapstructure:"first_sync_videos"`
+}
+
+// HookConfig is a command run at a stage of each download
+type HookConfig struct {
+    Stage     string `mapstructure:"stage" yaml:"stage"`
+    Command   string `mapstructure:"command" yaml:"command"`
+    Timeout   int    `mapstructure:"timeout_seconds" yaml:"timeout_seconds,omitempty"`
+    OnFailure string `mapstructure:"on_failure" yaml:"on_failure,omitempty"`
 }
 
 // DefaultConfig returns a Config with default values
@@ -47,14 +81,38 @@ func DefaultConfig() *Config {
             Directory:        "./downloads",
             CreateSubfolders: true,
             NamingPattern:    "{title}",
+            ChapterPattern:   "{title} - {chapter_number} {chapter}",
         },
         Network: NetworkConfig{
             Timeout:   1800, // 30 minutes
             Retries:   3,
             UserAgent: "red-goose/1.0",
             RateLimit: 100,
+
+            RetryPolicy:     "exponential",
+            RetryDelay:      2000,
+            RetryMaxElapsed: 300, // 5 minutes
+            RetryBudget:     50,
         },
+        Subscriptions: SubscriptionsConfig{
+            FeedURL:       "https://www.youtube.com/feeds/videos.xml",
+            FullSyncHours:   168, // a week
+            FirstSyncVideos: 15,
+        },
+    }
+}
+
+// DataDir returns the directory red-goose keeps its state in, such as the
+// download queue: $XDG_DATA_HOME/red-goose, or ~/.local/share/red-goose
+func DataDir() (string, error) {
+    if dir := os.Getenv("XDG_DATA_HOME"); dir != "" {
+        return filepath.Join(dir, "red-goose"), nil
+    }
+    home, err := os.UserHomeDir()
+    if err != nil {
+        return "", fmt.Errorf("failed to find home directory: %w", err)
     }
+    return filepath.Join(home, ".local", "share", "red-goose"), nil
 }
 
 // LoadConfig loads the configuration from the specified file
@@ -110,6 +168,10 @@ func SaveConfig(config *Config, cfgFile string) error {
     viper.Set("download", config.Download)
     viper.Set("output", config.Output)
     viper.Set("network", config.Network)
+    viper.Set("subscriptions", config.Subscriptions)
+    if len(config.Hooks) > 0 {
+        viper.Set("hooks", config.Hooks)
+    }
 
     // Write config file
     return viper.WriteConfigAs(cfgFile)
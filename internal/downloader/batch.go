@@ -0,0 +1,353 @@
+package downloader
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
+)
+
+// Outcome is how a task of a batch ended
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	// OutcomeSkipped tasks had nothing to do, e.g. videos in the download
+	// archive
+	OutcomeSkipped Outcome = "skipped"
+	OutcomeFailed  Outcome = "failed"
+	// OutcomeInterrupted tasks were stopped before they finished
+	OutcomeInterrupted Outcome = "interrupted"
+)
+
+// TaskResult is the outcome of one download of a batch
+type TaskResult struct {
+	Task     int
+	VideoID  string
+	Title    string
+	Outcome  Outcome
+	Path     string
+	Bytes    int64
+	Duration time.Duration
+	// Retries counts the requests that failed and were tried again
+	Retries int
+	// Err is why a failed task failed
+	Err *errors.RedGooseError
+}
+
+// BatchResult collects the outcomes of a batch of downloads. It is safe
+// for concurrent use.
+type BatchResult struct {
+	mu       sync.Mutex
+	total    int
+	started  time.Time
+	duration time.Duration
+	tasks    []TaskResult
+}
+
+// NewBatchResult starts the result of a batch of total downloads
+func NewBatchResult(total int) *BatchResult {
+	return &BatchResult{total: total, started: time.Now()}
+}
+
+// Add records how a task ended
+func (r *BatchResult) Add(t TaskResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tasks = append(r.tasks, t)
+}
+
+// Finish stops the batch's clock
+func (r *BatchResult) Finish() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.duration = time.Since(r.started)
+}
+
+// Tasks returns the recorded outcomes in task order
+func (r *BatchResult) Tasks() []TaskResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	tasks := append([]TaskResult(nil), r.tasks...)
+	sort.SliceStable(tasks, func(i, j int) bool { return tasks[i].Task < tasks[j].Task })
+	return tasks
+}
+
+// Count returns the number of tasks with the outcome
+func (r *BatchResult) Count(o Outcome) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, t := range r.tasks {
+		if t.Outcome == o {
+			n++
+		}
+	}
+	return n
+}
+
+// NotRun returns the number of tasks of the batch that never started
+func (r *BatchResult) NotRun() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return max(r.total-len(r.tasks), 0)
+}
+
+// Bytes adds up the sizes of the downloaded files
+func (r *BatchResult) Bytes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var n int64
+	for _, t := range r.tasks {
+		n += t.Bytes
+	}
+	return n
+}
+
+// Err is the batch's verdict: nil when no task failed, a *BatchError
+// otherwise. Skipped and interrupted tasks are not failures.
+func (r *BatchResult) Err() error {
+	var failed []TaskResult
+	done := 0
+	for _, t := range r.Tasks() {
+		switch t.Outcome {
+		case OutcomeFailed:
+			failed = append(failed, t)
+		case OutcomeSuccess, OutcomeSkipped:
+			done++
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return &BatchError{Failed: len(failed), Succeeded: done, Total: r.total, First: failed[0].Err}
+}
+
+// BatchError reports the failed tasks of a batch
+type BatchError struct {
+	Failed int
+	// Succeeded counts the tasks that succeeded or were skipped. Tasks that
+	// never ran, as when the batch stops at its first failure, are in
+	// neither count.
+	Succeeded int
+	Total     int
+	// First is the error of the first failed task
+	First *errors.RedGooseError
+}
+
+func (e *BatchError) Error() string {
+	msg := fmt.Sprintf("%d of %d downloads failed", e.Failed, e.Total)
+	if e.Failed >= e.Total {
+		msg = "all downloads failed"
+	}
+	if e.First != nil {
+		msg += ": " + e.First.Error()
+	}
+	return msg
+}
+
+func (e *BatchError) Unwrap() error {
+	if e.First == nil {
+		return nil
+	}
+	return e.First
+}
+
+// Partial reports whether some tasks of the batch succeeded or were
+// skipped besides the failed ones
+func (e *BatchError) Partial() bool {
+	return e.Succeeded > 0
+}
+
+// TaskOutcome decides the outcome of a task from its error. Errors that
+// are not a *errors.RedGooseError become download errors.
+func TaskOutcome(err error, skipped bool) (Outcome, *errors.RedGooseError) {
+	switch {
+	case err == nil && skipped:
+		return OutcomeSkipped, nil
+	case err == nil:
+		return OutcomeSuccess, nil
+	case stderrors.Is(err, context.Canceled):
+		return OutcomeInterrupted, nil
+	}
+	var rgErr *errors.RedGooseError
+	typed := stderrors.As(err, &rgErr)
+	var netErr net.Error
+	switch {
+	case stderrors.As(err, &netErr):
+		// Connection failures are network trouble, whichever step met them
+		return OutcomeFailed, &errors.RedGooseError{Type: errors.ErrorTypeNetwork, Message: err.Error()}
+	case !typed:
+		return OutcomeFailed, &errors.RedGooseError{Type: errors.ErrorTypeDownload, Message: err.Error()}
+	case err != error(rgErr):
+		// Keep the whole message of errors that wrap a typed one
+		return OutcomeFailed, &errors.RedGooseError{
+			Type:       rgErr.Type,
+			Message:    err.Error(),
+			Context:    rgErr.Context,
+			StatusCode: rgErr.StatusCode,
+			Code:       rgErr.Code,
+		}
+	}
+	return OutcomeFailed, rgErr
+}
+
+// WriteTable prints a line for each task and the totals of the batch
+func (r *BatchResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TASK\tVIDEO\tTITLE\tRESULT\tSIZE\tTIME\tRETRIES\tERROR")
+	for _, t := range r.Tasks() {
+		size := ""
+		if t.Bytes > 0 {
+			size = utils.FormatBytes(t.Bytes)
+		}
+		errText := ""
+		if t.Err != nil {
+			errText = t.Err.Type.String() + ": " + t.Err.Error()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", t.Task, t.VideoID, truncate(t.Title, 40),
+			t.Outcome, size, t.Duration.Round(time.Second), t.Retries, errText)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	duration := r.duration
+	r.mu.Unlock()
+	summary := fmt.Sprintf("%d downloaded, %d skipped, %d failed", r.Count(OutcomeSuccess), r.Count(OutcomeSkipped), r.Count(OutcomeFailed))
+	if n := r.Count(OutcomeInterrupted) + r.NotRun(); n > 0 {
+		summary += fmt.Sprintf(", %d not finished", n)
+	}
+	_, err := fmt.Fprintf(w, "%s (%s in %s)\n", summary, utils.FormatBytes(r.Bytes()), duration.Round(time.Second))
+	return err
+}
+
+func truncate(s string, n int) string {
+	if r := []rune(s); len(r) > n {
+		return string(r[:n-1]) + "…"
+	}
+	return s
+}
+
+// batchReport is the JSON form of a batch result
+type batchReport struct {
+	Started     time.Time    `json:"started"`
+	Duration    float64      `json:"duration"`
+	Total       int          `json:"total"`
+	Succeeded   int          `json:"succeeded"`
+	Skipped     int          `json:"skipped"`
+	Failed      int          `json:"failed"`
+	Interrupted int          `json:"interrupted"`
+	NotRun      int          `json:"not_run"`
+	Bytes       int64        `json:"bytes"`
+	Tasks       []taskReport `json:"tasks"`
+}
+
+type taskReport struct {
+	Task      int     `json:"task"`
+	VideoID   string  `json:"video_id,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	Outcome   Outcome `json:"outcome"`
+	Path      string  `json:"path,omitempty"`
+	Bytes     int64   `json:"bytes"`
+	Duration  float64 `json:"duration"`
+	Retries   int     `json:"retries"`
+	ErrorType string  `json:"error_type,omitempty"`
+	ErrorCode string  `json:"error_code,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func newTaskReport(t TaskResult) taskReport {
+	tr := taskReport{
+		Task:     t.Task,
+		VideoID:  t.VideoID,
+		Title:    t.Title,
+		Outcome:  t.Outcome,
+		Path:     t.Path,
+		Bytes:    t.Bytes,
+		Duration: t.Duration.Seconds(),
+		Retries:  t.Retries,
+	}
+	if t.Err != nil {
+		tr.ErrorType, tr.ErrorCode, tr.Error = t.Err.Type.String(), t.Err.ErrorCode(), t.Err.Error()
+	}
+	return tr
+}
+
+// WriteJSON writes the result as a JSON document. Durations are in
+// seconds.
+func (r *BatchResult) WriteJSON(w io.Writer) error {
+	report := batchReport{
+		Succeeded:   r.Count(OutcomeSuccess),
+		Skipped:     r.Count(OutcomeSkipped),
+		Failed:      r.Count(OutcomeFailed),
+		Interrupted: r.Count(OutcomeInterrupted),
+		NotRun:      r.NotRun(),
+		Bytes:       r.Bytes(),
+		Tasks:       []taskReport{},
+	}
+	r.mu.Lock()
+	report.Started, report.Duration, report.Total = r.started, r.duration.Seconds(), r.total
+	r.mu.Unlock()
+	for _, t := range r.Tasks() {
+		report.Tasks = append(report.Tasks, newTaskReport(t))
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// WriteCSV writes a row for each task
+func (r *BatchResult) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"task", "video_id", "title", "outcome", "path", "bytes", "duration", "retries", "error_type", "error_code", "error"})
+	for _, t := range r.Tasks() {
+		tr := newTaskReport(t)
+		cw.Write([]string{
+			strconv.Itoa(tr.Task), tr.VideoID, tr.Title, string(tr.Outcome), tr.Path,
+			strconv.FormatInt(tr.Bytes, 10), strconv.FormatFloat(tr.Duration, 'f', 3, 64),
+			strconv.Itoa(tr.Retries), tr.ErrorType, tr.ErrorCode, tr.Error,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteReport saves the result to path, as CSV if its extension is .csv
+// and as JSON otherwise
+func (r *BatchResult) WriteReport(path string) error {
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return errors.NewFileSystemError("failed to create report directory", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.NewFileSystemError("failed to create report", err)
+	}
+	write := r.WriteJSON
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		write = r.WriteCSV
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return errors.NewFileSystemError("failed to write report", err)
+	}
+	if err := file.Close(); err != nil {
+		return errors.NewFileSystemError("failed to write report", err)
+	}
+	return nil
+}
@@ -2,18 +2,20 @@ package downloader
 
 import (
 	"context"
+	stderrors "errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
-	"sync/atomic"
 	"time"
 
 	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/logging"
+	"github.com/MaVeN-13TTN/red_goose/internal/retry"
 	"github.com/MaVeN-13TTN/red_goose/internal/utils"
-	"github.com/schollz/progressbar/v3"
 )
 
 type DownloadOptions struct {
@@ -21,6 +23,19 @@ type DownloadOptions struct {
 	OutputDir    string
 	Filename     string
 	ShowProgress bool
+	// Progress, if set, receives snapshots of the download
+	Progress ProgressCallback
+	// Output receives the download's messages; they go to stdout if it is
+	// nil
+	Output io.Writer
+}
+
+// output returns the writer of the download's messages
+func (o DownloadOptions) output() io.Writer {
+	if o.Output == nil {
+		return os.Stdout
+	}
+	return o.Output
 }
 
 type Downloader struct {
@@ -35,43 +50,6 @@ func New() *Downloader {
 	}
 }
 
-type ProgressCallback func(downloaded, total int64, speed float64)
-
-type ProgressReader struct {
-	reader     io.Reader
-	total      int64
-	downloaded int64
-	callback   ProgressCallback
-	lastUpdate time.Time
-}
-
-func NewProgressReader(reader io.Reader, total int64, callback ProgressCallback) *ProgressReader {
-	return &ProgressReader{
-		reader:     reader,
-		total:      total,
-		callback:   callback,
-		lastUpdate: time.Now(),
-	}
-}
-
-func (pr *ProgressReader) Read(p []byte) (int, error) {
-	n, err := pr.reader.Read(p)
-	if n > 0 {
-		atomic.AddInt64(&pr.downloaded, int64(n))
-
-		// Update progress every 100ms to avoid too frequent updates
-		if time.Since(pr.lastUpdate) > 100*time.Millisecond {
-			downloaded := atomic.LoadInt64(&pr.downloaded)
-			if pr.callback != nil {
-				speed := float64(downloaded) / time.Since(pr.lastUpdate).Seconds()
-				pr.callback(downloaded, pr.total, speed)
-			}
-			pr.lastUpdate = time.Now()
-		}
-	}
-	return n, err
-}
-
 func (d *Downloader) Download(ctx context.Context, opts DownloadOptions) error {
 	// Create output directory if it doesn't exist
 	if err := utils.EnsureDir(opts.OutputDir); err != nil {
@@ -79,7 +57,7 @@ func (d *Downloader) Download(ctx context.Context, opts DownloadOptions) error {
 	}
 
 	// Retry the download operation with exponential backoff
-	return utils.RetryOperation(func() error {
+	return retryStep(ctx, "download", 2*time.Second, func(ctx context.Context) error {
 		// Create HTTP request
 		req, err := http.NewRequestWithContext(ctx, "GET", opts.URL, nil)
 		if err != nil {
@@ -97,7 +75,7 @@ func (d *Downloader) Download(ctx context.Context, opts DownloadOptions) error {
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return errors.NewNetworkError(fmt.Sprintf("bad status: %s", resp.Status), nil)
+			return errors.NewHTTPError(resp)
 		}
 
 		// Create output file
@@ -108,18 +86,8 @@ func (d *Downloader) Download(ctx context.Context, opts DownloadOptions) error {
 		}
 		defer file.Close()
 
-		// Setup progress bar if requested
-		var reader io.Reader = resp.Body
-		if opts.ShowProgress {
-			bar := progressbar.DefaultBytes(
-				resp.ContentLength,
-				fmt.Sprintf("Downloading %s", opts.Filename),
-			)
-
-			reader = NewProgressReader(resp.Body, resp.ContentLength, func(downloaded, total int64, speed float64) {
-				bar.Set64(downloaded)
-			})
-		}
+		// Setup progress tracking if requested
+		reader := progressReader(resp.Body, 0, resp.ContentLength, opts, opts.Progress)
 
 		// Copy response body to file
 		_, err = io.Copy(file, reader)
@@ -129,9 +97,10 @@ func (d *Downloader) Download(ctx context.Context, opts DownloadOptions) error {
 			return errors.NewDownloadError("failed to save file", err)
 		}
 
-		fmt.Printf("\nDownload completed: %s\n", outputPath)
+		fmt.Fprintf(opts.output(), "\nDownload completed: %s\n", outputPath)
+		logging.FromContext(ctx).Info("download completed", "path", outputPath)
 		return nil
-	}, 3, 2*time.Second)
+	})
 }
 
 func (d *Downloader) DownloadResumable(ctx context.Context, opts DownloadOptions) error {
@@ -142,15 +111,16 @@ func (d *Downloader) DownloadResumable(ctx context.Context, opts DownloadOptions
 
 	outputPath := filepath.Join(opts.OutputDir, opts.Filename)
 
-	// Check if partial file exists
-	var startByte int64 = 0
-	if stat, err := os.Stat(outputPath); err == nil {
-		startByte = stat.Size()
-		fmt.Printf("Resuming download from byte %d\n", startByte)
-	}
-
 	// Retry the download operation with exponential backoff
-	return utils.RetryOperation(func() error {
+	return retryStep(ctx, "download", 2*time.Second, func(ctx context.Context) error {
+		// Check if partial file exists, including one a failed attempt
+		// has added to
+		var startByte int64 = 0
+		if stat, err := os.Stat(outputPath); err == nil {
+			startByte = stat.Size()
+			fmt.Fprintf(opts.output(), "Resuming download from byte %d\n", startByte)
+		}
+
 		// Create HTTP request with Range header
 		req, err := http.NewRequestWithContext(ctx, "GET", opts.URL, nil)
 		if err != nil {
@@ -174,10 +144,10 @@ func (d *Downloader) DownloadResumable(ctx context.Context, opts DownloadOptions
 				// Server doesn't support resume, start over
 				startByte = 0
 			} else {
-				return errors.NewNetworkError(fmt.Sprintf("bad status: %s", resp.Status), nil)
+				return errors.NewHTTPError(resp)
 			}
 		} else if startByte == 0 && resp.StatusCode != http.StatusOK {
-			return errors.NewNetworkError(fmt.Sprintf("bad status: %s", resp.Status), nil)
+			return errors.NewHTTPError(resp)
 		}
 
 		// Open file for writing
@@ -192,29 +162,23 @@ func (d *Downloader) DownloadResumable(ctx context.Context, opts DownloadOptions
 		}
 		defer file.Close()
 
-		// Setup progress tracking
-		totalSize := startByte + resp.ContentLength
-		var writer io.Writer = file
-
-		if opts.ShowProgress {
-			bar := progressbar.NewOptions64(totalSize,
-				progressbar.OptionSetDescription(fmt.Sprintf("Downloading %s", opts.Filename)),
-				progressbar.OptionShowBytes(true),
-				progressbar.OptionSetWidth(50),
-			)
-			bar.Add64(startByte) // Set initial progress
-			writer = io.MultiWriter(file, bar)
+		// Setup progress tracking, counting the bytes already on disk
+		totalSize := resp.ContentLength
+		if totalSize >= 0 {
+			totalSize += startByte
 		}
+		reader := progressReader(resp.Body, startByte, totalSize, opts, opts.Progress)
 
 		// Copy response body to file
-		_, err = io.Copy(writer, resp.Body)
+		_, err = io.Copy(file, reader)
 		if err != nil {
 			return errors.NewDownloadError("failed to save file", err)
 		}
 
-		fmt.Printf("\nDownload completed: %s\n", outputPath)
+		fmt.Fprintf(opts.output(), "\nDownload completed: %s\n", outputPath)
+		logging.FromContext(ctx).Info("download completed", "path", outputPath)
 		return nil
-	}, 3, 2*time.Second)
+	})
 }
 
 func (d *Downloader) DownloadWithProgress(ctx context.Context, opts DownloadOptions, callback ProgressCallback) error {
@@ -224,7 +188,7 @@ func (d *Downloader) DownloadWithProgress(ctx context.Context, opts DownloadOpti
 	}
 
 	// Retry the download operation with exponential backoff
-	return utils.RetryOperation(func() error {
+	return retryStep(ctx, "download", 2*time.Second, func(ctx context.Context) error {
 		// Create HTTP request
 		req, err := http.NewRequestWithContext(ctx, "GET", opts.URL, nil)
 		if err != nil {
@@ -242,7 +206,7 @@ func (d *Downloader) DownloadWithProgress(ctx context.Context, opts DownloadOpti
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
-			return errors.NewNetworkError(fmt.Sprintf("bad status: %s", resp.Status), nil)
+			return errors.NewHTTPError(resp)
 		}
 
 		// Create output file
@@ -254,21 +218,76 @@ func (d *Downloader) DownloadWithProgress(ctx context.Context, opts DownloadOpti
 		defer file.Close()
 
 		// Setup progress reader
-		var reader io.Reader = resp.Body
-		if callback != nil {
-			reader = NewProgressReader(resp.Body, resp.ContentLength, callback)
-		}
+		reader := progressReader(resp.Body, 0, resp.ContentLength, opts, callback)
 
 		// Copy with progress tracking
-		_, err = io.Copy(file, reader)
+		written, err := io.Copy(file, reader)
 		if err != nil {
 			// If copy fails, try to remove the partial file
 			os.Remove(outputPath)
 			return errors.NewDownloadError("failed to save file", err)
 		}
 
+		logging.FromContext(ctx).Info("download completed", "path", outputPath, "bytes", written)
 		return nil
-	}, 3, 2*time.Second)
+	})
+}
+
+const (
+	// maxAttempts is how often a download step is tried
+	maxAttempts = 3
+	// maxRetryDelay caps the wait between the attempts of a step
+	maxRetryDelay = 30 * time.Second
+)
+
+// RetryFunc is told about a failed attempt of a download step that will be
+// tried again
+type RetryFunc func(attempt int, err error)
+
+type retryFuncKey struct{}
+
+// WithRetryFunc returns a context whose downloads report their retries to
+// fn, as well as to any RetryFunc ctx already has
+func WithRetryFunc(ctx context.Context, fn RetryFunc) context.Context {
+	if prev, ok := ctx.Value(retryFuncKey{}).(RetryFunc); ok {
+		next := fn
+		fn = func(attempt int, err error) {
+			prev(attempt, err)
+			next(attempt, err)
+		}
+	}
+	return context.WithValue(ctx, retryFuncKey{}, fn)
+}
+
+// retryStep runs a download step with exponential backoff and full jitter,
+// or the policy and limits of the context's retry options. Each attempt
+// gets a context whose logger records the attempt number, and failed
+// attempts are logged, marked with their attempt and, if they will be
+// tried again, reported to the context's RetryFunc.
+func retryStep(ctx context.Context, what string, initialDelay time.Duration, operation func(ctx context.Context) error) error {
+	onRetry, _ := ctx.Value(retryFuncKey{}).(RetryFunc)
+	opts := retry.OptionsFrom(ctx, retry.Options{
+		Policy:      retry.Exponential{Initial: initialDelay, Max: maxRetryDelay},
+		MaxAttempts: maxAttempts,
+	})
+	opts.OnRetry = func(a retry.Attempt) {
+		logging.FromContext(ctx).Debug("retrying "+what, "attempt", a.Number, "delay", a.Delay)
+		if onRetry != nil {
+			onRetry(a.Number, a.Err)
+		}
+	}
+	return retry.Do(ctx, opts, func(ctx context.Context, attempt int) error {
+		attemptCtx := logging.With(ctx, "attempt", attempt)
+		err := operation(attemptCtx)
+		if err != nil {
+			var rge *errors.RedGooseError
+			if stderrors.As(err, &rge) {
+				rge.WithContext(errors.ContextAttempt, attempt)
+			}
+			logging.FromContext(attemptCtx).Warn(what+" failed", "error", err)
+		}
+		return err
+	})
 }
 
 func SanitizeFilename(filename string) string {
@@ -288,8 +307,24 @@ func SanitizeFilename(filename string) string {
 	return sanitized
 }
 
+var namingFieldRegex = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// ExpandNamingPattern replaces the {field} placeholders of a naming pattern
+// such as "{author} - {title}" with the given values. Placeholders without
+// a value are left untouched.
+func ExpandNamingPattern(pattern string, fields map[string]string) string {
+	return namingFieldRegex.ReplaceAllStringFunc(pattern, func(placeholder string) string {
+		if value, ok := fields[placeholder[1:len(placeholder)-1]]; ok {
+			return value
+		}
+		return placeholder
+	})
+}
+
 func GetFileExtension(mimeType string) string {
 	switch {
+	case strings.HasPrefix(mimeType, "audio/mp4"):
+		return ".m4a"
 	case strings.Contains(mimeType, "mp4"):
 		return ".mp4"
 	case strings.Contains(mimeType, "webm"):
@@ -302,65 +337,3 @@ func GetFileExtension(mimeType string) string {
 		return ".unknown"
 	}
 }
-
-type BatchDownloader struct {
-	downloader *Downloader
-	maxWorkers int
-	semaphore  chan struct{}
-}
-
-func NewBatchDownloader(maxWorkers int) *BatchDownloader {
-	return &BatchDownloader{
-		downloader: New(),
-		maxWorkers: maxWorkers,
-		semaphore:  make(chan struct{}, maxWorkers),
-	}
-}
-
-func (bd *BatchDownloader) DownloadAll(ctx context.Context, tasks []DownloadOptions) error {
-	// Create a logger
-	logger := utils.NewLogger(false)
-
-	// Setup cleanup handler
-	utils.SetupCleanupHandler(func() {
-		logger.Info("Canceling downloads...")
-		// Nothing to clean up here, just let the goroutines finish
-	})
-
-	errChan := make(chan error, len(tasks))
-
-	for i, task := range tasks {
-		go func(idx int, opts DownloadOptions) {
-			// Recover from panics in goroutines
-			defer func() {
-				if r := recover(); r != nil {
-					logger.Error("Recovered from panic in download task %d: %v", idx, r)
-					errChan <- fmt.Errorf("task %d panicked: %v", idx, r)
-				}
-			}()
-
-			bd.semaphore <- struct{}{}        // Acquire
-			defer func() { <-bd.semaphore }() // Release
-
-			err := bd.downloader.Download(ctx, opts)
-			errChan <- err
-		}(i, task)
-	}
-
-	// Collect results
-	var errors []error
-	for i := 0; i < len(tasks); i++ {
-		if err := <-errChan; err != nil {
-			errors = append(errors, err)
-		}
-	}
-
-	if len(errors) > 0 {
-		if len(errors) == len(tasks) {
-			return fmt.Errorf("all downloads failed: %v", errors[0])
-		}
-		return fmt.Errorf("%d of %d downloads failed", len(errors), len(tasks))
-	}
-
-	return nil
-}
@@ -1,7 +1,13 @@
 package downloader
 
 import (
+    "bytes"
     "context"
+    "encoding/json"
+    stderrors "errors"
+    "fmt"
+    "io"
+    "net"
     "net/http"
     "net/http/httptest"
     "os"
@@ -9,6 +15,9 @@ import (
     "strings"
     "testing"
     "time"
+
+    "github.com/MaVeN-13TTN/red_goose/internal/errors"
+    "github.com/MaVeN-13TTN/red_goose/internal/retry"
 )
 
 func TestSanitizeFilename(t *testing.T) {
@@ -70,6 +79,11 @@ func TestGetFileExtension(t *testing.T) {
             mimeType: "audio/m4a",
             expected: ".m4a",
         },
+        {
+            name:     "MP4 audio",
+            mimeType: `audio/mp4; codecs="mp4a.40.2"`,
+            expected: ".m4a",
+        },
         {
             name:     "Unknown type",
             mimeType: "application/octet-stream",
@@ -87,6 +101,45 @@ func TestGetFileExtension(t *testing.T) {
     }
 }
 
+func TestExpandNamingPattern(t *testing.T) {
+    fields := map[string]string{
+        "title":  "My Video",
+        "author": "Someone",
+        "id":     "dQw4w9WgXcQ",
+    }
+
+    tests := []struct {
+        name     string
+        pattern  string
+        expected string
+    }{
+        {
+            name:     "Title only",
+            pattern:  "{title}",
+            expected: "My Video",
+        },
+        {
+            name:     "Several fields",
+            pattern:  "{author} - {title} [{id}]",
+            expected: "Someone - My Video [dQw4w9WgXcQ]",
+        },
+        {
+            name:     "Unknown field",
+            pattern:  "{title} {unknown}",
+            expected: "My Video {unknown}",
+        },
+    }
+
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            result := ExpandNamingPattern(tt.pattern, fields)
+            if result != tt.expected {
+                t.Errorf("ExpandNamingPattern(%q) = %q, want %q", tt.pattern, result, tt.expected)
+            }
+        })
+    }
+}
+
 func TestDownload(t *testing.T) {
     // Create a test server
     server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -137,3 +190,295 @@ func TestDownload(t *testing.T) {
         t.Errorf("Downloaded content = %q, want %q", string(content), "test content")
     }
 }
+
+func TestRetryReportsFailedAttempts(t *testing.T) {
+    var reported []int
+    ctx := WithRetryFunc(context.Background(), func(attempt int, err error) {
+        reported = append(reported, attempt)
+    })
+
+    calls := 0
+    err := retryStep(ctx, "request", time.Millisecond, func(ctx context.Context) error {
+        calls++
+        return errors.NewNetworkError(fmt.Sprintf("attempt %d failed", calls), nil)
+    })
+    if err == nil {
+        t.Fatal("retryStep succeeded, want the last error")
+    }
+    if calls != maxAttempts {
+        t.Errorf("operation ran %d times, want %d", calls, maxAttempts)
+    }
+    // The last failure is not retried, so it is not reported
+    if len(reported) != maxAttempts-1 || reported[0] != 1 || reported[1] != 2 {
+        t.Errorf("reported attempts %v, want [1 2]", reported)
+    }
+}
+
+func TestRetryStopsOnClientErrors(t *testing.T) {
+    requests := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        requests++
+        http.NotFound(w, r)
+    }))
+    defer server.Close()
+
+    err := New().Download(context.Background(), DownloadOptions{
+        URL:       server.URL,
+        OutputDir: t.TempDir(),
+        Filename:  "missing.txt",
+    })
+    if requests != 1 {
+        t.Errorf("made %d requests for a 404, want 1", requests)
+    }
+    var rge *errors.RedGooseError
+    if !stderrors.As(err, &rge) || rge.StatusCode != http.StatusNotFound || rge.ErrorCode() != errors.CodeNotFound {
+        t.Fatalf("error = %#v", err)
+    }
+    if rge.Context[errors.ContextURL] != server.URL || rge.Context[errors.ContextAttempt] != 1 {
+        t.Errorf("context = %v", rge.Context)
+    }
+}
+
+func TestRateEstimator(t *testing.T) {
+    start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+    at := func(seconds float64) time.Time {
+        return start.Add(time.Duration(seconds * float64(time.Second)))
+    }
+    // 1000 bytes were on disk before the download resumed
+    e := newRateEstimator(start, 1000)
+
+    p := e.update(at(1), 2000, 11000)
+    if p.Speed != 1000 || p.AverageSpeed != 1000 || p.ETA != 9*time.Second {
+        t.Errorf("first snapshot = %+v", p)
+    }
+
+    // The speed doesn't grow with the bytes downloaded at a steady rate
+    for i := 2; i <= 5; i++ {
+        p = e.update(at(float64(i)), 1000+int64(i)*1000, 11000)
+    }
+    if p.Speed != 1000 || p.AverageSpeed != 1000 || p.Elapsed != 5*time.Second {
+        t.Errorf("steady snapshot = %+v", p)
+    }
+
+    // A stall lowers the current speed but not to zero at once
+    p = e.update(at(6), 6000, 11000)
+    if p.Speed <= 0 || p.Speed >= 1000 {
+        t.Errorf("speed after a stall = %v, want between 0 and 1000", p.Speed)
+    }
+    if p.ETA <= 5*time.Second {
+        t.Errorf("ETA after a stall = %v, want more than 5s", p.ETA)
+    }
+
+    // Unknown sizes have no ETA
+    if p := e.update(at(7), 7000, -1); p.ETA != 0 {
+        t.Errorf("ETA of unknown size = %v", p.ETA)
+    }
+}
+
+func TestDownloadResumableProgress(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Header.Get("Range") != "bytes=5-" {
+            t.Errorf("Range = %q, want bytes=5-", r.Header.Get("Range"))
+        }
+        w.WriteHeader(http.StatusPartialContent)
+        io.WriteString(w, " world")
+    }))
+    defer server.Close()
+
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    var last Progress
+    err := New().DownloadResumable(context.Background(), DownloadOptions{
+        URL:       server.URL,
+        OutputDir: dir,
+        Filename:  "file.txt",
+        Progress:  func(p Progress) { last = p },
+    })
+    if err != nil {
+        t.Fatalf("DownloadResumable failed: %v", err)
+    }
+    // The bytes on disk count towards the progress
+    if last.Downloaded != 11 || last.Total != 11 {
+        t.Errorf("last progress = %+v, want 11 of 11 bytes", last)
+    }
+    content, _ := os.ReadFile(filepath.Join(dir, "file.txt"))
+    if string(content) != "hello world" {
+        t.Errorf("content = %q", content)
+    }
+}
+
+func TestDownloadResumableRetry(t *testing.T) {
+    const content = "hello world"
+    attempts := 0
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        attempts++
+        var start int
+        fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start)
+        w.Header().Set("Content-Length", fmt.Sprint(len(content)-start))
+        w.WriteHeader(http.StatusPartialContent)
+        if attempts == 1 {
+            // Send part of the body, then drop the connection
+            io.WriteString(w, content[start:start+3])
+            w.(http.Flusher).Flush()
+            conn, _, _ := w.(http.Hijacker).Hijack()
+            conn.Close()
+            return
+        }
+        io.WriteString(w, content[start:])
+    }))
+    defer server.Close()
+
+    dir := t.TempDir()
+    if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("he"), 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    var out bytes.Buffer
+    ctx := retry.WithOptions(context.Background(), retry.Options{Policy: retry.Constant{Interval: time.Millisecond}})
+    err := New().DownloadResumable(ctx, DownloadOptions{
+        URL:          server.URL,
+        OutputDir:    dir,
+        Filename:     "file.txt",
+        ShowProgress: true,
+        Output:       &out,
+    })
+    if err != nil {
+        t.Fatalf("DownloadResumable failed: %v", err)
+    }
+    if attempts != 2 {
+        t.Errorf("attempts = %d, want 2", attempts)
+    }
+    // The second attempt resumes after the bytes the first one appended
+    got, _ := os.ReadFile(filepath.Join(dir, "file.txt"))
+    if string(got) != content {
+        t.Errorf("content = %q, want %q", got, content)
+    }
+    if !strings.Contains(out.String(), "Resuming download from byte 5") || !strings.Contains(out.String(), "Downloading file.txt") {
+        t.Errorf("output does not show the resume and the progress bar:\n%s", out.String())
+    }
+}
+
+func TestBatchResult(t *testing.T) {
+    result := NewBatchResult(4)
+    result.Add(TaskResult{Task: 2, VideoID: "bbb", Title: "Second", Outcome: OutcomeFailed, Retries: 2,
+        Err: errors.NewNetworkError("failed to download", fmt.Errorf("connection reset"))})
+    result.Add(TaskResult{Task: 1, VideoID: "aaa", Title: "First", Outcome: OutcomeSuccess, Path: "out/First.mp4", Bytes: 2048})
+    result.Add(TaskResult{Task: 3, VideoID: "ccc", Outcome: OutcomeSkipped})
+    result.Finish()
+
+    if n := result.NotRun(); n != 1 {
+        t.Errorf("NotRun() = %d, want 1", n)
+    }
+
+    err := result.Err()
+    batchErr, ok := err.(*BatchError)
+    if !ok || batchErr.Failed != 1 || batchErr.Succeeded != 2 || batchErr.Total != 4 || !batchErr.Partial() {
+        t.Fatalf("Err() = %#v", err)
+    }
+    if err.Error() != "1 of 4 downloads failed: failed to download: connection reset" {
+        t.Errorf("Err() = %q", err.Error())
+    }
+
+    var table bytes.Buffer
+    result.WriteTable(&table)
+    lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+    if len(lines) != 5 || !strings.HasPrefix(lines[1], "1 ") || !strings.Contains(lines[1], "2.0 KiB") ||
+        !strings.Contains(lines[2], "network: failed to download") {
+        t.Errorf("table:\n%s", table.String())
+    }
+    if !strings.HasPrefix(lines[4], "1 downloaded, 1 skipped, 1 failed, 1 not finished (2.0 KiB in") {
+        t.Errorf("totals = %q", lines[4])
+    }
+
+    var report struct {
+        Total, Succeeded, Failed, NotRun int
+        Tasks []struct {
+            Task      int
+            Outcome   string
+            ErrorType string `json:"error_type"`
+            Retries   int
+        }
+    }
+    var buf bytes.Buffer
+    if err := result.WriteJSON(&buf); err != nil {
+        t.Fatal(err)
+    }
+    if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+        t.Fatal(err)
+    }
+    if report.Total != 4 || report.Succeeded != 1 || report.Failed != 1 || len(report.Tasks) != 3 {
+        t.Errorf("JSON report = %+v", report)
+    }
+    if tr := report.Tasks[1]; tr.Task != 2 || tr.Outcome != "failed" || tr.ErrorType != "network" || tr.Retries != 2 {
+        t.Errorf("JSON task = %+v", tr)
+    }
+
+    buf.Reset()
+    if err := result.WriteCSV(&buf); err != nil {
+        t.Fatal(err)
+    }
+    rows := strings.Split(strings.TrimSpace(buf.String()), "\n")
+    if len(rows) != 4 || rows[2] != "2,bbb,Second,failed,,0,0.000,2,network,network,failed to download: connection reset" {
+        t.Errorf("CSV:\n%s", buf.String())
+    }
+}
+
+func TestBatchResultStoppedEarly(t *testing.T) {
+    // Without --skip-errors the batch stops at its first failure
+    result := NewBatchResult(3)
+    result.Add(TaskResult{Task: 1, VideoID: "aaa", Outcome: OutcomeFailed,
+        Err: errors.NewDownloadError("failed to download", nil)})
+    result.Finish()
+
+    batchErr, ok := result.Err().(*BatchError)
+    if !ok || batchErr.Failed != 1 || batchErr.Succeeded != 0 || batchErr.Total != 3 {
+        t.Fatalf("Err() = %#v", result.Err())
+    }
+    if batchErr.Partial() {
+        t.Errorf("Partial() = true, want false when no task succeeded")
+    }
+    if n := result.NotRun(); n != 2 {
+        t.Errorf("NotRun() = %d, want 2", n)
+    }
+}
+
+func TestTaskOutcome(t *testing.T) {
+    typed := errors.NewFileSystemError("failed to create file", nil)
+    tests := []struct {
+        name    string
+        err     error
+        skipped bool
+        want    Outcome
+        typ     errors.ErrorType
+        message string
+    }{
+        {"success", nil, false, OutcomeSuccess, 0, ""},
+        {"skipped", nil, true, OutcomeSkipped, 0, ""},
+        {"interrupted", fmt.Errorf("download: %w", context.Canceled), false, OutcomeInterrupted, 0, ""},
+        {"typed", typed, false, OutcomeFailed, errors.ErrorTypeFileSystem, "failed to create file"},
+        {"wrapped", fmt.Errorf("video abc: %w", typed), false, OutcomeFailed, errors.ErrorTypeFileSystem, "video abc: failed to create file"},
+        {"untyped", fmt.Errorf("boom"), false, OutcomeFailed, errors.ErrorTypeDownload, "boom"},
+        {"connection", errors.NewExtractionError("failed to get video info", &net.OpError{Op: "dial", Err: fmt.Errorf("refused")}),
+            false, OutcomeFailed, errors.ErrorTypeNetwork, "failed to get video info: dial: refused"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            outcome, err := TaskOutcome(tt.err, tt.skipped)
+            if outcome != tt.want {
+                t.Errorf("outcome = %s, want %s", outcome, tt.want)
+            }
+            if tt.message == "" {
+                if err != nil {
+                    t.Errorf("error = %v, want none", err)
+                }
+                return
+            }
+            if err == nil || err.Type != tt.typ || err.Error() != tt.message {
+                t.Errorf("error = %#v, want %s %q", err, tt.typ, tt.message)
+            }
+        })
+    }
+}
@@ -0,0 +1,331 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/hls"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
+	"github.com/schollz/progressbar/v3"
+)
+
+// liveEdgeSegments is how many segments behind the live edge a recording
+// starts when not recording from the start, as recommended by the HLS spec
+const liveEdgeSegments = 3
+
+// LiveOptions configures the recording of a live stream
+type LiveOptions struct {
+	ManifestURL  string
+	Quality      string
+	OutputDir    string
+	Filename     string
+	FromStart    bool
+	Duration     time.Duration
+	ShowProgress bool
+}
+
+// LiveResult describes a finished recording
+type LiveResult struct {
+	Path        string
+	Segments    int
+	Bytes       int64
+	Duration    time.Duration
+	Interrupted bool
+}
+
+// LiveRecorder records HLS live streams
+type LiveRecorder struct {
+	client *http.Client
+	// PollInterval overrides the playlist refresh interval, which otherwise
+	// follows the playlist's target duration
+	PollInterval time.Duration
+}
+
+func NewLiveRecorder() *LiveRecorder {
+	return &LiveRecorder{
+		client: &http.Client{
+			Timeout: time.Minute,
+		},
+	}
+}
+
+// Record appends the segments of an HLS live stream to a single file until
+// the stream ends, the requested duration has been captured or ctx is
+// canceled. Segments are written whole, so canceling ctx leaves a playable
+// file rather than a truncated segment.
+func (r *LiveRecorder) Record(ctx context.Context, opts LiveOptions) (*LiveResult, error) {
+	if err := utils.EnsureDir(opts.OutputDir); err != nil {
+		return nil, errors.NewFileSystemError("failed to create output directory", err)
+	}
+
+	mediaURL, err := r.resolveMediaPlaylist(ctx, opts.ManifestURL, opts.Quality)
+	if err != nil {
+		return nil, err
+	}
+
+	outputPath := filepath.Join(opts.OutputDir, opts.Filename)
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, errors.NewFileSystemError("failed to create file", err)
+	}
+
+	result := &LiveResult{Path: outputPath}
+	recordErr := r.record(ctx, file, mediaURL, opts, result)
+
+	if err := file.Sync(); err != nil && recordErr == nil {
+		recordErr = errors.NewFileSystemError("failed to flush recording", err)
+	}
+	if err := file.Close(); err != nil && recordErr == nil {
+		recordErr = errors.NewFileSystemError("failed to close recording", err)
+	}
+
+	if recordErr != nil && ctx.Err() != nil {
+		// Interrupted while waiting for or fetching a segment; everything
+		// written so far is complete and kept.
+		result.Interrupted = true
+		recordErr = nil
+	}
+
+	return result, recordErr
+}
+
+func (r *LiveRecorder) record(ctx context.Context, file *os.File, mediaURL string, opts LiveOptions, result *LiveResult) error {
+	var bar *progressbar.ProgressBar
+	if opts.ShowProgress {
+		bar = progressbar.DefaultBytes(-1, fmt.Sprintf("Recording %s", opts.Filename))
+		defer bar.Finish()
+	}
+
+	nextSeq := int64(-1)
+
+	for {
+		playlist, err := r.fetchMediaPlaylist(ctx, mediaURL)
+		if err != nil {
+			return err
+		}
+
+		if nextSeq < 0 {
+			nextSeq = startSequence(playlist, opts.FromStart)
+		}
+
+		for _, seg := range playlist.Segments {
+			if seg.Sequence < nextSeq {
+				continue
+			}
+
+			data, err := r.fetchSegment(ctx, seg.URI)
+			if err != nil {
+				return err
+			}
+
+			if _, err := file.Write(data); err != nil {
+				return errors.NewFileSystemError("failed to write segment", err)
+			}
+
+			result.Segments++
+			result.Bytes += int64(len(data))
+			result.Duration += seg.Duration
+			nextSeq = seg.Sequence + 1
+
+			if bar != nil {
+				bar.Add(len(data))
+			}
+
+			if opts.Duration > 0 && result.Duration >= opts.Duration {
+				return nil
+			}
+		}
+
+		if playlist.EndList {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.pollInterval(playlist)):
+		}
+	}
+}
+
+// RecordSections downloads the segments of an HLS stream that overlap the
+// given sections, timed from the first segment the playlist lists, to a
+// single file. Segments are kept whole, so each section may start and end a
+// few seconds early or late.
+func (r *LiveRecorder) RecordSections(ctx context.Context, opts LiveOptions, sections []Section) (*LiveResult, error) {
+	if len(sections) == 0 {
+		return nil, errors.NewValidationError("no sections to download", nil)
+	}
+	if err := utils.EnsureDir(opts.OutputDir); err != nil {
+		return nil, errors.NewFileSystemError("failed to create output directory", err)
+	}
+
+	mediaURL, err := r.resolveMediaPlaylist(ctx, opts.ManifestURL, opts.Quality)
+	if err != nil {
+		return nil, err
+	}
+	playlist, err := r.fetchMediaPlaylist(ctx, mediaURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []hls.Segment
+	var at time.Duration
+	for _, seg := range playlist.Segments {
+		for _, s := range sections {
+			if s.Overlaps(at, at+seg.Duration) {
+				segments = append(segments, seg)
+				break
+			}
+		}
+		at += seg.Duration
+	}
+	if len(segments) == 0 {
+		return nil, errors.NewValidationError(fmt.Sprintf("the sections are past the end of the stream (%s)", at.Round(time.Second)), nil)
+	}
+
+	outputPath := filepath.Join(opts.OutputDir, opts.Filename)
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return nil, errors.NewFileSystemError("failed to create file", err)
+	}
+
+	var bar *progressbar.ProgressBar
+	if opts.ShowProgress {
+		bar = progressbar.Default(int64(len(segments)), fmt.Sprintf("Downloading sections of %s", opts.Filename))
+	}
+
+	result := &LiveResult{Path: outputPath}
+	for _, seg := range segments {
+		data, err := r.fetchSegment(ctx, seg.URI)
+		if err == nil {
+			_, err = file.Write(data)
+			if err != nil {
+				err = errors.NewFileSystemError("failed to write segment", err)
+			}
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(outputPath)
+			return nil, err
+		}
+		result.Segments++
+		result.Bytes += int64(len(data))
+		result.Duration += seg.Duration
+		if bar != nil {
+			bar.Add(1)
+		}
+	}
+
+	if err := file.Close(); err != nil {
+		return nil, errors.NewFileSystemError("failed to close file", err)
+	}
+	return result, nil
+}
+
+// startSequence returns the first segment to record: the oldest segment the
+// server still lists when recording from the start, otherwise a few segments
+// behind the live edge.
+func startSequence(playlist *hls.MediaPlaylist, fromStart bool) int64 {
+	if len(playlist.Segments) == 0 {
+		return playlist.MediaSequence
+	}
+	if fromStart || playlist.EndList || len(playlist.Segments) <= liveEdgeSegments {
+		return playlist.Segments[0].Sequence
+	}
+	return playlist.Segments[len(playlist.Segments)-liveEdgeSegments].Sequence
+}
+
+func (r *LiveRecorder) pollInterval(playlist *hls.MediaPlaylist) time.Duration {
+	if r.PollInterval > 0 {
+		return r.PollInterval
+	}
+	if playlist.TargetDuration > 0 {
+		return playlist.TargetDuration
+	}
+	return 5 * time.Second
+}
+
+func (r *LiveRecorder) resolveMediaPlaylist(ctx context.Context, manifestURL, quality string) (string, error) {
+	body, base, err := r.fetch(ctx, manifestURL)
+	if err != nil {
+		return "", err
+	}
+
+	if !hls.IsMaster(string(body)) {
+		return manifestURL, nil
+	}
+
+	master, err := hls.ParseMaster(bytes.NewReader(body), base)
+	if err != nil {
+		return "", errors.NewExtractionError("failed to parse master playlist", err)
+	}
+
+	return master.SelectVariant(quality).URI, nil
+}
+
+func (r *LiveRecorder) fetchMediaPlaylist(ctx context.Context, mediaURL string) (*hls.MediaPlaylist, error) {
+	var playlist *hls.MediaPlaylist
+
+	err := retryStep(ctx, "playlist request", time.Second, func(ctx context.Context) error {
+		body, base, err := r.fetch(ctx, mediaURL)
+		if err != nil {
+			return err
+		}
+		playlist, err = hls.ParseMedia(bytes.NewReader(body), base)
+		if err != nil {
+			return errors.NewExtractionError("failed to parse media playlist", err)
+		}
+		return nil
+	})
+
+	return playlist, err
+}
+
+func (r *LiveRecorder) fetchSegment(ctx context.Context, segmentURL string) ([]byte, error) {
+	var data []byte
+
+	err := retryStep(ctx, "segment request", time.Second, func(ctx context.Context) error {
+		body, _, err := r.fetch(ctx, segmentURL)
+		if err != nil {
+			return err
+		}
+		data = body
+		return nil
+	})
+
+	return data, err
+}
+
+func (r *LiveRecorder) fetch(ctx context.Context, rawURL string) ([]byte, *url.URL, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, nil, errors.NewNetworkError("failed to create request", err)
+	}
+	req.Header.Set("User-Agent", "red-goose/1.0")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, nil, errors.NewNetworkError("failed to fetch "+rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, errors.NewHTTPError(resp)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, errors.NewDownloadError("failed to read response", err)
+	}
+
+	return body, resp.Request.URL, nil
+}
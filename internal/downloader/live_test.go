@@ -0,0 +1,178 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// liveServer serves a master playlist with a single variant whose
+// sliding-window media playlist gains a segment on every refresh, optionally
+// ending after a number of segments.
+func liveServer(window, endAfter int) *httptest.Server {
+	var refreshes int64
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/master.m3u8" {
+			w.Write([]byte("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1000,RESOLUTION=640x360\nindex.m3u8\n"))
+			return
+		}
+		if strings.HasSuffix(r.URL.Path, ".ts") {
+			fmt.Fprintf(w, "[%s]", strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/seg"), ".ts"))
+			return
+		}
+
+		last := int(atomic.AddInt64(&refreshes, 1)) + window - 2
+		if endAfter > 0 && last > endAfter-1 {
+			last = endAfter - 1
+		}
+		first := last - window + 1
+		if first < 0 {
+			first = 0
+		}
+
+		var b strings.Builder
+		b.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:2\n")
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", first)
+		for seq := first; seq <= last; seq++ {
+			fmt.Fprintf(&b, "#EXTINF:2.0,\nseg%d.ts\n", seq)
+		}
+		if endAfter > 0 && last == endAfter-1 {
+			b.WriteString("#EXT-X-ENDLIST\n")
+		}
+		w.Write([]byte(b.String()))
+	}))
+}
+
+func TestLiveRecorder(t *testing.T) {
+	tests := []struct {
+		name      string
+		fromStart bool
+		duration  time.Duration
+		want      string
+	}{
+		{
+			name:      "From start until end of stream",
+			fromStart: true,
+			want:      "[0][1][2][3][4][5][6][7]",
+		},
+		{
+			name: "From live edge until end of stream",
+			want: "[2][3][4][5][6][7]",
+		},
+		{
+			name:      "Duration limit",
+			fromStart: true,
+			duration:  7 * time.Second,
+			want:      "[0][1][2][3]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := liveServer(5, 8)
+			defer server.Close()
+
+			tempDir := t.TempDir()
+			recorder := NewLiveRecorder()
+			recorder.PollInterval = time.Millisecond
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			result, err := recorder.Record(ctx, LiveOptions{
+				ManifestURL: server.URL + "/master.m3u8",
+				OutputDir:   tempDir,
+				Filename:    "live.ts",
+				FromStart:   tt.fromStart,
+				Duration:    tt.duration,
+			})
+			if err != nil {
+				t.Fatalf("Record failed: %v", err)
+			}
+			if result.Interrupted {
+				t.Error("recording reported as interrupted")
+			}
+
+			content, err := os.ReadFile(result.Path)
+			if err != nil {
+				t.Fatalf("Failed to read recording: %v", err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("recorded %q, want %q", content, tt.want)
+			}
+		})
+	}
+}
+
+func TestLiveRecorderInterrupted(t *testing.T) {
+	server := liveServer(3, 0)
+	defer server.Close()
+
+	recorder := NewLiveRecorder()
+	recorder.PollInterval = 10 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	result, err := recorder.Record(ctx, LiveOptions{
+		ManifestURL: server.URL + "/index.m3u8",
+		OutputDir:   t.TempDir(),
+		Filename:    "live.ts",
+	})
+	if err != nil {
+		t.Fatalf("Record failed: %v", err)
+	}
+	if !result.Interrupted {
+		t.Error("expected recording to be reported as interrupted")
+	}
+
+	content, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatalf("Failed to read recording: %v", err)
+	}
+	if result.Segments == 0 || !strings.HasSuffix(string(content), "]") {
+		t.Errorf("expected whole segments to be kept, got %q", content)
+	}
+}
+
+func TestRecordSections(t *testing.T) {
+	server := liveServer(5, 5)
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder := NewLiveRecorder()
+	result, err := recorder.RecordSections(context.Background(), LiveOptions{
+		ManifestURL: server.URL + "/master.m3u8",
+		OutputDir:   dir,
+		Filename:    "clip.ts",
+	}, []Section{{Start: 3 * time.Second, End: 5 * time.Second}, {Start: 9 * time.Second}})
+	if err != nil {
+		t.Fatalf("RecordSections() error = %v", err)
+	}
+
+	data, err := os.ReadFile(result.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "[1][2][4]"; got != want {
+		t.Errorf("recorded %q, want %q", got, want)
+	}
+	if result.Segments != 3 || result.Duration != 6*time.Second {
+		t.Errorf("result = %+v", result)
+	}
+
+	if _, err := recorder.RecordSections(context.Background(), LiveOptions{
+		ManifestURL: server.URL + "/master.m3u8",
+		OutputDir:   dir,
+		Filename:    "late.ts",
+	}, []Section{{Start: time.Minute}}); err == nil {
+		t.Error("RecordSections() accepted a section past the end")
+	}
+}
@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+)
+
+const (
+	// progressInterval is how often a ProgressReader reports
+	progressInterval = 100 * time.Millisecond
+	// speedWindow is the time constant of the current speed: bytes
+	// downloaded this long ago weigh about a third as much as bytes just
+	// downloaded
+	speedWindow = 3 * time.Second
+)
+
+// Progress is a snapshot of a download
+type Progress struct {
+	// Downloaded counts the bytes of the file so far, including any that
+	// an earlier, resumed download fetched
+	Downloaded int64
+	// Total is the size of the file, or 0 or less when it is not known
+	Total int64
+	// Speed is the current transfer rate in bytes per second, smoothed
+	// over the last few seconds
+	Speed float64
+	// AverageSpeed is the transfer rate since the download started
+	AverageSpeed float64
+	// ETA is the time left at the current speed, 0 when it is not known
+	ETA time.Duration
+	// Elapsed is the time since the download started
+	Elapsed time.Duration
+}
+
+// ProgressCallback receives snapshots of a download as it goes
+type ProgressCallback func(Progress)
+
+// rateEstimator turns byte counts into transfer rates. The current speed
+// is an exponentially weighted moving average, so it follows changes in
+// the connection within a few seconds without jumping at every read.
+type rateEstimator struct {
+	start     time.Time
+	offset    int64
+	last      time.Time
+	lastBytes int64
+	speed     float64
+}
+
+// newRateEstimator starts measuring at start, with offset bytes already
+// downloaded that don't count towards the speed
+func newRateEstimator(start time.Time, offset int64) *rateEstimator {
+	return &rateEstimator{start: start, offset: offset, last: start, lastBytes: offset}
+}
+
+// update takes the bytes downloaded by now and returns the snapshot
+func (e *rateEstimator) update(now time.Time, downloaded, total int64) Progress {
+	if dt := now.Sub(e.last); dt > 0 {
+		rate := float64(downloaded-e.lastBytes) / dt.Seconds()
+		if e.last.Equal(e.start) {
+			e.speed = rate
+		} else {
+			// The weight of the new rate grows with the time it covers
+			weight := 1 - math.Exp(-dt.Seconds()/speedWindow.Seconds())
+			e.speed += weight * (rate - e.speed)
+		}
+		e.last, e.lastBytes = now, downloaded
+	}
+
+	p := Progress{
+		Downloaded: downloaded,
+		Total:      total,
+		Speed:      e.speed,
+		Elapsed:    now.Sub(e.start),
+	}
+	if p.Elapsed > 0 {
+		p.AverageSpeed = float64(downloaded-e.offset) / p.Elapsed.Seconds()
+	}
+	if total > 0 && downloaded < total {
+		speed := p.Speed
+		if speed <= 0 {
+			speed = p.AverageSpeed
+		}
+		if speed > 0 {
+			p.ETA = time.Duration(float64(total-downloaded) / speed * float64(time.Second))
+		}
+	}
+	return p
+}
+
+// ProgressReader reports the progress of the reads from a download's body
+type ProgressReader struct {
+	reader     io.Reader
+	total      int64
+	downloaded int64
+	callback   ProgressCallback
+	estimator  *rateEstimator
+	lastReport time.Time
+	now        func() time.Time
+}
+
+// NewProgressReader reports the reads from reader to callback, at most
+// every 100ms and once more at the end. total is the size of the file, or
+// 0 or less when it is not known.
+func NewProgressReader(reader io.Reader, total int64, callback ProgressCallback) *ProgressReader {
+	return newProgressReader(reader, 0, total, callback)
+}
+
+// newProgressReader is NewProgressReader for a download that continues
+// after offset bytes
+func newProgressReader(reader io.Reader, offset, total int64, callback ProgressCallback) *ProgressReader {
+	now := time.Now()
+	return &ProgressReader{
+		reader:     reader,
+		total:      total,
+		downloaded: offset,
+		callback:   callback,
+		estimator:  newRateEstimator(now, offset),
+		lastReport: now,
+		now:        time.Now,
+	}
+}
+
+func (pr *ProgressReader) Read(p []byte) (int, error) {
+	n, err := pr.reader.Read(p)
+	pr.downloaded += int64(n)
+	now := pr.now()
+	if (n > 0 && now.Sub(pr.lastReport) >= progressInterval) || err == io.EOF {
+		pr.lastReport = now
+		if pr.callback != nil {
+			pr.callback(pr.estimator.update(now, pr.downloaded, pr.total))
+		}
+	}
+	return n, err
+}
+
+// newBytesBar returns a progress bar of bytes like progressbar.DefaultBytes
+// that draws on the download's output, or on stderr if it has none
+func newBytesBar(opts DownloadOptions, total int64, description string) *progressbar.ProgressBar {
+	w := opts.Output
+	if w == nil {
+		w = os.Stderr
+	}
+	return progressbar.NewOptions64(total,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(w),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionShowTotalBytes(true),
+		progressbar.OptionSetWidth(10),
+		progressbar.OptionThrottle(65*time.Millisecond),
+		progressbar.OptionShowCount(),
+		progressbar.OptionOnCompletion(func() { fmt.Fprint(w, "\n") }),
+		progressbar.OptionSpinnerType(14),
+		progressbar.OptionFullWidth(),
+		progressbar.OptionSetRenderBlankState(true),
+	)
+}
+
+// progressReader wraps the body of a download to report its progress to
+// callback and, if opts.ShowProgress is set, on a progress bar. offset is
+// the number of bytes a resumed download already has.
+func progressReader(body io.Reader, offset, total int64, opts DownloadOptions, callback ProgressCallback) io.Reader {
+	if opts.ShowProgress {
+		bar := newBytesBar(opts, total, fmt.Sprintf("Downloading %s", opts.Filename))
+		bar.Set64(offset)
+		next := callback
+		callback = func(p Progress) {
+			bar.Set64(p.Downloaded)
+			if next != nil {
+				next(p)
+			}
+		}
+	}
+	if callback == nil {
+		return body
+	}
+	return newProgressReader(body, offset, total, callback)
+}
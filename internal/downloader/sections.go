@@ -0,0 +1,374 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/mkv"
+	"github.com/MaVeN-13TTN/red_goose/internal/mp4"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
+)
+
+// rangeSkipLimit is the largest gap a range reader reads through rather
+// than starting a new request
+const rangeSkipLimit = 1 << 20
+
+// Section is a span of a video to download. An End of zero means the end
+// of the video.
+type Section struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseSections parses --download-sections values of the form
+// "*START-END", such as "*10:00-15:30" or "*90-inf". Times are seconds or
+// [HH:]MM:SS with optional fractions; an empty start is the beginning and an
+// empty or "inf" end the end of the video. Overlapping sections are merged
+// and the result is sorted.
+func ParseSections(specs []string) ([]Section, error) {
+	var sections []Section
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if !strings.HasPrefix(spec, "*") {
+			return nil, errors.NewValidationError(
+				fmt.Sprintf("invalid section %q: time ranges start with *, e.g. *10:00-15:30", spec), nil)
+		}
+		start, end, ok := strings.Cut(spec[1:], "-")
+		if !ok {
+			return nil, errors.NewValidationError(fmt.Sprintf("invalid section %q: missing -", spec), nil)
+		}
+
+		var s Section
+		var err error
+		if start != "" {
+			if s.Start, err = parseTimestamp(start); err != nil {
+				return nil, errors.NewValidationError(fmt.Sprintf("invalid section %q", spec), err)
+			}
+		}
+		if end != "" && end != "inf" {
+			if s.End, err = parseTimestamp(end); err != nil {
+				return nil, errors.NewValidationError(fmt.Sprintf("invalid section %q", spec), err)
+			}
+			if s.End <= s.Start {
+				return nil, errors.NewValidationError(fmt.Sprintf("invalid section %q: ends before it starts", spec), nil)
+			}
+		}
+		sections = append(sections, s)
+	}
+
+	sort.Slice(sections, func(i, j int) bool { return sections[i].Start < sections[j].Start })
+	var merged []Section
+	for _, s := range sections {
+		if n := len(merged); n > 0 && (merged[n-1].End == 0 || s.Start <= merged[n-1].End) {
+			if merged[n-1].End != 0 && (s.End == 0 || s.End > merged[n-1].End) {
+				merged[n-1].End = s.End
+			}
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged, nil
+}
+
+// parseTimestamp parses seconds or [HH:]MM:SS, each with optional fractions
+func parseTimestamp(s string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var seconds float64
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil || v < 0 || math.IsInf(v, 0) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		if i < len(parts)-1 && v != math.Trunc(v) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		seconds = seconds*60 + v
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Overlaps reports whether the section overlaps the span [start, end)
+func (s Section) Overlaps(start, end time.Duration) bool {
+	return end > s.Start && (s.End == 0 || start < s.End)
+}
+
+// ClipSpan returns the parts of the span [start, end) of a video of the
+// given duration that the sections keep, as start and end times in the
+// joined output. Adjacent parts are merged. A duration of zero is unknown.
+func ClipSpan(sections []Section, duration, start, end time.Duration) [][2]time.Duration {
+	if duration <= 0 {
+		duration = math.MaxInt64
+	}
+	var parts [][2]time.Duration
+	var offset time.Duration
+	for _, s := range sections {
+		sectionEnd := s.End
+		if sectionEnd == 0 || sectionEnd > duration {
+			sectionEnd = duration
+		}
+		from, to := max(start, s.Start), min(end, sectionEnd)
+		if to > from {
+			part := [2]time.Duration{offset + from - s.Start, offset + to - s.Start}
+			if n := len(parts); n > 0 && parts[n-1][1] == part[0] {
+				parts[n-1][1] = part[1]
+			} else {
+				parts = append(parts, part)
+			}
+		}
+		offset += max(sectionEnd-s.Start, 0)
+	}
+	return parts
+}
+
+// ClippedDuration returns the length of the sections of a video of the
+// given duration
+func ClippedDuration(sections []Section, duration time.Duration) time.Duration {
+	var total time.Duration
+	for _, part := range ClipSpan(sections, duration, 0, duration) {
+		total += part[1] - part[0]
+	}
+	return total
+}
+
+// DownloadSections downloads only the given sections of a media file,
+// joined in order. MP4 and WebM files are read with range requests guided
+// by their index, so only the media of the sections and the index are
+// fetched; servers without range support get a full download that is cut
+// afterwards. Cuts fall on keyframes.
+func (d *Downloader) DownloadSections(ctx context.Context, opts DownloadOptions, sections []Section) error {
+	if len(sections) == 0 {
+		return errors.NewValidationError("no sections to download", nil)
+	}
+	if err := utils.EnsureDir(opts.OutputDir); err != nil {
+		return errors.NewFileSystemError("failed to create output directory", err)
+	}
+	outputPath := filepath.Join(opts.OutputDir, opts.Filename)
+
+	r, err := openRange(ctx, d.client, opts.URL)
+	if err == errNoRanges {
+		return d.downloadAndClip(ctx, opts, sections)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if opts.ShowProgress {
+		bar := newBytesBar(opts, -1, fmt.Sprintf("Downloading sections of %s", opts.Filename))
+		defer bar.Finish()
+		r.progress = func(n int) { bar.Add(n) }
+	}
+
+	if err := clip(r, r.size, outputPath, sections); err != nil {
+		if r.err != nil {
+			return r.err
+		}
+		return err
+	}
+
+	fmt.Fprintf(opts.output(), "\nDownload completed: %s\n", outputPath)
+	return nil
+}
+
+// downloadAndClip downloads the whole file next to the output and cuts the
+// sections out of it
+func (d *Downloader) downloadAndClip(ctx context.Context, opts DownloadOptions, sections []Section) error {
+	full := opts
+	full.Filename = opts.Filename + ".full"
+	if err := d.Download(ctx, full); err != nil {
+		return err
+	}
+	fullPath := filepath.Join(opts.OutputDir, full.Filename)
+	defer os.Remove(fullPath)
+
+	f, err := os.Open(fullPath)
+	if err != nil {
+		return errors.NewFileSystemError("failed to open download", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return errors.NewFileSystemError("failed to open download", err)
+	}
+
+	return clip(f, info.Size(), filepath.Join(opts.OutputDir, opts.Filename), sections)
+}
+
+// clip writes the sections of a media file read through r to dst
+func clip(r io.ReaderAt, size int64, dst string, sections []Section) error {
+	magic := make([]byte, 8)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return errors.NewDownloadError("failed to read media header", err)
+	}
+
+	var err error
+	switch {
+	case string(magic[4:8]) == "ftyp":
+		ranges := make([]mp4.Range, len(sections))
+		for i, s := range sections {
+			ranges[i] = mp4.Range{Start: s.Start, End: s.End}
+		}
+		err = mp4.Clip(r, size, dst, ranges)
+	case string(magic[:4]) == "\x1a\x45\xdf\xa3":
+		ranges := make([]mkv.Range, len(sections))
+		for i, s := range sections {
+			ranges[i] = mkv.Range{Start: s.Start, End: s.End}
+		}
+		err = mkv.Clip(r, size, dst, ranges)
+	default:
+		return errors.NewValidationError("unsupported container for downloading sections", nil)
+	}
+	if err != nil {
+		return errors.NewDownloadError("failed to cut sections", err)
+	}
+	return nil
+}
+
+var errNoRanges = fmt.Errorf("server does not support range requests")
+
+// rangeReader reads a remote file with HTTP range requests. Reads that move
+// forward a little continue the open response instead of starting a new
+// request, so walking a file's boxes in order costs few round trips.
+type rangeReader struct {
+	ctx    context.Context
+	client *http.Client
+	url    string
+	size   int64
+
+	body io.ReadCloser
+	pos  int64 // offset of the next byte of body
+	// err is the first network error, which the container readers wrap
+	err      error
+	progress func(n int)
+}
+
+// openRange starts reading a remote file, returning errNoRanges if the
+// server ignores range requests
+func openRange(ctx context.Context, client *http.Client, url string) (*rangeReader, error) {
+	r := &rangeReader{ctx: ctx, client: client, url: url}
+	if err := r.open(0); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rangeReader) open(offset int64) error {
+	if r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+
+	noRanges := false
+	err := retryStep(r.ctx, "range request", time.Second, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, "GET", r.url, nil)
+		if err != nil {
+			return errors.NewNetworkError("failed to create request", err)
+		}
+		req.Header.Set("User-Agent", "red-goose/1.0")
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			return errors.NewNetworkError("failed to download", err)
+		}
+		if resp.StatusCode == http.StatusOK && offset == 0 {
+			resp.Body.Close()
+			noRanges = true
+			return nil
+		}
+		if resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return errors.NewHTTPError(resp)
+		}
+
+		if r.size == 0 {
+			_, total, _ := strings.Cut(resp.Header.Get("Content-Range"), "/")
+			size, err := strconv.ParseInt(total, 10, 64)
+			if err != nil || size <= 0 {
+				resp.Body.Close()
+				noRanges = true
+				return nil
+			}
+			r.size = size
+		}
+		r.body = resp.Body
+		r.pos = offset
+		return nil
+	})
+	if err == nil && noRanges {
+		return errNoRanges
+	}
+	return err
+}
+
+func (r *rangeReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil || off < r.pos || off-r.pos > rangeSkipLimit {
+		if err := r.open(off); err != nil {
+			return 0, r.fail(err)
+		}
+	}
+	if off > r.pos {
+		n, err := io.CopyN(io.Discard, r.body, off-r.pos)
+		r.pos += n
+		r.report(int(n))
+		if err != nil {
+			return 0, r.fail(errors.NewDownloadError("failed to read response", err))
+		}
+	}
+
+	want := p
+	if remaining := r.size - off; int64(len(want)) > remaining {
+		want = want[:remaining]
+	}
+	n, err := io.ReadFull(r.body, want)
+	r.pos += int64(n)
+	r.report(n)
+	if err != nil {
+		return n, r.fail(errors.NewDownloadError("failed to read response", err))
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// fail records the first error, so that it can be reported rather than the
+// container error it causes
+func (r *rangeReader) fail(err error) error {
+	if r.err == nil {
+		r.err = err
+	}
+	return err
+}
+
+func (r *rangeReader) report(n int) {
+	if r.progress != nil && n > 0 {
+		r.progress(n)
+	}
+}
+
+// Close ends the open response
+func (r *rangeReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
@@ -0,0 +1,183 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseSections(t *testing.T) {
+	tests := []struct {
+		name    string
+		specs   []string
+		want    []Section
+		wantErr bool
+	}{
+		{
+			name:  "minutes and seconds",
+			specs: []string{"*10:00-15:30"},
+			want:  []Section{{Start: 10 * time.Minute, End: 15*time.Minute + 30*time.Second}},
+		},
+		{
+			name:  "seconds with fractions",
+			specs: []string{"*90-120.5"},
+			want:  []Section{{Start: 90 * time.Second, End: 120500 * time.Millisecond}},
+		},
+		{
+			name:  "to the end",
+			specs: []string{"*1:00:00-inf"},
+			want:  []Section{{Start: time.Hour}},
+		},
+		{
+			name:  "from the start",
+			specs: []string{"*-30"},
+			want:  []Section{{End: 30 * time.Second}},
+		},
+		{
+			name:  "sorted and merged",
+			specs: []string{"*50-60", "*10-20", "*15-30"},
+			want:  []Section{{Start: 10 * time.Second, End: 30 * time.Second}, {Start: 50 * time.Second, End: 60 * time.Second}},
+		},
+		{
+			name:    "chapter regex",
+			specs:   []string{"intro"},
+			wantErr: true,
+		},
+		{
+			name:    "ends before it starts",
+			specs:   []string{"*20-10"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid time",
+			specs:   []string{"*1:xx-2:00"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSections(tt.specs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSections() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("ParseSections() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("section %d = %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRangeReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 300000)
+	var requests int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&requests, 1)
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader(data))
+	}))
+	defer server.Close()
+
+	r, err := openRange(context.Background(), http.DefaultClient, server.URL)
+	if err != nil {
+		t.Fatalf("openRange() error = %v", err)
+	}
+	defer r.Close()
+	if r.size != int64(len(data)) {
+		t.Fatalf("size = %d, want %d", r.size, len(data))
+	}
+
+	read := func(off int64, n int) {
+		t.Helper()
+		buf := make([]byte, n)
+		if _, err := r.ReadAt(buf, off); err != nil && err != io.EOF {
+			t.Fatalf("ReadAt(%d) error = %v", off, err)
+		}
+		if !bytes.Equal(buf, data[off:off+int64(n)]) {
+			t.Fatalf("ReadAt(%d) returned the wrong bytes", off)
+		}
+	}
+
+	// Short forward skips reuse the response
+	read(0, 8)
+	read(100, 8)
+	read(5000, 100)
+	if n := atomic.LoadInt64(&requests); n != 1 {
+		t.Errorf("requests after forward reads = %d, want 1", n)
+	}
+
+	// Long skips and reads backwards start new requests
+	read(2500000, 10)
+	read(10, 10)
+	if n := atomic.LoadInt64(&requests); n != 3 {
+		t.Errorf("requests after seeking = %d, want 3", n)
+	}
+
+	if _, err := r.ReadAt(make([]byte, 10), int64(len(data))); err != io.EOF {
+		t.Errorf("ReadAt() past the end error = %v, want EOF", err)
+	}
+}
+
+func TestDownloadSectionsUnsupported(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "media", time.Time{}, bytes.NewReader([]byte("not a media file")))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	err := New().DownloadSections(context.Background(), DownloadOptions{
+		URL:       server.URL,
+		OutputDir: dir,
+		Filename:  "out.mp4",
+	}, []Section{{Start: time.Second}})
+	if err == nil {
+		t.Fatal("DownloadSections() accepted an unknown container")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.mp4")); !os.IsNotExist(err) {
+		t.Errorf("output file was created")
+	}
+}
+
+func TestClipSpan(t *testing.T) {
+	sections := []Section{{Start: 10 * time.Second, End: 20 * time.Second}, {Start: 50 * time.Second}}
+	duration := 60 * time.Second
+
+	tests := []struct {
+		name       string
+		start, end time.Duration
+		want       [][2]time.Duration
+	}{
+		{"before the sections", 0, 5 * time.Second, nil},
+		{"inside a section", 12 * time.Second, 15 * time.Second, [][2]time.Duration{{2 * time.Second, 5 * time.Second}}},
+		{"across the gap", 15 * time.Second, 55 * time.Second, [][2]time.Duration{{5 * time.Second, 15 * time.Second}}},
+		{"to the end", 40 * time.Second, duration, [][2]time.Duration{{10 * time.Second, 20 * time.Second}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ClipSpan(sections, duration, tt.start, tt.end)
+			if len(got) != len(tt.want) {
+				t.Fatalf("ClipSpan() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("ClipSpan() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+
+	if got := ClippedDuration(sections, duration); got != 20*time.Second {
+		t.Errorf("ClippedDuration() = %s, want 20s", got)
+	}
+}
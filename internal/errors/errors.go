@@ -1,7 +1,12 @@
 package errors
 
 import (
+    "context"
+    stderrors "errors"
     "fmt"
+    "net/http"
+    "strconv"
+    "time"
 )
 
 type ErrorType int
@@ -15,11 +20,58 @@ const (
     ErrorTypeValidation
 )
 
+// String names the error type, e.g. in reports
+func (t ErrorType) String() string {
+    switch t {
+    case ErrorTypeNetwork:
+        return "network"
+    case ErrorTypeExtraction:
+        return "extraction"
+    case ErrorTypeDownload:
+        return "download"
+    case ErrorTypeFileSystem:
+        return "filesystem"
+    case ErrorTypeConfiguration:
+        return "configuration"
+    case ErrorTypeValidation:
+        return "validation"
+    }
+    return fmt.Sprintf("ErrorType(%d)", int(t))
+}
+
+// Stable error codes for scripts and reports. Errors without a code of
+// their own go by the name of their type.
+const (
+    CodeBadRequest   = "bad_request"
+    CodeUnauthorized = "unauthorized"
+    CodeForbidden    = "forbidden"
+    CodeNotFound     = "not_found"
+    CodeGone         = "gone"
+    CodeTimeout      = "timeout"
+    CodeRateLimited  = "rate_limited"
+    CodeClientError  = "client_error"
+    CodeServerError  = "server_error"
+    CodeUnavailable  = "unavailable"
+)
+
+// Keys of RedGooseError.Context
+const (
+    ContextURL     = "url"
+    ContextVideoID = "video_id"
+    ContextAttempt = "attempt"
+)
+
 type RedGooseError struct {
     Type    ErrorType
     Message string
     Cause   error
     Context map[string]interface{}
+    // StatusCode is the HTTP status of a failed request, or 0
+    StatusCode int
+    // Code is a stable name of the error, see ErrorCode
+    Code string
+    // RetryAfter is how long the server asked to wait before trying again
+    RetryAfter time.Duration
 }
 
 func (e *RedGooseError) Error() string {
@@ -33,6 +85,47 @@ func (e *RedGooseError) Unwrap() error {
     return e.Cause
 }
 
+// ErrorCode returns the error's code, or the name of its type if it has
+// none
+func (e *RedGooseError) ErrorCode() string {
+    if e.Code != "" {
+        return e.Code
+    }
+    return e.Type.String()
+}
+
+// WithContext records a detail of the error, such as ContextURL, and
+// returns the error
+func (e *RedGooseError) WithContext(key string, value interface{}) *RedGooseError {
+    if e.Context == nil {
+        e.Context = make(map[string]interface{})
+    }
+    e.Context[key] = value
+    return e
+}
+
+// Sentinels of the error types, for errors.Is: errors.Is(err, ErrNetwork)
+// reports whether err is or wraps a network error
+var (
+    ErrNetwork       = &RedGooseError{Type: ErrorTypeNetwork, Message: "network error"}
+    ErrExtraction    = &RedGooseError{Type: ErrorTypeExtraction, Message: "extraction error"}
+    ErrDownload      = &RedGooseError{Type: ErrorTypeDownload, Message: "download error"}
+    ErrFileSystem    = &RedGooseError{Type: ErrorTypeFileSystem, Message: "file system error"}
+    ErrConfiguration = &RedGooseError{Type: ErrorTypeConfiguration, Message: "configuration error"}
+    ErrValidation    = &RedGooseError{Type: ErrorTypeValidation, Message: "validation error"}
+)
+
+var sentinels = map[*RedGooseError]bool{
+    ErrNetwork: true, ErrExtraction: true, ErrDownload: true,
+    ErrFileSystem: true, ErrConfiguration: true, ErrValidation: true,
+}
+
+// Is matches the sentinel of the error's type
+func (e *RedGooseError) Is(target error) bool {
+    t, ok := target.(*RedGooseError)
+    return ok && sentinels[t] && t.Type == e.Type
+}
+
 func NewNetworkError(message string, cause error) *RedGooseError {
     return &RedGooseError{
         Type:    ErrorTypeNetwork,
@@ -81,9 +174,143 @@ func NewValidationError(message string, cause error) *RedGooseError {
     }
 }
 
+// NewHTTPError describes a response with an unexpected status. The code
+// follows the status, and a Retry-After header is kept.
+func NewHTTPError(resp *http.Response) *RedGooseError {
+    err := &RedGooseError{
+        Type:       ErrorTypeNetwork,
+        Message:    fmt.Sprintf("bad status: %s", resp.Status),
+        StatusCode: resp.StatusCode,
+        Code:       statusCode(resp.StatusCode),
+        RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+    }
+    if resp.Request != nil && resp.Request.URL != nil {
+        err.WithContext(ContextURL, resp.Request.URL.String())
+    }
+    return err
+}
+
+func statusCode(status int) string {
+    switch {
+    case status == http.StatusBadRequest:
+        return CodeBadRequest
+    case status == http.StatusUnauthorized:
+        return CodeUnauthorized
+    case status == http.StatusForbidden:
+        return CodeForbidden
+    case status == http.StatusNotFound:
+        return CodeNotFound
+    case status == http.StatusGone:
+        return CodeGone
+    case status == http.StatusRequestTimeout || status == http.StatusGatewayTimeout:
+        return CodeTimeout
+    case status == http.StatusTooManyRequests:
+        return CodeRateLimited
+    case status == http.StatusServiceUnavailable:
+        return CodeUnavailable
+    case status >= 500:
+        return CodeServerError
+    case status >= 400:
+        return CodeClientError
+    }
+    return ""
+}
+
+// ParseRetryAfter reads a Retry-After header, in seconds or as an HTTP
+// date, as the time to wait from now. Missing or invalid values are 0.
+func ParseRetryAfter(value string, now time.Time) time.Duration {
+    if value == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(value); err == nil {
+        return max(time.Duration(seconds)*time.Second, 0)
+    }
+    if t, err := http.ParseTime(value); err == nil {
+        return max(t.Sub(now), 0)
+    }
+    return 0
+}
+
+// IsRetryableError reports whether trying again may help: network and
+// download errors may pass, but not client errors other than timeouts
+// and rate limits, and not canceled operations. The status is that of the
+// innermost error in the chain that has one, so a 404 stays a 404 when a
+// download or extraction error wraps it.
 func IsRetryableError(err error) bool {
-    if rge, ok := err.(*RedGooseError); ok {
-        return rge.Type == ErrorTypeNetwork || rge.Type == ErrorTypeDownload
+    if stderrors.Is(err, context.Canceled) {
+        return false
     }
-    return false
-}
\ No newline at end of file
+    var rge *RedGooseError
+    if !stderrors.As(err, &rge) {
+        return false
+    }
+    if se := statusError(err); se != nil {
+        if status := se.StatusCode; status >= 400 && status < 500 {
+            return status == http.StatusRequestTimeout || status == http.StatusTooManyRequests
+        }
+    }
+    return rge.Type == ErrorTypeNetwork || rge.Type == ErrorTypeDownload
+}
+
+// RetryAfter returns the wait the server asked for before another try, if
+// err carries one
+func RetryAfter(err error) (time.Duration, bool) {
+    if se := statusError(err); se != nil && se.RetryAfter > 0 {
+        return se.RetryAfter, true
+    }
+    return 0, false
+}
+
+// statusError returns the innermost error in err's chain that carries an
+// HTTP status, or nil if none does
+func statusError(err error) *RedGooseError {
+    var found *RedGooseError
+    for err != nil {
+        if rge, ok := err.(*RedGooseError); ok && rge.StatusCode != 0 {
+            found = rge
+        }
+        err = stderrors.Unwrap(err)
+    }
+    return found
+}
+
+// As finds the first error of the given type in err's chain
+func As(err error, t ErrorType) (*RedGooseError, bool) {
+    for err != nil {
+        if rge, ok := err.(*RedGooseError); ok && rge.Type == t {
+            return rge, true
+        }
+        switch x := err.(type) {
+        case interface{ Unwrap() error }:
+            err = x.Unwrap()
+        case interface{ Unwrap() []error }:
+            for _, e := range x.Unwrap() {
+                if rge, ok := As(e, t); ok {
+                    return rge, true
+                }
+            }
+            return nil, false
+        default:
+            return nil, false
+        }
+    }
+    return nil, false
+}
+
+// IsNetwork reports whether err is or wraps a network error
+func IsNetwork(err error) bool { return stderrors.Is(err, ErrNetwork) }
+
+// IsExtraction reports whether err is or wraps an extraction error
+func IsExtraction(err error) bool { return stderrors.Is(err, ErrExtraction) }
+
+// IsDownload reports whether err is or wraps a download error
+func IsDownload(err error) bool { return stderrors.Is(err, ErrDownload) }
+
+// IsFileSystem reports whether err is or wraps a file system error
+func IsFileSystem(err error) bool { return stderrors.Is(err, ErrFileSystem) }
+
+// IsConfiguration reports whether err is or wraps a configuration error
+func IsConfiguration(err error) bool { return stderrors.Is(err, ErrConfiguration) }
+
+// IsValidation reports whether err is or wraps a validation error
+func IsValidation(err error) bool { return stderrors.Is(err, ErrValidation) }
\ No newline at end of file
@@ -0,0 +1,111 @@
+package errors
+
+import (
+    "context"
+    stderrors "errors"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestIsRetryableError(t *testing.T) {
+    tests := []struct {
+        name string
+        err  error
+        want bool
+    }{
+        {"network", NewNetworkError("failed to download", nil), true},
+        {"download", NewDownloadError("failed to save file", nil), true},
+        {"wrapped", fmt.Errorf("segment 3: %w", NewNetworkError("failed to download", nil)), true},
+        {"validation", NewValidationError("bad URL", nil), false},
+        {"filesystem", NewFileSystemError("failed to create file", nil), false},
+        {"untyped", fmt.Errorf("boom"), false},
+        {"canceled", NewNetworkError("failed to download", context.Canceled), false},
+        {"not found", &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 404}, false},
+        {"forbidden", &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 403}, false},
+        {"request timeout", &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 408}, true},
+        {"rate limited", &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 429}, true},
+        {"server error", &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 503}, true},
+        {"wrapped not found", NewDownloadError("failed to download video",
+            &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 404}), false},
+        {"wrapped twice", NewExtractionError("failed to get video info",
+            fmt.Errorf("player: %w", &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 403})), false},
+        {"wrapped server error", NewDownloadError("failed to download video",
+            &RedGooseError{Type: ErrorTypeNetwork, StatusCode: 503}), true},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := IsRetryableError(tt.err); got != tt.want {
+                t.Errorf("IsRetryableError(%v) = %v, want %v", tt.err, got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNewHTTPError(t *testing.T) {
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Retry-After", "120")
+        w.WriteHeader(http.StatusTooManyRequests)
+    }))
+    defer server.Close()
+
+    resp, err := http.Get(server.URL + "/video")
+    if err != nil {
+        t.Fatal(err)
+    }
+    resp.Body.Close()
+
+    e := NewHTTPError(resp)
+    if e.Error() != "bad status: 429 Too Many Requests" || e.StatusCode != 429 || e.ErrorCode() != CodeRateLimited {
+        t.Errorf("error = %#v", e)
+    }
+    if e.Context[ContextURL] != server.URL+"/video" {
+        t.Errorf("context = %v", e.Context)
+    }
+    if after, ok := RetryAfter(fmt.Errorf("wrapped: %w", e)); !ok || after != 2*time.Minute {
+        t.Errorf("RetryAfter = %v, %v", after, ok)
+    }
+}
+
+func TestParseRetryAfter(t *testing.T) {
+    now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+    tests := []struct {
+        value string
+        want  time.Duration
+    }{
+        {"", 0},
+        {"30", 30 * time.Second},
+        {"-5", 0},
+        {"Tue, 02 Jan 2024 03:05:05 GMT", time.Minute},
+        {"Tue, 02 Jan 2024 03:00:00 GMT", 0},
+        {"soon", 0},
+    }
+    for _, tt := range tests {
+        if got := ParseRetryAfter(tt.value, now); got != tt.want {
+            t.Errorf("ParseRetryAfter(%q) = %v, want %v", tt.value, got, tt.want)
+        }
+    }
+}
+
+func TestTypeHelpers(t *testing.T) {
+    inner := NewNetworkError("failed to download", nil)
+    err := fmt.Errorf("video abc: %w", NewExtractionError("failed to get video info", inner))
+
+    if !IsExtraction(err) || !IsNetwork(err) || IsValidation(err) {
+        t.Errorf("type helpers of %v", err)
+    }
+    if !stderrors.Is(err, ErrNetwork) || stderrors.Is(err, ErrFileSystem) {
+        t.Errorf("errors.Is of %v", err)
+    }
+    if got, ok := As(err, ErrorTypeNetwork); !ok || got != inner {
+        t.Errorf("As(network) = %v, %v", got, ok)
+    }
+    if _, ok := As(err, ErrorTypeDownload); ok {
+        t.Error("As(download) found an error")
+    }
+    if code := inner.ErrorCode(); code != "network" {
+        t.Errorf("ErrorCode() = %q, want the type name", code)
+    }
+}
@@ -0,0 +1,95 @@
+package extractor
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "net/http"
+    "regexp"
+    "time"
+
+    "github.com/MaVeN-13TTN/red_goose/internal/errors"
+    pkgyoutube "github.com/MaVeN-13TTN/red_goose/pkg/youtube"
+)
+
+var (
+    // canonicalChannelRegex finds the channel ID in the canonical link of a
+    // channel page
+    canonicalChannelRegex = regexp.MustCompile(`<link rel="canonical" href="https://www\.youtube\.com/channel/(UC[a-zA-Z0-9_-]{22})"`)
+    // externalIDRegex finds it in the page's player data
+    externalIDRegex = regexp.MustCompile(`"externalId":"(UC[a-zA-Z0-9_-]{22})"`)
+)
+
+// Video is an entry of a playlist or channel listing
+type Video struct {
+    ID       string
+    Title    string
+    Duration time.Duration
+}
+
+// ResolveChannelID returns the ID of the channel a URL links to. Handles and
+// custom names are looked up on the channel page.
+func (e *Extractor) ResolveChannelID(ctx context.Context, url string) (string, error) {
+    if id := pkgyoutube.ExtractChannelID(url); id != "" {
+        return id, nil
+    }
+    e.Logger.Debug("looking up channel ID", "url", url)
+
+    req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+    if err != nil {
+        return "", fmt.Errorf("failed to create request: %w", err)
+    }
+    req.Header.Set("User-Agent", "Mozilla/5.0 (compatible; red-goose/1.0)")
+    // Skip the cookie consent page served to European visitors
+    req.AddCookie(&http.Cookie{Name: "CONSENT", Value: "YES+"})
+
+    client := e.client.HTTPClient
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("failed to load channel page: %w", err)
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return "", fmt.Errorf("failed to load channel page: %w", errors.NewHTTPError(resp))
+    }
+
+    body, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+    if err != nil {
+        return "", fmt.Errorf("failed to read channel page: %w", err)
+    }
+    id := channelIDFromPage(body)
+    if id == "" {
+        return "", fmt.Errorf("no channel ID found on %s", url)
+    }
+    return id, nil
+}
+
+// channelIDFromPage finds the ID of the channel a page belongs to
+func channelIDFromPage(page []byte) string {
+    for _, re := range []*regexp.Regexp{canonicalChannelRegex, externalIDRegex} {
+        if m := re.FindSubmatch(page); m != nil {
+            return string(m[1])
+        }
+    }
+    return ""
+}
+
+// GetPlaylistVideos lists the videos of a playlist, such as the uploads of a
+// channel
+func (e *Extractor) GetPlaylistVideos(playlistID string) (title string, videos []Video, err error) {
+    playlist, err := e.GetPlaylistDetails(playlistID)
+    if err != nil {
+        return "", nil, err
+    }
+    for _, entry := range playlist.Videos {
+        videos = append(videos, Video{ID: entry.ID, Title: entry.Title, Duration: entry.Duration})
+    }
+    title = playlist.Title
+    if title == "" {
+        title = playlist.Author
+    }
+    return title, videos, nil
+}
@@ -0,0 +1,81 @@
+package extractor
+
+import (
+    "encoding/json"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Chapter is a named section of a video
+type Chapter struct {
+    Title string
+    Start time.Duration
+    End   time.Duration
+}
+
+// MarshalJSON encodes chapter times in seconds
+func (c Chapter) MarshalJSON() ([]byte, error) {
+    return json.Marshal(struct {
+        Title     string  `json:"title"`
+        StartTime float64 `json:"start_time"`
+        EndTime   float64 `json:"end_time"`
+    }{c.Title, c.Start.Seconds(), c.End.Seconds()})
+}
+
+// chapterLineRegex matches description lines such as "0:00 Intro",
+// "[01:02:03] Part two" or "12:30 - Questions"
+var chapterLineRegex = regexp.MustCompile(`^\s*[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?\s*(?:[-–—:|.]\s*)?(.+?)\s*$`)
+
+// ParseChapters extracts chapters from timestamps at the start of
+// description lines. Like YouTube, it only accepts a list that starts at
+// 0:00 and has at least two entries in ascending order; the last chapter
+// ends at the video duration.
+func ParseChapters(description string, duration time.Duration) []Chapter {
+    var chapters []Chapter
+
+    for _, line := range strings.Split(description, "\n") {
+        m := chapterLineRegex.FindStringSubmatch(line)
+        if m == nil {
+            continue
+        }
+
+        start := parseClock(m[1])
+        if len(chapters) == 0 && start != 0 {
+            continue
+        }
+        if len(chapters) > 0 && start <= chapters[len(chapters)-1].Start {
+            continue
+        }
+        if duration > 0 && start >= duration {
+            break
+        }
+
+        chapters = append(chapters, Chapter{Title: m[2], Start: start})
+    }
+
+    if len(chapters) < 2 {
+        return nil
+    }
+
+    for i := range chapters {
+        if i+1 < len(chapters) {
+            chapters[i].End = chapters[i+1].Start
+        } else {
+            chapters[i].End = duration
+        }
+    }
+
+    return chapters
+}
+
+// parseClock converts "h:mm:ss" or "m:ss" to a duration
+func parseClock(clock string) time.Duration {
+    var total time.Duration
+    for _, part := range strings.Split(clock, ":") {
+        n, _ := strconv.Atoi(part)
+        total = total*60 + time.Duration(n)
+    }
+    return total * time.Second
+}
@@ -1,58 +1,141 @@
 package extractor
 
 import (
+    "context"
+    "errors"
     "fmt"
+    "log/slog"
     "sort"
+    "time"
 
     "github.com/kkdai/youtube/v2"
 )
 
 type VideoDetails struct {
-    ID          string
-    Title       string
-    Author      string
-    Duration    string
-    Description string
-    Thumbnail   string
-    Formats     []FormatInfo
+    ID          string       `json:"id"`
+    Title       string       `json:"title"`
+    Author      string       `json:"author"`
+    Duration    string       `json:"duration"`
+    Description string       `json:"description"`
+    Thumbnail   string       `json:"thumbnail"`
+    Formats     []FormatInfo `json:"formats"`
+
+    ChannelID       string    `json:"channel_id,omitempty"`
+    UploadDate      string    `json:"upload_date,omitempty"`
+    DurationSeconds int64     `json:"duration_seconds"`
+    Chapters        []Chapter `json:"chapters,omitempty"`
+
+    // Live streams are served as HLS; IsLive is set while the broadcast
+    // is running
+    IsLive          bool   `json:"is_live"`
+    HLSManifestURL  string `json:"hls_manifest_url,omitempty"`
+    DASHManifestURL string `json:"dash_manifest_url,omitempty"`
+
+    Captions []CaptionTrack `json:"captions"`
+
+    // Thumbnails lists every available thumbnail, largest first;
+    // Thumbnail is the URL of the largest
+    Thumbnails []Thumbnail `json:"thumbnails"`
+}
+
+type Thumbnail struct {
+    URL    string `json:"url"`
+    Width  int    `json:"width"`
+    Height int    `json:"height"`
 }
 
 type FormatInfo struct {
-    Quality   string
-    MimeType  string
-    URL       string
-    Filesize  int64
-    AudioOnly bool
-    VideoOnly bool
+    Quality   string `json:"quality"`
+    MimeType  string `json:"mime_type"`
+    URL       string `json:"url"`
+    Filesize  int64  `json:"filesize"`
+    AudioOnly bool   `json:"audio_only"`
+    VideoOnly bool   `json:"video_only"`
+}
+
+// CaptionTrack is a subtitle track offered by the player. Automatic tracks
+// are generated by speech recognition rather than uploaded by the author.
+type CaptionTrack struct {
+    LanguageCode string `json:"language_code"`
+    Name         string `json:"name"`
+    URL          string `json:"url"`
+    Automatic    bool   `json:"automatic"`
+    Translatable bool   `json:"translatable"`
 }
 
 type Extractor struct {
     client *youtube.Client
+    // Logger receives debug records of each lookup
+    Logger *slog.Logger
 }
 
 func New() *Extractor {
     return &Extractor{
         client: &youtube.Client{},
+        Logger: slog.Default(),
     }
 }
 
+// WithLogger returns an extractor sharing e's client that logs to logger,
+// such as one carrying the video and task being downloaded
+func (e *Extractor) WithLogger(logger *slog.Logger) *Extractor {
+    copied := *e
+    copied.Logger = logger
+    return &copied
+}
+
 func (e *Extractor) GetVideoDetails(videoID string) (*VideoDetails, error) {
+    e.Logger.Debug("fetching video details", "video_id", videoID)
     video, err := e.client.GetVideo(videoID)
     if err != nil {
+        e.Logger.Debug("fetching video details failed", "video_id", videoID, "error", err)
         return nil, fmt.Errorf("failed to get video info: %w", err)
     }
 
     details := &VideoDetails{
-        ID:          video.ID,
-        Title:       video.Title,
-        Author:      video.Author,
-        Duration:    video.Duration.String(),
-        Description: video.Description,
+        ID:              video.ID,
+        Title:           video.Title,
+        Author:          video.Author,
+        Duration:        video.Duration.String(),
+        Description:     video.Description,
+        ChannelID:       video.ChannelID,
+        DurationSeconds: int64(video.Duration.Seconds()),
+        Chapters:        ParseChapters(video.Description, video.Duration),
+    }
+    if !video.PublishDate.IsZero() {
+        details.UploadDate = video.PublishDate.Format("2006-01-02")
+    }
+
+    // A running broadcast has an HLS manifest but no fixed length yet
+    details.HLSManifestURL = video.HLSManifestURL
+    details.DASHManifestURL = video.DASHManifestURL
+    details.IsLive = video.HLSManifestURL != "" && video.Duration == 0
+
+    // Extract thumbnails
+    for _, thumb := range video.Thumbnails {
+        details.Thumbnails = append(details.Thumbnails, Thumbnail{
+            URL:    thumb.URL,
+            Width:  int(thumb.Width),
+            Height: int(thumb.Height),
+        })
+    }
+    sort.SliceStable(details.Thumbnails, func(i, j int) bool {
+        a, b := details.Thumbnails[i], details.Thumbnails[j]
+        return a.Width*a.Height > b.Width*b.Height
+    })
+    if len(details.Thumbnails) > 0 {
+        details.Thumbnail = details.Thumbnails[0].URL
     }
 
-    // Extract thumbnail
-    if len(video.Thumbnails) > 0 {
-        details.Thumbnail = video.Thumbnails[0].URL
+    // Extract caption tracks
+    for _, track := range video.CaptionTracks {
+        details.Captions = append(details.Captions, CaptionTrack{
+            LanguageCode: track.LanguageCode,
+            Name:         track.Name.SimpleText,
+            URL:          track.BaseURL,
+            Automatic:    track.Kind == "asr",
+            Translatable: track.IsTranslatable,
+        })
     }
 
     // Process formats
@@ -73,15 +156,64 @@ func (e *Extractor) GetVideoDetails(videoID string) (*VideoDetails, error) {
         return details.Formats[i].Filesize > details.Formats[j].Filesize
     })
 
+    e.Logger.Debug("fetched video details", "video_id", videoID,
+        "formats", len(details.Formats), "captions", len(details.Captions), "live", details.IsLive)
     return details, nil
 }
 
+// IsLiveOffline reports whether err means the video is a scheduled live
+// stream that has not started yet
+func IsLiveOffline(err error) bool {
+    var status *youtube.ErrPlayabiltyStatus
+    if errors.As(err, &status) {
+        return status.Status == "LIVE_STREAM_OFFLINE"
+    }
+    return false
+}
+
+// WaitForLive polls a scheduled live stream until the broadcast starts,
+// backing off from interval up to maxInterval between checks. onWait, if
+// set, is called before each wait.
+func (e *Extractor) WaitForLive(ctx context.Context, videoID string, interval, maxInterval time.Duration, onWait func(delay time.Duration)) (*VideoDetails, error) {
+    delay := interval
+
+    for {
+        details, err := e.GetVideoDetails(videoID)
+        switch {
+        case err == nil && details.IsLive:
+            return details, nil
+        case err == nil:
+            return nil, fmt.Errorf("video %s is not a live stream", videoID)
+        case err != nil && !IsLiveOffline(err):
+            return nil, err
+        }
+
+        e.Logger.Info("waiting for live stream", "video_id", videoID, "delay", delay)
+        if onWait != nil {
+            onWait(delay)
+        }
+
+        select {
+        case <-ctx.Done():
+            return nil, ctx.Err()
+        case <-time.After(delay):
+        }
+
+        delay *= 2
+        if delay > maxInterval {
+            delay = maxInterval
+        }
+    }
+}
+
 func (e *Extractor) GetPlaylistDetails(playlistID string) (*youtube.Playlist, error) {
+    e.Logger.Debug("fetching playlist", "playlist_id", playlistID)
     playlist, err := e.client.GetPlaylist(playlistID)
     if err != nil {
         return nil, fmt.Errorf("failed to get playlist info: %w", err)
     }
 
+    e.Logger.Debug("fetched playlist", "playlist_id", playlistID, "videos", len(playlist.Videos))
     return playlist, nil
 }
 
@@ -0,0 +1,104 @@
+// Package feed reads the Atom feeds YouTube publishes for channels and
+// playlists. A feed lists only the latest uploads, but it is one small
+// request, so it is a cheap way to notice new videos.
+package feed
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// DefaultBaseURL is YouTube's feed endpoint
+const DefaultBaseURL = "https://www.youtube.com/feeds/videos.xml"
+
+// Window is the number of entries YouTube puts in a feed. A feed that is
+// full may have dropped older new videos.
+const Window = 15
+
+// Entry is a video listed in a feed
+type Entry struct {
+	VideoID   string
+	Title     string
+	Published time.Time
+}
+
+// Client fetches feeds
+type Client struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewClient returns a client for the feed endpoint at baseURL, or YouTube's
+// if baseURL is empty
+func NewClient(baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseURL: baseURL,
+	}
+}
+
+// atomFeed is the part of a feed that is read
+type atomFeed struct {
+	Entries []struct {
+		VideoID   string    `xml:"http://www.youtube.com/xml/schemas/2015 videoId"`
+		Title     string    `xml:"title"`
+		Published time.Time `xml:"published"`
+	} `xml:"entry"`
+}
+
+// Channel returns the latest uploads of a channel
+func (c *Client) Channel(ctx context.Context, channelID string) ([]Entry, error) {
+	return c.fetch(ctx, url.Values{"channel_id": {channelID}})
+}
+
+// Playlist returns the latest entries of a playlist
+func (c *Client) Playlist(ctx context.Context, playlistID string) ([]Entry, error) {
+	return c.fetch(ctx, url.Values{"playlist_id": {playlistID}})
+}
+
+func (c *Client) fetch(ctx context.Context, query url.Values) ([]Entry, error) {
+	sep := "?"
+	if strings.Contains(c.baseURL, "?") {
+		sep = "&"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+sep+query.Encode(), nil)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to create request", err)
+	}
+	req.Header.Set("User-Agent", "red-goose/1.0")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to fetch feed", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewHTTPError(resp)
+	}
+
+	var feed atomFeed
+	if err := xml.NewDecoder(resp.Body).Decode(&feed); err != nil {
+		return nil, errors.NewExtractionError("failed to parse feed", err)
+	}
+
+	var entries []Entry
+	for _, e := range feed.Entries {
+		if e.VideoID == "" {
+			continue
+		}
+		entries = append(entries, Entry{VideoID: e.VideoID, Title: e.Title, Published: e.Published})
+	}
+	return entries, nil
+}
@@ -0,0 +1,69 @@
+package feed
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const sampleFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+ <title>Some Channel</title>
+ <entry>
+  <id>yt:video:new12345678</id>
+  <yt:videoId>new12345678</yt:videoId>
+  <title>Newest upload</title>
+  <published>2024-05-02T10:00:00+00:00</published>
+ </entry>
+ <entry>
+  <id>yt:video:old12345678</id>
+  <yt:videoId>old12345678</yt:videoId>
+  <title>Older &amp; wiser</title>
+  <published>2024-04-20T08:30:00+00:00</published>
+ </entry>
+</feed>`
+
+func TestFetch(t *testing.T) {
+	var queries []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		if r.URL.Query().Get("channel_id") == "UCmissing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/atom+xml")
+		w.Write([]byte(sampleFeed))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL + "/feeds/videos.xml")
+	entries, err := c.Channel(context.Background(), "UCabc")
+	if err != nil {
+		t.Fatalf("Channel: %v", err)
+	}
+	want := []Entry{
+		{VideoID: "new12345678", Title: "Newest upload", Published: time.Date(2024, 5, 2, 10, 0, 0, 0, time.UTC)},
+		{VideoID: "old12345678", Title: "Older & wiser", Published: time.Date(2024, 4, 20, 8, 30, 0, 0, time.UTC)},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(entries), len(want))
+	}
+	for i := range want {
+		if entries[i].VideoID != want[i].VideoID || entries[i].Title != want[i].Title || !entries[i].Published.Equal(want[i].Published) {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+
+	if _, err := c.Playlist(context.Background(), "PLxyz"); err != nil {
+		t.Fatalf("Playlist: %v", err)
+	}
+	if _, err := c.Channel(context.Background(), "UCmissing"); err == nil {
+		t.Error("expected an error for a missing feed")
+	}
+	if want := []string{"channel_id=UCabc", "playlist_id=PLxyz", "channel_id=UCmissing"}; !reflect.DeepEqual(queries, want) {
+		t.Errorf("queries = %v, want %v", queries, want)
+	}
+}
@@ -0,0 +1,298 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variant is a single stream entry of a master playlist
+type Variant struct {
+	URI        string
+	Bandwidth  int
+	Width      int
+	Height     int
+	Codecs     string
+	FrameRate  float64
+	Resolution string
+}
+
+// Segment is a single media segment of a media playlist
+type Segment struct {
+	URI      string
+	Duration time.Duration
+	Sequence int64
+	Title    string
+}
+
+// MasterPlaylist lists the variant streams available for a presentation
+type MasterPlaylist struct {
+	Variants []Variant
+}
+
+// MediaPlaylist lists the media segments of a single variant
+type MediaPlaylist struct {
+	TargetDuration time.Duration
+	MediaSequence  int64
+	Segments       []Segment
+	EndList        bool
+	PlaylistType   string
+}
+
+// IsMaster reports whether the playlist content is a master playlist
+func IsMaster(content string) bool {
+	return strings.Contains(content, "#EXT-X-STREAM-INF")
+}
+
+// ParseMaster parses a master playlist, resolving variant URIs against base
+func ParseMaster(r io.Reader, base *url.URL) (*MasterPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if err := expectHeader(scanner); err != nil {
+		return nil, err
+	}
+
+	playlist := &MasterPlaylist{}
+	var pending *Variant
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF:"):
+			attrs := parseAttributes(strings.TrimPrefix(line, "#EXT-X-STREAM-INF:"))
+			v := &Variant{
+				Codecs:     attrs["CODECS"],
+				Resolution: attrs["RESOLUTION"],
+			}
+			v.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+			v.FrameRate, _ = strconv.ParseFloat(attrs["FRAME-RATE"], 64)
+			if w, h, ok := strings.Cut(v.Resolution, "x"); ok {
+				v.Width, _ = strconv.Atoi(w)
+				v.Height, _ = strconv.Atoi(h)
+			}
+			pending = v
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			if pending == nil {
+				continue
+			}
+			uri, err := resolve(base, line)
+			if err != nil {
+				return nil, err
+			}
+			pending.URI = uri
+			playlist.Variants = append(playlist.Variants, *pending)
+			pending = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	if len(playlist.Variants) == 0 {
+		return nil, fmt.Errorf("master playlist has no variants")
+	}
+
+	return playlist, nil
+}
+
+// ParseMedia parses a media playlist, resolving segment URIs against base
+func ParseMedia(r io.Reader, base *url.URL) (*MediaPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	if err := expectHeader(scanner); err != nil {
+		return nil, err
+	}
+
+	playlist := &MediaPlaylist{}
+	var (
+		duration time.Duration
+		title    string
+		haveInf  bool
+		sequence int64
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			secs, err := strconv.ParseFloat(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid target duration: %w", err)
+			}
+			playlist.TargetDuration = seconds(secs)
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			seq, err := strconv.ParseInt(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid media sequence: %w", err)
+			}
+			playlist.MediaSequence = seq
+			sequence = seq
+		case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
+			playlist.PlaylistType = strings.TrimPrefix(line, "#EXT-X-PLAYLIST-TYPE:")
+		case line == "#EXT-X-ENDLIST":
+			playlist.EndList = true
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			durStr, rest, _ := strings.Cut(value, ",")
+			secs, err := strconv.ParseFloat(strings.TrimSpace(durStr), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid segment duration %q: %w", durStr, err)
+			}
+			duration = seconds(secs)
+			title = strings.TrimSpace(rest)
+			haveInf = true
+		case strings.HasPrefix(line, "#"):
+			continue
+		default:
+			if !haveInf {
+				continue
+			}
+			uri, err := resolve(base, line)
+			if err != nil {
+				return nil, err
+			}
+			playlist.Segments = append(playlist.Segments, Segment{
+				URI:      uri,
+				Duration: duration,
+				Sequence: sequence,
+				Title:    title,
+			})
+			sequence++
+			haveInf = false
+			title = ""
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read playlist: %w", err)
+	}
+
+	return playlist, nil
+}
+
+// Duration returns the total duration of all segments in the playlist
+func (p *MediaPlaylist) Duration() time.Duration {
+	var total time.Duration
+	for _, seg := range p.Segments {
+		total += seg.Duration
+	}
+	return total
+}
+
+// SelectVariant picks the variant closest to the requested quality.
+// Quality may be "best", "worst" or a height such as "720p"; heights that
+// are not available fall back to the best variant below them, then to best.
+func (m *MasterPlaylist) SelectVariant(quality string) Variant {
+	best, worst := m.Variants[0], m.Variants[0]
+	for _, v := range m.Variants[1:] {
+		if v.Bandwidth > best.Bandwidth {
+			best = v
+		}
+		if v.Bandwidth < worst.Bandwidth {
+			worst = v
+		}
+	}
+
+	switch quality {
+	case "", "best":
+		return best
+	case "worst":
+		return worst
+	}
+
+	height, err := strconv.Atoi(strings.TrimSuffix(quality, "p"))
+	if err != nil {
+		return best
+	}
+
+	var (
+		match Variant
+		found bool
+	)
+	for _, v := range m.Variants {
+		if v.Height > height {
+			continue
+		}
+		if !found || v.Height > match.Height ||
+			(v.Height == match.Height && v.Bandwidth > match.Bandwidth) {
+			match = v
+			found = true
+		}
+	}
+	if !found {
+		return best
+	}
+	return match
+}
+
+func expectHeader(scanner *bufio.Scanner) error {
+	for scanner.Scan() {
+		line := strings.TrimSpace(strings.TrimPrefix(scanner.Text(), "\ufeff"))
+		if line == "" {
+			continue
+		}
+		if line != "#EXTM3U" {
+			return fmt.Errorf("not an HLS playlist: missing #EXTM3U header")
+		}
+		return nil
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read playlist: %w", err)
+	}
+	return fmt.Errorf("empty playlist")
+}
+
+// parseAttributes splits an attribute list such as
+// BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2" into key/value pairs
+func parseAttributes(list string) map[string]string {
+	attrs := make(map[string]string)
+	for len(list) > 0 {
+		key, rest, ok := strings.Cut(list, "=")
+		if !ok {
+			break
+		}
+		key = strings.TrimSpace(key)
+
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := strings.Index(rest[1:], "\"")
+			if end < 0 {
+				value, list = rest[1:], ""
+			} else {
+				value, list = rest[1:end+1], rest[end+2:]
+			}
+			list = strings.TrimPrefix(list, ",")
+		} else {
+			value, list, _ = strings.Cut(rest, ",")
+		}
+
+		attrs[key] = value
+	}
+	return attrs
+}
+
+func resolve(base *url.URL, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid URI %q: %w", ref, err)
+	}
+	if base == nil {
+		return u.String(), nil
+	}
+	return base.ResolveReference(u).String(), nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,110 @@
+package hls
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+const masterPlaylist = `#EXTM3U
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,CODECS="avc1.4d401e,mp4a.40.2"
+360/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2500000,RESOLUTION=1280x720,CODECS="avc1.4d401f,mp4a.40.2",FRAME-RATE=30
+720/index.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=5000000,RESOLUTION=1920x1080
+https://cdn.example.com/1080/index.m3u8
+`
+
+const mediaPlaylist = `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:5
+#EXT-X-MEDIA-SEQUENCE:120
+#EXTINF:5.0,
+seg120.ts
+#EXTINF:4.5,live
+seg121.ts
+#EXTINF:5.005,
+/abs/seg122.ts
+`
+
+func TestParseMaster(t *testing.T) {
+	base, _ := url.Parse("https://example.com/live/master.m3u8")
+
+	master, err := ParseMaster(strings.NewReader(masterPlaylist), base)
+	if err != nil {
+		t.Fatalf("ParseMaster failed: %v", err)
+	}
+
+	if len(master.Variants) != 3 {
+		t.Fatalf("got %d variants, want 3", len(master.Variants))
+	}
+
+	v := master.Variants[1]
+	if v.URI != "https://example.com/live/720/index.m3u8" {
+		t.Errorf("variant URI = %q", v.URI)
+	}
+	if v.Bandwidth != 2500000 || v.Width != 1280 || v.Height != 720 || v.FrameRate != 30 {
+		t.Errorf("unexpected variant attributes: %+v", v)
+	}
+	if v.Codecs != "avc1.4d401f,mp4a.40.2" {
+		t.Errorf("codecs = %q", v.Codecs)
+	}
+
+	tests := []struct {
+		quality string
+		height  int
+	}{
+		{"best", 1080},
+		{"worst", 360},
+		{"720p", 720},
+		{"480p", 360},
+		{"144p", 1080},
+		{"unknown", 1080},
+	}
+	for _, tt := range tests {
+		if got := master.SelectVariant(tt.quality).Height; got != tt.height {
+			t.Errorf("SelectVariant(%q) height = %d, want %d", tt.quality, got, tt.height)
+		}
+	}
+}
+
+func TestParseMedia(t *testing.T) {
+	base, _ := url.Parse("https://example.com/live/720/index.m3u8")
+
+	playlist, err := ParseMedia(strings.NewReader(mediaPlaylist), base)
+	if err != nil {
+		t.Fatalf("ParseMedia failed: %v", err)
+	}
+
+	if playlist.TargetDuration != 5*time.Second {
+		t.Errorf("target duration = %s", playlist.TargetDuration)
+	}
+	if playlist.EndList {
+		t.Error("live playlist reported as ended")
+	}
+	if len(playlist.Segments) != 3 {
+		t.Fatalf("got %d segments, want 3", len(playlist.Segments))
+	}
+
+	want := []Segment{
+		{URI: "https://example.com/live/720/seg120.ts", Duration: 5 * time.Second, Sequence: 120},
+		{URI: "https://example.com/live/720/seg121.ts", Duration: 4500 * time.Millisecond, Sequence: 121, Title: "live"},
+		{URI: "https://example.com/abs/seg122.ts", Duration: 5005 * time.Millisecond, Sequence: 122},
+	}
+	for i, seg := range playlist.Segments {
+		if seg != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, seg, want[i])
+		}
+	}
+
+	if playlist.Duration() != 14505*time.Millisecond {
+		t.Errorf("total duration = %s", playlist.Duration())
+	}
+}
+
+func TestParseRejectsNonPlaylist(t *testing.T) {
+	if _, err := ParseMedia(strings.NewReader("<html></html>"), nil); err == nil {
+		t.Error("expected error for non-playlist content")
+	}
+}
@@ -0,0 +1,233 @@
+// Package hooks runs user commands at stages of a download, such as after
+// a file is finished
+package hooks
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/pkg/youtube"
+)
+
+// Stages a hook can run at
+const (
+	StageBeforeDownload   = "before-download"
+	StageAfterDownload    = "after-download"
+	StageAfterPostprocess = "after-postprocess"
+	StageAfterPlaylist    = "after-playlist"
+)
+
+// Stages lists every stage in the order they run
+var Stages = []string{StageBeforeDownload, StageAfterDownload, StageAfterPostprocess, StageAfterPlaylist}
+
+// What to do when a hook fails
+const (
+	// OnFailureIgnore reports the failure and carries on
+	OnFailureIgnore = "ignore"
+	// OnFailureFail fails the video the hook ran for
+	OnFailureFail = "fail"
+	// OnFailureAbort stops the whole batch
+	OnFailureAbort = "abort"
+)
+
+// ErrAbort is wrapped by the errors of hooks whose failure aborts the batch
+var ErrAbort = stderrors.New("batch aborted by hook")
+
+// placeholderRegex matches template fields such as {filepath}
+var placeholderRegex = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// Hook is a shell command run at a stage
+type Hook struct {
+	Stage   string `json:"stage"`
+	Command string `json:"command"`
+	// Timeout kills the command after the given time; zero waits forever
+	Timeout   time.Duration `json:"timeout,omitempty"`
+	OnFailure string        `json:"on_failure,omitempty"`
+}
+
+// Vars are the fields a hook command and its environment are built from
+type Vars map[string]string
+
+// VideoVars returns the fields of a video saved at path. The path may be
+// empty for hooks that run before the filename is known.
+func VideoVars(details *extractor.VideoDetails, path string) Vars {
+	vars := Vars{
+		"id":          details.ID,
+		"title":       details.Title,
+		"author":      details.Author,
+		"channel_id":  details.ChannelID,
+		"upload_date": details.UploadDate,
+		"duration":    strconv.FormatInt(details.DurationSeconds, 10),
+		"description": details.Description,
+		"url":         youtube.WatchURL(details.ID),
+	}
+	if path != "" {
+		vars["filepath"] = path
+		vars["filename"] = filepath.Base(path)
+		vars["dir"] = filepath.Dir(path)
+		vars["ext"] = strings.TrimPrefix(filepath.Ext(path), ".")
+	}
+	return vars
+}
+
+// Runner runs the hooks of each stage
+type Runner struct {
+	hooks  []Hook
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// NewRunner checks the stage and failure policy of each hook. An empty
+// policy fails the video.
+func NewRunner(hooks []Hook) (*Runner, error) {
+	r := &Runner{Stdout: os.Stdout, Stderr: os.Stderr}
+	for _, hook := range hooks {
+		if !validStage(hook.Stage) {
+			return nil, errors.NewValidationError(
+				fmt.Sprintf("unknown hook stage %q (use %s)", hook.Stage, strings.Join(Stages, ", ")), nil)
+		}
+		if strings.TrimSpace(hook.Command) == "" {
+			return nil, errors.NewValidationError(fmt.Sprintf("%s hook has no command", hook.Stage), nil)
+		}
+		switch hook.OnFailure {
+		case "":
+			hook.OnFailure = OnFailureFail
+		case OnFailureIgnore, OnFailureFail, OnFailureAbort:
+		default:
+			return nil, errors.NewValidationError(
+				fmt.Sprintf("unknown hook failure policy %q (use ignore, fail or abort)", hook.OnFailure), nil)
+		}
+		if hook.Timeout < 0 {
+			return nil, errors.NewValidationError(fmt.Sprintf("%s hook has a negative timeout", hook.Stage), nil)
+		}
+		r.hooks = append(r.hooks, hook)
+	}
+	return r, nil
+}
+
+func validStage(stage string) bool {
+	for _, s := range Stages {
+		if s == stage {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs the hooks of a stage in order. A failing hook whose policy is
+// ignore is reported and skipped; otherwise its error is returned, wrapping
+// ErrAbort if the batch should stop.
+func (r *Runner) Run(ctx context.Context, stage string, vars Vars) error {
+	if r == nil {
+		return nil
+	}
+	for _, hook := range r.hooks {
+		if hook.Stage != stage {
+			continue
+		}
+		err := r.run(ctx, hook, vars)
+		if err == nil {
+			continue
+		}
+		switch hook.OnFailure {
+		case OnFailureIgnore:
+			fmt.Fprintf(r.Stderr, "Warning: %s hook failed: %v\n", stage, err)
+		case OnFailureAbort:
+			return fmt.Errorf("%w: %s hook failed: %v", ErrAbort, stage, err)
+		default:
+			return fmt.Errorf("%s hook failed: %w", stage, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) run(ctx context.Context, hook Hook, vars Vars) error {
+	if hook.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, hook.Timeout)
+		defer cancel()
+	}
+
+	command := Expand(hook.Command, vars)
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), Environ(hook.Stage, vars)...)
+	cmd.Stdout = r.Stdout
+	cmd.Stderr = r.Stderr
+	killGroup(cmd)
+	// Do not wait on output pipes held open by children of a killed shell
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%q timed out after %s", command, hook.Timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%q: %w", command, err)
+	}
+	return nil
+}
+
+// Expand replaces {field} placeholders in a command with the quoted values
+// of vars, so that values with spaces or quotes stay one argument. Unknown
+// placeholders are left as they are. Placeholders must not be put in quotes
+// themselves: inside "{title}" the quoting is undone and the shell expands
+// $ and backquotes in the value.
+func Expand(command string, vars Vars) string {
+	return placeholderRegex.ReplaceAllStringFunc(command, func(m string) string {
+		value, ok := vars[m[1:len(m)-1]]
+		if !ok {
+			return m
+		}
+		return quote(value)
+	})
+}
+
+func quote(s string) string {
+	if runtime.GOOS == "windows" {
+		return quoteCmd(s)
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// quoteCmd quotes s for cmd.exe. A % is expanded even inside quotes, so
+// each one is put outside them as ^%: the caret makes cmd look up a
+// variable that does not exist, which leaves the % in place, and is then
+// dropped.
+func quoteCmd(s string) string {
+	s = strings.ReplaceAll(s, `"`, `""`)
+	s = strings.ReplaceAll(s, "%", `"^%"`)
+	return `"` + s + `"`
+}
+
+// Environ returns vars as RED_GOOSE_* environment variables, with the stage
+// as RED_GOOSE_STAGE
+func Environ(stage string, vars Vars) []string {
+	env := []string{"RED_GOOSE_STAGE=" + stage}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = append(env, "RED_GOOSE_"+strings.ToUpper(name)+"="+vars[name])
+	}
+	return env
+}
@@ -0,0 +1,139 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	stderrors "errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+)
+
+func TestExpand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("quoting differs on Windows")
+	}
+	vars := Vars{"title": "It's a test", "filepath": "/tmp/a b.mp4"}
+	got := Expand("convert {filepath} --title {title} {unknown}", vars)
+	want := `convert '/tmp/a b.mp4' --title 'It'\''s a test' {unknown}`
+	if got != want {
+		t.Errorf("Expand() = %s, want %s", got, want)
+	}
+}
+
+func TestQuoteCmd(t *testing.T) {
+	got := quoteCmd(`50% "off" %PATH%`)
+	want := `"50"^%" ""off"" "^%"PATH"^%""`
+	if got != want {
+		t.Errorf("quoteCmd() = %s, want %s", got, want)
+	}
+}
+
+// Placeholders are quoted by Expand, so they are written unquoted and
+// their values reach the command as they are
+func TestRunnerUnquotedPlaceholder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a POSIX shell")
+	}
+	title := "$(echo injected) `echo injected` \"it's\" $HOME"
+	var out bytes.Buffer
+	r, err := NewRunner([]Hook{{Stage: StageAfterPostprocess, Command: "printf %s {title}"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Stdout = &out
+	if err := r.Run(context.Background(), StageAfterPostprocess, Vars{"title": title}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if out.String() != title {
+		t.Errorf("command printed %q, want %q", out.String(), title)
+	}
+}
+
+func TestRunner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run through sh")
+	}
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out.txt")
+	details := &extractor.VideoDetails{ID: "abc", Title: "A; rm -rf /", DurationSeconds: 61}
+	vars := VideoVars(details, filepath.Join(dir, "video.mp4"))
+
+	r, err := NewRunner([]Hook{
+		{Stage: StageAfterDownload, Command: "printf '%s|%s|%s' {title} \"$RED_GOOSE_DURATION\" \"$RED_GOOSE_STAGE\" > " + out},
+		{Stage: StageAfterPlaylist, Command: "echo never > " + out},
+	})
+	if err != nil {
+		t.Fatalf("NewRunner() error = %v", err)
+	}
+	if err := r.Run(context.Background(), StageAfterDownload, vars); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "A; rm -rf /|61|after-download"; got != want {
+		t.Errorf("hook wrote %q, want %q", got, want)
+	}
+}
+
+func TestRunnerFailures(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run through sh")
+	}
+	tests := []struct {
+		name      string
+		hook      Hook
+		wantErr   bool
+		wantAbort bool
+	}{
+		{"ignore", Hook{Command: "exit 3", OnFailure: OnFailureIgnore}, false, false},
+		{"fail by default", Hook{Command: "exit 3"}, true, false},
+		{"abort", Hook{Command: "exit 3", OnFailure: OnFailureAbort}, true, true},
+		{"timeout", Hook{Command: "sleep 5", Timeout: 50 * time.Millisecond}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.hook.Stage = StageAfterPostprocess
+			r, err := NewRunner([]Hook{tt.hook})
+			if err != nil {
+				t.Fatalf("NewRunner() error = %v", err)
+			}
+			var stderr bytes.Buffer
+			r.Stderr = &stderr
+
+			start := time.Now()
+			err = r.Run(context.Background(), StageAfterPostprocess, Vars{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if stderrors.Is(err, ErrAbort) != tt.wantAbort {
+				t.Errorf("Run() error = %v, abort = %v", err, tt.wantAbort)
+			}
+			if !tt.wantErr && !strings.Contains(stderr.String(), "hook failed") {
+				t.Errorf("ignored failure was not reported: %q", stderr.String())
+			}
+			if time.Since(start) > 3*time.Second {
+				t.Errorf("Run() took %s", time.Since(start))
+			}
+		})
+	}
+}
+
+func TestNewRunnerValidation(t *testing.T) {
+	for _, hook := range []Hook{
+		{Stage: "after-upload", Command: "true"},
+		{Stage: StageAfterDownload},
+		{Stage: StageAfterDownload, Command: "true", OnFailure: "retry"},
+	} {
+		if _, err := NewRunner([]Hook{hook}); err == nil {
+			t.Errorf("NewRunner() accepted %+v", hook)
+		}
+	}
+}
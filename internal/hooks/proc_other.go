@@ -0,0 +1,8 @@
+//go:build !unix
+
+package hooks
+
+import "os/exec"
+
+// killGroup leaves the default of killing only the shell on timeout
+func killGroup(cmd *exec.Cmd) {}
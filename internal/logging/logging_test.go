@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var stderr bytes.Buffer
+	logger, closer, err := New(Options{Level: "info", Format: "json"}, &stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+
+	ctx := With(NewContext(context.Background(), logger), "video_id", "abc")
+	ctx = With(ctx, "attempt", 2)
+	FromContext(ctx).Debug("hidden")
+	FromContext(ctx).Info("download started", "bytes", 10)
+
+	lines := strings.Split(strings.TrimSpace(stderr.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("got %d records, want 1:\n%s", len(lines), stderr.String())
+	}
+	var record map[string]any
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record["msg"] != "download started" || record["video_id"] != "abc" || record["attempt"] != float64(2) || record["level"] != "INFO" {
+		t.Errorf("record = %v", record)
+	}
+}
+
+func TestNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "red-goose.log")
+	for i := 0; i < 2; i++ {
+		logger, closer, err := New(Options{Level: "debug", File: path}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		logger.Debug("run", "n", i)
+		closer.Close()
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "level=DEBUG msg=run n=0") || !strings.Contains(string(data), "n=1") {
+		t.Errorf("log file =\n%s", data)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	if _, _, err := New(Options{Level: "loud"}, nil); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, _, err := New(Options{Format: "xml"}, nil); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestFromContextDefault(t *testing.T) {
+	if FromContext(context.Background()) == nil {
+		t.Error("FromContext without a logger returned nil")
+	}
+}
@@ -0,0 +1,469 @@
+// Package mirror keeps a folder in step with a playlist. A manifest in the
+// folder records which file holds which video, so that when the playlist
+// changes, files are renamed to their new position and videos that left the
+// playlist are moved aside, without guessing from filenames.
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/nfo"
+	"github.com/MaVeN-13TTN/red_goose/internal/postprocess"
+)
+
+// ManifestName is the manifest's filename inside the playlist folder
+const ManifestName = ".red-goose-manifest.json"
+
+// RemovedDir is the folder videos that left the playlist are moved to
+const RemovedDir = "_removed"
+
+// journalName is the file that records the renames of an Apply while they
+// are made, so that a run stopped halfway is finished by the next Load
+const journalName = ".red-goose-renames.json"
+
+// renamingPrefix starts the temporary names files are renamed through
+const renamingPrefix = ".renaming-"
+
+// What happens to the files of videos that left the playlist
+const (
+	PolicyMove   = "move"
+	PolicyDelete = "delete"
+)
+
+// ValidatePolicy checks a policy for removed videos
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case PolicyMove, PolicyDelete:
+		return nil
+	}
+	return errors.NewValidationError(fmt.Sprintf("unknown removal policy %q (use move or delete)", policy), nil)
+}
+
+// Entry is a downloaded video of the playlist
+type Entry struct {
+	VideoID string `json:"video_id"`
+	Title   string `json:"title"`
+	Index   int    `json:"index"`
+	// File is the video's path relative to the folder
+	File string `json:"file"`
+	// Parts are the other files of the video that are not named after
+	// File, such as the chapters of --split-chapters
+	Parts []string `json:"parts,omitempty"`
+}
+
+// Manifest lists the videos in a playlist folder
+type Manifest struct {
+	dir        string
+	PlaylistID string  `json:"playlist_id"`
+	Title      string  `json:"title"`
+	Entries    []Entry `json:"entries"`
+}
+
+// Load reads the manifest of a folder. A folder without one has no entries.
+// Renames that an earlier run left unfinished are finished first.
+func Load(dir string) (*Manifest, error) {
+	if err := recoverRenames(dir); err != nil {
+		return nil, err
+	}
+	m := &Manifest{dir: dir}
+	data, err := os.ReadFile(filepath.Join(dir, ManifestName))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, errors.NewFileSystemError("failed to read playlist manifest", err)
+	}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, errors.NewFileSystemError("failed to parse playlist manifest", err)
+	}
+	return m, nil
+}
+
+// Save writes the manifest to its folder
+func (m *Manifest) Save() error {
+	sort.Slice(m.Entries, func(i, j int) bool { return m.Entries[i].Index < m.Entries[j].Index })
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return errors.NewFileSystemError("failed to create playlist directory", err)
+	}
+	if err := writeFile(filepath.Join(m.dir, ManifestName), data); err != nil {
+		return errors.NewFileSystemError("failed to write playlist manifest", err)
+	}
+	return nil
+}
+
+// writeFile replaces the file at path in one rename
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Add records a downloaded video, replacing any earlier entry for it
+func (m *Manifest) Add(e Entry) {
+	for i := range m.Entries {
+		if m.Entries[i].VideoID == e.VideoID {
+			m.Entries[i] = e
+			return
+		}
+	}
+	m.Entries = append(m.Entries, e)
+}
+
+// Move is a downloaded video whose position in the playlist changed
+type Move struct {
+	Entry Entry
+	Index int
+}
+
+// Plan is what it takes to bring the folder in step with the playlist
+type Plan struct {
+	Moves   []Move
+	Removed []Entry
+	// New are the videos to download, with their position
+	New []Entry
+	// Total is the number of videos in the playlist
+	Total int
+}
+
+// Plan compares the manifest with the playlist's current videos. Entries
+// whose file is gone are downloaded again.
+func (m *Manifest) Plan(videos []extractor.Video) Plan {
+	plan := Plan{Total: len(videos)}
+	index := make(map[string]int)
+	for i, v := range videos {
+		if _, dup := index[v.ID]; !dup {
+			index[v.ID] = i + 1
+		}
+	}
+
+	have := make(map[string]bool)
+	for _, e := range m.Entries {
+		i, listed := index[e.VideoID]
+		switch {
+		case !listed:
+			plan.Removed = append(plan.Removed, e)
+		case !m.exists(e):
+			// Downloaded again below
+		case i != e.Index:
+			plan.Moves = append(plan.Moves, Move{Entry: e, Index: i})
+			have[e.VideoID] = true
+		default:
+			have[e.VideoID] = true
+		}
+	}
+
+	for _, v := range videos {
+		if !have[v.ID] {
+			plan.New = append(plan.New, Entry{VideoID: v.ID, Title: v.Title, Index: index[v.ID]})
+			have[v.ID] = true
+		}
+	}
+	return plan
+}
+
+func (m *Manifest) exists(e Entry) bool {
+	_, err := os.Stat(filepath.Join(m.dir, e.File))
+	return err == nil
+}
+
+// Apply renames moved videos and moves aside or deletes removed ones,
+// together with the files next to them such as subtitles and thumbnails,
+// and updates the manifest. Moved files get their new track number and
+// NFO episode number. New videos are left to the caller. Renames are
+// recorded in a journal before they start and the manifest is saved with
+// them, so that a failure or crash halfway through is rolled back or
+// finished by the next Load rather than leaving the folder and the
+// manifest out of step.
+func (m *Manifest) Apply(plan Plan, policy string) error {
+	if err := ValidatePolicy(policy); err != nil {
+		return err
+	}
+
+	for _, e := range plan.Removed {
+		files, err := m.files(e)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if err := m.remove(f, policy); err != nil {
+				return err
+			}
+		}
+		m.drop(e.VideoID)
+	}
+
+	// Renaming goes through temporary names, so that videos swapping
+	// places do not overwrite each other
+	var renames []rename
+	for _, mv := range plan.Moves {
+		files, err := m.files(mv.Entry)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			to := renumber(f, mv.Entry.Index, mv.Index)
+			if to == f {
+				continue
+			}
+			renames = append(renames, rename{From: f, Temp: renamingPrefix + f, To: to})
+		}
+	}
+	for _, mv := range plan.Moves {
+		e := mv.Entry
+		e.File = renumber(e.File, e.Index, mv.Index)
+		e.Parts = nil
+		for _, part := range mv.Entry.Parts {
+			e.Parts = append(e.Parts, renumber(part, e.Index, mv.Index))
+		}
+		e.Index = mv.Index
+		m.Add(e)
+	}
+
+	// Entries whose file disappeared are downloaded again
+	for _, e := range plan.New {
+		m.drop(e.VideoID)
+	}
+
+	if len(renames) == 0 {
+		return nil
+	}
+	return m.rename(renames, plan.Total)
+}
+
+// rename is a file renamed through a temporary name
+type rename struct {
+	From string `json:"from"`
+	Temp string `json:"temp"`
+	To   string `json:"to"`
+}
+
+// journal records the renames of an Apply and the manifest they lead to.
+// Phase is 1 while files get their temporary names and 2 once they are
+// all moving to their new ones.
+type journal struct {
+	Phase    int       `json:"phase"`
+	Renames  []rename  `json:"renames"`
+	Manifest *Manifest `json:"manifest"`
+	// Total is the track total written into renamed files
+	Total int `json:"total"`
+}
+
+// rename makes the renames and saves the manifest. A failure before every
+// file has its temporary name puts the files back; one after leaves the
+// journal for the next Load to finish.
+func (m *Manifest) rename(renames []rename, total int) error {
+	j := &journal{Phase: 1, Renames: renames, Manifest: m, Total: total}
+	if err := j.save(m.dir); err != nil {
+		return err
+	}
+
+	for i, r := range renames {
+		if err := os.Rename(filepath.Join(m.dir, r.From), filepath.Join(m.dir, r.Temp)); err != nil {
+			for _, done := range renames[:i] {
+				os.Rename(filepath.Join(m.dir, done.Temp), filepath.Join(m.dir, done.From))
+			}
+			os.Remove(filepath.Join(m.dir, journalName))
+			return errors.NewFileSystemError(fmt.Sprintf("failed to rename %s", r.From), err)
+		}
+	}
+
+	j.Phase = 2
+	if err := j.save(m.dir); err != nil {
+		return err
+	}
+	return j.finish(m.dir)
+}
+
+func (j *journal) save(dir string) error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(dir, journalName), data); err != nil {
+		return errors.NewFileSystemError("failed to write rename journal", err)
+	}
+	return nil
+}
+
+// finish moves the files that have their temporary names to their new
+// ones, updates the numbers written into them, saves the manifest and
+// removes the journal. A file that cannot be renumbered does not stop the
+// others; the first such error is returned at the end.
+func (j *journal) finish(dir string) error {
+	renamed := make(map[string]bool)
+	for _, r := range j.Renames {
+		renamed[r.To] = true
+		tmp := filepath.Join(dir, r.Temp)
+		if _, err := os.Stat(tmp); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.Rename(tmp, filepath.Join(dir, r.To)); err != nil {
+			return errors.NewFileSystemError(fmt.Sprintf("failed to rename %s", r.From), err)
+		}
+	}
+
+	var retagErr error
+	for _, e := range j.Manifest.Entries {
+		if err := retag(dir, e, renamed, j.Total); err != nil && retagErr == nil {
+			retagErr = err
+		}
+	}
+
+	j.Manifest.dir = dir
+	if err := j.Manifest.Save(); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dir, journalName)); err != nil {
+		return errors.NewFileSystemError("failed to remove rename journal", err)
+	}
+	return retagErr
+}
+
+// retag writes the new position of a renamed entry into its file's track
+// number and its NFO's episode number
+func retag(dir string, e Entry, renamed map[string]bool, total int) error {
+	if renamed[e.File] {
+		if err := postprocess.Renumber(filepath.Join(dir, e.File), e.Index, total); err != nil {
+			return err
+		}
+	}
+	if doc := nfo.Filename(e.File, ".nfo"); renamed[doc] {
+		if err := nfo.SetEpisode(filepath.Join(dir, doc), e.Index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// recoverRenames finishes the renames of an Apply that was stopped
+// halfway, then gives files still under a temporary name their old name
+// back if it is free
+func recoverRenames(dir string) error {
+	data, err := os.ReadFile(filepath.Join(dir, journalName))
+	switch {
+	case err == nil:
+		j := &journal{}
+		if err := json.Unmarshal(data, j); err != nil || j.Manifest == nil {
+			return errors.NewFileSystemError("failed to parse rename journal", err)
+		}
+		if j.Phase < 2 {
+			for _, r := range j.Renames {
+				from, tmp := filepath.Join(dir, r.From), filepath.Join(dir, r.Temp)
+				if _, err := os.Stat(tmp); err == nil {
+					continue
+				}
+				if err := os.Rename(from, tmp); err != nil && !os.IsNotExist(err) {
+					return errors.NewFileSystemError(fmt.Sprintf("failed to rename %s", r.From), err)
+				}
+			}
+		}
+		if err := j.finish(dir); err != nil {
+			return err
+		}
+	case !os.IsNotExist(err):
+		return errors.NewFileSystemError("failed to read rename journal", err)
+	}
+
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.NewFileSystemError("failed to read playlist directory", err)
+	}
+	for _, n := range names {
+		name := n.Name()
+		if n.IsDir() || !strings.HasPrefix(name, renamingPrefix) {
+			continue
+		}
+		old := filepath.Join(dir, strings.TrimPrefix(name, renamingPrefix))
+		if _, err := os.Stat(old); os.IsNotExist(err) {
+			os.Rename(filepath.Join(dir, name), old)
+		}
+	}
+	return nil
+}
+
+// files returns the file of an entry, those named after it, such as
+// "001 - Title.en.srt" next to "001 - Title.mp4", and its parts
+func (m *Manifest) files(e Entry) ([]string, error) {
+	stem := strings.TrimSuffix(e.File, filepath.Ext(e.File)) + "."
+	names, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, errors.NewFileSystemError("failed to read playlist directory", err)
+	}
+	parts := make(map[string]bool)
+	for _, part := range e.Parts {
+		parts[part] = true
+	}
+	var files []string
+	for _, n := range names {
+		if !n.IsDir() && (strings.HasPrefix(n.Name(), stem) || parts[n.Name()]) {
+			files = append(files, n.Name())
+		}
+	}
+	return files, nil
+}
+
+func (m *Manifest) remove(name, policy string) error {
+	path := filepath.Join(m.dir, name)
+	if policy == PolicyDelete {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.NewFileSystemError(fmt.Sprintf("failed to delete %s", name), err)
+		}
+		return nil
+	}
+	removed := filepath.Join(m.dir, RemovedDir)
+	if err := os.MkdirAll(removed, 0755); err != nil {
+		return errors.NewFileSystemError("failed to create removed directory", err)
+	}
+	if err := os.Rename(path, freePath(removed, name)); err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to move %s", name), err)
+	}
+	return nil
+}
+
+// freePath returns the path of name in dir, or of "name (2)", "name (3)"
+// and so on if it is taken, so that earlier removals are not overwritten
+func freePath(dir, name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	path := filepath.Join(dir, name)
+	for i := 2; ; i++ {
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+	}
+}
+
+func (m *Manifest) drop(videoID string) {
+	for i, e := range m.Entries {
+		if e.VideoID == videoID {
+			m.Entries = append(m.Entries[:i], m.Entries[i+1:]...)
+			return
+		}
+	}
+}
+
+// renumber replaces the playlist index a filename starts with
+func renumber(name string, from, to int) string {
+	prefix := fmt.Sprintf("%03d - ", from)
+	if !strings.HasPrefix(name, prefix) {
+		return name
+	}
+	return fmt.Sprintf("%03d - ", to) + strings.TrimPrefix(name, prefix)
+}
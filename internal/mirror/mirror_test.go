@@ -0,0 +1,270 @@
+package mirror
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/nfo"
+)
+
+// writeFiles creates empty files in dir
+func writeFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// listFiles returns the files under dir, relative to it
+func listFiles(t *testing.T, dir string) []string {
+	t.Helper()
+	var files []string
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel(dir, path)
+			files = append(files, rel)
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+func TestSync(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Load empty folder: %v", err)
+	}
+	m.PlaylistID = "PL1"
+	m.Add(Entry{VideoID: "a", Title: "A", Index: 1, File: "001 - A.mp4"})
+	m.Add(Entry{VideoID: "b", Title: "B", Index: 2, File: "002 - B.mp4"})
+	m.Add(Entry{VideoID: "c", Title: "C", Index: 3, File: "003 - C.mp4"})
+	m.Add(Entry{VideoID: "d", Title: "D", Index: 4, File: "004 - D.mp4"})
+	writeFiles(t, dir, "001 - A.mp4", "001 - A.en.srt", "002 - B.mp4", "002 - B.jpg", "003 - C.mp4", "notes.txt")
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A and B swapped places, C was removed, D's file was deleted by hand
+	// and E is new
+	m, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := m.Plan([]extractor.Video{{ID: "b"}, {ID: "a"}, {ID: "d", Title: "D"}, {ID: "e", Title: "E"}})
+
+	if len(plan.Moves) != 2 || len(plan.Removed) != 1 || plan.Removed[0].VideoID != "c" {
+		t.Fatalf("plan = %+v", plan)
+	}
+	if want := []Entry{{VideoID: "d", Title: "D", Index: 3}, {VideoID: "e", Title: "E", Index: 4}}; !reflect.DeepEqual(plan.New, want) {
+		t.Errorf("new = %+v, want %+v", plan.New, want)
+	}
+
+	if err := m.Apply(plan, PolicyMove); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{
+		"001 - B.jpg",
+		"001 - B.mp4",
+		"002 - A.en.srt",
+		"002 - A.mp4",
+		ManifestName,
+		filepath.Join(RemovedDir, "003 - C.mp4"),
+		"notes.txt",
+	}
+	sort.Strings(want)
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+
+	m.Add(Entry{VideoID: "e", Title: "E", Index: 4, File: "004 - E.mp4"})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	m, _ = Load(dir)
+	var got []string
+	for _, e := range m.Entries {
+		got = append(got, e.VideoID+"="+e.File)
+	}
+	if want := []string{"b=001 - B.mp4", "a=002 - A.mp4", "e=004 - E.mp4"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("manifest = %v, want %v", got, want)
+	}
+}
+
+func TestApplyDelete(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := Load(dir)
+	m.Add(Entry{VideoID: "a", Index: 1, File: "001 - A.mp4"})
+	writeFiles(t, dir, "001 - A.mp4", "001 - A.jpg")
+
+	if err := m.Apply(m.Plan(nil), "shred"); err == nil {
+		t.Error("expected an error for an unknown policy")
+	}
+	if err := m.Apply(m.Plan(nil), PolicyDelete); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if files := listFiles(t, dir); len(files) != 0 {
+		t.Errorf("files left after deleting: %v", files)
+	}
+	if len(m.Entries) != 0 {
+		t.Errorf("manifest still has %v", m.Entries)
+	}
+}
+
+func TestApplyRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := Load(dir)
+	m.Add(Entry{VideoID: "a", Index: 1, File: "001 - A.mp4"})
+	m.Add(Entry{VideoID: "b", Index: 2, File: "002 - B.mp4"})
+	writeFiles(t, dir, "001 - A.mp4", "002 - B.mp4")
+	m.Save()
+
+	// B can't get its temporary name, after A already has
+	if err := os.MkdirAll(filepath.Join(dir, renamingPrefix+"002 - B.mp4", "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Apply(m.Plan([]extractor.Video{{ID: "b"}, {ID: "a"}}), PolicyMove); err == nil {
+		t.Fatal("expected an error")
+	}
+	os.RemoveAll(filepath.Join(dir, renamingPrefix+"002 - B.mp4"))
+	want := []string{"001 - A.mp4", "002 - B.mp4", ManifestName}
+	sort.Strings(want)
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestLoadFinishesRenames(t *testing.T) {
+	tests := []struct {
+		name  string
+		phase int
+		files []string
+	}{
+		// A has its temporary name, B not yet
+		{"first phase", 1, []string{renamingPrefix + "001 - A.mp4", "002 - B.mp4"}},
+		// B has its new name, A not yet
+		{"second phase", 2, []string{renamingPrefix + "001 - A.mp4", "001 - B.mp4"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files...)
+			target := &Manifest{Entries: []Entry{
+				{VideoID: "b", Index: 1, File: "001 - B.mp4"},
+				{VideoID: "a", Index: 2, File: "002 - A.mp4"},
+			}}
+			j := &journal{Phase: tt.phase, Manifest: target, Renames: []rename{
+				{From: "001 - A.mp4", Temp: renamingPrefix + "001 - A.mp4", To: "002 - A.mp4"},
+				{From: "002 - B.mp4", Temp: renamingPrefix + "002 - B.mp4", To: "001 - B.mp4"},
+			}}
+			if err := j.save(dir); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err := Load(dir)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			want := []string{"001 - B.mp4", "002 - A.mp4", ManifestName}
+			sort.Strings(want)
+			if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+				t.Errorf("files = %v, want %v", got, want)
+			}
+			if len(m.Entries) != 2 || m.Entries[0].File != "001 - B.mp4" {
+				t.Errorf("manifest = %+v", m.Entries)
+			}
+		})
+	}
+}
+
+func TestLoadRestoresTemporaryNames(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, renamingPrefix+"001 - A.mp4", renamingPrefix+"002 - B.mp4", "002 - B.mp4")
+	if _, err := Load(dir); err != nil {
+		t.Fatal(err)
+	}
+	// B's old name is taken, so its temporary file is left for the user
+	want := []string{"001 - A.mp4", renamingPrefix + "002 - B.mp4", "002 - B.mp4"}
+	sort.Strings(want)
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestApplyKeepsEarlierRemovals(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, RemovedDir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, dir, "001 - A.mp4", filepath.Join(RemovedDir, "001 - A.mp4"))
+	m, _ := Load(dir)
+	m.Add(Entry{VideoID: "a", Index: 1, File: "001 - A.mp4"})
+
+	if err := m.Apply(m.Plan(nil), PolicyMove); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{filepath.Join(RemovedDir, "001 - A (2).mp4"), filepath.Join(RemovedDir, "001 - A.mp4")}
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+}
+
+func TestApplyMovesParts(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := Load(dir)
+	m.Add(Entry{VideoID: "a", Index: 1, File: "001 - A.mp4", Parts: []string{"A - 01 Intro.mp4", "A - 02 Outro.mp4"}})
+	m.Add(Entry{VideoID: "b", Index: 2, File: "002 - B.mp4", Parts: []string{"B - 01 Intro.mp4"}})
+	writeFiles(t, dir, "001 - A.mp4", "A - 01 Intro.mp4", "A - 02 Outro.mp4", "002 - B.mp4", "B - 01 Intro.mp4", "A - 03 Other.mp4")
+
+	// A left the playlist and B moved up
+	if err := m.Apply(m.Plan([]extractor.Video{{ID: "b"}}), PolicyMove); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	want := []string{
+		"001 - B.mp4",
+		"A - 03 Other.mp4",
+		"B - 01 Intro.mp4",
+		ManifestName,
+		filepath.Join(RemovedDir, "001 - A.mp4"),
+		filepath.Join(RemovedDir, "A - 01 Intro.mp4"),
+		filepath.Join(RemovedDir, "A - 02 Outro.mp4"),
+	}
+	sort.Strings(want)
+	if got := listFiles(t, dir); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if len(m.Entries) != 1 || !reflect.DeepEqual(m.Entries[0].Parts, []string{"B - 01 Intro.mp4"}) {
+		t.Errorf("manifest = %+v", m.Entries)
+	}
+}
+
+func TestApplyRenumbersNFO(t *testing.T) {
+	dir := t.TempDir()
+	m, _ := Load(dir)
+	m.Add(Entry{VideoID: "a", Title: "A", Index: 1, File: "001 - A.mp4"})
+	m.Add(Entry{VideoID: "b", Title: "B", Index: 2, File: "002 - B.mp4"})
+	writeFiles(t, dir, "001 - A.mp4", "002 - B.mp4")
+	details := &extractor.VideoDetails{ID: "a", Title: "A"}
+	if err := nfo.Write(filepath.Join(dir, "001 - A.nfo"), nfo.NewEpisode(details, &nfo.Position{Season: 1, Episode: 1})); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Apply(m.Plan([]extractor.Video{{ID: "b"}, {ID: "c"}, {ID: "a"}}), PolicyMove); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "003 - A.nfo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "<episode>3</episode>") {
+		t.Errorf("moved NFO was not renumbered:\n%s", data)
+	}
+}
@@ -0,0 +1,204 @@
+package mkv
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// cuePoint is a cluster listed in the cues
+type cuePoint struct {
+	time     int64 // in timestamp ticks
+	position int64 // relative to the segment data start
+}
+
+// Clip writes the given ranges of a Matroska file read through r, which is
+// size bytes long, to dst like Trim. The cues are used to read only the
+// clusters the ranges need, so r can be a remote file fetched with range
+// requests. Files without cues are read in full.
+func Clip(r io.ReaderAt, size int64, dst string, ranges []Range) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no ranges to keep")
+	}
+
+	mf, err := scanReader(r, size, true)
+	if err != nil {
+		return err
+	}
+	if len(mf.elements) == 0 {
+		return fmt.Errorf("no media found")
+	}
+
+	scale := int64(1000000)
+	var cues *level1
+	var seekHead *level1
+	for _, elem := range mf.elements {
+		switch elem.ID {
+		case IDInfo:
+			if scale, _, err = parseDuration(elem.Data); err != nil {
+				return fmt.Errorf("failed to parse segment info: %w", err)
+			}
+		case IDCues:
+			cues = elem
+		case IDSeekHead:
+			seekHead = elem
+		}
+	}
+	if cues == nil && seekHead != nil {
+		if pos, ok := seekPosition(seekHead.Data, IDCues); ok {
+			if cues, err = readElementAt(r, mf, mf.segStart+pos); err != nil {
+				return err
+			}
+		}
+	}
+
+	var points []cuePoint
+	if cues != nil {
+		if points, err = parseCues(cues.Data); err != nil {
+			return fmt.Errorf("failed to parse cues: %w", err)
+		}
+	}
+	if len(points) == 0 {
+		// Without an index every cluster has to be read
+		full, err := scanReader(r, size, false)
+		if err != nil {
+			return err
+		}
+		return full.trim(r, dst, ranges)
+	}
+
+	// Walk the clusters from the cue point before each range start to the
+	// one after its end, which is needed to place the cut
+	head := mf.elements[len(mf.elements)-1]
+	clustersAt := head.Offset + head.Size
+	ticks := func(d time.Duration) int64 { return int64(d) / scale }
+	var spans [][2]int64
+	for _, rg := range ranges {
+		start := clustersAt
+		i := sort.Search(len(points), func(i int) bool { return points[i].time > ticks(rg.Start) })
+		if i > 0 {
+			start = mf.segStart + points[i-1].position
+		}
+		end := mf.segEnd
+		if rg.End > 0 {
+			j := sort.Search(len(points), func(j int) bool { return points[j].time >= ticks(rg.End) })
+			if j < len(points) {
+				end = max(mf.segStart+points[j].position, start)
+			}
+		}
+		spans = append(spans, [2]int64{start, end})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+
+	walked := make(map[int64]bool)
+	for _, span := range spans {
+		for pos := span[0]; pos <= span[1] && pos < mf.segEnd; {
+			h, err := readHeader(r, pos)
+			if err != nil {
+				return fmt.Errorf("failed to read element at %d: %w", pos, err)
+			}
+			elem, err := readLevel1(r, mf, pos, h)
+			if err != nil {
+				return err
+			}
+			if elem.ID == IDCluster && !walked[pos] {
+				walked[pos] = true
+				mf.elements = append(mf.elements, elem)
+			}
+			pos += elem.Size
+		}
+	}
+
+	return mf.trim(r, dst, ranges)
+}
+
+// readElementAt reads the top-level element at pos
+func readElementAt(r io.ReaderAt, mf *file, pos int64) (*level1, error) {
+	h, err := readHeader(r, pos)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read element at %d: %w", pos, err)
+	}
+	return readLevel1(r, mf, pos, h)
+}
+
+// seekPosition looks up the position of the element with the given ID in a
+// seek head
+func seekPosition(data []byte, id uint32) (int64, bool) {
+	h, err := parseHeader(data)
+	if err != nil {
+		return 0, false
+	}
+	seeks, err := children(data[h.Len:])
+	if err != nil {
+		return 0, false
+	}
+	for _, seek := range seeks {
+		if seek.ID != IDSeek {
+			continue
+		}
+		entries, err := children(seek.Body)
+		if err != nil {
+			continue
+		}
+		var seekID uint32
+		var pos int64 = -1
+		for _, e := range entries {
+			switch e.ID {
+			case IDSeekID:
+				seekID = uint32(readUint(e.Body))
+			case IDSeekPos:
+				pos = int64(readUint(e.Body))
+			}
+		}
+		if seekID == id && pos >= 0 {
+			return pos, true
+		}
+	}
+	return 0, false
+}
+
+// parseCues returns the cue points of a cues element, sorted by time
+func parseCues(data []byte) ([]cuePoint, error) {
+	h, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	elems, err := children(data[h.Len:])
+	if err != nil {
+		return nil, err
+	}
+
+	var points []cuePoint
+	for _, cp := range elems {
+		if cp.ID != IDCuePoint {
+			continue
+		}
+		fields, err := children(cp.Body)
+		if err != nil {
+			return nil, err
+		}
+		point := cuePoint{time: -1, position: -1}
+		for _, f := range fields {
+			switch f.ID {
+			case IDCueTime:
+				point.time = int64(readUint(f.Body))
+			case IDCueTrackPositions:
+				positions, err := children(f.Body)
+				if err != nil {
+					return nil, err
+				}
+				for _, p := range positions {
+					if p.ID == IDCueClusterPosition {
+						point.position = int64(readUint(p.Body))
+					}
+				}
+			}
+		}
+		if point.time >= 0 && point.position >= 0 {
+			points = append(points, point)
+		}
+	}
+	sort.Slice(points, func(i, j int) bool { return points[i].time < points[j].time })
+	return points, nil
+}
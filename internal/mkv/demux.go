@@ -0,0 +1,377 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"time"
+)
+
+// Track, cluster and block element IDs used by the demuxer
+const (
+	IDTrackEntry     = 0xAE
+	IDTrackNumber    = 0xD7
+	IDTrackType      = 0x83
+	IDCodecID        = 0x86
+	IDCodecPrivate   = 0x63A2
+	IDCodecDelay     = 0x56AA
+	IDAudio          = 0xE1
+	IDSamplingFreq   = 0xB5
+	IDChannels       = 0x9F
+	IDTimestampScale = 0x2AD7B1
+	IDTimestamp      = 0xE7
+	IDSimpleBlock    = 0xA3
+	IDBlockGroup     = 0xA0
+	IDBlock          = 0xA1
+	IDDiscardPadding = 0x75A2
+)
+
+// TrackType values of video and audio tracks
+const (
+	TrackTypeVideo = 1
+	TrackTypeAudio = 2
+)
+
+// Track describes a track entry of the segment
+type Track struct {
+	Number       uint64
+	Type         uint64
+	CodecID      string
+	CodecPrivate []byte
+	CodecDelay   time.Duration
+	SampleRate   float64
+	Channels     uint64
+}
+
+// Frame is a single frame of a track. Frames from a laced block share the
+// block's timestamp.
+type Frame struct {
+	Track     uint64
+	Timestamp time.Duration
+	Keyframe  bool
+	Data      []byte
+	// DiscardPadding is the duration of audio to drop from the end of the
+	// frame, set on the last frame of a stream
+	DiscardPadding time.Duration
+}
+
+// Reader reads the tracks and frames of a Matroska or WebM file
+type Reader struct {
+	f              *os.File
+	mf             *file
+	timestampScale int64
+	tracks         []Track
+}
+
+// OpenReader opens a Matroska file for demuxing
+func OpenReader(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	mf, err := scan(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	r := &Reader{f: f, mf: mf, timestampScale: 1000000}
+	for _, elem := range mf.elements {
+		switch elem.ID {
+		case IDInfo:
+			err = r.parseInfo(elem.Data)
+		case IDTracks:
+			err = r.parseTracks(elem.Data)
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Close closes the underlying file
+func (r *Reader) Close() error {
+	return r.f.Close()
+}
+
+// Tracks returns the track entries of the segment
+func (r *Reader) Tracks() []Track {
+	return r.tracks
+}
+
+func (r *Reader) parseInfo(data []byte) error {
+	h, err := parseHeader(data)
+	if err != nil {
+		return err
+	}
+	elems, err := children(data[h.Len:])
+	if err != nil {
+		return fmt.Errorf("failed to parse segment info: %w", err)
+	}
+	for _, elem := range elems {
+		if elem.ID == IDTimestampScale {
+			if scale := readUint(elem.Body); scale > 0 {
+				r.timestampScale = int64(scale)
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Reader) parseTracks(data []byte) error {
+	h, err := parseHeader(data)
+	if err != nil {
+		return err
+	}
+	entries, err := children(data[h.Len:])
+	if err != nil {
+		return fmt.Errorf("failed to parse tracks: %w", err)
+	}
+
+	for _, entry := range entries {
+		if entry.ID != IDTrackEntry {
+			continue
+		}
+		fields, err := children(entry.Body)
+		if err != nil {
+			return fmt.Errorf("failed to parse track entry: %w", err)
+		}
+
+		var track Track
+		for _, field := range fields {
+			switch field.ID {
+			case IDTrackNumber:
+				track.Number = readUint(field.Body)
+			case IDTrackType:
+				track.Type = readUint(field.Body)
+			case IDCodecID:
+				track.CodecID = string(field.Body)
+			case IDCodecPrivate:
+				track.CodecPrivate = append([]byte(nil), field.Body...)
+			case IDCodecDelay:
+				track.CodecDelay = time.Duration(readUint(field.Body))
+			case IDAudio:
+				audio, err := children(field.Body)
+				if err != nil {
+					return fmt.Errorf("failed to parse audio settings: %w", err)
+				}
+				for _, a := range audio {
+					switch a.ID {
+					case IDSamplingFreq:
+						track.SampleRate = readFloat(a.Body)
+					case IDChannels:
+						track.Channels = readUint(a.Body)
+					}
+				}
+			}
+		}
+		r.tracks = append(r.tracks, track)
+	}
+	return nil
+}
+
+// Frames calls fn for every frame in the file, in storage order
+func (r *Reader) Frames(fn func(Frame) error) error {
+	for _, elem := range r.mf.elements {
+		if elem.ID != IDCluster {
+			continue
+		}
+		data := make([]byte, elem.Size)
+		if _, err := r.f.ReadAt(data, elem.Offset); err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read cluster at %d: %w", elem.Offset, err)
+		}
+		if err := r.clusterFrames(data, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clusterFrames walks the elements of a cluster. Clusters of unknown size,
+// as written by live muxers, run into the clusters that follow them, so
+// cluster headers met on the way are entered rather than skipped.
+func (r *Reader) clusterFrames(data []byte, fn func(Frame) error) error {
+	var clusterTime int64
+
+	for pos := 0; pos < len(data); {
+		h, err := parseHeader(data[pos:])
+		if err != nil {
+			return fmt.Errorf("invalid cluster element: %w", err)
+		}
+		if h.ID == IDCluster || h.Size == unknownSize {
+			pos += h.Len
+			continue
+		}
+
+		end := pos + h.Len + int(h.Size)
+		if end > len(data) {
+			// A truncated trailing element, e.g. from an interrupted
+			// download
+			return nil
+		}
+		body := data[pos+h.Len : end]
+
+		switch h.ID {
+		case IDTimestamp:
+			clusterTime = int64(readUint(body))
+		case IDSimpleBlock:
+			if err := r.blockFrames(body, clusterTime, true, 0, fn); err != nil {
+				return err
+			}
+		case IDBlockGroup:
+			if err := r.groupFrames(body, clusterTime, fn); err != nil {
+				return err
+			}
+		}
+		pos = end
+	}
+	return nil
+}
+
+func (r *Reader) groupFrames(body []byte, clusterTime int64, fn func(Frame) error) error {
+	fields, err := children(body)
+	if err != nil {
+		return fmt.Errorf("invalid block group: %w", err)
+	}
+
+	var block []byte
+	var discard time.Duration
+	for _, field := range fields {
+		switch field.ID {
+		case IDBlock:
+			block = field.Body
+		case IDDiscardPadding:
+			discard = time.Duration(readInt(field.Body))
+		}
+	}
+	if block == nil {
+		return nil
+	}
+	return r.blockFrames(block, clusterTime, false, discard, fn)
+}
+
+// blockFrames splits a Block or SimpleBlock into its frames
+func (r *Reader) blockFrames(block []byte, clusterTime int64, simple bool, discard time.Duration, fn func(Frame) error) error {
+	track, n, _, err := readVint(block)
+	if err != nil || len(block) < n+3 {
+		return fmt.Errorf("invalid block header")
+	}
+	relative := int64(int16(binary.BigEndian.Uint16(block[n:])))
+	flags := block[n+2]
+	payload := block[n+3:]
+
+	frames, err := unlace(payload, (flags>>1)&0x3)
+	if err != nil {
+		return err
+	}
+
+	timestamp := time.Duration((clusterTime + relative) * r.timestampScale)
+	for i, data := range frames {
+		frame := Frame{
+			Track:     track,
+			Timestamp: timestamp,
+			Keyframe:  simple && flags&0x80 != 0,
+			Data:      data,
+		}
+		if i == len(frames)-1 {
+			frame.DiscardPadding = discard
+		}
+		if err := fn(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unlace splits a block payload according to its lacing mode
+func unlace(payload []byte, lacing byte) ([][]byte, error) {
+	if lacing == 0 {
+		return [][]byte{payload}, nil
+	}
+	if len(payload) == 0 {
+		return nil, fmt.Errorf("invalid laced block")
+	}
+
+	count := int(payload[0]) + 1
+	payload = payload[1:]
+	sizes := make([]int, count)
+
+	switch lacing {
+	case 1: // Xiph
+		for i := 0; i < count-1; i++ {
+			for {
+				if len(payload) == 0 {
+					return nil, fmt.Errorf("invalid Xiph lacing")
+				}
+				b := payload[0]
+				payload = payload[1:]
+				sizes[i] += int(b)
+				if b != 0xFF {
+					break
+				}
+			}
+		}
+	case 2: // fixed-size
+		if len(payload)%count != 0 {
+			return nil, fmt.Errorf("invalid fixed-size lacing")
+		}
+		for i := 0; i < count-1; i++ {
+			sizes[i] = len(payload) / count
+		}
+	case 3: // EBML
+		first, n, _, err := readVint(payload)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EBML lacing")
+		}
+		payload = payload[n:]
+		sizes[0] = int(first)
+		for i := 1; i < count-1; i++ {
+			raw, n, _, err := readVint(payload)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EBML lacing")
+			}
+			payload = payload[n:]
+			// Differences are stored with a bias of half the range
+			diff := int64(raw) - (int64(1)<<(7*n-1) - 1)
+			sizes[i] = sizes[i-1] + int(diff)
+		}
+	}
+
+	frames := make([][]byte, count)
+	for i := 0; i < count-1; i++ {
+		if sizes[i] < 0 || sizes[i] > len(payload) {
+			return nil, fmt.Errorf("invalid lace size")
+		}
+		frames[i] = payload[:sizes[i]]
+		payload = payload[sizes[i]:]
+	}
+	frames[count-1] = payload
+	return frames, nil
+}
+
+func readInt(body []byte) int64 {
+	if len(body) == 0 {
+		return 0
+	}
+	v := int64(int8(body[0]))
+	for _, b := range body[1:] {
+		v = v<<8 | int64(b)
+	}
+	return v
+}
+
+func readFloat(body []byte) float64 {
+	switch len(body) {
+	case 4:
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(body)))
+	case 8:
+		return math.Float64frombits(binary.BigEndian.Uint64(body))
+	}
+	return 0
+}
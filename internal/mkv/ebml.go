@@ -0,0 +1,229 @@
+package mkv
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Element IDs used by the rewriter, with their length marker bits kept
+const (
+	IDEBML        = 0x1A45DFA3
+	IDSegment     = 0x18538067
+	IDSeekHead    = 0x114D9B74
+	IDSeek        = 0x4DBB
+	IDSeekID      = 0x53AB
+	IDSeekPos     = 0x53AC
+	IDInfo        = 0x1549A966
+	IDTracks      = 0x1654AE6B
+	IDCues        = 0x1C53BB6B
+	IDCluster     = 0x1F43B675
+	IDTags        = 0x1254C367
+	IDChapters    = 0x1043A770
+	IDAttachments = 0x1941A469
+	IDVoid        = 0xEC
+	IDCRC32       = 0xBF
+
+	IDCuePoint           = 0xBB
+	IDCueTrackPositions  = 0xB7
+	IDCueClusterPosition = 0xF1
+
+	IDTag             = 0x7373
+	IDTargets         = 0x63C0
+	IDTargetTypeValue = 0x68CA
+	IDTagTrackUID     = 0x63C5
+	IDTagEditionUID   = 0x63C9
+	IDTagChapterUID   = 0x63C4
+	IDTagAttachUID    = 0x63C6
+	IDSimpleTag       = 0x67C8
+	IDTagName         = 0x45A3
+	IDTagLanguage     = 0x447A
+	IDTagString       = 0x4487
+
+	IDEditionEntry     = 0x45B9
+	IDEditionUID       = 0x45BC
+	IDEditionFlagDef   = 0x45DB
+	IDChapterAtom      = 0xB6
+	IDChapterUID       = 0x73C4
+	IDChapterTimeStart = 0x91
+	IDChapterTimeEnd   = 0x92
+	IDChapterDisplay   = 0x80
+	IDChapString       = 0x85
+	IDChapLanguage     = 0x437C
+)
+
+// unknownSize is the value of an all-ones size field, used by live
+// streams for elements whose length is not known when they are written
+const unknownSize = -1
+
+// header is a parsed element header
+type header struct {
+	ID   uint32
+	Size int64 // unknownSize if not known
+	Len  int   // length of ID plus size field
+}
+
+// readVint reads a variable-length integer, returning its value with the
+// length marker cleared, its encoded length and whether all value bits are
+// set (the "unknown" marker for sizes)
+func readVint(data []byte) (uint64, int, bool, error) {
+	if len(data) == 0 {
+		return 0, 0, false, io.ErrUnexpectedEOF
+	}
+	length := bits.LeadingZeros8(data[0]) + 1
+	if length > 8 {
+		return 0, 0, false, fmt.Errorf("invalid variable-length integer")
+	}
+	if len(data) < length {
+		return 0, 0, false, io.ErrUnexpectedEOF
+	}
+
+	value := uint64(data[0]) & (0xFF >> length)
+	allOnes := value == 0xFF>>length
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+	return value, length, allOnes, nil
+}
+
+// parseHeader reads an element ID and size
+func parseHeader(data []byte) (header, error) {
+	if len(data) == 0 {
+		return header{}, io.ErrUnexpectedEOF
+	}
+	idLen := bits.LeadingZeros8(data[0]) + 1
+	if idLen > 4 {
+		return header{}, fmt.Errorf("invalid element ID")
+	}
+	if len(data) < idLen {
+		return header{}, io.ErrUnexpectedEOF
+	}
+	var id uint32
+	for _, b := range data[:idLen] {
+		id = id<<8 | uint32(b)
+	}
+
+	size, sizeLen, unknown, err := readVint(data[idLen:])
+	if err != nil {
+		return header{}, err
+	}
+
+	h := header{ID: id, Size: int64(size), Len: idLen + sizeLen}
+	if unknown {
+		h.Size = unknownSize
+	}
+	return h, nil
+}
+
+// readHeader reads an element header at offset
+func readHeader(r io.ReaderAt, offset int64) (header, error) {
+	buf := make([]byte, 12)
+	n, err := r.ReadAt(buf, offset)
+	if n == 0 && err != nil {
+		return header{}, err
+	}
+	return parseHeader(buf[:n])
+}
+
+// encodeID returns the bytes of an element ID
+func encodeID(id uint32) []byte {
+	switch {
+	case id > 0xFFFFFF:
+		return []byte{byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFFFF:
+		return []byte{byte(id >> 16), byte(id >> 8), byte(id)}
+	case id > 0xFF:
+		return []byte{byte(id >> 8), byte(id)}
+	default:
+		return []byte{byte(id)}
+	}
+}
+
+// encodeSize returns the shortest size field for n. A width of 8 forces
+// the eight-byte form so the field can be patched later.
+func encodeSize(n int64, width int) []byte {
+	if width == 0 {
+		width = 1
+		for width < 8 && uint64(n) >= (1<<(7*width))-1 {
+			width++
+		}
+	}
+	out := make([]byte, width)
+	v := uint64(n)
+	for i := width - 1; i >= 0; i-- {
+		out[i] = byte(v)
+		v >>= 8
+	}
+	out[0] |= 0x80 >> (width - 1)
+	return out
+}
+
+// unknownSizeField is the eight-byte "size unknown" marker
+var unknownSizeField = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+// element builds an element from its ID and body parts
+func element(id uint32, body ...[]byte) []byte {
+	var size int
+	for _, part := range body {
+		size += len(part)
+	}
+	out := append(encodeID(id), encodeSize(int64(size), 0)...)
+	for _, part := range body {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func stringElement(id uint32, s string) []byte {
+	return element(id, []byte(s))
+}
+
+// uintElement encodes an unsigned integer in the fewest bytes
+func uintElement(id uint32, v uint64) []byte {
+	n := (bits.Len64(v) + 7) / 8
+	if n == 0 {
+		n = 1
+	}
+	return element(id, binary.BigEndian.AppendUint64(nil, v)[8-n:])
+}
+
+// fixedUintElement encodes an unsigned integer in eight bytes, so the
+// element size does not depend on the value
+func fixedUintElement(id uint32, v uint64) []byte {
+	return element(id, binary.BigEndian.AppendUint64(nil, v))
+}
+
+// children splits the body of a master element into its child elements
+func children(body []byte) ([]rawElement, error) {
+	var elems []rawElement
+	for len(body) > 0 {
+		h, err := parseHeader(body)
+		if err != nil {
+			return nil, err
+		}
+		if h.Size == unknownSize || int64(h.Len)+h.Size > int64(len(body)) {
+			return nil, fmt.Errorf("invalid size for element 0x%X", h.ID)
+		}
+		end := h.Len + int(h.Size)
+		elems = append(elems, rawElement{ID: h.ID, Data: body[:end], Body: body[h.Len:end]})
+		body = body[end:]
+	}
+	return elems, nil
+}
+
+// rawElement is an element held in memory
+type rawElement struct {
+	ID   uint32
+	Data []byte // the whole element including its header
+	Body []byte
+}
+
+func readUint(body []byte) uint64 {
+	var v uint64
+	for _, b := range body {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
@@ -0,0 +1,534 @@
+package mkv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"time"
+)
+
+// Tag is a global simple tag such as TITLE or ARTIST
+type Tag struct {
+	Name  string
+	Value string
+}
+
+// Chapter is a named section of the segment. End may be zero when unknown.
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// IsMatroska reports whether the file starts with an EBML header, as
+// Matroska and WebM files do
+func IsMatroska(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	h, err := readHeader(f, 0)
+	return err == nil && h.ID == IDEBML
+}
+
+// level1 is a top-level element of the segment
+type level1 struct {
+	ID     uint32
+	Offset int64 // position in the source file
+	Size   int64 // whole element including header
+	Data   []byte
+	// NewOffset is the position relative to the new segment data start
+	NewOffset int64
+}
+
+// file describes the layout of a Matroska file
+type file struct {
+	prefixEnd  int64 // end of everything before the segment element
+	segStart   int64 // start of the segment data
+	segEnd     int64
+	unknown    bool // segment size unknown
+	elements   []*level1
+	fileSize   int64
+	trailingAt int64 // start of data after the segment, if any
+}
+
+// scan reads the layout of the segment, loading every top-level element
+// except clusters into memory
+func scan(f *os.File) (*file, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return scanReader(f, info.Size(), false)
+}
+
+// scanReader reads the layout of a Matroska file of the given size like
+// scan. With head set it stops at the first cluster.
+func scanReader(r io.ReaderAt, size int64, head bool) (*file, error) {
+	h, err := readHeader(r, 0)
+	if err != nil || h.ID != IDEBML {
+		return nil, fmt.Errorf("not a Matroska file")
+	}
+
+	offset := int64(h.Len) + h.Size
+	for {
+		h, err = readHeader(r, offset)
+		if err != nil {
+			return nil, fmt.Errorf("no segment found: %w", err)
+		}
+		if h.ID == IDSegment {
+			break
+		}
+		if h.Size == unknownSize {
+			return nil, fmt.Errorf("no segment found")
+		}
+		offset += int64(h.Len) + h.Size
+	}
+
+	mf := &file{
+		prefixEnd: offset,
+		segStart:  offset + int64(h.Len),
+		fileSize:  size,
+		unknown:   h.Size == unknownSize,
+	}
+	mf.segEnd = mf.segStart + h.Size
+	if mf.unknown || mf.segEnd > size {
+		mf.segEnd = size
+	}
+	mf.trailingAt = mf.segEnd
+
+	for pos := mf.segStart; pos < mf.segEnd; {
+		h, err := readHeader(r, pos)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read element at %d: %w", pos, err)
+		}
+		if head && h.ID == IDCluster {
+			break
+		}
+
+		elem, err := readLevel1(r, mf, pos, h)
+		if err != nil {
+			return nil, err
+		}
+		mf.elements = append(mf.elements, elem)
+		pos += elem.Size
+	}
+
+	return mf, nil
+}
+
+// readLevel1 reads the top-level element at pos, whose header is h, loading
+// it into memory unless it is a cluster
+func readLevel1(r io.ReaderAt, mf *file, pos int64, h header) (*level1, error) {
+	elem := &level1{ID: h.ID, Offset: pos, Size: int64(h.Len) + h.Size}
+	if h.Size == unknownSize || pos+elem.Size > mf.segEnd {
+		// An element of unknown size (a live cluster) runs to the end
+		// of the segment
+		elem.Size = mf.segEnd - pos
+	}
+
+	if elem.ID != IDCluster && h.Size != unknownSize {
+		elem.Data = make([]byte, elem.Size)
+		if _, err := r.ReadAt(elem.Data, pos); err != nil {
+			return nil, fmt.Errorf("failed to read element at %d: %w", pos, err)
+		}
+	}
+	return elem, nil
+}
+
+// WriteMetadata copies src to dst with the given global tags and chapters.
+// Existing global tags and chapters are replaced; tags targeting tracks or
+// chapters are kept. Media data is copied unchanged, and the seek index and
+// cue positions are updated for the new layout.
+func WriteMetadata(src, dst string, tags []Tag, chapters []Chapter) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	mf, err := scan(in)
+	if err != nil {
+		return err
+	}
+
+	// Drop the elements that are regenerated and find where new metadata
+	// goes: after the track list, ahead of the media
+	var (
+		kept      []*level1
+		cues      *level1
+		oldTags   *level1
+		insertAt  = -1
+		firstData = -1
+	)
+	for _, elem := range mf.elements {
+		switch elem.ID {
+		case IDSeekHead, IDVoid:
+			continue
+		case IDChapters:
+			if len(chapters) > 0 {
+				continue
+			}
+		case IDTags:
+			oldTags = elem
+			continue
+		case IDCues:
+			cues = elem
+		case IDCluster:
+			if firstData < 0 {
+				firstData = len(kept)
+			}
+		}
+		kept = append(kept, elem)
+		if elem.ID == IDTracks {
+			insertAt = len(kept)
+		}
+	}
+	if insertAt < 0 {
+		insertAt = firstData
+	}
+	if insertAt < 0 {
+		insertAt = len(kept)
+	}
+
+	var inserted []*level1
+	if len(chapters) > 0 {
+		inserted = append(inserted, &level1{ID: IDChapters, Data: chaptersElement(chapters)})
+	}
+	tagsData, err := tagsElement(tags, oldTags)
+	if err != nil {
+		return err
+	}
+	if tagsData != nil {
+		inserted = append(inserted, &level1{ID: IDTags, Data: tagsData})
+	}
+	for _, elem := range inserted {
+		elem.Size = int64(len(elem.Data))
+	}
+
+	layout := append(append(append([]*level1{}, kept[:insertAt]...), inserted...), kept[insertAt:]...)
+
+	// Cue positions are rewritten with fixed-width values, so sizes can be
+	// computed before the positions are known
+	var originalCues []byte
+	if cues != nil {
+		originalCues = cues.Data
+		if cues.Data, err = rewriteCues(originalCues, func(int64) int64 { return 0 }); err != nil {
+			return fmt.Errorf("failed to rewrite cues: %w", err)
+		}
+		cues.Size = int64(len(cues.Data))
+	}
+
+	seekHead := seekHeadElement(layout)
+	pos := int64(len(seekHead))
+	for _, elem := range layout {
+		elem.NewOffset = pos
+		pos += elem.Size
+	}
+	segmentSize := pos
+	seekHead = seekHeadElement(layout)
+
+	if cues != nil {
+		if cues.Data, err = rewriteCues(originalCues, mf.newPosition); err != nil {
+			return fmt.Errorf("failed to rewrite cues: %w", err)
+		}
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	if err := mf.write(w, in, seekHead, layout, segmentSize); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// ReadTags returns the global tags of a Matroska file, in file order.
+// Tags that target tracks or chapters are left out.
+func ReadTags(path string) ([]Tag, error) {
+	in, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+
+	mf, err := scan(in)
+	if err != nil {
+		return nil, err
+	}
+	var tags []Tag
+	for _, elem := range mf.elements {
+		if elem.ID != IDTags || elem.Data == nil {
+			continue
+		}
+		h, err := parseHeader(elem.Data)
+		if err != nil {
+			return nil, err
+		}
+		entries, err := children(elem.Data[h.Len:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tags: %w", err)
+		}
+		for _, entry := range entries {
+			if entry.ID != IDTag || targetsSpecific(entry.Body) {
+				continue
+			}
+			simple, err := children(entry.Body)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse tags: %w", err)
+			}
+			for _, st := range simple {
+				if st.ID != IDSimpleTag {
+					continue
+				}
+				fields, err := children(st.Body)
+				if err != nil {
+					return nil, fmt.Errorf("failed to parse tags: %w", err)
+				}
+				var tag Tag
+				for _, f := range fields {
+					switch f.ID {
+					case IDTagName:
+						tag.Name = string(f.Body)
+					case IDTagString:
+						tag.Value = string(f.Body)
+					}
+				}
+				if tag.Name != "" {
+					tags = append(tags, tag)
+				}
+			}
+		}
+	}
+	return tags, nil
+}
+
+// newPosition maps a segment-relative position in the source file to the
+// same data in the rewritten file
+func (mf *file) newPosition(old int64) int64 {
+	abs := mf.segStart + old
+	i := sort.Search(len(mf.elements), func(i int) bool {
+		return mf.elements[i].Offset+mf.elements[i].Size > abs
+	})
+	if i == len(mf.elements) {
+		return old
+	}
+	elem := mf.elements[i]
+	return elem.NewOffset + (abs - elem.Offset)
+}
+
+func (mf *file) write(w io.Writer, in io.ReaderAt, seekHead []byte, layout []*level1, segmentSize int64) error {
+	if _, err := io.Copy(w, io.NewSectionReader(in, 0, mf.prefixEnd)); err != nil {
+		return err
+	}
+
+	header := encodeID(IDSegment)
+	if mf.unknown {
+		header = append(header, unknownSizeField...)
+	} else {
+		header = append(header, encodeSize(segmentSize, 8)...)
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	if _, err := w.Write(seekHead); err != nil {
+		return err
+	}
+
+	for _, elem := range layout {
+		var err error
+		if elem.Data != nil {
+			_, err = w.Write(elem.Data)
+		} else {
+			_, err = io.Copy(w, io.NewSectionReader(in, elem.Offset, elem.Size))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to copy element 0x%X: %w", elem.ID, err)
+		}
+	}
+
+	if mf.trailingAt < mf.fileSize {
+		if _, err := io.Copy(w, io.NewSectionReader(in, mf.trailingAt, mf.fileSize-mf.trailingAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// seekHeadElement indexes every top-level element except clusters
+func seekHeadElement(layout []*level1) []byte {
+	var seeks [][]byte
+	for _, elem := range layout {
+		switch elem.ID {
+		case IDInfo, IDTracks, IDCues, IDTags, IDChapters, IDAttachments:
+			seeks = append(seeks, element(IDSeek,
+				element(IDSeekID, encodeID(elem.ID)),
+				fixedUintElement(IDSeekPos, uint64(elem.NewOffset)),
+			))
+		}
+	}
+	return element(IDSeekHead, seeks...)
+}
+
+// rewriteCues maps every cluster position in a Cues element and writes the
+// positions in eight bytes
+func rewriteCues(data []byte, mapPos func(int64) int64) ([]byte, error) {
+	h, err := parseHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	points, err := children(data[h.Len:])
+	if err != nil {
+		return nil, err
+	}
+
+	var body [][]byte
+	for _, point := range points {
+		if point.ID != IDCuePoint {
+			if point.ID != IDCRC32 && point.ID != IDVoid {
+				body = append(body, point.Data)
+			}
+			continue
+		}
+
+		fields, err := children(point.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		var pointBody [][]byte
+		for _, field := range fields {
+			if field.ID != IDCueTrackPositions {
+				pointBody = append(pointBody, field.Data)
+				continue
+			}
+
+			positions, err := children(field.Body)
+			if err != nil {
+				return nil, err
+			}
+			var posBody [][]byte
+			for _, p := range positions {
+				if p.ID == IDCueClusterPosition {
+					mapped := mapPos(int64(readUint(p.Body)))
+					posBody = append(posBody, fixedUintElement(IDCueClusterPosition, uint64(mapped)))
+				} else {
+					posBody = append(posBody, p.Data)
+				}
+			}
+			pointBody = append(pointBody, element(IDCueTrackPositions, posBody...))
+		}
+		body = append(body, element(IDCuePoint, pointBody...))
+	}
+
+	return element(IDCues, body...), nil
+}
+
+// tagsElement builds a Tags element holding the given global tags plus any
+// existing tags that target specific tracks, chapters or attachments
+func tagsElement(tags []Tag, existing *level1) ([]byte, error) {
+	var body [][]byte
+
+	if existing != nil && existing.Data != nil {
+		h, err := parseHeader(existing.Data)
+		if err != nil {
+			return nil, err
+		}
+		elems, err := children(existing.Data[h.Len:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tags: %w", err)
+		}
+		for _, tag := range elems {
+			if tag.ID == IDTag && (len(tags) == 0 || targetsSpecific(tag.Body)) {
+				body = append(body, tag.Data)
+			}
+		}
+	}
+
+	if len(tags) > 0 {
+		tag := [][]byte{element(IDTargets, uintElement(IDTargetTypeValue, 50))}
+		for _, t := range tags {
+			if t.Value == "" {
+				continue
+			}
+			tag = append(tag, element(IDSimpleTag,
+				stringElement(IDTagName, t.Name),
+				stringElement(IDTagLanguage, "und"),
+				stringElement(IDTagString, t.Value),
+			))
+		}
+		body = append(body, element(IDTag, tag...))
+	}
+
+	if len(body) == 0 {
+		return nil, nil
+	}
+	return element(IDTags, body...), nil
+}
+
+// targetsSpecific reports whether a Tag applies to particular tracks,
+// editions, chapters or attachments rather than the whole segment
+func targetsSpecific(tagBody []byte) bool {
+	elems, err := children(tagBody)
+	if err != nil {
+		return true
+	}
+	for _, elem := range elems {
+		if elem.ID != IDTargets {
+			continue
+		}
+		targets, err := children(elem.Body)
+		if err != nil {
+			return true
+		}
+		for _, target := range targets {
+			switch target.ID {
+			case IDTagTrackUID, IDTagEditionUID, IDTagChapterUID, IDTagAttachUID:
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func chaptersElement(chapters []Chapter) []byte {
+	atoms := [][]byte{
+		uintElement(IDEditionUID, uid()),
+		uintElement(IDEditionFlagDef, 1),
+	}
+	for _, chapter := range chapters {
+		atom := [][]byte{
+			uintElement(IDChapterUID, uid()),
+			uintElement(IDChapterTimeStart, uint64(chapter.Start.Nanoseconds())),
+		}
+		if chapter.End > chapter.Start {
+			atom = append(atom, uintElement(IDChapterTimeEnd, uint64(chapter.End.Nanoseconds())))
+		}
+		atom = append(atom, element(IDChapterDisplay,
+			stringElement(IDChapString, chapter.Title),
+			stringElement(IDChapLanguage, "und"),
+		))
+		atoms = append(atoms, element(IDChapterAtom, atom...))
+	}
+	return element(IDChapters, element(IDEditionEntry, atoms...))
+}
+
+// uid returns a random non-zero identifier for editions and chapters
+func uid() uint64 {
+	return rand.Uint64() | 1
+}
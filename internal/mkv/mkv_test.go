@@ -0,0 +1,324 @@
+package mkv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeTestWebM writes a minimal WebM file whose cues point at its only
+// cluster
+func writeTestWebM(t *testing.T) string {
+	t.Helper()
+
+	ebml := element(IDEBML, stringElement(0x4282, "webm"))
+	info := element(IDInfo, uintElement(0x2AD7B1, 1000000))
+	tracks := element(IDTracks, element(0xAE, uintElement(0xD7, 1)))
+	cluster := element(IDCluster, uintElement(0xE7, 0), element(0xA3, []byte("FRAMEDATA")))
+	seekHead := element(IDSeekHead, element(IDSeek,
+		element(IDSeekID, encodeID(IDInfo)),
+		uintElement(IDSeekPos, 0),
+	))
+	void := element(IDVoid, make([]byte, 20))
+
+	clusterPos := len(seekHead) + len(void) + len(info) + len(tracks)
+	cues := element(IDCues, element(IDCuePoint,
+		uintElement(0xB3, 0),
+		element(IDCueTrackPositions,
+			uintElement(0xF7, 1),
+			uintElement(IDCueClusterPosition, uint64(clusterPos)),
+		),
+	))
+
+	segment := element(IDSegment, seekHead, void, info, tracks, cluster, cues)
+
+	path := filepath.Join(t.TempDir(), "video.webm")
+	if err := os.WriteFile(path, append(ebml, segment...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// findElement returns the first direct child of body with the given ID
+func findElement(t *testing.T, body []byte, id uint32) rawElement {
+	t.Helper()
+	elems, err := children(body)
+	if err != nil {
+		t.Fatalf("children() error = %v", err)
+	}
+	for _, elem := range elems {
+		if elem.ID == id {
+			return elem
+		}
+	}
+	t.Fatalf("element 0x%X not found", id)
+	return rawElement{}
+}
+
+func TestWriteMetadata(t *testing.T) {
+	src := writeTestWebM(t)
+	if !IsMatroska(src) {
+		t.Fatal("IsMatroska() = false for test file")
+	}
+
+	dst := filepath.Join(t.TempDir(), "out.webm")
+	tags := []Tag{{Name: "TITLE", Value: "Test Video"}, {Name: "ARTIST", Value: "Test Channel"}}
+	chapters := []Chapter{
+		{Start: 0, End: 90 * time.Second, Title: "Intro"},
+		{Start: 90 * time.Second, End: 3 * time.Minute, Title: "Main part"},
+	}
+	if err := WriteMetadata(src, dst, tags, chapters); err != nil {
+		t.Fatalf("WriteMetadata() error = %v", err)
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	segment := findElement(t, data, IDSegment)
+	top, err := children(segment.Body)
+	if err != nil {
+		t.Fatalf("segment children error = %v", err)
+	}
+
+	offsets := make(map[uint32]int64)
+	var pos int64
+	var order []uint32
+	for _, elem := range top {
+		if _, ok := offsets[elem.ID]; !ok {
+			offsets[elem.ID] = pos
+		}
+		order = append(order, elem.ID)
+		pos += int64(len(elem.Data))
+	}
+
+	want := []uint32{IDSeekHead, IDInfo, IDTracks, IDChapters, IDTags, IDCluster, IDCues}
+	if len(order) != len(want) {
+		t.Fatalf("segment layout = %X, want %X", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("segment layout = %X, want %X", order, want)
+		}
+	}
+
+	// The seek head must point at the new elements
+	seekHead := findElement(t, segment.Body, IDSeekHead)
+	seeks, err := children(seekHead.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, seek := range seeks {
+		id := readUint(findElement(t, seek.Body, IDSeekID).Body)
+		position := int64(readUint(findElement(t, seek.Body, IDSeekPos).Body))
+		if offsets[uint32(id)] != position {
+			t.Errorf("seek entry for 0x%X = %d, want %d", id, position, offsets[uint32(id)])
+		}
+	}
+
+	// Cue positions must follow the moved cluster
+	cues := findElement(t, segment.Body, IDCues)
+	point := findElement(t, cues.Body, IDCuePoint)
+	trackPos := findElement(t, point.Body, IDCueTrackPositions)
+	clusterPos := int64(readUint(findElement(t, trackPos.Body, IDCueClusterPosition).Body))
+	if clusterPos != offsets[IDCluster] {
+		t.Errorf("cue cluster position = %d, want %d", clusterPos, offsets[IDCluster])
+	}
+
+	tagsData := findElement(t, segment.Body, IDTags).Data
+	for _, tag := range tags {
+		if !bytes.Contains(tagsData, []byte(tag.Name)) || !bytes.Contains(tagsData, []byte(tag.Value)) {
+			t.Errorf("tags missing %s=%s", tag.Name, tag.Value)
+		}
+	}
+
+	edition := findElement(t, findElement(t, segment.Body, IDChapters).Body, IDEditionEntry)
+	atoms, err := children(edition.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, atom := range atoms {
+		if atom.ID != IDChapterAtom {
+			continue
+		}
+		start := time.Duration(readUint(findElement(t, atom.Body, IDChapterTimeStart).Body))
+		display := findElement(t, atom.Body, IDChapterDisplay)
+		title := string(findElement(t, display.Body, IDChapString).Body)
+		if start != chapters[len(titles)].Start {
+			t.Errorf("chapter %q starts at %v, want %v", title, start, chapters[len(titles)].Start)
+		}
+		titles = append(titles, title)
+	}
+	if len(titles) != 2 || titles[0] != "Intro" || titles[1] != "Main part" {
+		t.Errorf("chapter titles = %v", titles)
+	}
+
+	if !bytes.Contains(data, []byte("FRAMEDATA")) {
+		t.Error("cluster data lost")
+	}
+
+	got, err := ReadTags(dst)
+	if err != nil {
+		t.Fatalf("ReadTags() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, tags) {
+		t.Errorf("ReadTags() = %v, want %v", got, tags)
+	}
+}
+
+// writeClusters writes a WebM file of one-second clusters holding one frame
+// each, without cues
+func writeClusters(t *testing.T, path string, frames ...string) {
+	t.Helper()
+	block := func(data string) []byte {
+		return element(IDSimpleBlock, append([]byte{0x81, 0, 0, 0x80}, data...))
+	}
+	info := element(IDInfo,
+		uintElement(IDTimestampScale, 1000000),
+		element(IDDuration, binary.BigEndian.AppendUint64(nil, math.Float64bits(float64(len(frames)*1000)))),
+	)
+	tracks := element(IDTracks, element(IDTrackEntry, uintElement(IDTrackNumber, 1), uintElement(IDTrackType, 1)))
+	chapters := element(IDChapters, element(IDEditionEntry))
+	var clusters []byte
+	for i, data := range frames {
+		clusters = append(clusters, element(IDCluster,
+			uintElement(IDTimestamp, uint64(i*1000)),
+			uintElement(IDPrevSize, 10),
+			block(data),
+		)...)
+	}
+	segment := element(IDSegment, info, tracks, chapters, clusters)
+	if err := os.WriteFile(path, append(element(IDEBML, stringElement(0x4282, "webm")), segment...), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// framesOf reads back the frames of a file as data@timestamp
+func framesOf(t *testing.T, path string) []string {
+	t.Helper()
+	r, err := OpenReader(path)
+	if err != nil {
+		t.Fatalf("OpenReader() error = %v", err)
+	}
+	defer r.Close()
+
+	var got []string
+	err = r.Frames(func(f Frame) error {
+		got = append(got, fmt.Sprintf("%s@%s", f.Data, f.Timestamp))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Frames() error = %v", err)
+	}
+	return got
+}
+
+func TestTrim(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.webm")
+	writeClusters(t, src, "A", "B", "C")
+
+	dst := filepath.Join(dir, "out.webm")
+	if err := Trim(src, dst, []Range{{Start: 1500 * time.Millisecond}}); err != nil {
+		t.Fatalf("Trim() error = %v", err)
+	}
+
+	got := framesOf(t, dst)
+	if want := []string{"B@0s", "C@1s"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("frames = %v, want %v", got, want)
+	}
+
+	in, _ := os.Open(dst)
+	defer in.Close()
+	mf, err := scan(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint32
+	for _, elem := range mf.elements {
+		ids = append(ids, elem.ID)
+		if elem.ID == IDInfo {
+			if _, duration, _ := parseDuration(elem.Data); duration != 2000 {
+				t.Errorf("duration = %v, want 2000", duration)
+			}
+		}
+		if elem.ID == IDCues {
+			h, _ := parseHeader(elem.Data)
+			cue := findElement(t, elem.Data[h.Len:], IDCuePoint)
+			positions := findElement(t, cue.Body, IDCueTrackPositions)
+			pos := int64(readUint(findElement(t, positions.Body, IDCueClusterPosition).Body))
+			if h, _ := readHeader(in, mf.segStart+pos); h.ID != IDCluster {
+				t.Errorf("cue points at 0x%X, want a cluster", h.ID)
+			}
+		}
+	}
+	want := []uint32{IDSeekHead, IDInfo, IDTracks, IDCluster, IDCluster, IDCues}
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("layout = %X, want %X", ids, want)
+	}
+}
+
+// countingReader records the bytes read from it
+type countingReader struct {
+	data []byte
+	read []bool
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.data[off:])
+	for i := off; i < off+int64(n); i++ {
+		r.read[i] = true
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func TestClip(t *testing.T) {
+	dir := t.TempDir()
+	raw := filepath.Join(dir, "raw.webm")
+	writeClusters(t, raw, "A", "B", "C", "D", "E")
+
+	// Trimming from the start adds cues after the clusters
+	src := filepath.Join(dir, "in.webm")
+	if err := Trim(raw, src, []Range{{Start: 0}}); err != nil {
+		t.Fatalf("Trim() error = %v", err)
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := &countingReader{data: data, read: make([]bool, len(data))}
+	dst := filepath.Join(dir, "out.webm")
+	if err := Clip(r, int64(len(data)), dst, []Range{{Start: 1500 * time.Millisecond, End: 3 * time.Second}}); err != nil {
+		t.Fatalf("Clip() error = %v", err)
+	}
+	if got, want := framesOf(t, dst), []string{"B@0s", "C@1s"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("frames = %v, want %v", got, want)
+	}
+
+	// Only the cluster after the range is read beyond it
+	for _, frame := range []string{"A", "E"} {
+		i := bytes.Index(data, []byte{0x81, 0, 0, 0x80, frame[0]})
+		if i < 0 {
+			t.Fatalf("frame %s not found", frame)
+		}
+		if r.read[i+4] {
+			t.Errorf("frame %s was read", frame)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package mkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Segment info and cue element IDs used when trimming
+const (
+	IDDuration = 0x4489
+	IDPrevSize = 0xAB
+	IDPosition = 0xA7
+	IDCueTime  = 0xB3
+	IDCueTrack = 0xF7
+)
+
+// Range is a span of media time. An End of zero, or past the end of the
+// media, keeps everything from Start on.
+type Range struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// trimCluster is a cluster of the source file with the child elements
+// that are copied to the output
+type trimCluster struct {
+	elem      *level1
+	timestamp int64
+	parts     [][2]int64 // offset and size of copied children
+}
+
+// Trim writes the given ranges of src, joined in order, to dst without
+// re-encoding. Cuts are made at cluster boundaries, moving each end back to
+// the start of the cluster it falls in; clusters start with a keyframe in
+// the files YouTube serves. Chapters are dropped and new cues are written.
+func Trim(src, dst string, ranges []Range) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no ranges to keep")
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	mf, err := scan(in)
+	if err != nil {
+		return err
+	}
+	return mf.trim(in, dst, ranges)
+}
+
+// trim writes the ranges of the scanned file read through in to dst
+func (mf *file) trim(in io.ReaderAt, dst string, ranges []Range) error {
+	scale := int64(1000000)
+	var duration float64
+	var err error
+	var info, tracks *level1
+	var clusters []*trimCluster
+	var keep []*level1
+	for _, elem := range mf.elements {
+		switch elem.ID {
+		case IDInfo:
+			info = elem
+			if scale, duration, err = parseDuration(elem.Data); err != nil {
+				return fmt.Errorf("failed to parse segment info: %w", err)
+			}
+			keep = append(keep, elem)
+		case IDTracks:
+			tracks = elem
+			keep = append(keep, elem)
+		case IDTags, IDAttachments:
+			keep = append(keep, elem)
+		case IDCluster:
+			c, err := readCluster(in, elem)
+			if err != nil {
+				return err
+			}
+			clusters = append(clusters, c)
+		}
+	}
+	if info == nil || tracks == nil || len(clusters) == 0 {
+		return fmt.Errorf("no media found")
+	}
+	sort.SliceStable(clusters, func(i, j int) bool { return clusters[i].timestamp < clusters[j].timestamp })
+
+	// Work in timestamp ticks
+	last := clusters[len(clusters)-1].timestamp
+	total := int64(duration)
+	if total <= last {
+		total = last + 1
+	}
+	ticks := func(d time.Duration) int64 { return int64(d) / scale }
+
+	// Move each range onto cluster starts and pick the clusters it covers
+	type keptCluster struct {
+		*trimCluster
+		newTimestamp int64
+	}
+	var kept []keptCluster
+	var outDuration int64
+	for _, r := range ranges {
+		start, end := ticks(r.Start), int64(math.MaxInt64)
+		if r.Start < 0 || (r.End > 0 && r.End <= r.Start) {
+			return fmt.Errorf("invalid range %s-%s", r.Start, r.End)
+		}
+		if start >= total {
+			return fmt.Errorf("range starts at %s, after the end of the media", r.Start)
+		}
+		start = clusterStart(clusters, start)
+		if r.End > 0 && ticks(r.End) < total {
+			end = clusterStart(clusters, ticks(r.End))
+			if end <= start {
+				end = nextClusterStart(clusters, start)
+			}
+		}
+
+		for _, c := range clusters {
+			if c.timestamp >= start && c.timestamp < end {
+				kept = append(kept, keptCluster{c, c.timestamp - start + outDuration})
+			}
+		}
+		outDuration += min(end, total) - start
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("the ranges contain no media")
+	}
+
+	info.Data = infoElement(info.Data, float64(outDuration))
+	info.Size = int64(len(info.Data))
+	cueTrack, err := cueTrackNumber(tracks.Data)
+	if err != nil {
+		return fmt.Errorf("failed to parse tracks: %w", err)
+	}
+
+	// Lay out the segment: metadata, clusters, then cues
+	layout := keep
+	headers := make(map[*level1][]byte)
+	for _, c := range kept {
+		ts := uintElement(IDTimestamp, uint64(c.newTimestamp))
+		size := int64(len(ts))
+		for _, part := range c.parts {
+			size += part[1]
+		}
+		header := append(append(encodeID(IDCluster), encodeSize(size, 8)...), ts...)
+		elem := &level1{ID: IDCluster, Offset: c.elem.Offset, Size: int64(len(header)-len(ts)) + size}
+		headers[elem] = header
+		layout = append(layout, elem)
+	}
+	cues := &level1{ID: IDCues}
+	layout = append(layout, cues)
+
+	// Cue positions are written in eight bytes, so the size of the cues
+	// does not depend on the layout
+	buildCues := func() []byte {
+		var points [][]byte
+		for i, c := range kept {
+			points = append(points, element(IDCuePoint,
+				uintElement(IDCueTime, uint64(c.newTimestamp)),
+				element(IDCueTrackPositions,
+					uintElement(IDCueTrack, cueTrack),
+					fixedUintElement(IDCueClusterPosition, uint64(layout[len(keep)+i].NewOffset)),
+				),
+			))
+		}
+		return element(IDCues, points...)
+	}
+	cues.Data = buildCues()
+	cues.Size = int64(len(cues.Data))
+
+	seekHead := seekHeadElement(layout)
+	pos := int64(len(seekHead))
+	for _, elem := range layout {
+		elem.NewOffset = pos
+		pos += elem.Size
+	}
+	segmentSize := pos
+	seekHead = seekHeadElement(layout)
+	cues.Data = buildCues()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	err = func() error {
+		if _, err := io.Copy(w, io.NewSectionReader(in, 0, mf.prefixEnd)); err != nil {
+			return err
+		}
+		if _, err := w.Write(append(encodeID(IDSegment), encodeSize(segmentSize, 8)...)); err != nil {
+			return err
+		}
+		if _, err := w.Write(seekHead); err != nil {
+			return err
+		}
+		for i, elem := range layout {
+			if elem.Data != nil {
+				if _, err := w.Write(elem.Data); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, err := w.Write(headers[elem]); err != nil {
+				return err
+			}
+			for _, part := range kept[i-len(keep)].parts {
+				if _, err := io.Copy(w, io.NewSectionReader(in, part[0], part[1])); err != nil {
+					return fmt.Errorf("failed to copy cluster: %w", err)
+				}
+			}
+		}
+		return w.Flush()
+	}()
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// readCluster finds the timestamp of a cluster and the children to copy,
+// leaving out those that depend on its position
+func readCluster(in io.ReaderAt, elem *level1) (*trimCluster, error) {
+	h, err := readHeader(in, elem.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster at %d: %w", elem.Offset, err)
+	}
+	if h.Size == unknownSize {
+		return nil, fmt.Errorf("clusters of unknown size are not supported")
+	}
+
+	c := &trimCluster{elem: elem, timestamp: -1}
+	end := elem.Offset + elem.Size
+	for pos := elem.Offset + int64(h.Len); pos < end; {
+		child, err := readHeader(in, pos)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read cluster at %d: %w", elem.Offset, err)
+		}
+		size := int64(child.Len) + child.Size
+		if child.Size == unknownSize || pos+size > end {
+			return nil, fmt.Errorf("invalid element in cluster at %d", elem.Offset)
+		}
+
+		switch child.ID {
+		case IDTimestamp:
+			body := make([]byte, child.Size)
+			if _, err := in.ReadAt(body, pos+int64(child.Len)); err != nil {
+				return nil, err
+			}
+			c.timestamp = int64(readUint(body))
+		case IDPrevSize, IDPosition, IDVoid, IDCRC32:
+		default:
+			c.parts = append(c.parts, [2]int64{pos, size})
+		}
+		pos += size
+	}
+	if c.timestamp < 0 {
+		return nil, fmt.Errorf("cluster at %d has no timestamp", elem.Offset)
+	}
+	return c, nil
+}
+
+// clusterStart returns the start of the cluster holding t
+func clusterStart(clusters []*trimCluster, t int64) int64 {
+	i := sort.Search(len(clusters), func(i int) bool { return clusters[i].timestamp > t })
+	if i == 0 {
+		return clusters[0].timestamp
+	}
+	return clusters[i-1].timestamp
+}
+
+func nextClusterStart(clusters []*trimCluster, t int64) int64 {
+	i := sort.Search(len(clusters), func(i int) bool { return clusters[i].timestamp > t })
+	if i == len(clusters) {
+		return math.MaxInt64
+	}
+	return clusters[i].timestamp
+}
+
+// parseDuration returns the timestamp scale and duration of a segment info
+// element
+func parseDuration(data []byte) (int64, float64, error) {
+	h, err := parseHeader(data)
+	if err != nil {
+		return 0, 0, err
+	}
+	elems, err := children(data[h.Len:])
+	if err != nil {
+		return 0, 0, err
+	}
+	scale := int64(1000000)
+	var duration float64
+	for _, elem := range elems {
+		switch elem.ID {
+		case IDTimestampScale:
+			if v := readUint(elem.Body); v > 0 {
+				scale = int64(v)
+			}
+		case IDDuration:
+			duration = readFloat(elem.Body)
+		}
+	}
+	return scale, duration, nil
+}
+
+// infoElement returns the segment info with a new duration
+func infoElement(data []byte, duration float64) []byte {
+	h, _ := parseHeader(data)
+	elems, _ := children(data[h.Len:])
+
+	var body [][]byte
+	for _, elem := range elems {
+		if elem.ID != IDDuration && elem.ID != IDVoid && elem.ID != IDCRC32 {
+			body = append(body, elem.Data)
+		}
+	}
+	body = append(body, element(IDDuration, binary.BigEndian.AppendUint64(nil, math.Float64bits(duration))))
+	return element(IDInfo, body...)
+}
+
+// cueTrackNumber returns the track that cues refer to: the first video
+// track, or the first track of an audio-only file
+func cueTrackNumber(data []byte) (uint64, error) {
+	r := &Reader{}
+	if err := r.parseTracks(data); err != nil {
+		return 0, err
+	}
+	if len(r.tracks) == 0 {
+		return 0, fmt.Errorf("no tracks")
+	}
+	for _, track := range r.tracks {
+		if track.Type == TrackTypeVideo {
+			return track.Number, nil
+		}
+	}
+	return r.tracks[0].Number, nil
+}
@@ -0,0 +1,239 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Box is an ISO base media file format box. Container boxes hold their
+// children; all other boxes keep their raw payload.
+type Box struct {
+	Type string
+	// Payload is the body of a leaf box, or for containers such as meta the
+	// bytes that precede the children (version and flags)
+	Payload  []byte
+	Children []*Box
+}
+
+// containers lists the boxes whose payload is a sequence of child boxes
+var containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+	"udta": true, "edts": true, "dinf": true, "mvex": true, "ilst": true,
+	"meta": true, "tref": true, "moof": true, "traf": true, "mfra": true,
+}
+
+// NewBox returns a leaf box
+func NewBox(boxType string, payload []byte) *Box {
+	return &Box{Type: boxType, Payload: payload}
+}
+
+// NewContainer returns a container box holding children
+func NewContainer(boxType string, children ...*Box) *Box {
+	return &Box{Type: boxType, Children: children}
+}
+
+// ParseBox parses a single box and all its descendants from data
+func ParseBox(data []byte) (*Box, error) {
+	boxes, err := parseBoxes(data, "")
+	if err != nil {
+		return nil, err
+	}
+	if len(boxes) != 1 {
+		return nil, fmt.Errorf("expected a single box, found %d", len(boxes))
+	}
+	return boxes[0], nil
+}
+
+func parseBoxes(data []byte, parent string) ([]*Box, error) {
+	var boxes []*Box
+
+	for len(data) > 0 {
+		if len(data) < 8 {
+			// Some muxers pad containers with a 32-bit zero terminator
+			if isZero(data) {
+				break
+			}
+			return nil, fmt.Errorf("truncated box header in %q", parent)
+		}
+
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		boxType := string(data[4:8])
+		header := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, fmt.Errorf("truncated large box header for %q", boxType)
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid size %d for box %q", size, boxType)
+		}
+
+		box := &Box{Type: boxType}
+		body := data[header:size]
+
+		// Items of an iTunes metadata list are containers of data boxes
+		if containers[boxType] || parent == "ilst" {
+			prefix := childOffset(boxType, body)
+			box.Payload = append([]byte(nil), body[:prefix]...)
+			children, err := parseBoxes(body[prefix:], boxType)
+			if err != nil {
+				return nil, err
+			}
+			box.Children = children
+		} else {
+			box.Payload = append([]byte(nil), body...)
+		}
+
+		boxes = append(boxes, box)
+		data = data[size:]
+	}
+
+	return boxes, nil
+}
+
+// childOffset returns how many payload bytes precede the children of a
+// container. The ISO meta box is a full box with version and flags, while
+// the QuickTime variant starts straight with its handler box.
+func childOffset(boxType string, body []byte) int {
+	if boxType != "meta" {
+		return 0
+	}
+	if len(body) >= 8 && string(body[4:8]) == "hdlr" {
+		return 0
+	}
+	if len(body) < 4 {
+		return len(body)
+	}
+	return 4
+}
+
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Size returns the encoded size of the box including its header
+func (b *Box) Size() uint64 {
+	size := uint64(8 + len(b.Payload))
+	for _, child := range b.Children {
+		size += child.Size()
+	}
+	if size > 0xFFFFFFFF {
+		size += 8
+	}
+	return size
+}
+
+// Encode serializes the box and its descendants
+func (b *Box) Encode() []byte {
+	out := make([]byte, 0, b.Size())
+	return b.appendTo(out)
+}
+
+func (b *Box) appendTo(out []byte) []byte {
+	size := b.Size()
+	if size > 0xFFFFFFFF {
+		out = binary.BigEndian.AppendUint32(out, 1)
+		out = append(out, b.Type...)
+		out = binary.BigEndian.AppendUint64(out, size)
+	} else {
+		out = binary.BigEndian.AppendUint32(out, uint32(size))
+		out = append(out, b.Type...)
+	}
+
+	out = append(out, b.Payload...)
+	for _, child := range b.Children {
+		out = child.appendTo(out)
+	}
+	return out
+}
+
+// Child returns the first direct child of the given type
+func (b *Box) Child(boxType string) *Box {
+	for _, child := range b.Children {
+		if child.Type == boxType {
+			return child
+		}
+	}
+	return nil
+}
+
+// Find returns the first descendant at the given path, e.g.
+// Find("mdia", "minf", "stbl")
+func (b *Box) Find(path ...string) *Box {
+	box := b
+	for _, boxType := range path {
+		if box = box.Child(boxType); box == nil {
+			return nil
+		}
+	}
+	return box
+}
+
+// FindAll returns every direct child of the given type
+func (b *Box) FindAll(boxType string) []*Box {
+	var boxes []*Box
+	for _, child := range b.Children {
+		if child.Type == boxType {
+			boxes = append(boxes, child)
+		}
+	}
+	return boxes
+}
+
+// Remove deletes every direct child of the given type
+func (b *Box) Remove(boxType string) {
+	kept := b.Children[:0]
+	for _, child := range b.Children {
+		if child.Type != boxType {
+			kept = append(kept, child)
+		}
+	}
+	b.Children = kept
+}
+
+// Replace swaps the first direct child of the same type for box, or
+// appends box when there is none
+func (b *Box) Replace(box *Box) {
+	for i, child := range b.Children {
+		if child.Type == box.Type {
+			b.Children[i] = box
+			return
+		}
+	}
+	b.Children = append(b.Children, box)
+}
+
+// ChildOrCreate returns the first direct child container of the given
+// type, appending an empty one when missing
+func (b *Box) ChildOrCreate(boxType string) *Box {
+	if child := b.Child(boxType); child != nil {
+		return child
+	}
+	child := NewContainer(boxType)
+	b.Children = append(b.Children, child)
+	return child
+}
+
+// fullBox builds the payload of a full box from its version, flags and body
+func fullBox(version uint8, flags uint32, body ...[]byte) []byte {
+	out := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags&0xFFFFFF)
+	for _, part := range body {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func u16(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+func u64(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
@@ -0,0 +1,160 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+)
+
+// subsegment is a span of a fragmented file listed in its segment index
+type subsegment struct {
+	offset int64
+	size   int64
+	start  time.Duration
+	end    time.Duration
+}
+
+// Clip writes the given ranges of an MP4 file read through r, which is size
+// bytes long, to dst like Trim. Only the boxes the ranges need are read, so
+// r can be a remote file fetched with range requests. Fragmented files are
+// skipped through with their segment index (sidx); without one every
+// fragment header is read.
+func Clip(r io.ReaderAt, size int64, dst string, ranges []Range) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no ranges to keep")
+	}
+
+	f := &File{src: r, moovIndex: -1}
+	var index []subsegment
+	offset := int64(0)
+	for offset < size {
+		box, err := readBoxHeader(r, offset, size)
+		if err != nil {
+			return err
+		}
+		// Stop at the media once the index is known
+		if box.Type == "moof" && f.moov != nil && index != nil {
+			break
+		}
+		switch box.Type {
+		case "moov":
+			if err := f.loadMoov(r, box); err != nil {
+				return err
+			}
+		case "sidx":
+			if index == nil {
+				sidx, err := readBox(r, box)
+				if err != nil {
+					return err
+				}
+				// Hierarchical indexes are not followed; the fragments are
+				// walked instead
+				index, _ = parseSidx(sidx, box.Offset+box.Size)
+			}
+		}
+		f.boxes = append(f.boxes, box)
+		offset += box.Size
+	}
+	if f.moov == nil {
+		return fmt.Errorf("no moov box found")
+	}
+
+	if !f.Fragmented() {
+		return f.trimProgressive(dst, ranges)
+	}
+
+	// Walk the fragments of the subsegments that overlap the ranges
+	for i := 0; i < len(index); {
+		if !overlaps(index[i], ranges) {
+			i++
+			continue
+		}
+		start := index[i].offset
+		end := start + index[i].size
+		for i++; i < len(index) && overlaps(index[i], ranges) && index[i].offset == end; i++ {
+			end += index[i].size
+		}
+		if end > size {
+			return fmt.Errorf("segment index points past the end of the file")
+		}
+		for pos := start; pos < end; {
+			box, err := readBoxHeader(r, pos, end)
+			if err != nil {
+				return err
+			}
+			f.boxes = append(f.boxes, box)
+			pos += box.Size
+		}
+	}
+
+	return f.trimFragmented(dst, ranges)
+}
+
+// overlaps reports whether any range overlaps the subsegment
+func overlaps(s subsegment, ranges []Range) bool {
+	for _, rg := range ranges {
+		if s.end > rg.Start && (rg.End <= 0 || s.start < rg.End) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSidx reads the subsegments of a segment index box, whose offsets are
+// relative to the end of the box at anchor
+func parseSidx(sidx *Box, anchor int64) ([]subsegment, error) {
+	p := sidx.Payload
+	if len(p) < 12 {
+		return nil, fmt.Errorf("sidx too short")
+	}
+	timescale := binary.BigEndian.Uint32(p[8:12])
+	if timescale == 0 {
+		return nil, fmt.Errorf("sidx has no timescale")
+	}
+
+	var earliest, first int64
+	pos := 12
+	if p[0] == 0 {
+		if len(p) < pos+12 {
+			return nil, fmt.Errorf("sidx too short")
+		}
+		earliest = int64(binary.BigEndian.Uint32(p[pos:]))
+		first = int64(binary.BigEndian.Uint32(p[pos+4:]))
+		pos += 8
+	} else {
+		if len(p) < pos+20 {
+			return nil, fmt.Errorf("sidx too short")
+		}
+		earliest = int64(binary.BigEndian.Uint64(p[pos:]))
+		first = int64(binary.BigEndian.Uint64(p[pos+8:]))
+		pos += 16
+	}
+	count := int(binary.BigEndian.Uint16(p[pos+2:]))
+	pos += 4
+	if len(p) < pos+count*12 {
+		return nil, fmt.Errorf("sidx too short for %d references", count)
+	}
+
+	offset := anchor + first
+	at := earliest
+	subsegments := make([]subsegment, 0, count)
+	for i := 0; i < count; i++ {
+		ref := binary.BigEndian.Uint32(p[pos:])
+		duration := int64(binary.BigEndian.Uint32(p[pos+4:]))
+		pos += 12
+		if ref>>31 == 1 {
+			return nil, fmt.Errorf("hierarchical sidx is not supported")
+		}
+		size := int64(ref & 0x7fffffff)
+		subsegments = append(subsegments, subsegment{
+			offset: offset,
+			size:   size,
+			start:  toDuration(at, timescale),
+			end:    toDuration(at+duration, timescale),
+		})
+		offset += size
+		at += duration
+	}
+	return subsegments, nil
+}
@@ -0,0 +1,453 @@
+package mp4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// topBox records where a top-level box sits in the source file
+type topBox struct {
+	Type   string
+	Offset int64
+	Size   int64
+	Header int64
+	// ToEnd is set for a box whose size field is zero ("extends to end of
+	// file"), which has to be rewritten with an explicit size once data is
+	// appended after it
+	ToEnd bool
+}
+
+// File is an MP4 file opened for editing. The movie box is held in memory
+// and edited in place; media data is copied from the source file on Save.
+type File struct {
+	path string
+	// src is set for files read through Clip rather than opened by path
+	src   io.ReaderAt
+	boxes []topBox
+	moov  *Box
+	// moovIndex is the position of the movie box in boxes
+	moovIndex int
+
+	// extra holds sample data for tracks added in memory, written to a
+	// trailing mdat box whose position is patched into their chunk offsets
+	extra []extraChunk
+}
+
+type extraChunk struct {
+	data []byte
+	co64 *Box
+}
+
+// IsMP4 reports whether the file starts like an ISO base media file
+func IsMP4(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return false
+	}
+	switch string(header[4:8]) {
+	case "ftyp", "moov", "styp":
+		return true
+	}
+	return false
+}
+
+// Open scans the top-level boxes of an MP4 file and loads its movie box
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	file := &File{path: path, moovIndex: -1}
+	for offset := int64(0); offset < info.Size(); {
+		box, err := readBoxHeader(f, offset, info.Size())
+		if err != nil {
+			return nil, err
+		}
+		if box.Type == "moov" {
+			if err := file.loadMoov(f, box); err != nil {
+				return nil, err
+			}
+		}
+		file.boxes = append(file.boxes, box)
+		offset += box.Size
+	}
+
+	if file.moov == nil {
+		return nil, fmt.Errorf("no moov box found")
+	}
+
+	return file, nil
+}
+
+// readBoxHeader reads the header of the top-level box at offset in a file
+// of the given size
+func readBoxHeader(r io.ReaderAt, offset, size int64) (topBox, error) {
+	header := make([]byte, 16)
+	if _, err := r.ReadAt(header[:8], offset); err != nil {
+		return topBox{}, fmt.Errorf("failed to read box header at %d: %w", offset, err)
+	}
+
+	box := topBox{
+		Type:   string(header[4:8]),
+		Offset: offset,
+		Size:   int64(binary.BigEndian.Uint32(header[0:4])),
+		Header: 8,
+	}
+
+	switch box.Size {
+	case 0:
+		box.Size = size - offset
+		box.ToEnd = true
+	case 1:
+		if _, err := r.ReadAt(header[8:16], offset+8); err != nil {
+			return topBox{}, fmt.Errorf("failed to read box header at %d: %w", offset, err)
+		}
+		box.Size = int64(binary.BigEndian.Uint64(header[8:16]))
+		box.Header = 16
+	}
+	if box.Size < box.Header || offset+box.Size > size {
+		return topBox{}, fmt.Errorf("invalid size %d for box %q at %d", box.Size, box.Type, offset)
+	}
+	return box, nil
+}
+
+func (f *File) loadMoov(r io.ReaderAt, box topBox) error {
+	if f.moovIndex >= 0 {
+		return fmt.Errorf("file has more than one moov box")
+	}
+	moov, err := readBox(r, box)
+	if err != nil {
+		return err
+	}
+	f.moov = moov
+	f.moovIndex = len(f.boxes)
+	return nil
+}
+
+// source opens the media the file was read from
+func (f *File) source() (io.ReaderAt, func() error, error) {
+	if f.src != nil {
+		return f.src, func() error { return nil }, nil
+	}
+	src, err := os.Open(f.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, src.Close, nil
+}
+
+// Moov returns the movie box for direct editing
+func (f *File) Moov() *Box {
+	return f.moov
+}
+
+// Fragmented reports whether the movie is split into movie fragments, in
+// which case sample tables in the movie box do not describe the media
+func (f *File) Fragmented() bool {
+	return f.moov.Child("mvex") != nil
+}
+
+// Save writes the edited file to dst, which must differ from the source.
+// Chunk offsets of existing tracks are shifted when the movie box precedes
+// the media data and changed size. A File can only be saved once.
+func (f *File) Save(dst string) error {
+	oldMoov := f.boxes[f.moovIndex]
+	newSize := int64(f.moov.Size())
+	delta := newSize - oldMoov.Size
+	oldMoovEnd := oldMoov.Offset + oldMoov.Size
+
+	if delta != 0 {
+		if err := shiftChunkOffsets(f.moov, oldMoovEnd, delta, f.addedOffsets()); err != nil {
+			return err
+		}
+	}
+
+	// Sample data of added tracks goes into a trailing mdat
+	var extraSize int64
+	for _, chunk := range f.extra {
+		extraSize += int64(len(chunk.data))
+	}
+	if extraSize > 0 {
+		last := f.boxes[len(f.boxes)-1]
+		pos := last.Offset + last.Size + delta + 8
+		if extraSize+8 > math.MaxUint32 {
+			return fmt.Errorf("added sample data too large")
+		}
+		for _, chunk := range f.extra {
+			chunk.co64.Payload = fullBox(0, 0, u32(1), u64(uint64(pos)))
+			pos += int64(len(chunk.data))
+		}
+	}
+
+	src, closeSrc, err := f.source()
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	if err := f.write(w, src, extraSize, oldMoovEnd, delta); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func (f *File) write(w io.Writer, src io.ReaderAt, extraSize, from, delta int64) error {
+	for i, box := range f.boxes {
+		if i == f.moovIndex {
+			if _, err := w.Write(f.moov.Encode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// Fragment boxes may hold absolute file offsets
+		if delta != 0 && (box.Type == "moof" || box.Type == "mfra") {
+			data := make([]byte, box.Size)
+			if _, err := src.ReadAt(data, box.Offset); err != nil {
+				return fmt.Errorf("failed to read %q box: %w", box.Type, err)
+			}
+			fragment, err := ParseBox(data)
+			if err != nil {
+				return fmt.Errorf("failed to parse %q box: %w", box.Type, err)
+			}
+			if err := shiftFragmentOffsets(fragment, from, delta); err != nil {
+				return err
+			}
+			if _, err := w.Write(fragment.Encode()); err != nil {
+				return err
+			}
+			continue
+		}
+
+		offset, size := box.Offset, box.Size
+		if box.ToEnd && extraSize > 0 {
+			// Give the box an explicit size now that data follows it
+			if size > math.MaxUint32 {
+				return fmt.Errorf("cannot append after open-ended box %q larger than 4GB", box.Type)
+			}
+			header := append(u32(uint32(size)), box.Type...)
+			if _, err := w.Write(header); err != nil {
+				return err
+			}
+			offset, size = offset+8, size-8
+		}
+
+		if _, err := io.Copy(w, io.NewSectionReader(src, offset, size)); err != nil {
+			return fmt.Errorf("failed to copy %q box: %w", box.Type, err)
+		}
+	}
+
+	if extraSize == 0 {
+		return nil
+	}
+
+	if _, err := w.Write(append(u32(uint32(extraSize+8)), "mdat"...)); err != nil {
+		return err
+	}
+	for _, chunk := range f.extra {
+		if _, err := w.Write(chunk.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *File) addedOffsets() map[*Box]bool {
+	added := make(map[*Box]bool, len(f.extra))
+	for _, chunk := range f.extra {
+		added[chunk.co64] = true
+	}
+	return added
+}
+
+// shiftChunkOffsets moves every chunk offset at or beyond from by delta
+func shiftChunkOffsets(moov *Box, from, delta int64, skip map[*Box]bool) error {
+	for _, trak := range moov.FindAll("trak") {
+		stbl := trak.Find("mdia", "minf", "stbl")
+		if stbl == nil {
+			continue
+		}
+
+		if stco := stbl.Child("stco"); stco != nil && !skip[stco] {
+			p := stco.Payload
+			if len(p) < 8 {
+				return fmt.Errorf("truncated stco box")
+			}
+			count := int(binary.BigEndian.Uint32(p[4:8]))
+			if len(p) < 8+4*count {
+				return fmt.Errorf("truncated stco box")
+			}
+			for i := 0; i < count; i++ {
+				pos := 8 + 4*i
+				offset := int64(binary.BigEndian.Uint32(p[pos:]))
+				if offset < from {
+					continue
+				}
+				offset += delta
+				if offset < 0 || offset > math.MaxUint32 {
+					return fmt.Errorf("chunk offset out of range after rewrite")
+				}
+				binary.BigEndian.PutUint32(p[pos:], uint32(offset))
+			}
+		}
+
+		if co64 := stbl.Child("co64"); co64 != nil && !skip[co64] {
+			p := co64.Payload
+			if len(p) < 8 {
+				return fmt.Errorf("truncated co64 box")
+			}
+			count := int(binary.BigEndian.Uint32(p[4:8]))
+			if len(p) < 8+8*count {
+				return fmt.Errorf("truncated co64 box")
+			}
+			for i := 0; i < count; i++ {
+				pos := 8 + 8*i
+				offset := int64(binary.BigEndian.Uint64(p[pos:]))
+				if offset >= from {
+					binary.BigEndian.PutUint64(p[pos:], uint64(offset+delta))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// shiftFragmentOffsets moves the explicit base data offsets of a movie
+// fragment, and the fragment positions of a random access index, that lie at
+// or beyond from by delta
+func shiftFragmentOffsets(box *Box, from, delta int64) error {
+	for _, traf := range box.FindAll("traf") {
+		tfhd := traf.Child("tfhd")
+		if tfhd == nil {
+			continue
+		}
+		p := tfhd.Payload
+		if len(p) < 8 {
+			return fmt.Errorf("truncated tfhd box")
+		}
+		flags := binary.BigEndian.Uint32(p[0:4]) & 0xFFFFFF
+		if flags&0x1 == 0 {
+			continue
+		}
+		if len(p) < 16 {
+			return fmt.Errorf("truncated tfhd box")
+		}
+		if offset := int64(binary.BigEndian.Uint64(p[8:16])); offset >= from {
+			binary.BigEndian.PutUint64(p[8:16], uint64(offset+delta))
+		}
+	}
+
+	for _, tfra := range box.FindAll("tfra") {
+		p := tfra.Payload
+		if len(p) < 16 {
+			return fmt.Errorf("truncated tfra box")
+		}
+		version := p[0]
+		sizes := binary.BigEndian.Uint32(p[8:12])
+		count := int(binary.BigEndian.Uint32(p[12:16]))
+
+		fieldSize := 4
+		if version == 1 {
+			fieldSize = 8
+		}
+		// traf, trun and sample numbers take 1 to 4 bytes each
+		numbers := int(sizes>>4&3) + int(sizes>>2&3) + int(sizes&3) + 3
+		entrySize := 2*fieldSize + numbers
+		if len(p) < 16+count*entrySize {
+			return fmt.Errorf("truncated tfra box")
+		}
+
+		for i := 0; i < count; i++ {
+			pos := 16 + i*entrySize + fieldSize
+			if version == 1 {
+				if offset := int64(binary.BigEndian.Uint64(p[pos:])); offset >= from {
+					binary.BigEndian.PutUint64(p[pos:], uint64(offset+delta))
+				}
+				continue
+			}
+			offset := int64(binary.BigEndian.Uint32(p[pos:]))
+			if offset < from {
+				continue
+			}
+			offset += delta
+			if offset < 0 || offset > math.MaxUint32 {
+				return fmt.Errorf("fragment offset out of range after rewrite")
+			}
+			binary.BigEndian.PutUint32(p[pos:], uint32(offset))
+		}
+	}
+	return nil
+}
+
+// Timescale returns the movie timescale from the movie header
+func (f *File) Timescale() (uint32, error) {
+	mvhd := f.moov.Child("mvhd")
+	if mvhd == nil || len(mvhd.Payload) < 4 {
+		return 0, fmt.Errorf("missing mvhd box")
+	}
+	pos := 12
+	if mvhd.Payload[0] == 1 {
+		pos = 20
+	}
+	if len(mvhd.Payload) < pos+4 {
+		return 0, fmt.Errorf("truncated mvhd box")
+	}
+	return binary.BigEndian.Uint32(mvhd.Payload[pos:]), nil
+}
+
+// nextTrackID returns a free track ID and reserves it in the movie header
+func (f *File) nextTrackID() (uint32, error) {
+	mvhd := f.moov.Child("mvhd")
+	if mvhd == nil || len(mvhd.Payload) < 4 {
+		return 0, fmt.Errorf("missing mvhd box")
+	}
+	p := mvhd.Payload
+	pos := len(p) - 4
+	id := binary.BigEndian.Uint32(p[pos:])
+
+	// Guard against writers that leave next_track_ID stale
+	for _, trak := range f.moov.FindAll("trak") {
+		if tkhd := trak.Child("tkhd"); tkhd != nil && len(tkhd.Payload) >= 24 {
+			idPos := 12
+			if tkhd.Payload[0] == 1 {
+				idPos = 20
+			}
+			if existing := binary.BigEndian.Uint32(tkhd.Payload[idPos:]); existing >= id {
+				id = existing + 1
+			}
+		}
+	}
+
+	binary.BigEndian.PutUint32(p[pos:], id+1)
+	return id, nil
+}
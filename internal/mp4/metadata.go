@@ -0,0 +1,200 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// iTunes metadata item keys. The leading byte is the MacRoman copyright sign.
+const (
+	KeyTitle       = "\xa9nam"
+	KeyArtist      = "\xa9ART"
+	KeyAlbumArtist = "aART"
+	KeyAlbum       = "\xa9alb"
+	KeyDate        = "\xa9day"
+	KeyComment     = "\xa9cmt"
+	KeyDescription = "desc"
+	KeyLongDesc    = "ldes"
+	KeyTrack       = "trkn"
+	KeyCover       = "covr"
+	KeyEncoder     = "\xa9too"
+)
+
+// Well-known data types of iTunes metadata values
+const (
+	dataTypeImplicit = 0
+	dataTypeUTF8     = 1
+	dataTypeJPEG     = 13
+	dataTypePNG      = 14
+)
+
+// Metadata holds the tags written to the iTunes metadata list. Empty
+// fields are left as they are in the file.
+type Metadata struct {
+	Title       string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Date        string
+	Description string
+	Comment     string
+	Track       int
+	TrackTotal  int
+	Cover       []byte
+}
+
+// Chapter marks the start of a named section of the movie
+type Chapter struct {
+	Start time.Duration
+	Title string
+}
+
+// SetMetadata writes tags to moov/udta/meta/ilst, creating the boxes as
+// needed and replacing existing values for the same keys
+func (f *File) SetMetadata(meta Metadata) {
+	ilst := f.metadataList()
+
+	setText := func(key, value string) {
+		if value != "" {
+			ilst.Replace(dataItem(key, dataTypeUTF8, []byte(value)))
+		}
+	}
+
+	setText(KeyTitle, meta.Title)
+	setText(KeyArtist, meta.Artist)
+	setText(KeyAlbumArtist, meta.AlbumArtist)
+	setText(KeyAlbum, meta.Album)
+	setText(KeyDate, meta.Date)
+	setText(KeyComment, meta.Comment)
+	if meta.Description != "" {
+		// desc is limited to 255 bytes by most readers; ldes carries the
+		// full text
+		setText(KeyDescription, truncateUTF8(meta.Description, 255))
+		setText(KeyLongDesc, meta.Description)
+	}
+
+	if meta.Track > 0 {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint16(value[2:], uint16(meta.Track))
+		binary.BigEndian.PutUint16(value[4:], uint16(meta.TrackTotal))
+		ilst.Replace(dataItem(KeyTrack, dataTypeImplicit, value))
+	}
+
+	if len(meta.Cover) > 0 {
+		dataType := uint32(dataTypeJPEG)
+		if isPNG(meta.Cover) {
+			dataType = dataTypePNG
+		}
+		ilst.Replace(dataItem(KeyCover, dataType, meta.Cover))
+	}
+}
+
+// Tag returns the text value of an iTunes metadata item
+func (f *File) Tag(key string) (string, bool) {
+	ilst := f.moov.Find("udta", "meta", "ilst")
+	if ilst == nil {
+		return "", false
+	}
+	item := ilst.Child(key)
+	if item == nil {
+		return "", false
+	}
+	data := item.Child("data")
+	if data == nil || len(data.Payload) < 8 {
+		return "", false
+	}
+	return string(data.Payload[8:]), true
+}
+
+// metadataList returns moov/udta/meta/ilst, creating it with the handler
+// box iTunes-style readers require
+func (f *File) metadataList() *Box {
+	udta := f.moov.ChildOrCreate("udta")
+
+	meta := udta.Child("meta")
+	if meta == nil {
+		meta = NewContainer("meta")
+		meta.Payload = fullBox(0, 0)
+		udta.Children = append(udta.Children, meta)
+	}
+
+	if meta.Child("hdlr") == nil {
+		hdlr := NewBox("hdlr", fullBox(0, 0,
+			u32(0), []byte("mdir"), []byte("appl"), u32(0), u32(0), []byte{0}))
+		meta.Children = append([]*Box{hdlr}, meta.Children...)
+	}
+
+	return meta.ChildOrCreate("ilst")
+}
+
+func dataItem(key string, dataType uint32, value []byte) *Box {
+	payload := make([]byte, 0, 8+len(value))
+	payload = append(payload, u32(dataType)...)
+	payload = append(payload, u32(0)...) // locale
+	payload = append(payload, value...)
+	return NewContainer(key, NewBox("data", payload))
+}
+
+// SetChapters writes Nero-style chapter marks (moov/udta/chpl), which are
+// read by ffmpeg-based players and media servers. Chapter titles are
+// limited to 255 bytes and the list to 255 entries.
+func (f *File) SetChapters(chapters []Chapter) {
+	udta := f.moov.ChildOrCreate("udta")
+	if len(chapters) == 0 {
+		udta.Remove("chpl")
+		return
+	}
+	if len(chapters) > 255 {
+		chapters = chapters[:255]
+	}
+
+	body := []byte{0, 0, 0, 0, byte(len(chapters))}
+	for _, chapter := range chapters {
+		title := truncateUTF8(chapter.Title, 255)
+		// Start times are in 100ns units
+		body = append(body, u64(uint64(chapter.Start/100))...)
+		body = append(body, byte(len(title)))
+		body = append(body, title...)
+	}
+
+	udta.Replace(NewBox("chpl", fullBox(1, 0, body)))
+}
+
+// Chapters returns the Nero-style chapter marks of the movie
+func (f *File) Chapters() []Chapter {
+	chpl := f.moov.Find("udta", "chpl")
+	if chpl == nil || len(chpl.Payload) < 9 {
+		return nil
+	}
+
+	p := chpl.Payload
+	count := int(p[8])
+	pos := 9
+	var chapters []Chapter
+	for i := 0; i < count && pos+9 <= len(p); i++ {
+		start := time.Duration(binary.BigEndian.Uint64(p[pos:])) * 100
+		length := int(p[pos+8])
+		pos += 9
+		if pos+length > len(p) {
+			break
+		}
+		chapters = append(chapters, Chapter{Start: start, Title: string(p[pos : pos+length])})
+		pos += length
+	}
+	return chapters
+}
+
+func isPNG(data []byte) bool {
+	return len(data) > 8 && string(data[1:4]) == "PNG"
+}
+
+// truncateUTF8 shortens s to at most n bytes without splitting a rune
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,183 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// sampleData is the media payload of the test file, whose chunk offset must
+// still point at it after the movie box grows
+var sampleData = []byte("SAMPLEDATA")
+
+// writeTestMovie writes a minimal non-fragmented MP4 with the movie box
+// ahead of the media data and one track pointing into it
+func writeTestMovie(t *testing.T) string {
+	t.Helper()
+
+	ftyp := NewBox("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	build := func(offset uint32) *Box {
+		return NewContainer("moov",
+			NewBox("mvhd", fullBox(0, 0,
+				u32(0), u32(0), u32(1000), u32(5000), // times, timescale, duration
+				make([]byte, 76),
+				u32(2), // next track ID
+			)),
+			NewContainer("trak",
+				NewBox("tkhd", fullBox(0, 3, u32(0), u32(0), u32(1), make([]byte, 68))),
+				NewContainer("mdia",
+					NewContainer("minf",
+						NewContainer("stbl",
+							NewBox("stco", fullBox(0, 0, u32(1), u32(offset))),
+						),
+					),
+				),
+			),
+		)
+	}
+
+	moov := build(0)
+	offset := uint32(ftyp.Size() + moov.Size() + 8)
+	moov = build(offset)
+
+	var buf bytes.Buffer
+	buf.Write(ftyp.Encode())
+	buf.Write(moov.Encode())
+	buf.Write(NewBox("mdat", sampleData).Encode())
+
+	path := filepath.Join(t.TempDir(), "movie.mp4")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func chunkOffset(t *testing.T, f *File, track int) int64 {
+	t.Helper()
+	stbl := f.Moov().FindAll("trak")[track].Find("mdia", "minf", "stbl")
+	if stco := stbl.Child("stco"); stco != nil {
+		return int64(binary.BigEndian.Uint32(stco.Payload[8:]))
+	}
+	return int64(binary.BigEndian.Uint64(stbl.Child("co64").Payload[8:]))
+}
+
+func TestSaveMetadataAndChapters(t *testing.T) {
+	src := writeTestMovie(t)
+	if !IsMP4(src) {
+		t.Fatal("IsMP4() = false for test movie")
+	}
+
+	f, err := Open(src)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	f.SetMetadata(Metadata{
+		Title:       "Test Video",
+		Artist:      "Test Channel",
+		Date:        "2024-03-01",
+		Description: "A description",
+		Comment:     "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+	})
+	chapters := []Chapter{
+		{Start: 0, Title: "Intro"},
+		{Start: 90 * time.Second, Title: "Main part"},
+	}
+	f.SetChapters(chapters)
+
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := f.Save(dst); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	out, err := Open(dst)
+	if err != nil {
+		t.Fatalf("Open() of saved file error = %v", err)
+	}
+
+	tags := map[string]string{
+		KeyTitle:       "Test Video",
+		KeyArtist:      "Test Channel",
+		KeyDate:        "2024-03-01",
+		KeyDescription: "A description",
+		KeyLongDesc:    "A description",
+		KeyComment:     "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+	}
+	for key, want := range tags {
+		if got, ok := out.Tag(key); !ok || got != want {
+			t.Errorf("Tag(%q) = %q, %v, want %q", key, got, ok, want)
+		}
+	}
+
+	got := out.Chapters()
+	if len(got) != len(chapters) {
+		t.Fatalf("Chapters() returned %d chapters, want %d", len(got), len(chapters))
+	}
+	for i := range chapters {
+		if got[i] != chapters[i] {
+			t.Errorf("chapter %d = %+v, want %+v", i, got[i], chapters[i])
+		}
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := chunkOffset(t, out, 0)
+	if !bytes.Equal(data[offset:offset+int64(len(sampleData))], sampleData) {
+		t.Errorf("chunk offset %d does not point at the sample data after rewrite", offset)
+	}
+}
+
+func TestAddTextTrack(t *testing.T) {
+	f, err := Open(writeTestMovie(t))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+
+	cues := []TextCue{
+		{Start: time.Second, End: 2 * time.Second, Text: "Hello"},
+		{Start: 3 * time.Second, End: 4 * time.Second, Text: "World"},
+	}
+	if err := f.AddTextTrack("en", cues); err != nil {
+		t.Fatalf("AddTextTrack() error = %v", err)
+	}
+
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := f.Save(dst); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	out, err := Open(dst)
+	if err != nil {
+		t.Fatalf("Open() of saved file error = %v", err)
+	}
+	traks := out.Moov().FindAll("trak")
+	if len(traks) != 2 {
+		t.Fatalf("saved file has %d tracks, want 2", len(traks))
+	}
+
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := chunkOffset(t, out, 0)
+	if !bytes.Equal(data[offset:offset+int64(len(sampleData))], sampleData) {
+		t.Errorf("original chunk offset %d does not point at the sample data", offset)
+	}
+
+	// Samples are a 16-bit length followed by the text; an empty sample
+	// fills the gap before the first cue
+	text := data[chunkOffset(t, out, 1):]
+	want := []byte("\x00\x00\x00\x05Hello\x00\x00\x00\x05World")
+	if !bytes.HasPrefix(text, want) {
+		t.Errorf("text samples = %q, want prefix %q", text, want)
+	}
+
+	if got := string(traks[1].Find("mdia", "hdlr").Payload[8:12]); got != "sbtl" {
+		t.Errorf("text track handler = %q, want sbtl", got)
+	}
+}
@@ -0,0 +1,239 @@
+package mp4
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// sample is one entry of a track's sample tables
+type sample struct {
+	offset   int64
+	size     uint32
+	dts      int64
+	duration uint32
+	cts      int32 // composition offset
+	sync     bool
+	sdi      uint32 // sample description index
+	chunk    int
+}
+
+// sampleTable is the decoded sample table of a non-fragmented track
+type sampleTable struct {
+	trak      *Box
+	timescale uint32
+	handler   string
+	samples   []sample
+
+	hasSync    bool // stss present; otherwise every sample is a sync sample
+	hasCTS     bool
+	ctsVersion uint8
+}
+
+// readSampleTable decodes the sample tables of a track
+func readSampleTable(trak *Box) (*sampleTable, error) {
+	mdia := trak.Child("mdia")
+	if mdia == nil {
+		return nil, fmt.Errorf("track has no mdia box")
+	}
+	stbl := mdia.Find("minf", "stbl")
+	if stbl == nil {
+		return nil, fmt.Errorf("track has no sample table")
+	}
+
+	t := &sampleTable{trak: trak}
+
+	mdhd := mdia.Child("mdhd")
+	if mdhd == nil || len(mdhd.Payload) < 20 {
+		return nil, fmt.Errorf("missing mdhd box")
+	}
+	if mdhd.Payload[0] == 1 {
+		if len(mdhd.Payload) < 24 {
+			return nil, fmt.Errorf("truncated mdhd box")
+		}
+		t.timescale = binary.BigEndian.Uint32(mdhd.Payload[20:])
+	} else {
+		t.timescale = binary.BigEndian.Uint32(mdhd.Payload[12:])
+	}
+	if t.timescale == 0 {
+		return nil, fmt.Errorf("track timescale is zero")
+	}
+	if hdlr := mdia.Child("hdlr"); hdlr != nil && len(hdlr.Payload) >= 12 {
+		t.handler = string(hdlr.Payload[8:12])
+	}
+
+	// Sample sizes fix the sample count
+	var sizes []uint32
+	if stz2 := stbl.Child("stz2"); stz2 != nil {
+		return nil, fmt.Errorf("compact sample sizes (stz2) are not supported")
+	}
+	stsz := stbl.Child("stsz")
+	if stsz == nil || len(stsz.Payload) < 12 {
+		return nil, fmt.Errorf("missing stsz box")
+	}
+	fixedSize := binary.BigEndian.Uint32(stsz.Payload[4:8])
+	count := int(binary.BigEndian.Uint32(stsz.Payload[8:12]))
+	if fixedSize == 0 {
+		if len(stsz.Payload) < 12+4*count {
+			return nil, fmt.Errorf("truncated stsz box")
+		}
+		sizes = make([]uint32, count)
+		for i := range sizes {
+			sizes[i] = binary.BigEndian.Uint32(stsz.Payload[12+4*i:])
+		}
+	} else {
+		sizes = make([]uint32, count)
+		for i := range sizes {
+			sizes[i] = fixedSize
+		}
+	}
+	t.samples = make([]sample, count)
+	for i := range t.samples {
+		t.samples[i].size = sizes[i]
+		t.samples[i].sync = true
+	}
+
+	// Decoding times
+	stts := stbl.Child("stts")
+	if stts == nil {
+		return nil, fmt.Errorf("missing stts box")
+	}
+	entries, err := tableEntries(stts.Payload, 8)
+	if err != nil {
+		return nil, fmt.Errorf("stts: %w", err)
+	}
+	i := 0
+	var dts int64
+	for _, e := range entries {
+		n, delta := binary.BigEndian.Uint32(e), binary.BigEndian.Uint32(e[4:])
+		for j := uint32(0); j < n && i < count; j++ {
+			t.samples[i].dts = dts
+			t.samples[i].duration = delta
+			dts += int64(delta)
+			i++
+		}
+	}
+
+	// Composition offsets
+	if ctts := stbl.Child("ctts"); ctts != nil {
+		entries, err := tableEntries(ctts.Payload, 8)
+		if err != nil {
+			return nil, fmt.Errorf("ctts: %w", err)
+		}
+		t.hasCTS = true
+		t.ctsVersion = ctts.Payload[0]
+		i := 0
+		for _, e := range entries {
+			n, offset := binary.BigEndian.Uint32(e), int32(binary.BigEndian.Uint32(e[4:]))
+			for j := uint32(0); j < n && i < count; j++ {
+				t.samples[i].cts = offset
+				i++
+			}
+		}
+	}
+
+	// Sync samples
+	if stss := stbl.Child("stss"); stss != nil {
+		t.hasSync = true
+		for i := range t.samples {
+			t.samples[i].sync = false
+		}
+		entries, err := tableEntries(stss.Payload, 4)
+		if err != nil {
+			return nil, fmt.Errorf("stss: %w", err)
+		}
+		for _, e := range entries {
+			if n := int(binary.BigEndian.Uint32(e)); n >= 1 && n <= count {
+				t.samples[n-1].sync = true
+			}
+		}
+	}
+
+	// Chunk offsets
+	var chunkOffsets []int64
+	if stco := stbl.Child("stco"); stco != nil {
+		entries, err := tableEntries(stco.Payload, 4)
+		if err != nil {
+			return nil, fmt.Errorf("stco: %w", err)
+		}
+		for _, e := range entries {
+			chunkOffsets = append(chunkOffsets, int64(binary.BigEndian.Uint32(e)))
+		}
+	} else if co64 := stbl.Child("co64"); co64 != nil {
+		entries, err := tableEntries(co64.Payload, 8)
+		if err != nil {
+			return nil, fmt.Errorf("co64: %w", err)
+		}
+		for _, e := range entries {
+			chunkOffsets = append(chunkOffsets, int64(binary.BigEndian.Uint64(e)))
+		}
+	} else {
+		return nil, fmt.Errorf("missing chunk offset box")
+	}
+
+	// Samples to chunks
+	stsc := stbl.Child("stsc")
+	if stsc == nil {
+		return nil, fmt.Errorf("missing stsc box")
+	}
+	runs, err := tableEntries(stsc.Payload, 12)
+	if err != nil {
+		return nil, fmt.Errorf("stsc: %w", err)
+	}
+	i = 0
+	for r, run := range runs {
+		first := int(binary.BigEndian.Uint32(run)) - 1
+		perChunk := int(binary.BigEndian.Uint32(run[4:]))
+		sdi := binary.BigEndian.Uint32(run[8:])
+		last := len(chunkOffsets)
+		if r+1 < len(runs) {
+			last = int(binary.BigEndian.Uint32(runs[r+1])) - 1
+		}
+		for chunk := first; chunk < last && chunk < len(chunkOffsets); chunk++ {
+			offset := chunkOffsets[chunk]
+			for j := 0; j < perChunk && i < count; j++ {
+				t.samples[i].offset = offset
+				t.samples[i].chunk = chunk
+				t.samples[i].sdi = sdi
+				offset += int64(t.samples[i].size)
+				i++
+			}
+		}
+	}
+	if i != count {
+		return nil, fmt.Errorf("sample tables describe %d of %d samples", i, count)
+	}
+
+	return t, nil
+}
+
+// tableEntries splits the entries of a full box holding an entry count
+// followed by fixed-size entries
+func tableEntries(payload []byte, entrySize int) ([][]byte, error) {
+	if len(payload) < 8 {
+		return nil, fmt.Errorf("truncated box")
+	}
+	count := int(binary.BigEndian.Uint32(payload[4:8]))
+	if count < 0 || len(payload) < 8+count*entrySize {
+		return nil, fmt.Errorf("truncated box")
+	}
+	entries := make([][]byte, count)
+	for i := range entries {
+		entries[i] = payload[8+i*entrySize : 8+(i+1)*entrySize]
+	}
+	return entries, nil
+}
+
+// toDuration converts a time in timescale units to a duration without
+// overflowing for long media
+func toDuration(v int64, timescale uint32) time.Duration {
+	ts := int64(timescale)
+	return time.Duration(v/ts)*time.Second + time.Duration(v%ts)*time.Second/time.Duration(ts)
+}
+
+// fromDuration converts a duration to timescale units
+func fromDuration(d time.Duration, timescale uint32) int64 {
+	ts := int64(timescale)
+	sec := int64(d / time.Second)
+	return sec*ts + int64(d%time.Second)*ts/int64(time.Second)
+}
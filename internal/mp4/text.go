@@ -0,0 +1,254 @@
+package mp4
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TextCue is a timed subtitle line for a text track
+type TextCue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// textTimescale is the media timescale of added text tracks (milliseconds)
+const textTimescale = 1000
+
+// AddTextTrack adds a soft subtitle track (3GPP timed text, tx3g) with the
+// given ISO 639 language code. Gaps between cues are filled with empty
+// samples so the track covers a continuous timeline. The first text track
+// added is enabled by default.
+func (f *File) AddTextTrack(language string, cues []TextCue) error {
+	if f.Fragmented() {
+		return fmt.Errorf("cannot add tracks to a fragmented MP4")
+	}
+
+	movieTimescale, err := f.Timescale()
+	if err != nil {
+		return err
+	}
+
+	samples, durations, total := textSamples(cues)
+	if len(samples) == 0 {
+		return fmt.Errorf("no cues to add")
+	}
+
+	trackID, err := f.nextTrackID()
+	if err != nil {
+		return err
+	}
+
+	flags := uint32(0x2) // in movie
+	if !f.hasTextTrack() {
+		flags |= 0x1 // enabled
+	}
+
+	var data []byte
+	sizes := make([]byte, 0, 4*len(samples))
+	for _, sample := range samples {
+		data = append(data, sample...)
+		sizes = append(sizes, u32(uint32(len(sample)))...)
+	}
+
+	co64 := NewBox("co64", fullBox(0, 0, u32(1), u64(0)))
+	movieDuration := uint32(uint64(total) * uint64(movieTimescale) / textTimescale)
+
+	stbl := NewContainer("stbl",
+		NewBox("stsd", fullBox(0, 0, u32(1), tx3gSampleEntry())),
+		NewBox("stts", fullBox(0, 0, timeToSample(durations))),
+		NewBox("stsc", fullBox(0, 0, u32(1), u32(1), u32(uint32(len(samples))), u32(1))),
+		NewBox("stsz", fullBox(0, 0, u32(0), u32(uint32(len(samples))), sizes)),
+		co64,
+	)
+
+	trak := NewContainer("trak",
+		NewBox("tkhd", fullBox(0, flags,
+			u32(0), u32(0), // creation and modification time
+			u32(trackID),
+			u32(0), // reserved
+			u32(movieDuration),
+			make([]byte, 8), // reserved
+			u16(0),          // layer
+			u16(2),          // alternate group shared by subtitle tracks
+			u16(0),          // volume
+			u16(0),          // reserved
+			identityMatrix(),
+			u32(0), u32(0), // width and height
+		)),
+		NewContainer("mdia",
+			NewBox("mdhd", fullBox(0, 0,
+				u32(0), u32(0),
+				u32(textTimescale),
+				u32(uint32(total)),
+				u16(packLanguage(language)),
+				u16(0),
+			)),
+			NewBox("hdlr", fullBox(0, 0,
+				u32(0), []byte("sbtl"), make([]byte, 12), []byte("SubtitleHandler\x00"))),
+			NewContainer("minf",
+				NewBox("nmhd", fullBox(0, 0)),
+				NewContainer("dinf",
+					NewBox("dref", fullBox(0, 0, u32(1), NewBox("url ", fullBox(0, 1)).Encode())),
+				),
+				stbl,
+			),
+		),
+	)
+
+	f.insertTrack(trak)
+	f.extra = append(f.extra, extraChunk{data: data, co64: co64})
+	return nil
+}
+
+// textSamples turns cues into tx3g samples (16-bit length + UTF-8 text) and
+// their durations in milliseconds, inserting empty samples for gaps
+func textSamples(cues []TextCue) ([][]byte, []uint32, uint32) {
+	sorted := append([]TextCue(nil), cues...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var (
+		samples   [][]byte
+		durations []uint32
+		now       uint32
+	)
+
+	for _, cue := range sorted {
+		start := uint32(cue.Start.Milliseconds())
+		end := uint32(cue.End.Milliseconds())
+		if end <= now || end <= start {
+			continue
+		}
+		if start > now {
+			samples = append(samples, u16(0))
+			durations = append(durations, start-now)
+			now = start
+		}
+
+		text := strings.ReplaceAll(cue.Text, "\r\n", "\n")
+		if len(text) > 0xFFFF {
+			text = truncateUTF8(text, 0xFFFF)
+		}
+		samples = append(samples, append(u16(uint16(len(text))), text...))
+		durations = append(durations, end-now)
+		now = end
+	}
+
+	return samples, durations, now
+}
+
+// timeToSample run-length encodes sample durations into stts entries
+func timeToSample(durations []uint32) []byte {
+	var entries []byte
+	count := uint32(0)
+	for i, d := range durations {
+		count++
+		if i+1 < len(durations) && durations[i+1] == d {
+			continue
+		}
+		entries = append(entries, u32(count)...)
+		entries = append(entries, u32(d)...)
+		count = 0
+	}
+	return append(u32(uint32(len(entries)/8)), entries...)
+}
+
+// tx3gSampleEntry returns a timed text sample entry with bottom-centered
+// white text in the default serif font
+func tx3gSampleEntry() []byte {
+	body := make([]byte, 0, 56)
+	body = append(body, make([]byte, 6)...) // reserved
+	body = append(body, u16(1)...)          // data reference index
+	body = append(body, u32(0)...)          // display flags
+	body = append(body, 1, 0xFF)            // horizontal center, vertical bottom
+	body = append(body, 0, 0, 0, 0)         // background color
+	body = append(body, make([]byte, 8)...) // default text box
+	// Default style: characters 0-0, font 1, plain, 18pt, opaque white
+	body = append(body, u16(0)...)
+	body = append(body, u16(0)...)
+	body = append(body, u16(1)...)
+	body = append(body, 0, 18)
+	body = append(body, 0xFF, 0xFF, 0xFF, 0xFF)
+
+	font := "Serif"
+	ftab := append(u16(1), u16(1)...)
+	ftab = append(ftab, byte(len(font)))
+	ftab = append(ftab, font...)
+	body = append(body, NewBox("ftab", ftab).Encode()...)
+
+	return NewBox("tx3g", body).Encode()
+}
+
+func identityMatrix() []byte {
+	var m []byte
+	for _, v := range []uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		m = append(m, u32(v)...)
+	}
+	return m
+}
+
+func (f *File) hasTextTrack() bool {
+	for _, trak := range f.moov.FindAll("trak") {
+		if hdlr := trak.Find("mdia", "hdlr"); hdlr != nil && len(hdlr.Payload) >= 12 {
+			switch string(hdlr.Payload[8:12]) {
+			case "sbtl", "text", "subt":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// insertTrack places a track after the existing ones, ahead of any
+// trailing user data or extension boxes
+func (f *File) insertTrack(trak *Box) {
+	children := f.moov.Children
+	pos := len(children)
+	for i, child := range children {
+		if child.Type == "trak" {
+			pos = i + 1
+		}
+	}
+	f.moov.Children = append(children[:pos], append([]*Box{trak}, children[pos:]...)...)
+}
+
+// packLanguage encodes an ISO 639 language as the three packed 5-bit
+// letters used by media headers
+func packLanguage(language string) uint16 {
+	code := iso6392(language)
+	var packed uint16
+	for i := 0; i < 3; i++ {
+		packed = packed<<5 | uint16(code[i]-0x60)&0x1F
+	}
+	return packed
+}
+
+var iso6391To6392 = map[string]string{
+	"ar": "ara", "bn": "ben", "cs": "ces", "da": "dan", "de": "deu",
+	"el": "ell", "en": "eng", "es": "spa", "fa": "fas", "fi": "fin",
+	"fr": "fra", "he": "heb", "hi": "hin", "hu": "hun", "id": "ind",
+	"it": "ita", "ja": "jpn", "ko": "kor", "ms": "msa", "nl": "nld",
+	"no": "nor", "pl": "pol", "pt": "por", "ro": "ron", "ru": "rus",
+	"sk": "slk", "sv": "swe", "sw": "swa", "ta": "tam", "th": "tha",
+	"tr": "tur", "uk": "ukr", "ur": "urd", "vi": "vie", "zh": "zho",
+}
+
+// iso6392 maps a language tag such as "en" or "pt-BR" to its three-letter
+// ISO 639-2 code, falling back to "und"
+func iso6392(language string) string {
+	base, _, _ := strings.Cut(strings.ToLower(language), "-")
+	if code, ok := iso6391To6392[base]; ok {
+		return code
+	}
+	if len(base) != 3 {
+		return "und"
+	}
+	for _, c := range base {
+		if c < 'a' || c > 'z' {
+			return "und"
+		}
+	}
+	return base
+}
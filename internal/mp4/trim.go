@@ -0,0 +1,715 @@
+package mp4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// Range is a span of media time. An End of zero, or past the end of the
+// media, keeps everything from Start on.
+type Range struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// forever is the end of a cut that runs to the end of the media
+const forever = time.Duration(math.MaxInt64)
+
+// cut is a range moved onto keyframes
+type cut struct {
+	start, end time.Duration
+}
+
+// snapCuts moves both ends of every range back to the nearest preceding
+// keyframe, so that adjacent ranges tile without overlap and every part
+// starts decodable. With no keyframes the ranges are used as they are.
+func snapCuts(keyframes []time.Duration, total time.Duration, ranges []Range) ([]cut, error) {
+	if len(ranges) == 0 {
+		return nil, fmt.Errorf("no ranges to keep")
+	}
+
+	var cuts []cut
+	for _, r := range ranges {
+		if r.Start < 0 || (r.End > 0 && r.End <= r.Start) {
+			return nil, fmt.Errorf("invalid range %s-%s", r.Start, r.End)
+		}
+		if r.Start >= total {
+			return nil, fmt.Errorf("range starts at %s, after the end of the media", r.Start)
+		}
+
+		c := cut{start: snapBack(keyframes, r.Start), end: forever}
+		if r.End > 0 && r.End < total {
+			c.end = snapBack(keyframes, r.End)
+			if c.end <= c.start {
+				c.end = nextKeyframe(keyframes, c.start)
+			}
+		}
+		cuts = append(cuts, c)
+	}
+	return cuts, nil
+}
+
+func snapBack(keyframes []time.Duration, t time.Duration) time.Duration {
+	if len(keyframes) == 0 {
+		return t
+	}
+	i := sort.Search(len(keyframes), func(i int) bool { return keyframes[i] > t })
+	if i == 0 {
+		return keyframes[0]
+	}
+	return keyframes[i-1]
+}
+
+func nextKeyframe(keyframes []time.Duration, t time.Duration) time.Duration {
+	i := sort.Search(len(keyframes), func(i int) bool { return keyframes[i] > t })
+	if i == len(keyframes) {
+		return forever
+	}
+	return keyframes[i]
+}
+
+// Trim writes the given ranges of src, joined in order, to dst without
+// re-encoding. Cuts are moved back to the preceding keyframe of the video
+// track; audio-only files are cut at the nearest packet. Fragmented files
+// are cut at fragment boundaries, which start at keyframes. Nero chapters
+// are dropped, as their times no longer apply.
+func Trim(src, dst string, ranges []Range) error {
+	f, err := Open(src)
+	if err != nil {
+		return err
+	}
+
+	if f.Fragmented() {
+		return f.trimFragmented(dst, ranges)
+	}
+	return f.trimProgressive(dst, ranges)
+}
+
+// outChunk is a run of samples copied as one chunk
+type outChunk struct {
+	table     int
+	cut       int
+	count     int
+	sdi       uint32
+	srcOffset int64
+	size      int64
+	newOffset int64
+}
+
+func (f *File) trimProgressive(dst string, ranges []Range) error {
+	var tables []*sampleTable
+	var total time.Duration
+	for i, trak := range f.moov.FindAll("trak") {
+		t, err := readSampleTable(trak)
+		if err != nil {
+			return fmt.Errorf("track %d: %w", i+1, err)
+		}
+		if n := len(t.samples); n > 0 {
+			last := t.samples[n-1]
+			if end := toDuration(last.dts+int64(last.duration), t.timescale); end > total {
+				total = end
+			}
+		}
+		tables = append(tables, t)
+	}
+
+	var keyframes []time.Duration
+	for _, t := range tables {
+		if t.handler == "vide" && t.hasSync {
+			for _, s := range t.samples {
+				if s.sync {
+					keyframes = append(keyframes, toDuration(s.dts, t.timescale))
+				}
+			}
+			break
+		}
+	}
+
+	cuts, err := snapCuts(keyframes, total, ranges)
+	if err != nil {
+		return err
+	}
+
+	// Select the samples of every cut, keeping runs from the same source
+	// chunk together
+	selected := make([][]int, len(tables))
+	trackChunks := make([][]*outChunk, len(tables))
+	var chunks []*outChunk
+	var mdatSize int64
+	for ti, t := range tables {
+		for ci, c := range cuts {
+			var current *outChunk
+			prev := -1
+			for i, s := range t.samples {
+				at := toDuration(s.dts, t.timescale)
+				if at < c.start || at >= c.end {
+					continue
+				}
+				if current == nil || prev != i-1 || t.samples[prev].chunk != s.chunk {
+					current = &outChunk{table: ti, cut: ci, sdi: s.sdi, srcOffset: s.offset}
+					chunks = append(chunks, current)
+					trackChunks[ti] = append(trackChunks[ti], current)
+				}
+				selected[ti] = append(selected[ti], i)
+				current.count++
+				current.size += int64(s.size)
+				mdatSize += int64(s.size)
+				prev = i
+			}
+		}
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("the ranges contain no media")
+	}
+
+	// Keep the source interleaving within each cut
+	sort.SliceStable(chunks, func(i, j int) bool {
+		if chunks[i].cut != chunks[j].cut {
+			return chunks[i].cut < chunks[j].cut
+		}
+		return chunks[i].srcOffset < chunks[j].srcOffset
+	})
+
+	large := mdatSize > math.MaxUint32-(1<<24)
+	movieTimescale, err := f.Timescale()
+	if err != nil {
+		return err
+	}
+
+	var movieDuration int64
+	for ti, t := range tables {
+		duration := t.rebuild(selected[ti], trackChunks[ti], large)
+		if d := fromDuration(toDuration(duration, t.timescale), movieTimescale); d > movieDuration {
+			movieDuration = d
+		}
+		t.setDurations(duration, movieTimescale)
+	}
+	setMovieDuration(f.moov, movieDuration)
+	if udta := f.moov.Child("udta"); udta != nil {
+		udta.Remove("chpl")
+	}
+
+	src, closeSrc, err := f.source()
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	ftyp, err := f.readTopBox(src, "ftyp")
+	if err != nil {
+		return err
+	}
+
+	mdatHeader := int64(8)
+	if large {
+		mdatHeader = 16
+	}
+	pos := int64(len(ftyp)) + int64(f.moov.Size()) + mdatHeader
+	for _, chunk := range chunks {
+		chunk.newOffset = pos
+		pos += chunk.size
+	}
+	for ti, t := range tables {
+		t.setChunkOffsets(trackChunks[ti], large)
+	}
+
+	return writeFile(dst, func(w io.Writer) error {
+		if _, err := w.Write(ftyp); err != nil {
+			return err
+		}
+		if _, err := w.Write(f.moov.Encode()); err != nil {
+			return err
+		}
+		header := append(u32(uint32(mdatSize+8)), "mdat"...)
+		if large {
+			header = append(append(u32(1), "mdat"...), u64(uint64(mdatSize+16))...)
+		}
+		if _, err := w.Write(header); err != nil {
+			return err
+		}
+		for _, chunk := range chunks {
+			if _, err := io.Copy(w, io.NewSectionReader(src, chunk.srcOffset, chunk.size)); err != nil {
+				return fmt.Errorf("failed to copy samples: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// rebuild replaces the sample tables with ones describing the selected
+// samples, returning the new track duration. Chunk offsets are filled in
+// by setChunkOffsets once the layout is known.
+func (t *sampleTable) rebuild(selected []int, chunks []*outChunk, large bool) int64 {
+	stbl := t.trak.Find("mdia", "minf", "stbl")
+
+	var stts, ctts, stss, sizes []byte
+	var sttsCount, cttsCount, stssCount uint32
+	var duration int64
+
+	var runDelta uint32
+	var runCTS int32
+	var sttsRun, cttsRun uint32
+	flushStts := func() {
+		if sttsRun > 0 {
+			stts = append(stts, u32(sttsRun)...)
+			stts = append(stts, u32(runDelta)...)
+			sttsCount++
+		}
+	}
+	flushCtts := func() {
+		if cttsRun > 0 {
+			ctts = append(ctts, u32(cttsRun)...)
+			ctts = append(ctts, u32(uint32(runCTS))...)
+			cttsCount++
+		}
+	}
+
+	for n, i := range selected {
+		s := t.samples[i]
+		duration += int64(s.duration)
+
+		if sttsRun > 0 && s.duration == runDelta {
+			sttsRun++
+		} else {
+			flushStts()
+			sttsRun, runDelta = 1, s.duration
+		}
+		if cttsRun > 0 && s.cts == runCTS {
+			cttsRun++
+		} else {
+			flushCtts()
+			cttsRun, runCTS = 1, s.cts
+		}
+		if s.sync {
+			stss = append(stss, u32(uint32(n+1))...)
+			stssCount++
+		}
+		sizes = append(sizes, u32(s.size)...)
+	}
+	flushStts()
+	flushCtts()
+
+	var stsc []byte
+	var stscCount uint32
+	for i, chunk := range chunks {
+		if i > 0 && chunk.count == chunks[i-1].count && chunk.sdi == chunks[i-1].sdi {
+			continue
+		}
+		stsc = append(stsc, u32(uint32(i+1))...)
+		stsc = append(stsc, u32(uint32(chunk.count))...)
+		stsc = append(stsc, u32(chunk.sdi)...)
+		stscCount++
+	}
+
+	children := []*Box{stbl.Child("stsd")}
+	children = append(children, NewBox("stts", fullBox(0, 0, u32(sttsCount), stts)))
+	if t.hasCTS {
+		children = append(children, NewBox("ctts", fullBox(t.ctsVersion, 0, u32(cttsCount), ctts)))
+	}
+	if t.hasSync {
+		children = append(children, NewBox("stss", fullBox(0, 0, u32(stssCount), stss)))
+	}
+	children = append(children,
+		NewBox("stsz", fullBox(0, 0, u32(0), u32(uint32(len(selected))), sizes)),
+		NewBox("stsc", fullBox(0, 0, u32(stscCount), stsc)),
+	)
+	offsetSize := 4
+	offsetType := "stco"
+	if large {
+		offsetSize, offsetType = 8, "co64"
+	}
+	children = append(children, NewBox(offsetType,
+		fullBox(0, 0, u32(uint32(len(chunks))), make([]byte, offsetSize*len(chunks)))))
+
+	stbl.Children = children
+	return duration
+}
+
+// setChunkOffsets writes the final chunk positions into the offset box
+func (t *sampleTable) setChunkOffsets(chunks []*outChunk, large bool) {
+	stbl := t.trak.Find("mdia", "minf", "stbl")
+	if large {
+		p := stbl.Child("co64").Payload
+		for i, chunk := range chunks {
+			binary.BigEndian.PutUint64(p[8+8*i:], uint64(chunk.newOffset))
+		}
+		return
+	}
+	p := stbl.Child("stco").Payload
+	for i, chunk := range chunks {
+		binary.BigEndian.PutUint32(p[8+4*i:], uint32(chunk.newOffset))
+	}
+}
+
+// setDurations updates the media and track header durations and the edit
+// list after trimming
+func (t *sampleTable) setDurations(duration int64, movieTimescale uint32) {
+	if mdhd := t.trak.Find("mdia", "mdhd"); mdhd != nil {
+		if mdhd.Payload[0] == 1 && len(mdhd.Payload) >= 32 {
+			binary.BigEndian.PutUint64(mdhd.Payload[24:], uint64(duration))
+		} else if len(mdhd.Payload) >= 20 {
+			binary.BigEndian.PutUint32(mdhd.Payload[16:], uint32(duration))
+		}
+	}
+
+	movieDuration := fromDuration(toDuration(duration, t.timescale), movieTimescale)
+	if tkhd := t.trak.Child("tkhd"); tkhd != nil {
+		if tkhd.Payload[0] == 1 && len(tkhd.Payload) >= 36 {
+			binary.BigEndian.PutUint64(tkhd.Payload[28:], uint64(movieDuration))
+		} else if len(tkhd.Payload) >= 24 {
+			binary.BigEndian.PutUint32(tkhd.Payload[20:], uint32(movieDuration))
+		}
+	}
+
+	// A single edit that skips into the media (encoder delay or
+	// composition offset) still applies; anything else is dropped
+	edts := t.trak.Child("edts")
+	if edts == nil {
+		return
+	}
+	elst := edts.Child("elst")
+	if elst == nil || len(elst.Payload) < 8 || binary.BigEndian.Uint32(elst.Payload[4:8]) != 1 || duration == 0 {
+		t.trak.Remove("edts")
+		return
+	}
+	p := elst.Payload
+	if p[0] == 1 && len(p) >= 28 {
+		mediaTime := int64(binary.BigEndian.Uint64(p[16:]))
+		if mediaTime < 0 || mediaTime > duration {
+			t.trak.Remove("edts")
+			return
+		}
+		binary.BigEndian.PutUint64(p[8:], uint64(fromDuration(toDuration(duration-mediaTime, t.timescale), movieTimescale)))
+	} else if len(p) >= 20 {
+		mediaTime := int64(int32(binary.BigEndian.Uint32(p[12:])))
+		if mediaTime < 0 || mediaTime > duration {
+			t.trak.Remove("edts")
+			return
+		}
+		binary.BigEndian.PutUint32(p[8:], uint32(fromDuration(toDuration(duration-mediaTime, t.timescale), movieTimescale)))
+	}
+}
+
+func setMovieDuration(moov *Box, duration int64) {
+	mvhd := moov.Child("mvhd")
+	if mvhd == nil || len(mvhd.Payload) < 20 {
+		return
+	}
+	if mvhd.Payload[0] == 1 && len(mvhd.Payload) >= 32 {
+		binary.BigEndian.PutUint64(mvhd.Payload[24:], uint64(duration))
+	} else {
+		binary.BigEndian.PutUint32(mvhd.Payload[16:], uint32(duration))
+	}
+}
+
+// readTopBox returns the raw bytes of the first top-level box of a type
+func (f *File) readTopBox(src io.ReaderAt, boxType string) ([]byte, error) {
+	for _, box := range f.boxes {
+		if box.Type == boxType {
+			data := make([]byte, box.Size)
+			if _, err := src.ReadAt(data, box.Offset); err != nil {
+				return nil, fmt.Errorf("failed to read %q box: %w", boxType, err)
+			}
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("no %q box found", boxType)
+}
+
+// fragment is a movie fragment (moof) with the mdat that follows it
+type fragment struct {
+	moof, mdat topBox
+	track      uint32
+	start      time.Duration
+}
+
+func (f *File) trimFragmented(dst string, ranges []Range) error {
+	timescales := make(map[uint32]uint32)
+	var refTrack uint32
+	for _, trak := range f.moov.FindAll("trak") {
+		tkhd := trak.Child("tkhd")
+		mdhd := trak.Find("mdia", "mdhd")
+		if tkhd == nil || mdhd == nil || len(tkhd.Payload) < 24 || len(mdhd.Payload) < 24 {
+			continue
+		}
+		idPos, tsPos := 12, 12
+		if tkhd.Payload[0] == 1 {
+			idPos = 20
+		}
+		if mdhd.Payload[0] == 1 {
+			tsPos = 20
+		}
+		id := binary.BigEndian.Uint32(tkhd.Payload[idPos:])
+		timescales[id] = binary.BigEndian.Uint32(mdhd.Payload[tsPos:])
+		if hdlr := trak.Find("mdia", "hdlr"); refTrack == 0 || (hdlr != nil && len(hdlr.Payload) >= 12 && string(hdlr.Payload[8:12]) == "vide") {
+			refTrack = id
+		}
+	}
+	defaults := trackDefaults(f.moov)
+
+	src, closeSrc, err := f.source()
+	if err != nil {
+		return err
+	}
+	defer closeSrc()
+
+	// Locate the fragments and their start times
+	var fragments []fragment
+	var keyframes []time.Duration
+	var total time.Duration
+	next := make(map[uint32]int64)
+	for i := 0; i+1 < len(f.boxes); i++ {
+		if f.boxes[i].Type != "moof" || f.boxes[i+1].Type != "mdat" {
+			continue
+		}
+		moof, err := readBox(src, f.boxes[i])
+		if err != nil {
+			return err
+		}
+		traf := moof.Child("traf")
+		if traf == nil {
+			continue
+		}
+		track, decode, duration, err := trafTiming(traf, defaults)
+		if err != nil {
+			return err
+		}
+		if decode < 0 {
+			decode = next[track]
+		}
+		next[track] = decode + duration
+
+		timescale := timescales[track]
+		if timescale == 0 {
+			return fmt.Errorf("fragment for unknown track %d", track)
+		}
+		frag := fragment{moof: f.boxes[i], mdat: f.boxes[i+1], track: track, start: toDuration(decode, timescale)}
+		fragments = append(fragments, frag)
+		if track == refTrack {
+			keyframes = append(keyframes, frag.start)
+		}
+		if end := toDuration(decode+duration, timescale); end > total {
+			total = end
+		}
+	}
+	if len(fragments) == 0 {
+		return fmt.Errorf("no movie fragments found")
+	}
+	sort.Slice(keyframes, func(i, j int) bool { return keyframes[i] < keyframes[j] })
+
+	cuts, err := snapCuts(keyframes, total, ranges)
+	if err != nil {
+		return err
+	}
+
+	var kept []fragment
+	for _, c := range cuts {
+		for _, frag := range fragments {
+			if frag.start >= c.start && frag.start < c.end {
+				kept = append(kept, frag)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("the ranges contain no media")
+	}
+
+	if mvex := f.moov.Child("mvex"); mvex != nil {
+		mvex.Remove("mehd")
+	}
+	if udta := f.moov.Child("udta"); udta != nil {
+		udta.Remove("chpl")
+	}
+
+	ftyp, err := f.readTopBox(src, "ftyp")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(dst, func(w io.Writer) error {
+		if _, err := w.Write(ftyp); err != nil {
+			return err
+		}
+		moov := f.moov.Encode()
+		if _, err := w.Write(moov); err != nil {
+			return err
+		}
+
+		pos := int64(len(ftyp) + len(moov))
+		decode := make(map[uint32]int64)
+		for n, frag := range kept {
+			moof, err := readBox(src, frag.moof)
+			if err != nil {
+				return err
+			}
+			if err := renumberFragment(moof, uint32(n+1), pos-frag.moof.Offset, decode, defaults); err != nil {
+				return err
+			}
+			if _, err := w.Write(moof.Encode()); err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, io.NewSectionReader(src, frag.mdat.Offset, frag.mdat.Size)); err != nil {
+				return fmt.Errorf("failed to copy fragment data: %w", err)
+			}
+			pos += frag.moof.Size + frag.mdat.Size
+		}
+		return nil
+	})
+}
+
+// trackDefaults returns the default sample duration of every track from
+// the movie extends box
+func trackDefaults(moov *Box) map[uint32]uint32 {
+	defaults := make(map[uint32]uint32)
+	if mvex := moov.Child("mvex"); mvex != nil {
+		for _, trex := range mvex.FindAll("trex") {
+			if len(trex.Payload) >= 16 {
+				defaults[binary.BigEndian.Uint32(trex.Payload[4:])] = binary.BigEndian.Uint32(trex.Payload[12:])
+			}
+		}
+	}
+	return defaults
+}
+
+// trafTiming returns the track, decode time (-1 without tfdt) and duration
+// of a track fragment
+func trafTiming(traf *Box, defaults map[uint32]uint32) (uint32, int64, int64, error) {
+	tfhd := traf.Child("tfhd")
+	if tfhd == nil || len(tfhd.Payload) < 8 {
+		return 0, 0, 0, fmt.Errorf("missing tfhd box")
+	}
+	p := tfhd.Payload
+	flags := binary.BigEndian.Uint32(p[0:4]) & 0xFFFFFF
+	track := binary.BigEndian.Uint32(p[4:8])
+
+	defaultDuration := defaults[track]
+	pos := 8
+	if flags&0x1 != 0 {
+		pos += 8
+	}
+	if flags&0x2 != 0 {
+		pos += 4
+	}
+	if flags&0x8 != 0 {
+		if len(p) < pos+4 {
+			return 0, 0, 0, fmt.Errorf("truncated tfhd box")
+		}
+		defaultDuration = binary.BigEndian.Uint32(p[pos:])
+	}
+
+	decode := int64(-1)
+	if tfdt := traf.Child("tfdt"); tfdt != nil && len(tfdt.Payload) >= 8 {
+		if tfdt.Payload[0] == 1 && len(tfdt.Payload) >= 12 {
+			decode = int64(binary.BigEndian.Uint64(tfdt.Payload[4:]))
+		} else {
+			decode = int64(binary.BigEndian.Uint32(tfdt.Payload[4:]))
+		}
+	}
+
+	var duration int64
+	for _, trun := range traf.FindAll("trun") {
+		p := trun.Payload
+		if len(p) < 8 {
+			return 0, 0, 0, fmt.Errorf("truncated trun box")
+		}
+		flags := binary.BigEndian.Uint32(p[0:4]) & 0xFFFFFF
+		count := int(binary.BigEndian.Uint32(p[4:8]))
+		pos := 8
+		if flags&0x1 != 0 {
+			pos += 4
+		}
+		if flags&0x4 != 0 {
+			pos += 4
+		}
+		if flags&0x100 == 0 {
+			duration += int64(count) * int64(defaultDuration)
+			continue
+		}
+
+		entrySize := 0
+		for _, bit := range []uint32{0x100, 0x200, 0x400, 0x800} {
+			if flags&bit != 0 {
+				entrySize += 4
+			}
+		}
+		if len(p) < pos+count*entrySize {
+			return 0, 0, 0, fmt.Errorf("truncated trun box")
+		}
+		for i := 0; i < count; i++ {
+			duration += int64(binary.BigEndian.Uint32(p[pos+i*entrySize:]))
+		}
+	}
+
+	return track, decode, duration, nil
+}
+
+// renumberFragment gives a kept fragment its new sequence number, moves
+// explicit base data offsets by shift and makes decode times continue from
+// the previous kept fragment of each track
+func renumberFragment(moof *Box, seq uint32, shift int64, decode map[uint32]int64, defaults map[uint32]uint32) error {
+	if mfhd := moof.Child("mfhd"); mfhd != nil && len(mfhd.Payload) >= 8 {
+		binary.BigEndian.PutUint32(mfhd.Payload[4:], seq)
+	}
+
+	for _, traf := range moof.FindAll("traf") {
+		track, _, duration, err := trafTiming(traf, defaults)
+		if err != nil {
+			return err
+		}
+
+		tfhd := traf.Child("tfhd")
+		if flags := binary.BigEndian.Uint32(tfhd.Payload[0:4]) & 0xFFFFFF; flags&0x1 != 0 {
+			if len(tfhd.Payload) < 16 {
+				return fmt.Errorf("truncated tfhd box")
+			}
+			base := int64(binary.BigEndian.Uint64(tfhd.Payload[8:]))
+			binary.BigEndian.PutUint64(tfhd.Payload[8:], uint64(base+shift))
+		}
+
+		if tfdt := traf.Child("tfdt"); tfdt != nil && len(tfdt.Payload) >= 8 {
+			if tfdt.Payload[0] == 1 && len(tfdt.Payload) >= 12 {
+				binary.BigEndian.PutUint64(tfdt.Payload[4:], uint64(decode[track]))
+			} else {
+				binary.BigEndian.PutUint32(tfdt.Payload[4:], uint32(decode[track]))
+			}
+		}
+		decode[track] += duration
+	}
+	return nil
+}
+
+func readBox(src io.ReaderAt, box topBox) (*Box, error) {
+	data := make([]byte, box.Size)
+	if _, err := src.ReadAt(data, box.Offset); err != nil {
+		return nil, fmt.Errorf("failed to read %q box: %w", box.Type, err)
+	}
+	return ParseBox(data)
+}
+
+// writeFile creates dst and writes it through a buffer, removing it again
+// if write fails
+func writeFile(dst string, write func(w io.Writer) error) error {
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriterSize(out, 1<<20)
+	if err := write(w); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,302 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// trimTrack builds a track of ten one-second samples of one byte each,
+// stored perChunk samples to a chunk from offset on
+func trimTrack(id uint32, handler string, sync []uint32, perChunk uint32, offset uint32) *Box {
+	var sizes, offsets []byte
+	for i := 0; i < 10; i++ {
+		sizes = append(sizes, u32(1)...)
+	}
+	chunks := 10 / perChunk
+	for i := uint32(0); i < chunks; i++ {
+		offsets = append(offsets, u32(offset+i*perChunk)...)
+	}
+
+	stbl := NewContainer("stbl",
+		NewBox("stsd", fullBox(0, 0, u32(0))),
+		NewBox("stts", fullBox(0, 0, u32(1), u32(10), u32(1000))),
+		NewBox("stsz", fullBox(0, 0, u32(0), u32(10), sizes)),
+		NewBox("stsc", fullBox(0, 0, u32(1), u32(1), u32(perChunk), u32(1))),
+		NewBox("stco", fullBox(0, 0, u32(chunks), offsets)),
+	)
+	if sync != nil {
+		var entries []byte
+		for _, n := range sync {
+			entries = append(entries, u32(n)...)
+		}
+		stbl.Children = append(stbl.Children, NewBox("stss", fullBox(0, 0, u32(uint32(len(sync))), entries)))
+	}
+
+	return NewContainer("trak",
+		NewBox("tkhd", fullBox(0, 3, u32(0), u32(0), u32(id), u32(0), u32(10000), make([]byte, 60))),
+		NewContainer("mdia",
+			NewBox("mdhd", fullBox(0, 0, u32(0), u32(0), u32(1000), u32(10000), make([]byte, 4))),
+			NewBox("hdlr", fullBox(0, 0, u32(0), []byte(handler), make([]byte, 13))),
+			NewContainer("minf", stbl),
+		),
+	)
+}
+
+func testMovieHeader() *Box {
+	return NewBox("mvhd", fullBox(0, 0, u32(0), u32(0), u32(1000), u32(10000), make([]byte, 76), u32(3)))
+}
+
+// samplesOf reads back the data of every sample of a track
+func samplesOf(t *testing.T, path string, track int) (string, *sampleTable) {
+	t.Helper()
+	f, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	table, err := readSampleTable(f.Moov().FindAll("trak")[track])
+	if err != nil {
+		t.Fatalf("readSampleTable() error = %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for _, s := range table.samples {
+		out = append(out, data[s.offset:s.offset+int64(s.size)]...)
+	}
+	return string(out), table
+}
+
+func TestTrimProgressive(t *testing.T) {
+	ftyp := NewBox("ftyp", []byte("isom\x00\x00\x02\x00isom"))
+	build := func(base uint32) *Box {
+		return NewContainer("moov",
+			testMovieHeader(),
+			trimTrack(1, "vide", []uint32{1, 4, 7}, 2, base),
+			trimTrack(2, "soun", nil, 10, base+10),
+		)
+	}
+	base := uint32(ftyp.Size() + build(0).Size() + 8)
+
+	var buf bytes.Buffer
+	buf.Write(ftyp.Encode())
+	buf.Write(build(base).Encode())
+	buf.Write(NewBox("mdat", []byte("ABCDEFGHIJabcdefghij")).Encode())
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.mp4")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		ranges    []Range
+		wantVideo string
+		wantAudio string
+	}{
+		// 4s-8s moves back to the keyframes at 3s and 6s
+		{"snapped to keyframes", []Range{{Start: 4 * time.Second, End: 8 * time.Second}}, "DEF", "def"},
+		{"to the end", []Range{{Start: 7 * time.Second}}, "GHIJ", "ghij"},
+		{"two ranges", []Range{{End: 3 * time.Second}, {Start: 6 * time.Second, End: 20 * time.Second}}, "ABCGHIJ", "abcghij"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(dir, "out.mp4")
+			if err := Trim(src, dst, tt.ranges); err != nil {
+				t.Fatalf("Trim() error = %v", err)
+			}
+
+			video, table := samplesOf(t, dst, 0)
+			if video != tt.wantVideo {
+				t.Errorf("video samples = %q, want %q", video, tt.wantVideo)
+			}
+			if !table.samples[0].sync {
+				t.Error("first video sample is not a sync sample")
+			}
+			if audio, _ := samplesOf(t, dst, 1); audio != tt.wantAudio {
+				t.Errorf("audio samples = %q, want %q", audio, tt.wantAudio)
+			}
+
+			f, _ := Open(dst)
+			mvhd := f.Moov().Child("mvhd")
+			if got, want := binary.BigEndian.Uint32(mvhd.Payload[16:]), uint32(len(tt.wantVideo)*1000); got != want {
+				t.Errorf("movie duration = %d, want %d", got, want)
+			}
+		})
+	}
+
+	if err := Trim(src, filepath.Join(dir, "bad.mp4"), []Range{{Start: 30 * time.Second}}); err == nil {
+		t.Error("Trim() past the end succeeded")
+	}
+}
+
+func TestTrimFragmented(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(NewBox("ftyp", []byte("iso6\x00\x00\x00\x00iso6")).Encode())
+	buf.Write(NewContainer("moov",
+		testMovieHeader(),
+		trimTrack(1, "vide", nil, 10, 0),
+		NewContainer("mvex",
+			NewBox("mehd", fullBox(0, 0, u32(3000))),
+			NewBox("trex", fullBox(0, 0, u32(1), u32(1), u32(1000), u32(0), u32(0))),
+		),
+	).Encode())
+
+	for i, data := range []string{"X", "Y", "Z"} {
+		moof := NewContainer("moof",
+			NewBox("mfhd", fullBox(0, 0, u32(uint32(i+1)))),
+			NewContainer("traf",
+				NewBox("tfhd", fullBox(0, 0x20000, u32(1))),
+				NewBox("tfdt", fullBox(1, 0, u64(uint64(i*1000)))),
+				NewBox("trun", fullBox(0, 0x1, u32(1), u32(0))),
+			),
+		)
+		binary.BigEndian.PutUint32(moof.Children[1].Children[2].Payload[8:], uint32(moof.Size()+8))
+		buf.Write(moof.Encode())
+		buf.Write(NewBox("mdat", []byte(data)).Encode())
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.mp4")
+	if err := os.WriteFile(src, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := filepath.Join(dir, "out.mp4")
+	if err := Trim(src, dst, []Range{{Start: 1500 * time.Millisecond, End: 2500 * time.Millisecond}}); err != nil {
+		t.Fatalf("Trim() error = %v", err)
+	}
+
+	f, err := Open(dst)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if f.Moov().Find("mvex", "mehd") != nil {
+		t.Error("mehd box was kept")
+	}
+
+	data, _ := os.ReadFile(dst)
+	var moofs []*Box
+	var mdats []string
+	for _, box := range f.boxes {
+		switch box.Type {
+		case "moof":
+			moof, err := ParseBox(data[box.Offset : box.Offset+box.Size])
+			if err != nil {
+				t.Fatal(err)
+			}
+			moofs = append(moofs, moof)
+		case "mdat":
+			mdats = append(mdats, string(data[box.Offset+box.Header:box.Offset+box.Size]))
+		}
+	}
+	if len(moofs) != 1 || len(mdats) != 1 || mdats[0] != "Y" {
+		t.Fatalf("got %d fragments with data %q, want the one holding Y", len(moofs), mdats)
+	}
+	if seq := binary.BigEndian.Uint32(moofs[0].Child("mfhd").Payload[4:]); seq != 1 {
+		t.Errorf("sequence number = %d, want 1", seq)
+	}
+	if decode := binary.BigEndian.Uint64(moofs[0].Find("traf", "tfdt").Payload[4:]); decode != 0 {
+		t.Errorf("decode time = %d, want 0", decode)
+	}
+}
+
+// countingReader records the bytes read from it
+type countingReader struct {
+	data []byte
+	read []bool
+}
+
+func (r *countingReader) ReadAt(p []byte, off int64) (int, error) {
+	n := copy(p, r.data[off:])
+	for i := off; i < off+int64(n); i++ {
+		r.read[i] = true
+	}
+	return n, nil
+}
+
+func TestClipFragmented(t *testing.T) {
+	var head, media bytes.Buffer
+	head.Write(NewBox("ftyp", []byte("iso6\x00\x00\x00\x00iso6")).Encode())
+	head.Write(NewContainer("moov",
+		testMovieHeader(),
+		trimTrack(1, "vide", nil, 10, 0),
+		NewContainer("mvex",
+			NewBox("trex", fullBox(0, 0, u32(1), u32(1), u32(1000), u32(0), u32(0))),
+		),
+	).Encode())
+
+	var refs []byte
+	for i, data := range []string{"X", "Y", "Z"} {
+		moof := NewContainer("moof",
+			NewBox("mfhd", fullBox(0, 0, u32(uint32(i+1)))),
+			NewContainer("traf",
+				NewBox("tfhd", fullBox(0, 0x20000, u32(1))),
+				NewBox("tfdt", fullBox(1, 0, u64(uint64(i*1000)))),
+				NewBox("trun", fullBox(0, 0x1, u32(1), u32(0))),
+			),
+		)
+		binary.BigEndian.PutUint32(moof.Children[1].Children[2].Payload[8:], uint32(moof.Size()+8))
+		fragment := append(moof.Encode(), NewBox("mdat", []byte(data)).Encode()...)
+		media.Write(fragment)
+		refs = append(refs, u32(uint32(len(fragment)))...)
+		refs = append(refs, u32(1000)...)
+		refs = append(refs, u32(0x90000000)...)
+	}
+	head.Write(NewBox("sidx", fullBox(0, 0, u32(1), u32(1000), u32(0), u32(0), []byte{0, 0, 0, 3}, refs)).Encode())
+	fragmentsAt := head.Len()
+	head.Write(media.Bytes())
+
+	r := &countingReader{data: head.Bytes(), read: make([]bool, head.Len())}
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := Clip(r, int64(head.Len()), dst, []Range{{Start: 1200 * time.Millisecond, End: 2 * time.Second}}); err != nil {
+		t.Fatalf("Clip() error = %v", err)
+	}
+
+	// Past the header of the first box after the index, only the second
+	// fragment is read
+	fragment := media.Len() / 3
+	for i := fragmentsAt + 8; i < head.Len(); i++ {
+		if second := i >= fragmentsAt+fragment && i < fragmentsAt+2*fragment; r.read[i] && !second {
+			t.Fatalf("byte %d of the skipped fragments was read", i)
+		}
+	}
+
+	data, _ := os.ReadFile(dst)
+	if !bytes.HasSuffix(data, NewBox("mdat", []byte("Y")).Encode()) || bytes.Contains(data, []byte("mdat\x58")) {
+		t.Errorf("clip does not hold only the fragment with Y")
+	}
+}
+
+func TestClipProgressive(t *testing.T) {
+	ftyp := NewBox("ftyp", []byte("isom\x00\x00\x02\x00isom"))
+	build := func(base uint32) *Box {
+		return NewContainer("moov", testMovieHeader(), trimTrack(1, "vide", []uint32{1, 4, 7}, 1, base))
+	}
+	base := uint32(ftyp.Size() + build(0).Size() + 8)
+	var buf bytes.Buffer
+	buf.Write(ftyp.Encode())
+	buf.Write(build(base).Encode())
+	buf.Write(NewBox("mdat", []byte("ABCDEFGHIJ")).Encode())
+
+	r := &countingReader{data: buf.Bytes(), read: make([]bool, buf.Len())}
+	dst := filepath.Join(t.TempDir(), "out.mp4")
+	if err := Clip(r, int64(buf.Len()), dst, []Range{{Start: 3 * time.Second, End: 6 * time.Second}}); err != nil {
+		t.Fatalf("Clip() error = %v", err)
+	}
+	for i := int(base); i < buf.Len(); i++ {
+		if want := i >= int(base)+3 && i < int(base)+6; r.read[i] != want {
+			t.Errorf("sample byte %d read = %v, want %v", i-int(base), r.read[i], want)
+		}
+	}
+	if got, _ := samplesOf(t, dst, 0); got != "DEF" {
+		t.Errorf("samples = %q, want DEF", got)
+	}
+}
@@ -0,0 +1,213 @@
+// Package nfo writes the sidecar files media servers such as Kodi and
+// Jellyfin read: NFO metadata for episodes, seasons and shows, the video
+// details as JSON and the description as text. A channel is treated as a
+// show, a playlist as one of its seasons and a video as an episode.
+package nfo
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+)
+
+// Sidecar file names
+const (
+	ShowFilename   = "tvshow.nfo"
+	SeasonFilename = "season.nfo"
+)
+
+// Filename returns the name of a sidecar of mediaFile with the given
+// extension, such as "Video.nfo" or "Video.info.json" for "Video.mp4"
+func Filename(mediaFile, ext string) string {
+	return mediaFile[:len(mediaFile)-len(filepath.Ext(mediaFile))] + ext
+}
+
+// uniqueID identifies an item on YouTube
+type uniqueID struct {
+	Type    string `xml:"type,attr"`
+	Default bool   `xml:"default,attr"`
+	Value   string `xml:",chardata"`
+}
+
+// Episode is the episodedetails document of a video
+type Episode struct {
+	XMLName   xml.Name `xml:"episodedetails"`
+	Title     string   `xml:"title"`
+	ShowTitle string   `xml:"showtitle,omitempty"`
+	// Season and Episode place a playlist video; other videos have none
+	Season    int      `xml:"season,omitempty"`
+	Episode   int      `xml:"episode,omitempty"`
+	Plot      string   `xml:"plot,omitempty"`
+	Aired     string   `xml:"aired,omitempty"`
+	Premiered string   `xml:"premiered,omitempty"`
+	Runtime   int      `xml:"runtime,omitempty"`
+	Studio    string   `xml:"studio,omitempty"`
+	Thumb     string   `xml:"thumb,omitempty"`
+	UniqueID  uniqueID `xml:"uniqueid"`
+}
+
+// Position places an episode in a season
+type Position struct {
+	Season  int
+	Episode int
+}
+
+// NewEpisode describes a video. pos is nil for videos outside a playlist.
+func NewEpisode(details *extractor.VideoDetails, pos *Position) *Episode {
+	e := &Episode{
+		Title:     details.Title,
+		ShowTitle: details.Author,
+		Plot:      details.Description,
+		Aired:     details.UploadDate,
+		Premiered: details.UploadDate,
+		Studio:    details.Author,
+		Thumb:     details.Thumbnail,
+		UniqueID:  uniqueID{Type: "youtube", Default: true, Value: details.ID},
+	}
+	// Runtime is in whole minutes, rounded up so short videos have one
+	if details.DurationSeconds > 0 {
+		e.Runtime = int((details.DurationSeconds + 59) / 60)
+	}
+	if pos != nil {
+		e.Season, e.Episode = pos.Season, pos.Episode
+	}
+	return e
+}
+
+// Season is the season document of a playlist
+type Season struct {
+	XMLName      xml.Name `xml:"season"`
+	Title        string   `xml:"title"`
+	SeasonNumber int      `xml:"seasonnumber"`
+	UniqueID     uniqueID `xml:"uniqueid"`
+}
+
+// NewSeason describes a playlist
+func NewSeason(playlistID, title string, number int) *Season {
+	return &Season{
+		Title:        title,
+		SeasonNumber: number,
+		UniqueID:     uniqueID{Type: "youtube", Default: true, Value: playlistID},
+	}
+}
+
+// Show is the tvshow document of a channel
+type Show struct {
+	XMLName  xml.Name `xml:"tvshow"`
+	Title    string   `xml:"title"`
+	Studio   string   `xml:"studio,omitempty"`
+	UniqueID uniqueID `xml:"uniqueid"`
+}
+
+// NewShow describes the channel of a video
+func NewShow(details *extractor.VideoDetails) *Show {
+	return &Show{
+		Title:    details.Author,
+		Studio:   details.Author,
+		UniqueID: uniqueID{Type: "youtube", Default: true, Value: details.ChannelID},
+	}
+}
+
+// Write saves an NFO document
+func Write(path string, doc interface{}) error {
+	return writeFile(path, func(w io.Writer) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		enc := xml.NewEncoder(w)
+		enc.Indent("", "  ")
+		if err := enc.Encode(doc); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, "\n")
+		return err
+	})
+}
+
+// WriteOnce saves an NFO document unless the file exists, so that shared
+// files such as tvshow.nfo are written once and edits to them are kept
+func WriteOnce(path string, doc interface{}) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return Write(path, doc)
+}
+
+// episodeTag matches the episode number of an episodedetails document
+var episodeTag = regexp.MustCompile(`<episode>\d+</episode>`)
+
+// SetEpisode changes the episode number in the NFO document at path, as
+// when its video moves within a playlist. The rest of the file, including
+// edits made to it, is kept, and a document without an episode number is
+// left alone.
+func SetEpisode(path string, episode int) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to read %s", filepath.Base(path)), err)
+	}
+	loc := episodeTag.FindIndex(data)
+	if loc == nil {
+		return nil
+	}
+	updated := fmt.Sprintf("%s<episode>%d</episode>%s", data[:loc[0]], episode, data[loc[1]:])
+	if updated == string(data) {
+		return nil
+	}
+	return writeFile(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, updated)
+		return err
+	})
+}
+
+// WriteInfoJSON saves the details of a video as JSON
+func WriteInfoJSON(path string, details *extractor.VideoDetails) error {
+	return writeFile(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(details)
+	})
+}
+
+// WriteDescription saves the description of a video as text
+func WriteDescription(path string, details *extractor.VideoDetails) error {
+	return writeFile(path, func(w io.Writer) error {
+		_, err := io.WriteString(w, details.Description)
+		return err
+	})
+}
+
+// writeFile replaces path with what write produces. Each write goes through
+// its own temporary file, so concurrent downloads sharing a file do not
+// corrupt it.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to create %s", filepath.Base(path)), err)
+	}
+	tmp := f.Name()
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write %s", filepath.Base(path)), err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write %s", filepath.Base(path)), err)
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write %s", filepath.Base(path)), err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write %s", filepath.Base(path)), err)
+	}
+	return nil
+}
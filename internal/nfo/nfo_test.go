@@ -0,0 +1,162 @@
+package nfo
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+)
+
+var details = &extractor.VideoDetails{
+	ID:              "abc123",
+	Title:           "Cats & Dogs",
+	Author:          "Pet Channel",
+	Description:     "All about <pets>",
+	UploadDate:      "2024-03-01",
+	DurationSeconds: 61,
+	ChannelID:       "UC123",
+	Thumbnail:       "https://i.ytimg.com/vi/abc123/maxresdefault.jpg",
+}
+
+func TestFilename(t *testing.T) {
+	if got := Filename("001 - Cats.mp4", ".nfo"); got != "001 - Cats.nfo" {
+		t.Errorf("Filename = %q", got)
+	}
+	if got := Filename("Cats", ".info.json"); got != "Cats.info.json" {
+		t.Errorf("Filename without extension = %q", got)
+	}
+}
+
+func TestWriteNFO(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name string
+		doc  interface{}
+		want []string
+	}{
+		{
+			name: "playlist episode",
+			doc:  NewEpisode(details, &Position{Season: 1, Episode: 7}),
+			want: []string{
+				"<episodedetails>",
+				"<title>Cats &amp; Dogs</title>",
+				"<showtitle>Pet Channel</showtitle>",
+				"<season>1</season>",
+				"<episode>7</episode>",
+				"<plot>All about &lt;pets&gt;</plot>",
+				"<aired>2024-03-01</aired>",
+				"<runtime>2</runtime>",
+				`<uniqueid type="youtube" default="true">abc123</uniqueid>`,
+			},
+		},
+		{
+			name: "single episode",
+			doc:  NewEpisode(details, nil),
+			want: []string{"<episodedetails>", "<title>Cats &amp; Dogs</title>"},
+		},
+		{
+			name: "season",
+			doc:  NewSeason("PL1", "Best Of", 2),
+			want: []string{"<season>", "<title>Best Of</title>", "<seasonnumber>2</seasonnumber>"},
+		},
+		{
+			name: "show",
+			doc:  NewShow(details),
+			want: []string{"<tvshow>", "<title>Pet Channel</title>", `<uniqueid type="youtube" default="true">UC123</uniqueid>`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".nfo")
+			if err := Write(path, tt.doc); err != nil {
+				t.Fatal(err)
+			}
+			data, _ := os.ReadFile(path)
+			if !strings.HasPrefix(string(data), "<?xml") {
+				t.Errorf("missing XML header:\n%s", data)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(data), want) {
+					t.Errorf("missing %s in:\n%s", want, data)
+				}
+			}
+		})
+	}
+
+	single, _ := os.ReadFile(filepath.Join(dir, "single episode.nfo"))
+	if strings.Contains(string(single), "<season>") {
+		t.Errorf("a video outside a playlist has a season:\n%s", single)
+	}
+}
+
+func TestWriteOnce(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ShowFilename)
+	os.WriteFile(path, []byte("edited"), 0644)
+	if err := WriteOnce(path, NewShow(details)); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "edited" {
+		t.Errorf("WriteOnce replaced an existing file: %s", data)
+	}
+}
+
+func TestSetEpisode(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "episode.nfo")
+	if err := Write(path, NewEpisode(details, &Position{Season: 2, Episode: 3})); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetEpisode(path, 12); err != nil {
+		t.Fatalf("SetEpisode() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	for _, want := range []string{"<episode>12</episode>", "<season>2</season>", "<title>Cats &amp; Dogs</title>"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("episode NFO is missing %s:\n%s", want, data)
+		}
+	}
+
+	single := filepath.Join(dir, "single.nfo")
+	if err := Write(single, NewEpisode(details, nil)); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := os.ReadFile(single)
+	if err := SetEpisode(single, 12); err != nil {
+		t.Fatalf("SetEpisode() error = %v", err)
+	}
+	if after, _ := os.ReadFile(single); string(after) != string(before) {
+		t.Errorf("SetEpisode() changed a document without an episode:\n%s", after)
+	}
+}
+
+func TestWriteInfoJSONAndDescription(t *testing.T) {
+	dir := t.TempDir()
+
+	jsonPath := filepath.Join(dir, "v.info.json")
+	if err := WriteInfoJSON(jsonPath, details); err != nil {
+		t.Fatal(err)
+	}
+	var decoded extractor.VideoDetails
+	data, _ := os.ReadFile(jsonPath)
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.ID != "abc123" || decoded.ChannelID != "UC123" {
+		t.Errorf("info JSON = %s (%v)", data, err)
+	}
+
+	descPath := filepath.Join(dir, "v.description")
+	if err := WriteDescription(descPath, details); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(descPath); string(data) != details.Description {
+		t.Errorf("description = %q", data)
+	}
+
+	// No temporary files are left behind
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		t.Errorf("directory has %d files, want 2", len(entries))
+	}
+}
@@ -0,0 +1,153 @@
+// Package ogg reads and writes Ogg bitstreams (RFC 3533) for remuxed audio
+package ogg
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Page header flags
+const (
+	flagContinued = 0x01
+	flagBOS       = 0x02
+	flagEOS       = 0x04
+)
+
+// maxPageData is the size past which the next packet starts a new page;
+// pages may grow up to the 255-segment limit for a single large packet
+const maxPageData = 4096
+
+// crcTable is the CRC-32 variant Ogg uses: polynomial 0x04C11DB7, no bit
+// reflection, zero initial value
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+	for i := range table {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04C11DB7
+			} else {
+				r <<= 1
+			}
+		}
+		table[i] = r
+	}
+	return table
+}()
+
+func crc(data []byte) uint32 {
+	var c uint32
+	for _, b := range data {
+		c = c<<8 ^ crcTable[byte(c>>24)^b]
+	}
+	return c
+}
+
+// Writer packs packets of a single logical bitstream into pages
+type Writer struct {
+	w      io.Writer
+	serial uint32
+	seq    uint32
+
+	// the page being filled
+	segments  []byte
+	data      []byte
+	granule   int64
+	continued bool
+	bos       bool
+}
+
+// NewWriter returns a Writer for the logical bitstream with the given
+// serial number
+func NewWriter(w io.Writer, serial uint32) *Writer {
+	return &Writer{w: w, serial: serial, granule: -1, bos: true}
+}
+
+// WritePacket adds a packet ending at the given granule position. Packets
+// that do not fit on the current page continue on the next one; the last
+// packet stays pending until Close so its page can end the stream.
+func (w *Writer) WritePacket(packet []byte, granule int64) error {
+	if len(w.data) >= maxPageData || len(w.segments) == 255 {
+		if err := w.flush(0); err != nil {
+			return err
+		}
+	}
+
+	for {
+		// Lacing values: a run of 255s plus a final value below 255
+		remaining := len(packet)
+		for remaining >= 255 && len(w.segments) < 255 {
+			w.segments = append(w.segments, 255)
+			w.data = append(w.data, packet[:255]...)
+			packet = packet[255:]
+			remaining -= 255
+		}
+		if len(w.segments) < 255 {
+			w.segments = append(w.segments, byte(remaining))
+			w.data = append(w.data, packet...)
+			w.granule = granule
+			break
+		}
+
+		// The page is full in the middle of the packet
+		if err := w.flush(0); err != nil {
+			return err
+		}
+		w.continued = true
+	}
+
+	return nil
+}
+
+// Flush ends the current page, so the next packet starts a new one. Codec
+// headers must be flushed onto their own pages.
+func (w *Writer) Flush() error {
+	if len(w.segments) == 0 {
+		return nil
+	}
+	return w.flush(0)
+}
+
+// Close writes the last page of the stream, marked end-of-stream
+func (w *Writer) Close() error {
+	return w.flush(flagEOS)
+}
+
+// SetGranule overrides the granule position of the page being filled, used
+// to trim padding from the end of the stream
+func (w *Writer) SetGranule(granule int64) {
+	w.granule = granule
+}
+
+func (w *Writer) flush(flags byte) error {
+	if w.continued {
+		flags |= flagContinued
+	}
+	if w.bos {
+		flags |= flagBOS
+	}
+
+	page := make([]byte, 27, 27+len(w.segments)+len(w.data))
+	copy(page, "OggS")
+	page[4] = 0 // version
+	page[5] = flags
+	binary.LittleEndian.PutUint64(page[6:], uint64(w.granule))
+	binary.LittleEndian.PutUint32(page[14:], w.serial)
+	binary.LittleEndian.PutUint32(page[18:], w.seq)
+	page[26] = byte(len(w.segments))
+	page = append(page, w.segments...)
+	page = append(page, w.data...)
+	binary.LittleEndian.PutUint32(page[22:], crc(page))
+
+	if _, err := w.w.Write(page); err != nil {
+		return err
+	}
+
+	w.seq++
+	w.segments = w.segments[:0]
+	w.data = w.data[:0]
+	w.granule = -1
+	w.continued = false
+	w.bos = false
+	return nil
+}
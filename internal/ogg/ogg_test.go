@@ -0,0 +1,291 @@
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type page struct {
+	flags    byte
+	granule  int64
+	seq      uint32
+	segments []byte
+	data     []byte
+}
+
+// readPages parses the pages of a stream, checking their CRCs
+func readPages(t *testing.T, stream []byte) []page {
+	t.Helper()
+	var pages []page
+	for len(stream) > 0 {
+		if len(stream) < 27 || string(stream[:4]) != "OggS" {
+			t.Fatalf("invalid page header")
+		}
+		n := int(stream[26])
+		size := 27 + n
+		for _, s := range stream[27 : 27+n] {
+			size += int(s)
+		}
+
+		raw := append([]byte(nil), stream[:size]...)
+		want := binary.LittleEndian.Uint32(raw[22:])
+		binary.LittleEndian.PutUint32(raw[22:], 0)
+		if got := crc(raw); got != want {
+			t.Errorf("page CRC = %08x, want %08x", got, want)
+		}
+
+		pages = append(pages, page{
+			flags:    stream[5],
+			granule:  int64(binary.LittleEndian.Uint64(stream[6:])),
+			seq:      binary.LittleEndian.Uint32(stream[18:]),
+			segments: stream[27 : 27+n],
+			data:     stream[27+n : size],
+		})
+		stream = stream[size:]
+	}
+	return pages
+}
+
+func TestCRC(t *testing.T) {
+	// Reference value of the Ogg CRC for "123456789"
+	if got := crc([]byte("123456789")); got != 0x89A1897F {
+		t.Errorf("crc() = %08x, want 89a1897f", got)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 1234)
+
+	if err := w.WritePacket([]byte("header"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A packet larger than one page spills onto a continued page
+	large := bytes.Repeat([]byte{'x'}, 255*255+10)
+	if err := w.WritePacket(large, 960); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WritePacket([]byte("last"), 1920); err != nil {
+		t.Fatal(err)
+	}
+	w.SetGranule(1900)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := readPages(t, buf.Bytes())
+	if len(pages) != 3 {
+		t.Fatalf("wrote %d pages, want 3", len(pages))
+	}
+
+	if pages[0].flags != flagBOS || string(pages[0].data) != "header" {
+		t.Errorf("first page = flags %d data %q", pages[0].flags, pages[0].data)
+	}
+	if pages[1].flags != 0 || pages[1].granule != -1 || len(pages[1].segments) != 255 {
+		t.Errorf("second page = flags %d granule %d segments %d, want 0, -1, 255",
+			pages[1].flags, pages[1].granule, len(pages[1].segments))
+	}
+	if pages[2].flags != flagContinued|flagEOS || pages[2].granule != 1900 {
+		t.Errorf("last page = flags %d granule %d, want %d, 1900",
+			pages[2].flags, pages[2].granule, flagContinued|flagEOS)
+	}
+	for i, p := range pages {
+		if p.seq != uint32(i) {
+			t.Errorf("page %d has sequence number %d", i, p.seq)
+		}
+	}
+
+	got := append(append([]byte(nil), pages[1].data...), pages[2].data...)
+	if !bytes.Equal(got, append(large, "last"...)) {
+		t.Error("packet data does not round-trip")
+	}
+}
+
+func TestPacketSamples(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   int
+	}{
+		{"CELT 20ms single frame", []byte{31<<3 | 0}, 960},
+		{"CELT 2.5ms two frames", []byte{16<<3 | 1}, 240},
+		{"SILK 60ms", []byte{3 << 3}, 2880},
+		{"hybrid 10ms", []byte{12 << 3}, 480},
+		{"CELT 20ms arbitrary frames", []byte{31<<3 | 3, 3}, 2880},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := PacketSamples(tt.packet)
+			if err != nil {
+				t.Fatalf("PacketSamples() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("PacketSamples() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := PacketSamples(nil); err == nil {
+		t.Error("PacketSamples(nil) should fail")
+	}
+}
+
+func TestOpusTags(t *testing.T) {
+	tags := OpusTags("red-goose", []string{"TITLE=Song", "ARTIST=Band"})
+	want := "OpusTags\x09\x00\x00\x00red-goose\x02\x00\x00\x00" +
+		"\x0a\x00\x00\x00TITLE=Song\x0b\x00\x00\x00ARTIST=Band"
+	if string(tags) != want {
+		t.Errorf("OpusTags() = %q, want %q", tags, want)
+	}
+}
+
+// writeOpus writes an Ogg Opus file of ten 20ms packets, "a" to "j", with
+// 312 samples of pre-skip and 100 samples trimmed from the end
+func writeOpus(t *testing.T, path string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := NewWriter(&buf, 7)
+	head := []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	for _, header := range [][]byte{head, OpusTags("test", nil)} {
+		if err := w.WritePacket(header, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.WritePacket([]byte{0xf8, byte('a' + i)}, int64(i+1)*960); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.SetGranule(10*960 - 100)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTrimOpus(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.opus")
+	writeOpus(t, src)
+
+	tests := []struct {
+		name        string
+		ranges      []Range
+		want        string
+		wantGranule int64
+	}{
+		{"middle", []Range{{Start: 40 * time.Millisecond, End: 100 * time.Millisecond}}, "cde", 3 * 960},
+		{"to the end keeps the end trim", []Range{{Start: 150 * time.Millisecond}}, "ij", 2*960 - 100},
+		{"two ranges", []Range{{End: 20 * time.Millisecond}, {Start: 180 * time.Millisecond, End: time.Second}}, "aj", 2*960 - 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := filepath.Join(dir, "out.opus")
+			if err := TrimOpus(src, dst, tt.ranges); err != nil {
+				t.Fatalf("TrimOpus() error = %v", err)
+			}
+
+			f, err := os.Open(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			r := NewReader(f)
+			var got []byte
+			var granule int64
+			for i := 0; ; i++ {
+				packet, g, err := r.ReadPacket()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("ReadPacket() error = %v", err)
+				}
+				if g >= 0 {
+					granule = g
+				}
+				if i == 0 && !bytes.HasPrefix(packet, []byte("OpusHead")) {
+					t.Error("first packet is not OpusHead")
+				}
+				if i >= 2 {
+					got = append(got, packet[1])
+				}
+			}
+			if string(got) != tt.want {
+				t.Errorf("packets = %q, want %q", got, tt.want)
+			}
+			if granule != tt.wantGranule {
+				t.Errorf("final granule = %d, want %d", granule, tt.wantGranule)
+			}
+		})
+	}
+}
+
+func TestRewriteOpusTags(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "in.opus")
+	writeOpus(t, src)
+
+	dst := filepath.Join(dir, "out.opus")
+	err := RewriteOpusTags(src, dst, func(comments []string) []string {
+		if len(comments) != 0 {
+			t.Errorf("comments = %q, want none", comments)
+		}
+		return []string{"TRACKNUMBER=3"}
+	})
+	if err != nil {
+		t.Fatalf("RewriteOpusTags() error = %v", err)
+	}
+
+	f, err := os.Open(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := NewReader(f)
+	var got []byte
+	var granule int64
+	for i := 0; ; i++ {
+		packet, g, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadPacket() error = %v", err)
+		}
+		if g >= 0 {
+			granule = g
+		}
+		switch {
+		case i == 1:
+			vendor, comments, err := ParseOpusTags(packet)
+			if err != nil || vendor != "test" || len(comments) != 1 || comments[0] != "TRACKNUMBER=3" {
+				t.Errorf("ParseOpusTags() = %q, %q, %v", vendor, comments, err)
+			}
+		case i >= 2:
+			got = append(got, packet[1])
+		}
+	}
+	if string(got) != "abcdefghij" {
+		t.Errorf("packets = %q, want all ten", got)
+	}
+	if granule != 10*960-100 {
+		t.Errorf("final granule = %d, want the end trim kept", granule)
+	}
+}
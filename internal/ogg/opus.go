@@ -0,0 +1,88 @@
+package ogg
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+)
+
+// OpusSampleRate is the rate Opus granule positions are counted in
+const OpusSampleRate = 48000
+
+// PacketSamples returns the number of 48 kHz samples an Opus packet
+// decodes to, read from its TOC byte (RFC 6716 section 3.1)
+func PacketSamples(packet []byte) (int, error) {
+	if len(packet) == 0 {
+		return 0, fmt.Errorf("empty Opus packet")
+	}
+
+	toc := packet[0]
+	config := toc >> 3
+
+	// Frame duration in units of 2.5ms
+	var units int
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60ms
+		units = []int{4, 8, 16, 24}[config&3]
+	case config < 16: // hybrid: 10, 20ms
+		units = []int{4, 8}[config&1]
+	default: // CELT: 2.5, 5, 10, 20ms
+		units = []int{1, 2, 4, 8}[config&3]
+	}
+
+	var frames int
+	switch toc & 3 {
+	case 0:
+		frames = 1
+	case 1, 2:
+		frames = 2
+	default:
+		if len(packet) < 2 {
+			return 0, fmt.Errorf("truncated Opus packet")
+		}
+		frames = int(packet[1] & 0x3F)
+	}
+
+	return frames * units * 120, nil
+}
+
+// PreSkip returns the number of samples to discard at the start of the
+// stream, from an OpusHead header
+func PreSkip(head []byte) (int, error) {
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return 0, fmt.Errorf("invalid OpusHead header")
+	}
+	return int(binary.LittleEndian.Uint16(head[10:12])), nil
+}
+
+// OpusTags builds the comment header of an Ogg Opus stream. Comments are
+// "NAME=value" pairs using Vorbis comment field names.
+func OpusTags(vendor string, comments []string) []byte {
+	out := []byte("OpusTags")
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(vendor)))
+	out = append(out, vendor...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(comments)))
+	for _, c := range comments {
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c)))
+		out = append(out, c...)
+	}
+	return out
+}
+
+// PictureComment returns a METADATA_BLOCK_PICTURE comment embedding image
+// as front cover art, the form Vorbis comment readers expect cover art in
+func PictureComment(mimeType string, image []byte, width, height int) string {
+	var block []byte
+	block = binary.BigEndian.AppendUint32(block, 3) // front cover
+	block = binary.BigEndian.AppendUint32(block, uint32(len(mimeType)))
+	block = append(block, mimeType...)
+	block = binary.BigEndian.AppendUint32(block, 0) // description
+	block = binary.BigEndian.AppendUint32(block, uint32(width))
+	block = binary.BigEndian.AppendUint32(block, uint32(height))
+	block = binary.BigEndian.AppendUint32(block, 24) // colour depth
+	block = binary.BigEndian.AppendUint32(block, 0)  // palette size
+	block = binary.BigEndian.AppendUint32(block, uint32(len(image)))
+	block = append(block, image...)
+
+	return "METADATA_BLOCK_PICTURE=" + base64.StdEncoding.EncodeToString(block)
+}
@@ -0,0 +1,119 @@
+package ogg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Reader unpacks the packets of a single logical bitstream
+type Reader struct {
+	r      *bufio.Reader
+	serial uint32
+	pages  int
+
+	// the page being read
+	segments []byte
+	data     []byte
+	seg      int
+	pos      int
+	last     int // index of the last segment ending a packet
+	granule  int64
+}
+
+// NewReader returns a Reader for the stream in r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// ReadPacket returns the next packet. The granule position is that of the
+// page the packet ends, or -1 if another packet ends after it on the same
+// page. It returns io.EOF at the end of the stream.
+func (r *Reader) ReadPacket() ([]byte, int64, error) {
+	var packet []byte
+	for {
+		if r.seg == len(r.segments) {
+			if err := r.readPage(); err != nil {
+				if err == io.EOF && packet != nil {
+					return nil, 0, io.ErrUnexpectedEOF
+				}
+				return nil, 0, err
+			}
+			continue
+		}
+
+		n := int(r.segments[r.seg])
+		packet = append(packet, r.data[r.pos:r.pos+n]...)
+		r.pos += n
+		r.seg++
+		if n < 255 {
+			granule := int64(-1)
+			if r.seg-1 == r.last {
+				granule = r.granule
+			}
+			return packet, granule, nil
+		}
+	}
+}
+
+func (r *Reader) readPage() error {
+	header := make([]byte, 27)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return err
+	}
+	if string(header[:4]) != "OggS" {
+		return fmt.Errorf("invalid Ogg page header")
+	}
+
+	serial := binary.LittleEndian.Uint32(header[14:])
+	if r.pages > 0 && serial != r.serial {
+		return fmt.Errorf("multiplexed Ogg streams are not supported")
+	}
+	r.serial = serial
+	r.pages++
+
+	segments := make([]byte, header[26])
+	if _, err := io.ReadFull(r.r, segments); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	var size int
+	for _, s := range segments {
+		size += int(s)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+
+	want := binary.LittleEndian.Uint32(header[22:])
+	binary.LittleEndian.PutUint32(header[22:], 0)
+	if crc(append(append(header, segments...), data...)) != want {
+		return fmt.Errorf("Ogg page checksum mismatch")
+	}
+
+	r.segments, r.data = segments, data
+	r.seg, r.pos = 0, 0
+	r.granule = int64(binary.LittleEndian.Uint64(header[6:]))
+	r.last = -1
+	for i, s := range segments {
+		if s < 255 {
+			r.last = i
+		}
+	}
+	return nil
+}
+
+// IsOgg reports whether the file starts with an Ogg page
+func IsOgg(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(f, magic)
+	return err == nil && string(magic) == "OggS"
+}
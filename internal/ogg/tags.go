@@ -0,0 +1,127 @@
+package ogg
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ParseOpusTags reads the vendor string and comments of an OpusTags header
+func ParseOpusTags(packet []byte) (string, []string, error) {
+	if len(packet) < 8 || string(packet[:8]) != "OpusTags" {
+		return "", nil, fmt.Errorf("not an OpusTags header")
+	}
+	data := packet[8:]
+	next := func() (string, error) {
+		if len(data) < 4 {
+			return "", io.ErrUnexpectedEOF
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint64(n) > uint64(len(data)-4) {
+			return "", io.ErrUnexpectedEOF
+		}
+		s := string(data[4 : 4+n])
+		data = data[4+n:]
+		return s, nil
+	}
+
+	vendor, err := next()
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid OpusTags header: %w", err)
+	}
+	if len(data) < 4 {
+		return "", nil, fmt.Errorf("invalid OpusTags header: %w", io.ErrUnexpectedEOF)
+	}
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	var comments []string
+	for i := uint32(0); i < count; i++ {
+		c, err := next()
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid OpusTags header: %w", err)
+		}
+		comments = append(comments, c)
+	}
+	return vendor, comments, nil
+}
+
+// RewriteOpusTags copies an Ogg Opus file to dst with the comments that
+// edit returns for its current ones. The audio packets are copied
+// unchanged, and the stream keeps its end trim.
+func RewriteOpusTags(src, dst string, edit func(comments []string) []string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := NewReader(in)
+	head, _, err := r.ReadPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read Opus header: %w", err)
+	}
+	if _, err := PreSkip(head); err != nil {
+		return err
+	}
+	tags, _, err := r.ReadPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read Opus tags: %w", err)
+	}
+	vendor, comments, err := ParseOpusTags(tags)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(out)
+	err = func() error {
+		w := NewWriter(bw, r.serial)
+		for _, header := range [][]byte{head, OpusTags(vendor, edit(comments))} {
+			if err := w.WritePacket(header, 0); err != nil {
+				return err
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+
+		var granule, final int64
+		for {
+			packet, g, err := r.ReadPacket()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			n, err := PacketSamples(packet)
+			if err != nil {
+				return err
+			}
+			granule += int64(n)
+			if g >= 0 {
+				final = g
+			}
+			if err := w.WritePacket(packet, granule); err != nil {
+				return err
+			}
+		}
+		w.SetGranule(final)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}()
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
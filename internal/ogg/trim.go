@@ -0,0 +1,145 @@
+package ogg
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Range is a span of media time. An End of zero, or past the end of the
+// media, keeps everything from Start on.
+type Range struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// opusPacket is an audio packet with its start in samples after pre-skip
+type opusPacket struct {
+	data  []byte
+	start int64
+	size  int64
+}
+
+// TrimOpus writes the given ranges of an Ogg Opus file, joined in order, to
+// dst. Every Opus packet can be decoded on its own, so cuts are made at the
+// packet nearest each range start; the headers are copied unchanged.
+func TrimOpus(src, dst string, ranges []Range) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no ranges to keep")
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	r := NewReader(in)
+	head, _, err := r.ReadPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read Opus header: %w", err)
+	}
+	preSkip, err := PreSkip(head)
+	if err != nil {
+		return err
+	}
+	tags, _, err := r.ReadPacket()
+	if err != nil {
+		return fmt.Errorf("failed to read Opus tags: %w", err)
+	}
+
+	var packets []opusPacket
+	var samples, final int64
+	for {
+		data, granule, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		n, err := PacketSamples(data)
+		if err != nil {
+			return err
+		}
+		packets = append(packets, opusPacket{data: data, start: samples - int64(preSkip), size: int64(n)})
+		samples += int64(n)
+		if granule >= 0 {
+			final = granule
+		}
+	}
+	if len(packets) == 0 {
+		return fmt.Errorf("no audio found")
+	}
+	// Samples the last page drops from the end of the stream
+	padding := max(samples-final, 0)
+	total := toDuration(final - int64(preSkip))
+
+	var kept []opusPacket
+	for _, rg := range ranges {
+		if rg.Start < 0 || (rg.End > 0 && rg.End <= rg.Start) {
+			return fmt.Errorf("invalid range %s-%s", rg.Start, rg.End)
+		}
+		if rg.Start >= total {
+			return fmt.Errorf("range starts at %s, after the end of the media", rg.Start)
+		}
+		for _, p := range packets {
+			at := toDuration(p.start)
+			if at+toDuration(p.size)/2 > rg.Start && (rg.End <= 0 || at+toDuration(p.size)/2 <= rg.End) {
+				kept = append(kept, p)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return fmt.Errorf("the ranges contain no media")
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(out)
+	err = func() error {
+		w := NewWriter(bw, r.serial)
+		if err := w.WritePacket(head, 0); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		if err := w.WritePacket(tags, 0); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		var granule int64
+		for _, p := range kept {
+			granule += p.size
+			if err := w.WritePacket(p.data, granule); err != nil {
+				return err
+			}
+		}
+		if last := kept[len(kept)-1]; last.start == packets[len(packets)-1].start {
+			w.SetGranule(granule - padding)
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		return bw.Flush()
+	}()
+	if err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+func toDuration(samples int64) time.Duration {
+	return time.Duration(samples) * time.Second / OpusSampleRate
+}
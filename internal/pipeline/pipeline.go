@@ -0,0 +1,637 @@
+// Package pipeline downloads a video and everything that goes with it:
+// subtitles, thumbnails, post-processing and hooks. The command line, the
+// download queue and the server all download through it.
+package pipeline
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/archive"
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/hooks"
+	"github.com/MaVeN-13TTN/red_goose/internal/logging"
+	"github.com/MaVeN-13TTN/red_goose/internal/nfo"
+	"github.com/MaVeN-13TTN/red_goose/internal/postprocess"
+	"github.com/MaVeN-13TTN/red_goose/internal/sponsorblock"
+	"github.com/MaVeN-13TTN/red_goose/internal/subtitles"
+	"github.com/MaVeN-13TTN/red_goose/internal/thumbnails"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
+)
+
+// Options are the settings a video is downloaded with. They are saved with
+// queued downloads, so that a resumed download uses the same settings.
+type Options struct {
+	OutputDir      string `json:"output_dir"`
+	Quality        string `json:"quality"`
+	AudioOnly      bool   `json:"audio_only,omitempty"`
+	NamingPattern  string `json:"naming_pattern,omitempty"`
+	ChapterPattern string `json:"chapter_pattern,omitempty"`
+
+	WriteSubs     bool     `json:"write_subs,omitempty"`
+	WriteAutoSubs bool     `json:"write_auto_subs,omitempty"`
+	SubLangs      []string `json:"sub_langs,omitempty"`
+	SubFormat     string   `json:"sub_format,omitempty"`
+
+	WriteThumbnail     bool   `json:"write_thumbnail,omitempty"`
+	WriteAllThumbnails bool   `json:"write_all_thumbnails,omitempty"`
+	ConvertThumbnails  string `json:"convert_thumbnails,omitempty"`
+
+	WriteInfoJSON    bool `json:"write_info_json,omitempty"`
+	WriteDescription bool `json:"write_description,omitempty"`
+	WriteNFO         bool `json:"write_nfo,omitempty"`
+	// NFOSeason is the season number of playlist videos in NFO files
+	NFOSeason int `json:"nfo_season,omitempty"`
+
+	EmbedMetadata bool `json:"embed_metadata,omitempty"`
+	EmbedChapters bool `json:"embed_chapters,omitempty"`
+	EmbedSubs     bool `json:"embed_subs,omitempty"`
+	SplitChapters bool `json:"split_chapters,omitempty"`
+
+	SponsorBlockMark   []string `json:"sponsorblock_mark,omitempty"`
+	SponsorBlockRemove []string `json:"sponsorblock_remove,omitempty"`
+	SponsorBlockAPI    string   `json:"sponsorblock_api,omitempty"`
+
+	Sections []downloader.Section `json:"sections,omitempty"`
+	Hooks    []hooks.Hook         `json:"hooks,omitempty"`
+
+	// DownloadArchive is a file of downloaded video IDs. Videos in it are
+	// skipped and finished videos are added to it.
+	DownloadArchive string `json:"download_archive,omitempty"`
+
+	// ShowProgress prints the video details and a progress bar
+	ShowProgress bool `json:"-"`
+}
+
+// Validate checks the options before anything is downloaded
+func (o *Options) Validate() error {
+	if err := o.validate(); err != nil {
+		return err
+	}
+	_, err := hooks.NewRunner(o.Hooks)
+	return err
+}
+
+// validate checks the options other than the hooks, which New checks by
+// building their runner
+func (o *Options) validate() error {
+	if o.WriteSubs || o.WriteAutoSubs || o.EmbedSubs {
+		if _, err := subtitles.ParseFormat(o.SubFormat); err != nil {
+			return err
+		}
+	}
+	switch o.ConvertThumbnails {
+	case "", "jpg":
+	default:
+		return errors.NewValidationError(fmt.Sprintf("unsupported thumbnail format %q (use jpg)", o.ConvertThumbnails), nil)
+	}
+	if _, err := sponsorblock.ParseCategories(o.SponsorBlockMark); err != nil {
+		return err
+	}
+	if _, err := sponsorblock.ParseCategories(o.SponsorBlockRemove); err != nil {
+		return err
+	}
+	if o.NFOSeason < 0 {
+		return errors.NewValidationError("the NFO season number cannot be negative", nil)
+	}
+	if len(o.Sections) > 0 && (len(o.SponsorBlockMark) > 0 || len(o.SponsorBlockRemove) > 0) {
+		return errors.NewValidationError("--download-sections cannot be combined with SponsorBlock options", nil)
+	}
+	return nil
+}
+
+// Item is a video to download
+type Item struct {
+	VideoID string `json:"video_id"`
+	// Playlist is set for videos downloaded as part of a playlist, which
+	// are saved to a folder named after it
+	Playlist *PlaylistRef `json:"playlist,omitempty"`
+}
+
+// PlaylistRef places a video in a playlist
+type PlaylistRef struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	Index int    `json:"index"`
+	Total int    `json:"total"`
+}
+
+// Dir returns the folder the playlist's videos are saved to
+func (p *PlaylistRef) Dir(outputDir string) string {
+	return filepath.Join(outputDir, downloader.SanitizeFilename(p.Title))
+}
+
+// Stage is a step of a download, reported as it starts
+type Stage string
+
+const (
+	StageResolving      Stage = "resolving"
+	StageDownloading    Stage = "downloading"
+	StagePostprocessing Stage = "postprocessing"
+)
+
+// Result describes a finished download
+type Result struct {
+	Path    string
+	Details *extractor.VideoDetails
+	// Parts are extra files written by post-processing, such as chapters
+	Parts []string
+	// Skipped is set when the video was already in the download archive
+	Skipped bool
+}
+
+// Extractor looks up videos. *extractor.Extractor implements it.
+type Extractor interface {
+	GetVideoDetails(videoID string) (*extractor.VideoDetails, error)
+	SelectFormat(formats []extractor.FormatInfo, quality string, audioOnly bool) (*extractor.FormatInfo, error)
+}
+
+// Pipeline downloads videos with a fixed set of options
+type Pipeline struct {
+	opts       Options
+	hooks      *hooks.Runner
+	stdout     io.Writer
+	stderr     io.Writer
+	Extractor  Extractor
+	Downloader *downloader.Downloader
+	// Progress, if set, receives the progress of whole-file downloads
+	Progress downloader.ProgressCallback
+}
+
+// New validates the options and returns a pipeline using them
+func New(opts Options) (*Pipeline, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	runner, err := hooks.NewRunner(opts.Hooks)
+	if err != nil {
+		return nil, err
+	}
+	return &Pipeline{
+		opts:       opts,
+		hooks:      runner,
+		stdout:     os.Stdout,
+		stderr:     os.Stderr,
+		Extractor:  extractor.New(),
+		Downloader: downloader.New(),
+	}, nil
+}
+
+// SetOutput sends the messages of the pipeline and its hooks to stdout and
+// stderr instead of the process's own
+func (p *Pipeline) SetOutput(stdout, stderr io.Writer) {
+	p.stdout, p.stderr = stdout, stderr
+	p.hooks.Stdout, p.hooks.Stderr = stdout, stderr
+}
+
+// Run downloads a video and runs everything that follows it. onStage, if
+// not nil, is called as each stage starts. Typed errors carry the video's
+// ID in their context.
+func (p *Pipeline) Run(ctx context.Context, item Item, onStage func(Stage)) (*Result, error) {
+	result, err := p.run(ctx, item, onStage)
+	var rge *errors.RedGooseError
+	if stderrors.As(err, &rge) {
+		if _, ok := rge.Context[errors.ContextVideoID]; !ok {
+			rge.WithContext(errors.ContextVideoID, item.VideoID)
+		}
+	}
+	return result, err
+}
+
+func (p *Pipeline) run(ctx context.Context, item Item, onStage func(Stage)) (*Result, error) {
+	ctx = logging.With(ctx, "video_id", item.VideoID)
+	if item.Playlist != nil {
+		ctx = logging.With(ctx, "playlist_index", item.Playlist.Index)
+	}
+	log := logging.FromContext(ctx)
+	ext := p.Extractor
+	if e, ok := ext.(*extractor.Extractor); ok {
+		ext = e.WithLogger(log)
+	}
+
+	stage := func(s Stage) {
+		log.Debug("stage started", "stage", string(s))
+		if onStage != nil {
+			onStage(s)
+		}
+	}
+
+	var downloaded *archive.Archive
+	if p.opts.DownloadArchive != "" {
+		var err error
+		if downloaded, err = archive.Open(p.opts.DownloadArchive); err != nil {
+			return nil, err
+		}
+		if downloaded.Has(item.VideoID) {
+			log.Info("skipping archived video")
+			fmt.Fprintf(p.stdout, "Skipping %s: already in the download archive\n", item.VideoID)
+			return &Result{Skipped: true}, nil
+		}
+	}
+
+	stage(StageResolving)
+	details, err := ext.GetVideoDetails(item.VideoID)
+	if err != nil {
+		return nil, errors.NewExtractionError(fmt.Sprintf("failed to extract video info for %s", item.VideoID), err)
+	}
+
+	if p.opts.ShowProgress {
+		fmt.Fprintf(p.stdout, "Title: %s\n", details.Title)
+		fmt.Fprintf(p.stdout, "Author: %s\n", details.Author)
+		fmt.Fprintf(p.stdout, "Duration: %s\n", details.Duration)
+		fmt.Fprintf(p.stdout, "Available formats: %d\n", len(details.Formats))
+	}
+
+	dir := p.opts.OutputDir
+	if item.Playlist != nil {
+		dir = item.Playlist.Dir(p.opts.OutputDir)
+	}
+
+	var filename string
+	var download func() error
+	sections := p.opts.Sections
+	if len(sections) > 0 && details.HLSManifestURL != "" && (details.IsLive || len(details.Formats) == 0) {
+		// Streams without progressive formats are cut from their segments
+		filename = p.filename(item, details, nil)
+		download = func() error {
+			_, err := downloader.NewLiveRecorder().RecordSections(ctx, downloader.LiveOptions{
+				ManifestURL:  details.HLSManifestURL,
+				Quality:      p.opts.Quality,
+				OutputDir:    dir,
+				Filename:     filename,
+				ShowProgress: p.opts.ShowProgress,
+			}, sections)
+			return err
+		}
+	} else {
+		format, err := ext.SelectFormat(details.Formats, p.opts.Quality, p.opts.AudioOnly)
+		if err != nil {
+			return nil, errors.NewExtractionError(fmt.Sprintf("failed to select format for %s", item.VideoID), err)
+		}
+		log.Debug("selected format", "quality", format.Quality, "mime_type", format.MimeType, "size", format.Filesize)
+		if p.opts.ShowProgress {
+			fmt.Fprintf(p.stdout, "Selected quality: %s\n", format.Quality)
+			fmt.Fprintf(p.stdout, "File size: %d bytes\n", format.Filesize)
+		}
+
+		filename = p.filename(item, details, format)
+		opts := downloader.DownloadOptions{
+			URL:          format.URL,
+			OutputDir:    dir,
+			Filename:     filename,
+			ShowProgress: p.opts.ShowProgress,
+			Output:       p.stdout,
+		}
+		download = func() error {
+			if len(sections) > 0 {
+				return p.Downloader.DownloadSections(ctx, opts, sections)
+			}
+			if p.Progress != nil {
+				return p.Downloader.DownloadWithProgress(ctx, opts, p.Progress)
+			}
+			return p.Downloader.Download(ctx, opts)
+		}
+	}
+
+	file := &postprocess.File{
+		Path:    filepath.Join(dir, filename),
+		Details: details,
+		Output:  p.stdout,
+	}
+	if item.Playlist != nil {
+		file.Album = item.Playlist.Title
+		file.Track = item.Playlist.Index
+		file.TrackTotal = item.Playlist.Total
+	}
+
+	if err := p.hooks.Run(ctx, hooks.StageBeforeDownload, p.vars(item, file)); err != nil {
+		return nil, err
+	}
+	stage(StageDownloading)
+	if err := download(); err != nil {
+		return nil, err
+	}
+	if err := p.hooks.Run(ctx, hooks.StageAfterDownload, p.vars(item, file)); err != nil {
+		return nil, err
+	}
+
+	stage(StagePostprocessing)
+	subs, err := p.downloadSubtitles(ctx, details, dir, filename)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.downloadThumbnails(ctx, details, dir, filename); err != nil {
+		return nil, err
+	}
+
+	if len(sections) > 0 {
+		file.Details, subs = clipToSections(details, subs, sections)
+	}
+	file.Subtitles = subs
+
+	if err := p.postProcess(ctx, file); err != nil {
+		return nil, err
+	}
+	if err := p.writeSidecars(item, file); err != nil {
+		return nil, err
+	}
+	if err := p.hooks.Run(ctx, hooks.StageAfterPostprocess, p.vars(item, file)); err != nil {
+		return nil, err
+	}
+	if downloaded != nil {
+		if err := downloaded.Add(item.VideoID); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info("video downloaded", "path", file.Path)
+	return &Result{Path: file.Path, Details: file.Details, Parts: file.Parts}, nil
+}
+
+// filename builds the output filename of a video: numbered within a
+// playlist, otherwise from the naming pattern. Without a format the video
+// is a stream saved as MPEG-TS.
+func (p *Pipeline) filename(item Item, details *extractor.VideoDetails, format *extractor.FormatInfo) string {
+	ext, quality := ".ts", ""
+	if format != nil {
+		ext, quality = downloader.GetFileExtension(format.MimeType), format.Quality
+	}
+
+	if item.Playlist != nil {
+		return fmt.Sprintf("%03d - %s%s", item.Playlist.Index, downloader.SanitizeFilename(details.Title), ext)
+	}
+
+	pattern := p.opts.NamingPattern
+	if pattern == "" {
+		pattern = "{title}"
+	}
+	fields := map[string]string{
+		"title":   details.Title,
+		"author":  details.Author,
+		"id":      details.ID,
+		"quality": quality,
+	}
+	return downloader.SanitizeFilename(downloader.ExpandNamingPattern(pattern, fields)) + ext
+}
+
+// vars returns the hook fields of a video, with the playlist fields of
+// playlist videos
+func (p *Pipeline) vars(item Item, file *postprocess.File) hooks.Vars {
+	vars := hooks.VideoVars(file.Details, file.Path)
+	if item.Playlist != nil {
+		vars["playlist_id"] = item.Playlist.ID
+		vars["playlist_title"] = item.Playlist.Title
+		vars["playlist_index"] = strconv.Itoa(item.Playlist.Index)
+	}
+	return vars
+}
+
+// processors returns the enabled post-processors
+func (p *Pipeline) processors() []postprocess.Processor {
+	var processors []postprocess.Processor
+
+	// Segments are marked before any are removed, so that removal moves
+	// the marked chapters too, and both happen before chapters are embedded
+	mark, _ := sponsorblock.ParseCategories(p.opts.SponsorBlockMark)
+	remove, _ := sponsorblock.ParseCategories(p.opts.SponsorBlockRemove)
+	if len(mark) > 0 || len(remove) > 0 {
+		provider := sponsorblock.NewClient(p.opts.SponsorBlockAPI)
+		if len(mark) > 0 {
+			processors = append(processors, &postprocess.MarkSegments{Provider: provider, Categories: mark})
+		}
+		if len(remove) > 0 {
+			processors = append(processors, &postprocess.RemoveSegments{Provider: provider, Categories: remove})
+		}
+	}
+
+	if p.opts.EmbedMetadata || p.opts.EmbedChapters || p.opts.EmbedSubs || len(mark) > 0 {
+		processors = append(processors, &postprocess.EmbedMetadata{
+			Metadata:  p.opts.EmbedMetadata,
+			Chapters:  p.opts.EmbedChapters || len(mark) > 0,
+			Subtitles: p.opts.EmbedSubs,
+		})
+	}
+	if p.opts.AudioOnly {
+		processors = append(processors, &postprocess.TagAudio{Cover: true})
+	}
+	// Splitting runs last so the parts are cut from the finished file
+	if p.opts.SplitChapters {
+		processors = append(processors, &postprocess.SplitChapters{Pattern: p.opts.ChapterPattern})
+	}
+	return processors
+}
+
+// postProcess runs the enabled processors on a downloaded file
+func (p *Pipeline) postProcess(ctx context.Context, file *postprocess.File) error {
+	processors := p.processors()
+	if len(processors) == 0 {
+		return nil
+	}
+	if err := postprocess.Run(ctx, file, processors...); err != nil {
+		return fmt.Errorf("post-processing %s failed: %w", file.Path, err)
+	}
+	return nil
+}
+
+// downloadSubtitles fetches the requested subtitle tracks, saving them next
+// to the media file when writing subtitles is enabled, and returns them for
+// embedding. A missing or failed track is reported but does not fail the
+// video.
+func (p *Pipeline) downloadSubtitles(ctx context.Context, details *extractor.VideoDetails, dir, filename string) ([]*subtitles.Document, error) {
+	write := p.opts.WriteSubs || p.opts.WriteAutoSubs
+	if !write && !p.opts.EmbedSubs {
+		return nil, nil
+	}
+
+	format, err := subtitles.ParseFormat(p.opts.SubFormat)
+	if err != nil {
+		return nil, err
+	}
+
+	// Embedding alone uses uploaded tracks, like --write-subs
+	tracks := subtitles.SelectTracks(details.Captions, p.opts.SubLangs, p.opts.WriteSubs || !p.opts.WriteAutoSubs, p.opts.WriteAutoSubs)
+	if len(tracks) == 0 {
+		fmt.Fprintf(p.stdout, "No subtitles available for languages: %s\n", strings.Join(p.opts.SubLangs, ","))
+		return nil, nil
+	}
+
+	if write {
+		if err := utils.EnsureDir(dir); err != nil {
+			return nil, errors.NewFileSystemError("failed to create output directory", err)
+		}
+	}
+
+	var docs []*subtitles.Document
+	client := subtitles.NewClient()
+	for _, track := range tracks {
+		doc, err := client.Fetch(ctx, track)
+		if err != nil {
+			fmt.Fprintf(p.stderr, "Warning: failed to download %s subtitles: %v\n", track.LanguageCode, err)
+			continue
+		}
+		docs = append(docs, doc)
+
+		if !write {
+			continue
+		}
+		path := filepath.Join(dir, subtitles.Filename(filename, track.LanguageCode, format))
+		if err := subtitles.WriteFile(path, doc, format); err != nil {
+			fmt.Fprintf(p.stderr, "Warning: failed to save %s subtitles: %v\n", track.LanguageCode, err)
+			continue
+		}
+		fmt.Fprintf(p.stdout, "Subtitles saved: %s\n", path)
+	}
+
+	return docs, nil
+}
+
+// writeSidecars saves the video details, description and NFO files next to
+// the media file. A failed file is reported but does not fail the video.
+func (p *Pipeline) writeSidecars(item Item, file *postprocess.File) error {
+	if !p.opts.WriteInfoJSON && !p.opts.WriteDescription && !p.opts.WriteNFO {
+		return nil
+	}
+	dir, name := filepath.Split(file.Path)
+	if err := utils.EnsureDir(dir); err != nil {
+		return errors.NewFileSystemError("failed to create output directory", err)
+	}
+
+	save := func(path string, write func() error) {
+		if err := write(); err != nil {
+			fmt.Fprintf(p.stderr, "Warning: %v\n", err)
+			return
+		}
+		fmt.Fprintf(p.stdout, "Saved %s\n", path)
+	}
+	if p.opts.WriteInfoJSON {
+		path := filepath.Join(dir, nfo.Filename(name, ".info.json"))
+		save(path, func() error { return nfo.WriteInfoJSON(path, file.Details) })
+	}
+	if p.opts.WriteDescription {
+		path := filepath.Join(dir, nfo.Filename(name, ".description"))
+		save(path, func() error { return nfo.WriteDescription(path, file.Details) })
+	}
+	if !p.opts.WriteNFO {
+		return nil
+	}
+
+	// A playlist is a season in the channel's folder; other videos are
+	// episodes of the channel in their own folder
+	showDir := dir
+	var pos *nfo.Position
+	if pl := item.Playlist; pl != nil {
+		season := p.opts.NFOSeason
+		if season == 0 {
+			season = 1
+		}
+		pos = &nfo.Position{Season: season, Episode: pl.Index}
+		showDir = p.opts.OutputDir
+		path := filepath.Join(dir, nfo.SeasonFilename)
+		save(path, func() error { return nfo.Write(path, nfo.NewSeason(pl.ID, pl.Title, season)) })
+	}
+	path := filepath.Join(dir, nfo.Filename(name, ".nfo"))
+	save(path, func() error { return nfo.Write(path, nfo.NewEpisode(file.Details, pos)) })
+	if err := nfo.WriteOnce(filepath.Join(showDir, nfo.ShowFilename), nfo.NewShow(file.Details)); err != nil {
+		fmt.Fprintf(p.stderr, "Warning: %v\n", err)
+	}
+	return nil
+}
+
+// downloadThumbnails saves the largest thumbnail, or all of them, next to
+// the media file. A failed download is reported but does not fail the video.
+func (p *Pipeline) downloadThumbnails(ctx context.Context, details *extractor.VideoDetails, dir, filename string) error {
+	if !p.opts.WriteThumbnail && !p.opts.WriteAllThumbnails {
+		return nil
+	}
+
+	client := thumbnails.NewClient()
+	var images []*thumbnails.Image
+	var err error
+	if p.opts.WriteAllThumbnails {
+		images, err = client.All(ctx, details.Thumbnails)
+	} else {
+		var img *thumbnails.Image
+		if img, err = client.Best(ctx, details.Thumbnails); err == nil {
+			images = []*thumbnails.Image{img}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(p.stderr, "Warning: failed to download thumbnail: %v\n", err)
+		return nil
+	}
+
+	if err := utils.EnsureDir(dir); err != nil {
+		return errors.NewFileSystemError("failed to create output directory", err)
+	}
+
+	for _, img := range images {
+		if p.opts.ConvertThumbnails == "jpg" {
+			converted, err := thumbnails.ToJPEG(img)
+			if err != nil {
+				fmt.Fprintf(p.stderr, "Warning: failed to convert thumbnail: %v\n", err)
+				continue
+			}
+			img = converted
+		}
+
+		id := ""
+		if p.opts.WriteAllThumbnails {
+			id = thumbnails.ID(img.URL)
+		}
+		path := filepath.Join(dir, thumbnails.Filename(filename, id, img.Ext()))
+		if err := thumbnails.Save(path, img); err != nil {
+			fmt.Fprintf(p.stderr, "Warning: %v\n", err)
+			continue
+		}
+		fmt.Fprintf(p.stdout, "Thumbnail saved: %s (%dx%d)\n", path, img.Width, img.Height)
+	}
+
+	return nil
+}
+
+// clipToSections moves the chapters and subtitles of a video to their times
+// in a download of only the given sections, dropping those outside them
+func clipToSections(details *extractor.VideoDetails, subs []*subtitles.Document, sections []downloader.Section) (*extractor.VideoDetails, []*subtitles.Document) {
+	duration := time.Duration(details.DurationSeconds) * time.Second
+
+	clipped := *details
+	clipped.Chapters = nil
+	for i, c := range details.Chapters {
+		end := c.End
+		if end == 0 {
+			end = duration
+			if i+1 < len(details.Chapters) {
+				end = details.Chapters[i+1].Start
+			}
+		}
+		for _, part := range downloader.ClipSpan(sections, duration, c.Start, end) {
+			clipped.Chapters = append(clipped.Chapters, extractor.Chapter{Title: c.Title, Start: part[0], End: part[1]})
+		}
+	}
+	if duration > 0 {
+		d := downloader.ClippedDuration(sections, duration)
+		clipped.Duration = d.String()
+		clipped.DurationSeconds = int64(d.Seconds())
+	}
+
+	var clippedSubs []*subtitles.Document
+	for _, doc := range subs {
+		out := &subtitles.Document{Language: doc.Language}
+		for _, cue := range doc.Cues {
+			for _, part := range downloader.ClipSpan(sections, duration, cue.Start, cue.End) {
+				out.Cues = append(out.Cues, subtitles.Cue{Start: part[0], End: part[1], Text: cue.Text})
+			}
+		}
+		clippedSubs = append(clippedSubs, out)
+	}
+	return &clipped, clippedSubs
+}
@@ -0,0 +1,231 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/hooks"
+)
+
+// fakeExtractor serves fixed details for each video ID
+type fakeExtractor struct {
+	videos map[string]*extractor.VideoDetails
+}
+
+func (f *fakeExtractor) GetVideoDetails(videoID string) (*extractor.VideoDetails, error) {
+	details, ok := f.videos[videoID]
+	if !ok {
+		return nil, fmt.Errorf("video %s not found", videoID)
+	}
+	return details, nil
+}
+
+func (f *fakeExtractor) SelectFormat(formats []extractor.FormatInfo, quality string, audioOnly bool) (*extractor.FormatInfo, error) {
+	if len(formats) == 0 {
+		return nil, fmt.Errorf("no formats")
+	}
+	return &formats[0], nil
+}
+
+func newTestPipeline(t *testing.T, opts Options, serverURL string) *Pipeline {
+	t.Helper()
+	p, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	p.Extractor = &fakeExtractor{videos: map[string]*extractor.VideoDetails{
+		"abc": {
+			ID:      "abc",
+			Title:   "First: Video",
+			Author:  "Someone",
+			Formats: []extractor.FormatInfo{{Quality: "720p", MimeType: "video/mp4", URL: serverURL + "/abc"}},
+		},
+	}}
+	return p
+}
+
+func TestRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media of " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		pattern string
+		item    Item
+		want    string
+	}{
+		{
+			name:    "single video",
+			pattern: "{author} - {title} [{quality}]",
+			item:    Item{VideoID: "abc"},
+			want:    "Someone - First_ Video [720p].mp4",
+		},
+		{
+			name: "playlist video",
+			item: Item{VideoID: "abc", Playlist: &PlaylistRef{ID: "PL1", Title: "My/List", Index: 7, Total: 9}},
+			want: filepath.Join("My_List", "007 - First_ Video.mp4"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			p := newTestPipeline(t, Options{OutputDir: dir, Quality: "best", NamingPattern: tt.pattern}, server.URL)
+
+			var stages []Stage
+			result, err := p.Run(context.Background(), tt.item, func(s Stage) { stages = append(stages, s) })
+			if err != nil {
+				t.Fatalf("Run: %v", err)
+			}
+
+			if want := filepath.Join(dir, tt.want); result.Path != want {
+				t.Errorf("path = %q, want %q", result.Path, want)
+			}
+			data, err := os.ReadFile(result.Path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "media of /abc" {
+				t.Errorf("file = %q", data)
+			}
+			if want := []Stage{StageResolving, StageDownloading, StagePostprocessing}; !reflect.DeepEqual(stages, want) {
+				t.Errorf("stages = %v, want %v", stages, want)
+			}
+		})
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks run through sh")
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "hook.txt")
+	p := newTestPipeline(t, Options{
+		OutputDir: dir,
+		Hooks: []hooks.Hook{
+			{Stage: hooks.StageAfterPostprocess, Command: "printf '%s|%s' {playlist_index} {filename} > " + out},
+		},
+	}, server.URL)
+
+	item := Item{VideoID: "abc", Playlist: &PlaylistRef{ID: "PL1", Title: "List", Index: 2, Total: 3}}
+	if _, err := p.Run(context.Background(), item, nil); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2|002 - First_ Video.mp4"; string(data) != want {
+		t.Errorf("hook wrote %q, want %q", data, want)
+	}
+}
+
+func TestRunSidecars(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("media"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	p := newTestPipeline(t, Options{OutputDir: dir, WriteInfoJSON: true, WriteDescription: true, WriteNFO: true, NFOSeason: 2}, server.URL)
+	p.SetOutput(io.Discard, io.Discard)
+
+	item := Item{VideoID: "abc", Playlist: &PlaylistRef{ID: "PL1", Title: "List", Index: 4, Total: 5}}
+	if _, err := p.Run(context.Background(), item, nil); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	for _, name := range []string{
+		filepath.Join("List", "004 - First_ Video.info.json"),
+		filepath.Join("List", "004 - First_ Video.description"),
+		filepath.Join("List", "004 - First_ Video.nfo"),
+		filepath.Join("List", "season.nfo"),
+		"tvshow.nfo",
+	} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("missing %s", name)
+		}
+	}
+	episode, _ := os.ReadFile(filepath.Join(dir, "List", "004 - First_ Video.nfo"))
+	if !strings.Contains(string(episode), "<season>2</season>") || !strings.Contains(string(episode), "<episode>4</episode>") {
+		t.Errorf("episode NFO =\n%s", episode)
+	}
+}
+
+func TestRunDownloadArchive(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte("media"))
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	p := newTestPipeline(t, Options{OutputDir: dir, DownloadArchive: filepath.Join(dir, "archive.txt")}, server.URL)
+	p.SetOutput(io.Discard, io.Discard)
+
+	first, err := p.Run(context.Background(), Item{VideoID: "abc"}, nil)
+	if err != nil || first.Skipped {
+		t.Fatalf("first Run = %+v, %v", first, err)
+	}
+	second, err := p.Run(context.Background(), Item{VideoID: "abc"}, nil)
+	if err != nil || !second.Skipped {
+		t.Fatalf("second Run = %+v, %v; want skipped", second, err)
+	}
+	if requests != 1 {
+		t.Errorf("downloaded %d times, want once", requests)
+	}
+}
+
+func TestRunUnknownVideo(t *testing.T) {
+	p := newTestPipeline(t, Options{OutputDir: t.TempDir()}, "http://127.0.0.1:0")
+
+	var stages []Stage
+	if _, err := p.Run(context.Background(), Item{VideoID: "missing"}, func(s Stage) { stages = append(stages, s) }); err == nil {
+		t.Error("expected an error for an unknown video")
+	}
+	if want := []Stage{StageResolving}; !reflect.DeepEqual(stages, want) {
+		t.Errorf("stages = %v, want %v", stages, want)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{"defaults", Options{}, false},
+		{"bad subtitle format", Options{WriteSubs: true, SubFormat: "doc"}, true},
+		{"bad thumbnail format", Options{ConvertThumbnails: "gif"}, true},
+		{"bad category", Options{SponsorBlockRemove: []string{"nope"}}, true},
+		{"bad hook", Options{Hooks: []hooks.Hook{{Stage: "never", Command: "true"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
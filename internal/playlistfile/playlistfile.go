@@ -0,0 +1,185 @@
+// Package playlistfile writes playlist files, extended M3U and XSPF, that
+// list the downloaded videos of a playlist in order so media players can
+// play the folder as a playlist.
+package playlistfile
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// Formats of playlist files
+const (
+	FormatM3U8 = "m3u8"
+	FormatXSPF = "xspf"
+)
+
+// ParseFormats checks a list of playlist file formats. "none" writes none.
+func ParseFormats(formats []string) ([]string, error) {
+	var parsed []string
+	for _, f := range formats {
+		switch f = strings.ToLower(strings.TrimSpace(f)); f {
+		case FormatM3U8, FormatXSPF:
+			parsed = append(parsed, f)
+		case "none", "":
+		default:
+			return nil, errors.NewValidationError(fmt.Sprintf("unknown playlist file format %q (use m3u8, xspf or none)", f), nil)
+		}
+	}
+	return parsed, nil
+}
+
+// Track is a video in a playlist file
+type Track struct {
+	Title string
+	// Path is relative to the playlist file
+	Path     string
+	Duration time.Duration
+}
+
+// Entry is a video of a playlist, at its 1-based index
+type Entry struct {
+	Index    int
+	Title    string
+	Duration time.Duration
+}
+
+// mediaExtensions are the files that can hold a downloaded video
+var mediaExtensions = map[string]bool{
+	".mp4": true, ".webm": true, ".mkv": true, ".ts": true,
+	".m4a": true, ".mka": true, ".opus": true, ".ogg": true, ".mp3": true,
+}
+
+// FindTracks looks up the files of a playlist's videos in dir, where they
+// are named after their index ("007 - Title.mp4"). Videos without a file,
+// such as failed downloads, are left out.
+func FindTracks(dir string, entries []Entry) ([]Track, error) {
+	names, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.NewFileSystemError("failed to read playlist directory", err)
+	}
+
+	var tracks []Track
+	for _, e := range entries {
+		prefix := fmt.Sprintf("%03d - ", e.Index)
+		for _, n := range names {
+			if n.IsDir() || !strings.HasPrefix(n.Name(), prefix) || !mediaExtensions[strings.ToLower(filepath.Ext(n.Name()))] {
+				continue
+			}
+			tracks = append(tracks, Track{Title: e.Title, Path: n.Name(), Duration: e.Duration})
+			break
+		}
+	}
+	return tracks, nil
+}
+
+// WriteM3U8 writes an extended M3U playlist
+func WriteM3U8(w io.Writer, title string, tracks []Track) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	if title != "" {
+		fmt.Fprintf(&b, "#PLAYLIST:%s\n", oneLine(title))
+	}
+	for _, t := range tracks {
+		seconds := -1
+		if t.Duration > 0 {
+			seconds = int(t.Duration.Round(time.Second) / time.Second)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%s\n%s\n", seconds, oneLine(t.Title), t.Path)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title,omitempty"`
+	// Duration is in milliseconds
+	Duration int64 `xml:"duration,omitempty"`
+}
+
+// WriteXSPF writes an XSPF playlist
+func WriteXSPF(w io.Writer, title string, tracks []Track) error {
+	playlist := xspfPlaylist{Version: "1", XMLNS: "http://xspf.org/ns/0/", Title: title}
+	for _, t := range tracks {
+		// Locations are URIs, relative to the playlist file
+		location := (&url.URL{Path: filepath.ToSlash(t.Path)}).EscapedPath()
+		playlist.Tracks = append(playlist.Tracks, xspfTrack{
+			Location: location,
+			Title:    t.Title,
+			Duration: t.Duration.Milliseconds(),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(playlist); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// Write writes the playlist files of the given formats to dir, named after
+// the playlist, replacing earlier ones. It returns their paths.
+func Write(dir, title string, tracks []Track, formats []string) ([]string, error) {
+	var paths []string
+	for _, format := range formats {
+		write := WriteM3U8
+		if format == FormatXSPF {
+			write = WriteXSPF
+		}
+		path := filepath.Join(dir, downloader.SanitizeFilename(title)+"."+format)
+		if err := writeFile(path, func(w io.Writer) error { return write(w, title, tracks) }); err != nil {
+			return paths, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+func writeFile(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.NewFileSystemError("failed to create playlist file", err)
+	}
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to write playlist file", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to write playlist file", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return errors.NewFileSystemError("failed to write playlist file", err)
+	}
+	return nil
+}
+
+// oneLine keeps a title from breaking the line-based M3U format
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,82 @@
+package playlistfile
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindTracks(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"001 - One.mp4", "001 - One.en.srt", "003 - Three.m4a", "003 - Three.jpg", "notes.txt"} {
+		os.WriteFile(filepath.Join(dir, name), nil, 0644)
+	}
+
+	tracks, err := FindTracks(dir, []Entry{
+		{Index: 1, Title: "One", Duration: 90 * time.Second},
+		{Index: 2, Title: "Two (failed)"},
+		{Index: 3, Title: "Three"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Track{
+		{Title: "One", Path: "001 - One.mp4", Duration: 90 * time.Second},
+		{Title: "Three", Path: "003 - Three.m4a"},
+	}
+	if !reflect.DeepEqual(tracks, want) {
+		t.Errorf("tracks = %+v, want %+v", tracks, want)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tracks := []Track{
+		{Title: "First\nvideo", Path: "001 - First video.mp4", Duration: 61500 * time.Millisecond},
+		{Title: "Rock & Roll", Path: "002 - Rock & Roll.webm"},
+	}
+
+	dir := t.TempDir()
+	paths, err := Write(dir, "My: List", tracks, []string{FormatM3U8, FormatXSPF})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 2 || filepath.Base(paths[0]) != "My_ List.m3u8" {
+		t.Fatalf("paths = %v", paths)
+	}
+
+	m3u, _ := os.ReadFile(paths[0])
+	wantM3U := "#EXTM3U\n#PLAYLIST:My: List\n" +
+		"#EXTINF:62,First video\n001 - First video.mp4\n" +
+		"#EXTINF:-1,Rock & Roll\n002 - Rock & Roll.webm\n"
+	if string(m3u) != wantM3U {
+		t.Errorf("m3u8 =\n%s\nwant\n%s", m3u, wantM3U)
+	}
+
+	xspf, _ := os.ReadFile(paths[1])
+	for _, want := range []string{
+		`<playlist version="1" xmlns="http://xspf.org/ns/0/">`,
+		`<title>My: List</title>`,
+		`<location>001%20-%20First%20video.mp4</location>`,
+		`<duration>61500</duration>`,
+		`<title>Rock &amp; Roll</title>`,
+	} {
+		if !strings.Contains(string(xspf), want) {
+			t.Errorf("xspf is missing %s:\n%s", want, xspf)
+		}
+	}
+}
+
+func TestParseFormats(t *testing.T) {
+	if got, err := ParseFormats([]string{"M3U8", "xspf"}); err != nil || !reflect.DeepEqual(got, []string{"m3u8", "xspf"}) {
+		t.Errorf("ParseFormats = %v, %v", got, err)
+	}
+	if got, err := ParseFormats([]string{"none"}); err != nil || len(got) != 0 {
+		t.Errorf("ParseFormats(none) = %v, %v", got, err)
+	}
+	if _, err := ParseFormats([]string{"pls"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
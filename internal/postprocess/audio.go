@@ -0,0 +1,289 @@
+package postprocess
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/mkv"
+	"github.com/MaVeN-13TTN/red_goose/internal/mp4"
+	"github.com/MaVeN-13TTN/red_goose/internal/ogg"
+	"github.com/MaVeN-13TTN/red_goose/internal/thumbnails"
+)
+
+// TagAudio tags audio-only downloads with title, artist, album, track
+// number, year and cover art. M4A files get iTunes atoms; Opus audio in
+// WebM is remuxed to an Ogg Opus file carrying Vorbis comments, and other
+// WebM audio gets Matroska tags.
+type TagAudio struct {
+	// Cover embeds the largest video thumbnail as front cover art,
+	// converted to JPEG if needed
+	Cover bool
+}
+
+func (p *TagAudio) Name() string {
+	return "tag-audio"
+}
+
+func (p *TagAudio) Process(ctx context.Context, file *File) error {
+	var cover *thumbnails.Image
+	if p.Cover && len(file.Details.Thumbnails) > 0 {
+		var err error
+		if cover, err = fetchCover(ctx, file); err != nil {
+			fmt.Fprintf(file.output(), "Warning: cover art not embedded: %v\n", err)
+		}
+	}
+
+	switch {
+	case mp4.IsMP4(file.Path):
+		return p.tagM4A(file, cover)
+	case mkv.IsMatroska(file.Path):
+		return p.tagWebM(file, cover)
+	default:
+		return errors.NewValidationError("unsupported audio container for tagging", nil)
+	}
+}
+
+func (p *TagAudio) tagM4A(file *File, cover *thumbnails.Image) error {
+	movie, err := mp4.Open(file.Path)
+	if err != nil {
+		return errors.NewFileSystemError("failed to read M4A", err)
+	}
+
+	meta := mp4.Metadata{
+		Title:      file.Details.Title,
+		Artist:     file.Details.Author,
+		Album:      file.Album,
+		Date:       year(file),
+		Track:      file.Track,
+		TrackTotal: file.TrackTotal,
+	}
+	if cover != nil {
+		meta.Cover = cover.Data
+	}
+	movie.SetMetadata(meta)
+
+	if err := rewrite(file.Path, movie.Save); err != nil {
+		return err
+	}
+	return renameExt(file, ".m4a")
+}
+
+func (p *TagAudio) tagWebM(file *File, cover *thumbnails.Image) error {
+	reader, err := mkv.OpenReader(file.Path)
+	if err != nil {
+		return errors.NewFileSystemError("failed to read WebM", err)
+	}
+
+	tracks := reader.Tracks()
+	if len(tracks) == 1 && tracks[0].CodecID == "A_OPUS" {
+		dst := strings.TrimSuffix(file.Path, filepath.Ext(file.Path)) + ".opus"
+		err := rewrite(dst, func(tmp string) error {
+			return remuxOpus(reader, tracks[0], tmp, vorbisComments(file, cover))
+		})
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to remux to Ogg Opus: %w", err)
+		}
+		if err := os.Remove(file.Path); err != nil {
+			return errors.NewFileSystemError("failed to remove original file", err)
+		}
+		file.Path = dst
+		return nil
+	}
+
+	// Other codecs stay in WebM, tagged without cover art
+	tags := []mkv.Tag{
+		{Name: "TITLE", Value: file.Details.Title},
+		{Name: "ARTIST", Value: file.Details.Author},
+		{Name: "ALBUM", Value: file.Album},
+		{Name: "DATE_RELEASED", Value: year(file)},
+	}
+	if file.Track > 0 {
+		tags = append(tags,
+			mkv.Tag{Name: "PART_NUMBER", Value: strconv.Itoa(file.Track)},
+			mkv.Tag{Name: "TOTAL_PARTS", Value: strconv.Itoa(file.TrackTotal)})
+	}
+	reader.Close()
+
+	return rewrite(file.Path, func(tmp string) error {
+		return mkv.WriteMetadata(file.Path, tmp, tags, nil)
+	})
+}
+
+// Renumber changes the track number that TagAudio wrote into an audio
+// file, as when its video moves within a playlist. Files without a track
+// number are left alone.
+func Renumber(path string, track, total int) error {
+	switch {
+	case mp4.IsMP4(path):
+		movie, err := mp4.Open(path)
+		if err != nil {
+			return errors.NewFileSystemError("failed to read M4A", err)
+		}
+		if _, ok := movie.Tag(mp4.KeyTrack); !ok {
+			return nil
+		}
+		movie.SetMetadata(mp4.Metadata{Track: track, TrackTotal: total})
+		return rewrite(path, movie.Save)
+	case mkv.IsMatroska(path):
+		tags, err := mkv.ReadTags(path)
+		if err != nil {
+			return errors.NewFileSystemError("failed to read WebM", err)
+		}
+		numbered := false
+		for i := range tags {
+			switch tags[i].Name {
+			case "PART_NUMBER":
+				tags[i].Value, numbered = strconv.Itoa(track), true
+			case "TOTAL_PARTS":
+				tags[i].Value = strconv.Itoa(total)
+			}
+		}
+		if !numbered {
+			return nil
+		}
+		return rewrite(path, func(tmp string) error {
+			return mkv.WriteMetadata(path, tmp, tags, nil)
+		})
+	case ogg.IsOgg(path):
+		return rewrite(path, func(tmp string) error {
+			return ogg.RewriteOpusTags(path, tmp, func(comments []string) []string {
+				for i, c := range comments {
+					name, _, _ := strings.Cut(c, "=")
+					switch strings.ToUpper(name) {
+					case "TRACKNUMBER":
+						comments[i] = "TRACKNUMBER=" + strconv.Itoa(track)
+					case "TRACKTOTAL":
+						comments[i] = "TRACKTOTAL=" + strconv.Itoa(total)
+					}
+				}
+				return comments
+			})
+		})
+	}
+	return nil
+}
+
+// vorbisComments returns the Vorbis comment fields for an Opus file
+func vorbisComments(file *File, cover *thumbnails.Image) []string {
+	var comments []string
+	add := func(name, value string) {
+		if value != "" {
+			comments = append(comments, name+"="+value)
+		}
+	}
+
+	add("TITLE", file.Details.Title)
+	add("ARTIST", file.Details.Author)
+	add("ALBUM", file.Album)
+	add("DATE", year(file))
+	if file.Track > 0 {
+		add("TRACKNUMBER", strconv.Itoa(file.Track))
+		if file.TrackTotal > 0 {
+			add("TRACKTOTAL", strconv.Itoa(file.TrackTotal))
+		}
+	}
+	if cover != nil {
+		comments = append(comments, ogg.PictureComment(cover.MimeType(), cover.Data, cover.Width, cover.Height))
+	}
+	return comments
+}
+
+// remuxOpus copies the Opus packets of track into an Ogg Opus file at dst
+func remuxOpus(reader *mkv.Reader, track mkv.Track, dst string, comments []string) error {
+	if _, err := ogg.PreSkip(track.CodecPrivate); err != nil {
+		return err
+	}
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	bw := bufio.NewWriter(out)
+	w := ogg.NewWriter(bw, rand.Uint32())
+
+	// Each header goes on its own page
+	headers := [][]byte{track.CodecPrivate, ogg.OpusTags("red-goose", comments)}
+	for _, header := range headers {
+		if err := w.WritePacket(header, 0); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	var granule int64
+	var discard time.Duration
+	err = reader.Frames(func(frame mkv.Frame) error {
+		if frame.Track != track.Number {
+			return nil
+		}
+		samples, err := ogg.PacketSamples(frame.Data)
+		if err != nil {
+			return err
+		}
+		granule += int64(samples)
+		discard = frame.DiscardPadding
+		return w.WritePacket(frame.Data, granule)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Padding at the end of the last packet is trimmed through the final
+	// granule position
+	if discard > 0 {
+		w.SetGranule(granule - int64(discard)*ogg.OpusSampleRate/int64(time.Second))
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// fetchCover downloads the largest thumbnail as JPEG, which every tag
+// reader supports
+func fetchCover(ctx context.Context, file *File) (*thumbnails.Image, error) {
+	img, err := thumbnails.NewClient().Best(ctx, file.Details.Thumbnails)
+	if err != nil {
+		return nil, err
+	}
+	return thumbnails.ToJPEG(img)
+}
+
+// year returns the upload year of the video, or "" if unknown
+func year(file *File) string {
+	if len(file.Details.UploadDate) < 4 {
+		return ""
+	}
+	return file.Details.UploadDate[:4]
+}
+
+// renameExt gives the file a new extension, keeping the name
+func renameExt(file *File, ext string) error {
+	current := filepath.Ext(file.Path)
+	if current == ext {
+		return nil
+	}
+
+	dst := strings.TrimSuffix(file.Path, current) + ext
+	if err := os.Rename(file.Path, dst); err != nil {
+		return errors.NewFileSystemError("failed to rename file", err)
+	}
+	file.Path = dst
+	return nil
+}
@@ -0,0 +1,251 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/mp4"
+)
+
+// ebml encodes an element with an eight-byte size field
+func ebml(id uint32, body ...[]byte) []byte {
+	var out []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if b := byte(id >> shift); b != 0 || len(out) > 0 {
+			out = append(out, b)
+		}
+	}
+	var size uint64
+	for _, part := range body {
+		size += uint64(len(part))
+	}
+	out = binary.BigEndian.AppendUint64(out, size|1<<56)
+	for _, part := range body {
+		out = append(out, part...)
+	}
+	return out
+}
+
+func uintBytes(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+// writeOpusWebM writes a WebM file with one Opus track of three 20ms
+// packets, the last of which carries 5ms of discard padding
+func writeOpusWebM(t *testing.T, dir string) string {
+	t.Helper()
+
+	head := []byte("OpusHead\x01\x02\x38\x01\x80\xbb\x00\x00\x00\x00\x00")
+	block := func(timecode int16, payload string) []byte {
+		return append([]byte{0x81, byte(timecode >> 8), byte(timecode), 0x80}, payload...)
+	}
+	packet := "\xf8" // CELT, 20ms, one frame
+
+	data := append(ebml(0x1A45DFA3, ebml(0x4282, []byte("webm"))),
+		ebml(0x18538067,
+			ebml(0x1549A966, ebml(0x2AD7B1, uintBytes(1000000))),
+			ebml(0x1654AE6B, ebml(0xAE,
+				ebml(0xD7, uintBytes(1)),
+				ebml(0x83, uintBytes(2)),
+				ebml(0x86, []byte("A_OPUS")),
+				ebml(0x63A2, head),
+			)),
+			ebml(0x1F43B675,
+				ebml(0xE7, uintBytes(0)),
+				ebml(0xA3, block(0, packet+"a")),
+				ebml(0xA3, block(20, packet+"b")),
+				ebml(0xA0,
+					ebml(0xA1, block(40, packet+"c")),
+					ebml(0x75A2, uintBytes(5000000)),
+				),
+			),
+		)...)
+
+	path := filepath.Join(dir, "song.webm")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func coverServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 3))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(img.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTagAudioOpus(t *testing.T) {
+	server := coverServer(t)
+	dir := t.TempDir()
+
+	file := &File{
+		Path: writeOpusWebM(t, dir),
+		Details: &extractor.VideoDetails{
+			Title:      "Song",
+			Author:     "Band",
+			UploadDate: "2023-05-01",
+			Thumbnails: []extractor.Thumbnail{{URL: server.URL + "/cover.png", Width: 4, Height: 3}},
+		},
+		Album:      "Album",
+		Track:      2,
+		TrackTotal: 10,
+	}
+
+	p := &TagAudio{Cover: true}
+	if err := Run(context.Background(), file, p); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if file.Path != filepath.Join(dir, "song.opus") {
+		t.Fatalf("Path = %q, want song.opus", file.Path)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "song.webm")); !os.IsNotExist(err) {
+		t.Error("original WebM file was not removed")
+	}
+
+	data, err := os.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages: OpusHead, OpusTags, then the audio
+	var pages [][]byte
+	var granules []int64
+	for len(data) >= 27 {
+		n := int(data[26])
+		size := 27 + n
+		for _, s := range data[27 : 27+n] {
+			size += int(s)
+		}
+		granules = append(granules, int64(binary.LittleEndian.Uint64(data[6:])))
+		pages = append(pages, data[27+n:size])
+		data = data[size:]
+	}
+	if len(pages) != 3 {
+		t.Fatalf("got %d pages, want 3", len(pages))
+	}
+
+	if !bytes.HasPrefix(pages[0], []byte("OpusHead")) {
+		t.Error("first page is not OpusHead")
+	}
+	tags := string(pages[1])
+	for _, want := range []string{"TITLE=Song", "ARTIST=Band", "ALBUM=Album", "DATE=2023",
+		"TRACKNUMBER=2", "TRACKTOTAL=10", "METADATA_BLOCK_PICTURE="} {
+		if !strings.Contains(tags, want) {
+			t.Errorf("OpusTags missing %q", want)
+		}
+	}
+
+	picture := tags[strings.Index(tags, "METADATA_BLOCK_PICTURE=")+len("METADATA_BLOCK_PICTURE="):]
+	block, err := base64.StdEncoding.DecodeString(picture)
+	if err != nil {
+		t.Fatalf("invalid picture block: %v", err)
+	}
+	if !bytes.Contains(block, []byte("image/jpeg")) {
+		t.Error("cover art was not converted to JPEG")
+	}
+
+	if string(pages[2]) != "\xf8a\xf8b\xf8c" {
+		t.Errorf("audio packets = %q", pages[2])
+	}
+	// Three 960-sample packets less 5ms (240 samples) of padding
+	if granules[2] != 3*960-240 {
+		t.Errorf("final granule = %d, want %d", granules[2], 3*960-240)
+	}
+
+	if err := Renumber(file.Path, 4, 9); err != nil {
+		t.Fatalf("Renumber() error = %v", err)
+	}
+	data, err = os.ReadFile(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"TITLE=Song", "TRACKNUMBER=4", "TRACKTOTAL=9", "\xf8a\xf8b\xf8c"} {
+		if !bytes.Contains(data, []byte(want)) {
+			t.Errorf("renumbered file is missing %q", want)
+		}
+	}
+}
+
+func TestTagAudioM4A(t *testing.T) {
+	dir := t.TempDir()
+
+	moov := mp4.NewContainer("moov",
+		mp4.NewBox("mvhd", make([]byte, 100)),
+	)
+	var buf bytes.Buffer
+	buf.Write(mp4.NewBox("ftyp", []byte("M4A \x00\x00\x00\x00")).Encode())
+	buf.Write(moov.Encode())
+	buf.Write(mp4.NewBox("mdat", []byte("audio")).Encode())
+
+	path := filepath.Join(dir, "song.mp4")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	file := &File{
+		Path:    path,
+		Details: &extractor.VideoDetails{Title: "Song", Author: "Band", UploadDate: "2023-05-01"},
+		Album:   "Album",
+		Track:   3,
+	}
+	if err := Run(context.Background(), file, &TagAudio{}); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	if file.Path != filepath.Join(dir, "song.m4a") {
+		t.Fatalf("Path = %q, want song.m4a", file.Path)
+	}
+
+	movie, err := mp4.Open(file.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tags := map[string]string{
+		mp4.KeyTitle:  "Song",
+		mp4.KeyArtist: "Band",
+		mp4.KeyAlbum:  "Album",
+		mp4.KeyDate:   "2023",
+	}
+	for key, want := range tags {
+		if got, _ := movie.Tag(key); got != want {
+			t.Errorf("Tag(%q) = %q, want %q", key, got, want)
+		}
+	}
+	track, _ := movie.Tag(mp4.KeyTrack)
+	if len(track) != 8 || track[3] != 3 {
+		t.Errorf("track number tag = %q", track)
+	}
+
+	if err := Renumber(file.Path, 5, 9); err != nil {
+		t.Fatalf("Renumber() error = %v", err)
+	}
+	if movie, err = mp4.Open(file.Path); err != nil {
+		t.Fatal(err)
+	}
+	track, _ = movie.Tag(mp4.KeyTrack)
+	if len(track) != 8 || track[3] != 5 || track[5] != 9 {
+		t.Errorf("renumbered track number tag = %q", track)
+	}
+	if got, _ := movie.Tag(mp4.KeyTitle); got != "Song" {
+		t.Errorf("title after Renumber() = %q", got)
+	}
+}
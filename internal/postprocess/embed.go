@@ -0,0 +1,119 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/mkv"
+	"github.com/MaVeN-13TTN/red_goose/internal/mp4"
+	"github.com/MaVeN-13TTN/red_goose/pkg/youtube"
+)
+
+// EmbedMetadata writes video metadata, chapters and subtitles into the
+// downloaded container without re-encoding. MP4 files get iTunes-style
+// tags, Nero chapters and tx3g subtitle tracks; Matroska and WebM files get
+// global tags and chapters.
+type EmbedMetadata struct {
+	Metadata  bool
+	Chapters  bool
+	Subtitles bool
+}
+
+func (p *EmbedMetadata) Name() string {
+	return "embed-metadata"
+}
+
+func (p *EmbedMetadata) Process(ctx context.Context, file *File) error {
+	switch {
+	case mp4.IsMP4(file.Path):
+		return p.embedMP4(file)
+	case mkv.IsMatroska(file.Path):
+		return p.embedMatroska(file)
+	default:
+		return errors.NewValidationError("unsupported container for embedding metadata", nil)
+	}
+}
+
+func (p *EmbedMetadata) embedMP4(file *File) error {
+	movie, err := mp4.Open(file.Path)
+	if err != nil {
+		return errors.NewFileSystemError("failed to read MP4", err)
+	}
+
+	details := file.Details
+	if p.Metadata {
+		movie.SetMetadata(mp4.Metadata{
+			Title:       details.Title,
+			Artist:      details.Author,
+			Date:        details.UploadDate,
+			Description: details.Description,
+			Comment:     youtube.WatchURL(details.ID),
+		})
+	}
+
+	if p.Chapters && len(details.Chapters) > 0 {
+		chapters := make([]mp4.Chapter, len(details.Chapters))
+		for i, c := range details.Chapters {
+			chapters[i] = mp4.Chapter{Start: c.Start, Title: c.Title}
+		}
+		movie.SetChapters(chapters)
+	}
+
+	if p.Subtitles && len(file.Subtitles) > 0 {
+		if movie.Fragmented() {
+			fmt.Fprintf(file.output(), "Warning: %s is a fragmented MP4, subtitles were not embedded\n", file.Path)
+		} else {
+			for _, doc := range file.Subtitles {
+				cues := make([]mp4.TextCue, len(doc.Cues))
+				for i, c := range doc.Cues {
+					cues[i] = mp4.TextCue{Start: c.Start, End: c.End, Text: c.Text}
+				}
+				if err := movie.AddTextTrack(doc.Language, cues); err != nil {
+					return fmt.Errorf("failed to embed %s subtitles: %w", doc.Language, err)
+				}
+			}
+		}
+	}
+
+	return rewrite(file.Path, movie.Save)
+}
+
+func (p *EmbedMetadata) embedMatroska(file *File) error {
+	details := file.Details
+
+	var tags []mkv.Tag
+	if p.Metadata {
+		tags = []mkv.Tag{
+			{Name: "TITLE", Value: details.Title},
+			{Name: "ARTIST", Value: details.Author},
+			{Name: "DATE_RELEASED", Value: details.UploadDate},
+			{Name: "DESCRIPTION", Value: details.Description},
+			{Name: "URL", Value: youtube.WatchURL(details.ID)},
+		}
+	}
+
+	var chapters []mkv.Chapter
+	if p.Chapters {
+		for _, c := range details.Chapters {
+			chapters = append(chapters, mkv.Chapter{Start: c.Start, End: c.End, Title: c.Title})
+		}
+	}
+
+	if p.Subtitles && len(file.Subtitles) > 0 {
+		langs := make([]string, len(file.Subtitles))
+		for i, doc := range file.Subtitles {
+			langs[i] = doc.Language
+		}
+		fmt.Fprintf(file.output(), "Warning: embedding subtitles is only supported for MP4, skipped %s\n", strings.Join(langs, ","))
+	}
+
+	if len(tags) == 0 && len(chapters) == 0 {
+		return nil
+	}
+
+	return rewrite(file.Path, func(tmp string) error {
+		return mkv.WriteMetadata(file.Path, tmp, tags, chapters)
+	})
+}
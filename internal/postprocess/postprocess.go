@@ -0,0 +1,77 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/subtitles"
+)
+
+// File is a downloaded media file handed along the post-processing chain.
+// Processors that rename or replace the file update Path.
+type File struct {
+	Path      string
+	Details   *extractor.VideoDetails
+	Subtitles []*subtitles.Document
+
+	// Album, Track and TrackTotal place the file within a playlist download
+	Album      string
+	Track      int
+	TrackTotal int
+
+	// Parts lists files cut from Path, such as one per chapter
+	Parts []string
+
+	// Output receives the messages of the processors; they go to stdout
+	// if it is nil
+	Output io.Writer
+}
+
+// output returns the writer of the processors' messages
+func (f *File) output() io.Writer {
+	if f.Output == nil {
+		return os.Stdout
+	}
+	return f.Output
+}
+
+// Processor is a step run on a file after it has been downloaded
+type Processor interface {
+	Name() string
+	Process(ctx context.Context, file *File) error
+}
+
+// Run applies the processors in order, stopping at the first failure
+func Run(ctx context.Context, file *File, processors ...Processor) error {
+	for _, p := range processors {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := p.Process(ctx, file); err != nil {
+			return fmt.Errorf("%s: %w", p.Name(), err)
+		}
+	}
+	return nil
+}
+
+// rewrite produces a new version of path through write, which receives a
+// temporary path in the same directory, and replaces the original with it
+func rewrite(path string, write func(tmp string) error) error {
+	tmp := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+
+	if err := write(tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to replace file", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/mkv"
+	"github.com/MaVeN-13TTN/red_goose/internal/mp4"
+	"github.com/MaVeN-13TTN/red_goose/internal/ogg"
+)
+
+// SplitChapters cuts the file into one file per chapter without
+// re-encoding, keeping the original. Part names come from Pattern, which
+// accepts the {title}, {author}, {id}, {chapter} and {chapter_number}
+// fields.
+type SplitChapters struct {
+	Pattern string
+}
+
+func (p *SplitChapters) Name() string {
+	return "split-chapters"
+}
+
+func (p *SplitChapters) Process(ctx context.Context, file *File) error {
+	chapters := file.Details.Chapters
+	if len(chapters) == 0 {
+		fmt.Fprintf(file.output(), "No chapters found for %s, not splitting\n", file.Details.Title)
+		return nil
+	}
+
+	dir, ext := filepath.Dir(file.Path), filepath.Ext(file.Path)
+	width := max(2, len(strconv.Itoa(len(chapters))))
+	for i, c := range chapters {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		name := downloader.ExpandNamingPattern(p.Pattern, map[string]string{
+			"title":          file.Details.Title,
+			"author":         file.Details.Author,
+			"id":             file.Details.ID,
+			"chapter":        c.Title,
+			"chapter_number": fmt.Sprintf("%0*d", width, i+1),
+		})
+		path := filepath.Join(dir, downloader.SanitizeFilename(name)+ext)
+		if path == file.Path {
+			return errors.NewValidationError("chapter file name matches the downloaded file", nil)
+		}
+
+		end := c.End
+		if end == 0 && i+1 < len(chapters) {
+			end = chapters[i+1].Start
+		}
+		err := rewrite(path, func(tmp string) error {
+			return trim(file.Path, tmp, []span{{Start: c.Start, End: end}})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to cut chapter %q: %w", c.Title, err)
+		}
+		file.Parts = append(file.Parts, path)
+	}
+
+	fmt.Fprintf(file.output(), "Split into %d chapter files\n", len(chapters))
+	return nil
+}
+
+// span is a part of the media to keep. A zero End runs to the end.
+type span struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// trim writes the spans of src, joined in order, to dst without
+// re-encoding, using the cutter for the file's container
+func trim(src, dst string, spans []span) error {
+	switch {
+	case mp4.IsMP4(src):
+		ranges := make([]mp4.Range, len(spans))
+		for i, s := range spans {
+			ranges[i] = mp4.Range{Start: s.Start, End: s.End}
+		}
+		return mp4.Trim(src, dst, ranges)
+	case mkv.IsMatroska(src):
+		ranges := make([]mkv.Range, len(spans))
+		for i, s := range spans {
+			ranges[i] = mkv.Range{Start: s.Start, End: s.End}
+		}
+		return mkv.Trim(src, dst, ranges)
+	case ogg.IsOgg(src):
+		ranges := make([]ogg.Range, len(spans))
+		for i, s := range spans {
+			ranges[i] = ogg.Range{Start: s.Start, End: s.End}
+		}
+		return ogg.TrimOpus(src, dst, ranges)
+	default:
+		return errors.NewValidationError("unsupported container for cutting", nil)
+	}
+}
@@ -0,0 +1,91 @@
+package postprocess
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/ogg"
+)
+
+// writeOpus writes an Ogg Opus file of ten 20ms packets
+func writeOpus(t *testing.T, path string) {
+	t.Helper()
+	var buf bytes.Buffer
+	w := ogg.NewWriter(&buf, 1)
+	head := []byte("OpusHead\x01\x02\x00\x00\x80\xbb\x00\x00\x00\x00\x00")
+	for _, header := range [][]byte{head, ogg.OpusTags("test", nil)} {
+		if err := w.WritePacket(header, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err := w.WritePacket([]byte{0xf8, byte(i)}, int64(i+1)*960); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSplitChapters(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Song.opus")
+	writeOpus(t, path)
+
+	var out bytes.Buffer
+	file := &File{
+		Path:   path,
+		Output: &out,
+		Details: &extractor.VideoDetails{
+			Title: "Song",
+			Chapters: []extractor.Chapter{
+				{Title: "Intro", Start: 0, End: 60 * time.Millisecond},
+				{Title: "Main: part", Start: 60 * time.Millisecond, End: 200 * time.Millisecond},
+			},
+		},
+	}
+	p := &SplitChapters{Pattern: "{title} - {chapter_number} {chapter}"}
+	if err := Run(context.Background(), file, p); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	want := []string{
+		filepath.Join(dir, "Song - 01 Intro.opus"),
+		filepath.Join(dir, "Song - 02 Main_ part.opus"),
+	}
+	if len(file.Parts) != len(want) {
+		t.Fatalf("Parts = %v, want %v", file.Parts, want)
+	}
+	for i, part := range file.Parts {
+		if part != want[i] {
+			t.Errorf("part %d = %q, want %q", i, part, want[i])
+		}
+		if _, err := os.Stat(part); err != nil {
+			t.Errorf("part %d was not written: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Error("original file was removed")
+	}
+	if out.String() != "Split into 2 chapter files\n" {
+		t.Errorf("output = %q", out.String())
+	}
+
+	// Files without chapters are left alone
+	file = &File{Path: path, Output: &out, Details: &extractor.VideoDetails{Title: "Song"}}
+	if err := Run(context.Background(), file, p); err != nil || len(file.Parts) != 0 {
+		t.Errorf("Run() without chapters = %v, parts %v", err, file.Parts)
+	}
+}
@@ -0,0 +1,196 @@
+package postprocess
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/sponsorblock"
+)
+
+// MarkSegments adds the SponsorBlock segments of the chosen categories to
+// the video's chapters, so that embedding chapters shows them in players
+type MarkSegments struct {
+	Provider   sponsorblock.Provider
+	Categories []string
+}
+
+func (p *MarkSegments) Name() string {
+	return "sponsorblock-mark"
+}
+
+func (p *MarkSegments) Process(ctx context.Context, file *File) error {
+	segments, err := p.Provider.Segments(ctx, file.Details.ID, p.Categories)
+	if err != nil {
+		return err
+	}
+	if len(segments) == 0 {
+		return nil
+	}
+
+	details := *file.Details
+	duration := time.Duration(details.DurationSeconds) * time.Second
+	details.Chapters = markChapters(details.Title, details.Chapters, segments, duration)
+	file.Details = &details
+	return nil
+}
+
+// markChapters splits the chapters around the segments and inserts a
+// chapter for each segment. Without chapters the rest of the video becomes
+// chapters named after it.
+func markChapters(title string, chapters []extractor.Chapter, segments []sponsorblock.Segment, duration time.Duration) []extractor.Chapter {
+	// Overlapping segments are shortened so chapters do not overlap
+	var flat []sponsorblock.Segment
+	for _, s := range segments {
+		if n := len(flat); n > 0 && s.Start < flat[n-1].End {
+			s.Start = flat[n-1].End
+		}
+		if s.End > s.Start {
+			flat = append(flat, s)
+		}
+		if s.End > duration {
+			duration = s.End
+		}
+	}
+
+	content := chapters
+	if len(content) == 0 {
+		content = []extractor.Chapter{{Title: title, Start: 0, End: duration}}
+	}
+
+	var out []extractor.Chapter
+	add := func(title string, start, end time.Duration) {
+		if end > start {
+			out = append(out, extractor.Chapter{Title: title, Start: start, End: end})
+		}
+	}
+	for i, c := range content {
+		end := c.End
+		if end == 0 {
+			end = duration
+			if i+1 < len(content) {
+				end = content[i+1].Start
+			}
+		}
+		pos := c.Start
+		for _, s := range flat {
+			if s.Start < end && s.End > pos {
+				add(c.Title, pos, s.Start)
+				pos = s.End
+			}
+		}
+		add(c.Title, pos, end)
+	}
+	for _, s := range flat {
+		add(s.Title(), s.Start, s.End)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
+
+// RemoveSegments cuts the SponsorBlock segments of the chosen categories
+// out of the file without re-encoding. Video cuts fall on keyframes, so a
+// little of a segment may remain. Chapters are moved to match.
+type RemoveSegments struct {
+	Provider   sponsorblock.Provider
+	Categories []string
+}
+
+func (p *RemoveSegments) Name() string {
+	return "sponsorblock-remove"
+}
+
+func (p *RemoveSegments) Process(ctx context.Context, file *File) error {
+	segments, err := p.Provider.Segments(ctx, file.Details.ID, p.Categories)
+	if err != nil {
+		return err
+	}
+	removed := mergeSegments(segments)
+	if len(removed) == 0 {
+		return nil
+	}
+
+	// Keep the gaps between the segments
+	duration := time.Duration(file.Details.DurationSeconds) * time.Second
+	var spans []span
+	var pos, total time.Duration
+	for _, s := range removed {
+		if s.Start > pos {
+			spans = append(spans, span{Start: pos, End: s.Start})
+		}
+		pos = s.End
+		total += s.End - s.Start
+	}
+	if duration == 0 || pos < duration {
+		spans = append(spans, span{Start: pos})
+	}
+	if len(spans) == 0 {
+		return errors.NewValidationError("the segments cover the whole video", nil)
+	}
+
+	err = rewrite(file.Path, func(tmp string) error {
+		return trim(file.Path, tmp, spans)
+	})
+	if err != nil {
+		return err
+	}
+
+	details := *file.Details
+	details.Chapters = shiftChapters(details.Chapters, removed)
+	if duration > total {
+		details.Duration = (duration - total).String()
+		details.DurationSeconds = int64((duration - total).Seconds())
+	}
+	file.Details = &details
+
+	fmt.Fprintf(file.output(), "Removed %d SponsorBlock segments (%s)\n", len(removed), total.Round(time.Second))
+	return nil
+}
+
+// mergeSegments joins overlapping segments
+func mergeSegments(segments []sponsorblock.Segment) []sponsorblock.Segment {
+	sorted := append([]sponsorblock.Segment(nil), segments...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+
+	var merged []sponsorblock.Segment
+	for _, s := range sorted {
+		if n := len(merged); n > 0 && s.Start <= merged[n-1].End {
+			merged[n-1].End = max(merged[n-1].End, s.End)
+			continue
+		}
+		merged = append(merged, s)
+	}
+	return merged
+}
+
+// shiftChapters moves chapters to their times after the removed segments
+// are cut out, dropping those left empty
+func shiftChapters(chapters []extractor.Chapter, removed []sponsorblock.Segment) []extractor.Chapter {
+	shift := func(t time.Duration) time.Duration {
+		var cut time.Duration
+		for _, s := range removed {
+			if s.Start >= t {
+				break
+			}
+			cut += min(s.End, t) - s.Start
+		}
+		return t - cut
+	}
+
+	var out []extractor.Chapter
+	for _, c := range chapters {
+		c.Start = shift(c.Start)
+		if c.End > 0 {
+			c.End = shift(c.End)
+			if c.End <= c.Start {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
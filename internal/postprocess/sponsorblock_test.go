@@ -0,0 +1,121 @@
+package postprocess
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/ogg"
+	"github.com/MaVeN-13TTN/red_goose/internal/sponsorblock"
+)
+
+// staticSegments is a segment provider with a fixed answer
+type staticSegments []sponsorblock.Segment
+
+func (s staticSegments) Segments(ctx context.Context, videoID string, categories []string) ([]sponsorblock.Segment, error) {
+	return s, nil
+}
+
+func TestMarkChapters(t *testing.T) {
+	chapters := []extractor.Chapter{
+		{Title: "Intro", Start: 0, End: time.Minute},
+		{Title: "Talk", Start: time.Minute, End: 10 * time.Minute},
+	}
+	segments := []sponsorblock.Segment{
+		{Category: sponsorblock.CategorySponsor, Start: 50 * time.Second, End: 90 * time.Second},
+	}
+
+	got := markChapters("Video", chapters, segments, 10*time.Minute)
+	want := []extractor.Chapter{
+		{Title: "Intro", Start: 0, End: 50 * time.Second},
+		{Title: "Sponsor", Start: 50 * time.Second, End: 90 * time.Second},
+		{Title: "Talk", Start: 90 * time.Second, End: 10 * time.Minute},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("markChapters() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("chapter %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Without chapters the rest of the video is named after it
+	got = markChapters("Video", nil, segments, 10*time.Minute)
+	if len(got) != 3 || got[0].Title != "Video" || got[2].Start != 90*time.Second {
+		t.Errorf("markChapters() without chapters = %+v", got)
+	}
+}
+
+func TestRemoveSegments(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "Talk.opus")
+	writeOpus(t, path)
+
+	file := &File{
+		Path: path,
+		Details: &extractor.VideoDetails{
+			ID:              "abc",
+			DurationSeconds: 1,
+			Chapters: []extractor.Chapter{
+				{Title: "Start", Start: 0, End: 50 * time.Millisecond},
+				{Title: "Ad", Start: 50 * time.Millisecond, End: 100 * time.Millisecond},
+				{Title: "End", Start: 100 * time.Millisecond, End: 200 * time.Millisecond},
+			},
+		},
+	}
+	details := file.Details
+	p := &RemoveSegments{Provider: staticSegments{
+		{Category: sponsorblock.CategorySponsor, Start: 40 * time.Millisecond, End: 100 * time.Millisecond},
+		{Category: sponsorblock.CategoryIntro, Start: 50 * time.Millisecond, End: 60 * time.Millisecond},
+	}}
+	if err := Run(context.Background(), file, p); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r := ogg.NewReader(f)
+	var packets []byte
+	for i := 0; ; i++ {
+		packet, _, err := r.ReadPacket()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= 2 {
+			packets = append(packets, packet[1])
+		}
+	}
+	if want := []byte{0, 1, 5, 6, 7, 8, 9}; string(packets) != string(want) {
+		t.Errorf("packets = %v, want %v", packets, want)
+	}
+
+	want := []extractor.Chapter{
+		{Title: "Start", Start: 0, End: 40 * time.Millisecond},
+		{Title: "End", Start: 40 * time.Millisecond, End: 140 * time.Millisecond},
+	}
+	if len(file.Details.Chapters) != len(want) {
+		t.Fatalf("chapters = %+v, want %+v", file.Details.Chapters, want)
+	}
+	for i := range want {
+		if file.Details.Chapters[i] != want[i] {
+			t.Errorf("chapter %d = %+v, want %+v", i, file.Details.Chapters[i], want[i])
+		}
+	}
+	if file.Details.Duration != "940ms" {
+		t.Errorf("Duration = %s, want 940ms", file.Details.Duration)
+	}
+	if len(details.Chapters) != 3 {
+		t.Error("the original video details were modified")
+	}
+}
@@ -0,0 +1,289 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
+	"golang.org/x/term"
+)
+
+const (
+	// barWidth is the number of cells of a progress bar
+	barWidth = 25
+	// nameWidth is the room for a download's name before its bar
+	nameWidth = 32
+)
+
+// IsTerminal reports whether f is a terminal that bars can be drawn on
+func IsTerminal(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd()))
+}
+
+// Display shows the progress of a batch of downloads. On a terminal it
+// draws a bar for each running download and one for the whole batch,
+// redrawn in place; otherwise it prints a summary line now and then.
+// It is safe for concurrent use.
+type Display struct {
+	mu       sync.Mutex
+	w        io.Writer
+	tty      bool
+	interval time.Duration
+
+	files    int
+	done     int
+	failed   int
+	finished int64
+	// sized counts the finished downloads whose size was known, to guess
+	// the size of the ones still to come
+	sized      int
+	sizedBytes int64
+	bars       []*Bar
+	drawn      int
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// NewDisplay returns a display of a batch of files downloads on w. tty
+// says whether w is a terminal.
+func NewDisplay(w io.Writer, files int, tty bool) *Display {
+	interval := 10 * time.Second
+	if tty {
+		interval = 200 * time.Millisecond
+	}
+	return &Display{w: w, tty: tty, interval: interval, files: files}
+}
+
+// Start redraws the display until Stop is called
+func (d *Display) Start() {
+	d.stop = make(chan struct{})
+	d.stopped = make(chan struct{})
+	go func() {
+		defer close(d.stopped)
+		ticker := time.NewTicker(d.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.refresh()
+				d.mu.Unlock()
+			}
+		}
+	}()
+}
+
+// Stop ends the redrawing and leaves the summary of the batch behind
+func (d *Display) Stop() {
+	if d.stop != nil {
+		close(d.stop)
+		<-d.stopped
+		d.stop = nil
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	fmt.Fprintln(d.w, d.summary())
+}
+
+// Writer returns a writer to w for messages printed while the display is
+// shown. On a terminal the bars are taken down for each message and drawn
+// again below it.
+func (d *Display) Writer(w io.Writer) io.Writer {
+	return &messageWriter{d: d, w: w}
+}
+
+type messageWriter struct {
+	d *Display
+	w io.Writer
+}
+
+func (m *messageWriter) Write(p []byte) (int, error) {
+	m.d.mu.Lock()
+	defer m.d.mu.Unlock()
+	m.d.clear()
+	n, err := m.w.Write(p)
+	if m.d.tty {
+		m.d.draw()
+	}
+	return n, err
+}
+
+// Add shows a bar for a download that started
+func (d *Display) Add(name string) *Bar {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	b := &Bar{d: d, name: name}
+	d.bars = append(d.bars, b)
+	return b
+}
+
+// refresh brings the display up to date: the bars on a terminal, a
+// summary line otherwise
+func (d *Display) refresh() {
+	if d.tty {
+		d.clear()
+		d.draw()
+		return
+	}
+	if len(d.bars) > 0 {
+		fmt.Fprintln(d.w, d.summary())
+	}
+}
+
+// clear takes the drawn bars off the terminal, leaving the cursor where
+// the first of them was
+func (d *Display) clear() {
+	if d.drawn > 0 {
+		fmt.Fprintf(d.w, "\x1b[%dA\x1b[J", d.drawn)
+		d.drawn = 0
+	}
+}
+
+// draw writes a line for each running download and one for the batch
+func (d *Display) draw() {
+	var b strings.Builder
+	for _, bar := range d.bars {
+		b.WriteString(bar.line())
+		b.WriteByte('\n')
+	}
+	b.WriteString(d.overall())
+	b.WriteByte('\n')
+	io.WriteString(d.w, b.String())
+	d.drawn = len(d.bars) + 1
+}
+
+// totals adds up the bytes downloaded and the speed of the running
+// downloads, and guesses the size of the whole batch. The estimate is 0
+// when no size is known yet.
+func (d *Display) totals() (downloaded int64, speed float64, estimate int64) {
+	downloaded = d.finished
+	estimate = d.sizedBytes
+	sized := d.sized
+	for _, b := range d.bars {
+		downloaded += b.downloaded
+		speed += b.speed
+		if b.total > 0 {
+			estimate += b.total
+			sized++
+		}
+	}
+	if sized == 0 {
+		return downloaded, speed, 0
+	}
+	if rest := d.files - d.done - d.failed - len(d.bars); rest > 0 {
+		estimate += estimate / int64(sized) * int64(rest)
+	}
+	return downloaded, speed, estimate
+}
+
+// overall is the bar of the whole batch
+func (d *Display) overall() string {
+	downloaded, speed, estimate := d.totals()
+	fraction := 0.0
+	if d.files > 0 {
+		fraction = float64(d.done+d.failed) / float64(d.files)
+	}
+	if estimate > 0 {
+		fraction = float64(downloaded) / float64(estimate)
+	}
+	return fmt.Sprintf("%-*s %s %s", nameWidth, "Total", bar(fraction), d.status(downloaded, speed, estimate))
+}
+
+// summary is the line that stands for the display where bars can't be
+// drawn, and after it is stopped
+func (d *Display) summary() string {
+	downloaded, speed, estimate := d.totals()
+	return "Progress: " + d.status(downloaded, speed, estimate)
+}
+
+func (d *Display) status(downloaded int64, speed float64, estimate int64) string {
+	s := fmt.Sprintf("%d/%d files", d.done, d.files)
+	if d.failed > 0 {
+		s += fmt.Sprintf(" (%d failed)", d.failed)
+	}
+	s += ", " + utils.FormatBytes(downloaded)
+	if len(d.bars) > 0 {
+		s += ", " + utils.FormatBytes(int64(speed)) + "/s"
+		if estimate > downloaded && speed > 0 {
+			s += ", ETA " + formatETA(float64(estimate-downloaded)/speed)
+		}
+	}
+	return s
+}
+
+// Bar is the progress of one download in a display
+type Bar struct {
+	d          *Display
+	name       string
+	downloaded int64
+	total      int64
+	speed      float64
+}
+
+// Progress updates the bar. It fits downloader.ProgressCallback.
+func (b *Bar) Progress(p downloader.Progress) {
+	b.d.mu.Lock()
+	defer b.d.mu.Unlock()
+	b.downloaded, b.total, b.speed = p.Downloaded, p.Total, p.Speed
+}
+
+// Done removes the bar of a finished download, counting it as done or
+// failed
+func (b *Bar) Done(ok bool) {
+	d := b.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for i, other := range d.bars {
+		if other == b {
+			d.bars = append(d.bars[:i], d.bars[i+1:]...)
+			break
+		}
+	}
+	if !ok {
+		d.failed++
+		return
+	}
+	d.done++
+	size := b.downloaded
+	if b.total > 0 {
+		size = b.total
+		d.sized++
+		d.sizedBytes += b.total
+	}
+	d.finished += size
+}
+
+func (b *Bar) line() string {
+	name := b.name
+	if r := []rune(name); len(r) > nameWidth {
+		name = string(r[:nameWidth-1]) + "…"
+	}
+	progress := utils.FormatBytes(b.downloaded)
+	fraction := 0.0
+	if b.total > 0 {
+		fraction = float64(b.downloaded) / float64(b.total)
+		progress = fmt.Sprintf("%3.0f%% %s/%s", fraction*100, progress, utils.FormatBytes(b.total))
+	}
+	return fmt.Sprintf("%-*s %s %s, %s/s", nameWidth, name, bar(fraction), progress, utils.FormatBytes(int64(b.speed)))
+}
+
+// bar draws a fraction between 0 and 1
+func bar(fraction float64) string {
+	fraction = max(0, min(fraction, 1))
+	filled := int(fraction * barWidth)
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "]"
+}
+
+// formatETA writes a number of seconds as a rounded duration
+func formatETA(seconds float64) string {
+	return (time.Duration(seconds) * time.Second).Round(time.Second).String()
+}
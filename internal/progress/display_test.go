@@ -0,0 +1,69 @@
+package progress
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+)
+
+func TestDisplaySummary(t *testing.T) {
+	var buf bytes.Buffer
+	d := NewDisplay(&buf, 4, false)
+
+	first := d.Add("first")
+	first.Progress(downloader.Progress{Downloaded: 1024 * 1024, Total: 1024 * 1024})
+	first.Done(true)
+	second := d.Add("second")
+	second.Progress(downloader.Progress{Downloaded: 512 * 1024, Total: 1024 * 1024, Speed: 256 * 1024})
+	d.Add("third").Done(false)
+
+	d.refresh()
+	// Half of the second file and all of the fourth, guessed at 1 MiB,
+	// are left: 1.5 MiB at 256 KiB/s
+	want := "Progress: 1/4 files (1 failed), 1.5 MiB, 256.0 KiB/s, ETA 6s\n"
+	if buf.String() != want {
+		t.Errorf("summary = %q, want %q", buf.String(), want)
+	}
+
+	buf.Reset()
+	fmt.Fprintln(d.Writer(&buf), "Finished second")
+	if buf.String() != "Finished second\n" {
+		t.Errorf("message = %q, want it unchanged", buf.String())
+	}
+}
+
+func TestDisplayTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	d := NewDisplay(&buf, 2, true)
+	b := d.Add("A video")
+	b.Progress(downloader.Progress{Downloaded: 50, Total: 100, Speed: 10})
+
+	d.refresh()
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("drew %d lines, want a bar and the total:\n%s", len(lines), buf.String())
+	}
+	if !strings.HasPrefix(lines[0], "A video") || !strings.Contains(lines[0], " 50% 50 B/100 B, 10 B/s") {
+		t.Errorf("bar = %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "Total") || !strings.Contains(lines[1], "0/2 files, 50 B, 10 B/s, ETA 15s") {
+		t.Errorf("total = %q", lines[1])
+	}
+
+	// Messages take the bars down and draw them again below
+	buf.Reset()
+	fmt.Fprintln(d.Writer(&buf), "Downloading another")
+	if !strings.HasPrefix(buf.String(), "\x1b[2A\x1b[JDownloading another\nA video") {
+		t.Errorf("message output = %q", buf.String())
+	}
+
+	buf.Reset()
+	b.Done(true)
+	d.Stop()
+	if want := "\x1b[2A\x1b[JProgress: 1/2 files, 100 B\n"; buf.String() != want {
+		t.Errorf("stop = %q, want %q", buf.String(), want)
+	}
+}
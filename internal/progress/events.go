@@ -0,0 +1,137 @@
+// Package progress reports how downloads are going: as newline-delimited
+// JSON events for scripts and other programs, or as terminal bars.
+package progress
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// Progress formats
+const (
+	FormatBar  = "bar"
+	FormatJSON = "json"
+)
+
+// ValidateFormat checks a progress format name
+func ValidateFormat(name string) error {
+	switch name {
+	case FormatBar, FormatJSON:
+		return nil
+	}
+	return errors.NewValidationError(fmt.Sprintf("unknown progress format %q (use bar or json)", name), nil)
+}
+
+// Event types
+const (
+	EventStarted   = "started"
+	EventProgress  = "progress"
+	EventStage     = "stage"
+	EventRetry     = "retry"
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+)
+
+// Event is one line of the JSON progress stream
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Task    int       `json:"task,omitempty"`
+	VideoID string    `json:"video_id,omitempty"`
+	Title   string    `json:"title,omitempty"`
+
+	// Attempt is the attempt of the task on started events, and the failed
+	// request's attempt on retry events
+	Attempt int `json:"attempt,omitempty"`
+
+	Downloaded   int64   `json:"downloaded,omitempty"`
+	Total        int64   `json:"total,omitempty"`
+	Speed        float64 `json:"speed,omitempty"`
+	AverageSpeed float64 `json:"average_speed,omitempty"`
+	// ETA is in seconds, and only set when the total size is known
+	ETA float64 `json:"eta,omitempty"`
+
+	Stage string `json:"stage,omitempty"`
+	Path  string `json:"path,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// JSON writes events as JSON lines. It is safe for concurrent use.
+type JSON struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// NewJSON returns a writer of events to w
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Emit writes an event, stamping it with the current time
+func (j *JSON) Emit(e Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e.Time = j.now()
+	j.enc.Encode(e)
+}
+
+// Task returns a reporter for the events of one download
+func (j *JSON) Task(task int, videoID, title string) *TaskEvents {
+	return &TaskEvents{out: j, base: Event{Task: task, VideoID: videoID, Title: title}}
+}
+
+// TaskEvents emits the events of one download. Its methods fit the
+// callbacks of the pipeline and the downloader.
+type TaskEvents struct {
+	out  *JSON
+	base Event
+}
+
+func (t *TaskEvents) emit(e Event) {
+	e.Task, e.VideoID, e.Title = t.base.Task, t.base.VideoID, t.base.Title
+	t.out.Emit(e)
+}
+
+// Started reports that the download began, on its given attempt
+func (t *TaskEvents) Started(attempt int) {
+	t.emit(Event{Type: EventStarted, Attempt: attempt})
+}
+
+// Stage reports a stage of the download, such as postprocessing
+func (t *TaskEvents) Stage(stage string) {
+	t.emit(Event{Type: EventStage, Stage: stage})
+}
+
+// Progress reports a snapshot of the download
+func (t *TaskEvents) Progress(p downloader.Progress) {
+	t.emit(Event{
+		Type:         EventProgress,
+		Downloaded:   p.Downloaded,
+		Total:        max(p.Total, 0),
+		Speed:        p.Speed,
+		AverageSpeed: p.AverageSpeed,
+		ETA:          p.ETA.Seconds(),
+	})
+}
+
+// Retry reports a failed request that will be tried again
+func (t *TaskEvents) Retry(attempt int, err error) {
+	t.emit(Event{Type: EventRetry, Attempt: attempt, Error: err.Error()})
+}
+
+// Completed reports a finished download
+func (t *TaskEvents) Completed(path string) {
+	t.emit(Event{Type: EventCompleted, Path: path})
+}
+
+// Failed reports a download that gave up
+func (t *TaskEvents) Failed(err error) {
+	t.emit(Event{Type: EventFailed, Error: err.Error()})
+}
@@ -0,0 +1,64 @@
+package progress
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+)
+
+func TestTaskEvents(t *testing.T) {
+	var buf bytes.Buffer
+	out := NewJSON(&buf)
+	out.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+
+	task := out.Task(7, "abc", "A video")
+	task.Started(1)
+	task.Stage("downloading")
+	task.Progress(downloader.Progress{Downloaded: 250, Total: 1000, Speed: 50, AverageSpeed: 40, ETA: 15 * time.Second})
+	task.Progress(downloader.Progress{Downloaded: 10, Total: -1, Speed: 5})
+	task.Retry(1, fmt.Errorf("connection reset"))
+	task.Stage("postprocessing")
+	task.Completed("out/A video.mp4")
+	out.Task(8, "def", "").Failed(fmt.Errorf("unavailable"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("got %d lines, want 8:\n%s", len(lines), buf.String())
+	}
+
+	var events []Event
+	for _, line := range lines {
+		var e Event
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %q: %v", line, err)
+		}
+		events = append(events, e)
+	}
+
+	if e := events[0]; e.Type != EventStarted || e.Task != 7 || e.VideoID != "abc" || e.Title != "A video" || e.Attempt != 1 {
+		t.Errorf("started = %+v", e)
+	}
+	if e := events[2]; e.Type != EventProgress || e.Downloaded != 250 || e.Total != 1000 || e.Speed != 50 || e.AverageSpeed != 40 || e.ETA != 15 {
+		t.Errorf("progress = %+v", e)
+	}
+	if e := events[3]; e.Total != 0 || e.ETA != 0 {
+		t.Errorf("progress of unknown size = %+v", e)
+	}
+	if e := events[4]; e.Type != EventRetry || e.Error != "connection reset" {
+		t.Errorf("retry = %+v", e)
+	}
+	if e := events[6]; e.Type != EventCompleted || e.Path != "out/A video.mp4" {
+		t.Errorf("completed = %+v", e)
+	}
+	if e := events[7]; e.Type != EventFailed || e.Task != 8 || e.Error != "unavailable" {
+		t.Errorf("failed = %+v", e)
+	}
+	if !strings.Contains(lines[0], `"time":"2024-01-02T03:04:05Z"`) {
+		t.Errorf("missing time: %s", lines[0])
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !unix
+
+package queue
+
+import "os"
+
+// lockFile creates the file at path as a lock, failing with errLocked if it
+// already exists. A process that crashes leaves it behind.
+func lockFile(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil, errLocked
+	}
+	return f, err
+}
+
+// unlockFile releases a lock taken by lockFile
+func unlockFile(f *os.File) error {
+	f.Close()
+	return os.Remove(f.Name())
+}
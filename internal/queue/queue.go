@@ -0,0 +1,620 @@
+// Package queue keeps downloads in a file, so that a batch stopped halfway
+// can be resumed by a later run
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/logging"
+	"github.com/MaVeN-13TTN/red_goose/internal/pipeline"
+	"github.com/MaVeN-13TTN/red_goose/internal/utils"
+)
+
+// State is where a task is in its download
+type State string
+
+// The states of a task. The working states match the pipeline stages.
+const (
+	StatePending        State = "pending"
+	StateResolving      State = State(pipeline.StageResolving)
+	StateDownloading    State = State(pipeline.StageDownloading)
+	StatePostprocessing State = State(pipeline.StagePostprocessing)
+	StateDone           State = "done"
+	StateFailed         State = "failed"
+	// StatePaused tasks are skipped until they are resumed
+	StatePaused State = "paused"
+	// StateCanceled tasks were stopped by the user; they can be retried
+	StateCanceled State = "canceled"
+)
+
+// ParseState checks a state name given on the command line
+func ParseState(s string) (State, error) {
+	switch state := State(s); state {
+	case StatePending, StateResolving, StateDownloading, StatePostprocessing,
+		StateDone, StateFailed, StatePaused, StateCanceled:
+		return state, nil
+	}
+	return "", errors.NewValidationError(fmt.Sprintf(
+		"unknown state %q (use pending, resolving, downloading, postprocessing, done, failed, paused or canceled)", s), nil)
+}
+
+// Working reports whether a task in the state is being downloaded
+func (s State) Working() bool {
+	return s == StateResolving || s == StateDownloading || s == StatePostprocessing
+}
+
+// Task is a queued download
+type Task struct {
+	ID      int              `json:"id"`
+	Item    pipeline.Item    `json:"item"`
+	Options pipeline.Options `json:"options"`
+	Title   string           `json:"title,omitempty"`
+	State   State            `json:"state"`
+	// Error is the reason of the last failure
+	Error    string    `json:"error,omitempty"`
+	Attempts int       `json:"attempts"`
+	Path     string    `json:"path,omitempty"`
+	Added    time.Time `json:"added"`
+	Updated  time.Time `json:"updated"`
+	// Parts are the other files the download wrote, such as the chapters
+	// of --split-chapters
+	Parts []string `json:"parts,omitempty"`
+}
+
+// ErrInUse is the cause of the error of opening a queue that another
+// process has open
+var ErrInUse = stderrors.New("queue in use by another red-goose process")
+
+// errLocked is how lockFile reports a lock that another process holds
+var errLocked = stderrors.New("locked")
+
+// Queue is a list of tasks stored as JSON lines. Every change appends the
+// task's new record, so a crash loses at most the line being written; the
+// file is compacted when it is opened. A lock file next to it keeps other
+// processes out while it is open; Read still lets them look.
+type Queue struct {
+	mu     sync.Mutex
+	path   string
+	lock   *os.File
+	file   *os.File
+	tasks  map[int]*Task
+	nextID int
+}
+
+// Open loads the queue stored at path, creating it if needed. Tasks that
+// were being worked on when the last run stopped are pending again. It
+// fails with ErrInUse while another process, such as "red-goose serve",
+// has the queue open.
+func Open(path string) (*Queue, error) {
+	if err := utils.EnsureDir(filepath.Dir(path)); err != nil {
+		return nil, errors.NewFileSystemError("failed to create queue directory", err)
+	}
+	lock, err := lockFile(path + ".lock")
+	if err == errLocked {
+		return nil, errors.NewFileSystemError(fmt.Sprintf("failed to open queue %s", path), ErrInUse)
+	}
+	if err != nil {
+		return nil, errors.NewFileSystemError("failed to lock queue", err)
+	}
+
+	q := &Queue{path: path, lock: lock, tasks: make(map[int]*Task), nextID: 1}
+	if err := q.load(); err != nil {
+		unlockFile(lock)
+		return nil, err
+	}
+	for _, t := range q.tasks {
+		if t.State.Working() {
+			t.State = StatePending
+		}
+	}
+	if err := q.rewrite(); err != nil {
+		q.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// Read returns the tasks stored at path, in the order they were added,
+// without opening the queue, so it works while another process has the
+// queue open. Records are appended whole and compaction replaces the file
+// in one rename, so a reader sees the queue as it was at some moment.
+func Read(path string) ([]Task, error) {
+	q := &Queue{path: path, tasks: make(map[int]*Task), nextID: 1}
+	if err := q.load(); err != nil {
+		return nil, err
+	}
+	return q.Tasks(), nil
+}
+
+// load reads the records of the queue file. The last record of a task wins;
+// lines that cannot be read, such as one cut short by a crash, are skipped.
+func (q *Queue) load() error {
+	f, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.NewFileSystemError("failed to open queue", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var t Task
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil || t.ID <= 0 {
+			continue
+		}
+		q.tasks[t.ID] = &t
+		if t.ID >= q.nextID {
+			q.nextID = t.ID + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return errors.NewFileSystemError("failed to read queue", err)
+	}
+	return nil
+}
+
+// rewrite replaces the queue file with one record per task and reopens it
+// for appending
+func (q *Queue) rewrite() error {
+	if q.file != nil {
+		q.file.Close()
+		q.file = nil
+	}
+
+	tmp := q.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, t := range q.sorted() {
+		if err := enc.Encode(t); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return errors.NewFileSystemError("failed to write queue", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		os.Remove(tmp)
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+
+	if q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return errors.NewFileSystemError("failed to open queue", err)
+	}
+	return nil
+}
+
+// sorted returns the tasks in the order they were added
+func (q *Queue) sorted() []*Task {
+	tasks := make([]*Task, 0, len(q.tasks))
+	for _, t := range q.tasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks
+}
+
+// save appends the current record of a task and flushes it to disk, so
+// that a task reported as queued survives a crash
+func (q *Queue) save(t *Task) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return errors.NewFileSystemError("failed to encode task", err)
+	}
+	if _, err := q.file.Write(append(data, '\n')); err != nil {
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+	if err := q.file.Sync(); err != nil {
+		return errors.NewFileSystemError("failed to write queue", err)
+	}
+	return nil
+}
+
+// Close closes the queue file and lets other processes open it
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var err error
+	if q.file != nil {
+		err = q.file.Close()
+		q.file = nil
+	}
+	if q.lock != nil {
+		unlockFile(q.lock)
+		q.lock = nil
+	}
+	return err
+}
+
+// Add queues a video as a pending task
+func (q *Queue) Add(item pipeline.Item, opts pipeline.Options, title string) (Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	t := &Task{
+		ID:      q.nextID,
+		Item:    item,
+		Options: opts,
+		Title:   title,
+		State:   StatePending,
+		Added:   now,
+		Updated: now,
+	}
+	if err := q.save(t); err != nil {
+		return Task{}, err
+	}
+	q.tasks[t.ID] = t
+	q.nextID++
+	return *t, nil
+}
+
+// Requeue makes a pending or failed task pending again with the item,
+// options and title of a new run of the same download, such as a playlist
+// downloaded again after an interrupted run
+func (q *Queue) Requeue(id int, item pipeline.Item, opts pipeline.Options, title string) (Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	t, ok := q.tasks[id]
+	if !ok {
+		return Task{}, errors.NewValidationError(fmt.Sprintf("no task %d in the queue", id), nil)
+	}
+	if t.State != StatePending && t.State != StateFailed {
+		return *t, errors.NewValidationError(fmt.Sprintf("task %d is %s", id, t.State), nil)
+	}
+	t.Item, t.Options, t.Title = item, opts, title
+	t.State, t.Error = StatePending, ""
+	t.Updated = time.Now()
+	if err := q.save(t); err != nil {
+		return Task{}, err
+	}
+	return *t, nil
+}
+
+// Tasks returns every task in the order they were added
+func (q *Queue) Tasks() []Task {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	tasks := make([]Task, 0, len(q.tasks))
+	for _, t := range q.sorted() {
+		tasks = append(tasks, *t)
+	}
+	return tasks
+}
+
+// Get returns the task with the given ID
+func (q *Queue) Get(id int) (Task, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	t, ok := q.tasks[id]
+	if !ok {
+		return Task{}, false
+	}
+	return *t, true
+}
+
+// update changes a task and saves its record
+func (q *Queue) update(id int, change func(t *Task)) (Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	t, ok := q.tasks[id]
+	if !ok {
+		return Task{}, errors.NewValidationError(fmt.Sprintf("no task %d in the queue", id), nil)
+	}
+	change(t)
+	t.Updated = time.Now()
+	if err := q.save(t); err != nil {
+		return Task{}, err
+	}
+	return *t, nil
+}
+
+// Retry makes failed or canceled tasks pending again: those with the given
+// IDs, or every failed task if none are given. It returns the IDs of the
+// tasks reset.
+func (q *Queue) Retry(ids ...int) ([]int, error) {
+	if len(ids) == 0 {
+		for _, t := range q.Tasks() {
+			if t.State == StateFailed {
+				ids = append(ids, t.ID)
+			}
+		}
+	}
+
+	var reset []int
+	for _, id := range ids {
+		t, ok := q.Get(id)
+		if !ok {
+			return reset, errors.NewValidationError(fmt.Sprintf("no task %d in the queue", id), nil)
+		}
+		if t.State != StateFailed && t.State != StateCanceled {
+			continue
+		}
+		if _, err := q.update(id, func(t *Task) {
+			t.State = StatePending
+			t.Error = ""
+		}); err != nil {
+			return reset, err
+		}
+		reset = append(reset, id)
+	}
+	return reset, nil
+}
+
+// Clear removes finished tasks, done, failed or canceled, or every task if
+// all is set. It returns how many were removed.
+func (q *Queue) Clear(all bool) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	removed := 0
+	for id, t := range q.tasks {
+		if all || t.State == StateDone || t.State == StateFailed || t.State == StateCanceled {
+			delete(q.tasks, id)
+			removed++
+		}
+	}
+	if len(q.tasks) == 0 {
+		q.nextID = 1
+	}
+	return removed, q.rewrite()
+}
+
+// Pause holds a pending task back until it is resumed
+func (q *Queue) Pause(id int) (Task, error) {
+	return q.transition(id, StatePaused, StatePending)
+}
+
+// Resume makes a paused task pending again
+func (q *Queue) Resume(id int) (Task, error) {
+	return q.transition(id, StatePending, StatePaused)
+}
+
+// Cancel gives up on a task that is not being worked on
+func (q *Queue) Cancel(id int) (Task, error) {
+	return q.transition(id, StateCanceled, StatePending, StatePaused)
+}
+
+// transition moves a task to a state if it is in one of the states from
+func (q *Queue) transition(id int, to State, from ...State) (Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	t, ok := q.tasks[id]
+	if !ok {
+		return Task{}, errors.NewValidationError(fmt.Sprintf("no task %d in the queue", id), nil)
+	}
+	for _, state := range from {
+		if t.State == state {
+			t.State = to
+			t.Updated = time.Now()
+			if err := q.save(t); err != nil {
+				return Task{}, err
+			}
+			return *t, nil
+		}
+	}
+	return *t, errors.NewValidationError(fmt.Sprintf("task %d is %s", id, t.State), nil)
+}
+
+// Output is what the download of a task wrote
+type Output struct {
+	Path  string
+	Parts []string
+}
+
+// Worker downloads a task, calling state as it moves through the working
+// states, and returns the files it wrote
+type Worker func(ctx context.Context, task Task, state func(State)) (Output, error)
+
+// RunOptions control how the tasks of a run are worked on
+type RunOptions struct {
+	// Workers is the number of tasks worked on at once
+	Workers int
+	// Stop, if set, is asked about each failure; returning true cancels the
+	// tasks in flight and starts no more
+	Stop func(err error) bool
+}
+
+// Run works on the pending tasks with the given IDs, or on every pending
+// task if ids is nil, recording each task's progress as it goes. Tasks that
+// are interrupted, by ctx or by a failure that stops the run, are pending
+// again afterwards. The error is the failure that stopped the run, the
+// context's error, or a summary of the tasks that failed.
+func (q *Queue) Run(ctx context.Context, ids []int, opts RunOptions, work Worker) error {
+	var tasks []Task
+	if ids == nil {
+		for _, t := range q.Tasks() {
+			if t.State == StatePending {
+				tasks = append(tasks, t)
+			}
+		}
+	} else {
+		for _, id := range ids {
+			if t, ok := q.Get(id); ok && t.State == StatePending {
+				tasks = append(tasks, t)
+			}
+		}
+	}
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var failed []error
+	var stopErr, saveErr error
+	record := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if saveErr == nil {
+			saveErr = err
+		}
+		cancel()
+	}
+
+	jobs := make(chan Task)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range jobs {
+				// Tasks handed out as the run stops stay pending
+				if runCtx.Err() != nil {
+					continue
+				}
+				err := q.runTask(runCtx, task, work)
+				if err == nil {
+					continue
+				}
+				if saved, ok := err.(saveError); ok {
+					record(saved.err)
+					continue
+				}
+				if runCtx.Err() != nil {
+					continue
+				}
+				mu.Lock()
+				failed = append(failed, err)
+				if stopErr == nil && opts.Stop != nil && opts.Stop(err) {
+					stopErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, task := range tasks {
+		select {
+		case jobs <- task:
+		case <-runCtx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	switch {
+	case saveErr != nil:
+		return saveErr
+	case stopErr != nil:
+		return stopErr
+	case ctx.Err() != nil:
+		return ctx.Err()
+	case len(failed) == len(tasks):
+		return errors.NewDownloadError("all downloads failed", failed[0])
+	case len(failed) > 0:
+		return errors.NewDownloadError(fmt.Sprintf("%d of %d downloads failed", len(failed), len(tasks)), failed[0])
+	}
+	return nil
+}
+
+// saveError marks a failure to write the queue, which ends the run
+type saveError struct{ err error }
+
+func (e saveError) Error() string { return e.err.Error() }
+
+// Work works on one pending task and records how it ended, like Run. It is
+// for callers that schedule tasks themselves.
+func (q *Queue) Work(ctx context.Context, id int, work Worker) error {
+	task, ok := q.Get(id)
+	if !ok {
+		return errors.NewValidationError(fmt.Sprintf("no task %d in the queue", id), nil)
+	}
+	if task.State != StatePending {
+		return errors.NewValidationError(fmt.Sprintf("task %d is %s", id, task.State), nil)
+	}
+	err := q.runTask(ctx, task, work)
+	if saved, ok := err.(saveError); ok {
+		return saved.err
+	}
+	return err
+}
+
+// runTask works on one task and records how it ended. A task canceled by
+// ctx goes back to pending.
+func (q *Queue) runTask(ctx context.Context, task Task, work Worker) error {
+	task, err := q.update(task.ID, func(t *Task) {
+		t.State = StateResolving
+		t.Error = ""
+		t.Attempts++
+	})
+	if err != nil {
+		return saveError{err}
+	}
+
+	ctx = logging.With(ctx, "task", task.ID, "task_attempt", task.Attempts)
+	log := logging.FromContext(ctx)
+	log.Debug("task started", "video_id", task.Item.VideoID)
+	output, workErr := work(ctx, task, func(s State) {
+		q.update(task.ID, func(t *Task) { t.State = s })
+	})
+	switch {
+	case workErr == nil:
+		log.Info("task done", "video_id", task.Item.VideoID, "path", output.Path)
+	case ctx.Err() != nil:
+		log.Info("task interrupted", "video_id", task.Item.VideoID)
+	default:
+		log.Error("task failed", "video_id", task.Item.VideoID, "error", workErr)
+	}
+
+	_, err = q.update(task.ID, func(t *Task) {
+		switch {
+		case workErr == nil:
+			t.State = StateDone
+			t.Path, t.Parts = output.Path, output.Parts
+		case ctx.Err() != nil:
+			t.State = StatePending
+		default:
+			t.State = StateFailed
+			t.Error = workErr.Error()
+		}
+	})
+	if err != nil {
+		return saveError{err}
+	}
+	return workErr
+}
@@ -0,0 +1,292 @@
+package queue
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/pipeline"
+)
+
+func openTestQueue(t *testing.T, path string, videos ...string) *Queue {
+	t.Helper()
+	q, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { q.Close() })
+	for _, v := range videos {
+		if _, err := q.Add(pipeline.Item{VideoID: v}, pipeline.Options{OutputDir: "out"}, "Video "+v); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	return q
+}
+
+func states(q *Queue) []State {
+	var s []State
+	for _, t := range q.Tasks() {
+		s = append(s, t.State)
+	}
+	return s
+}
+
+// worker finishes every video except those in fail
+func worker(fail ...string) Worker {
+	return func(ctx context.Context, task Task, state func(State)) (Output, error) {
+		state(StateDownloading)
+		for _, v := range fail {
+			if task.Item.VideoID == v {
+				return Output{}, fmt.Errorf("%s is broken", v)
+			}
+		}
+		return Output{Path: task.Item.VideoID + ".mp4"}, nil
+	}
+}
+
+func TestOpenResumesInterrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "queue.jsonl")
+	q := openTestQueue(t, path, "a", "b", "c")
+
+	// Simulate a run that died while downloading b, after finishing a
+	q.update(1, func(t *Task) { t.State = StateDone; t.Path = "a.mp4" })
+	q.update(2, func(t *Task) { t.State = StateDownloading; t.Attempts = 1 })
+	q.Close()
+
+	// A crash can also leave half a line behind
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"id":3,"state":"do`)
+	f.Close()
+
+	q = openTestQueue(t, path)
+	if got, want := states(q), []State{StateDone, StatePending, StatePending}; !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+	task, _ := q.Get(2)
+	if task.Title != "Video b" || task.Attempts != 1 || task.Options.OutputDir != "out" {
+		t.Errorf("task 2 = %+v", task)
+	}
+
+	// Opening compacts the file to one line per task
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines != 3 {
+		t.Errorf("queue file has %d lines, want 3", lines)
+	}
+
+	// New tasks continue the numbering
+	added, err := q.Add(pipeline.Item{VideoID: "d"}, pipeline.Options{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added.ID != 4 {
+		t.Errorf("new task ID = %d, want 4", added.ID)
+	}
+}
+
+func TestOpenLocked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := openTestQueue(t, path, "a")
+
+	// A second process, such as the CLI while the daemon runs, is kept out
+	if _, err := Open(path); !stderrors.Is(err, ErrInUse) {
+		t.Fatalf("Open of a queue in use = %v, want ErrInUse", err)
+	}
+
+	// It can still read the queue
+	if _, err := q.Pause(1); err != nil {
+		t.Fatal(err)
+	}
+	tasks, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tasks) != 1 || tasks[0].State != StatePaused {
+		t.Errorf("Read() of a queue in use = %+v, want the paused task", tasks)
+	}
+
+	q.Close()
+	q = openTestQueue(t, path)
+	if len(q.Tasks()) != 1 {
+		t.Errorf("reopened queue has %d tasks, want 1", len(q.Tasks()))
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.jsonl"), "a", "b")
+	q.update(1, func(t *Task) { t.State = StateFailed; t.Error = "a is broken"; t.Attempts = 1 })
+	q.update(2, func(t *Task) { t.State = StateDone })
+
+	item := pipeline.Item{VideoID: "a", Playlist: &pipeline.PlaylistRef{ID: "PL", Index: 2}}
+	task, err := q.Requeue(1, item, pipeline.Options{Quality: "720p"}, "Video a, moved")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.State != StatePending || task.Error != "" || task.Attempts != 1 ||
+		task.Item.Playlist.Index != 2 || task.Options.Quality != "720p" || task.Title != "Video a, moved" {
+		t.Errorf("requeued task = %+v", task)
+	}
+
+	if _, err := q.Requeue(2, pipeline.Item{VideoID: "b"}, pipeline.Options{}, ""); err == nil {
+		t.Error("Requeue of a done task succeeded, want an error")
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := openTestQueue(t, path, "a", "b", "c")
+
+	err := q.Run(context.Background(), nil, RunOptions{Workers: 2}, worker("b"))
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 downloads failed") {
+		t.Fatalf("Run error = %v, want 1 of 3 failed", err)
+	}
+	if got, want := states(q), []State{StateDone, StateFailed, StateDone}; !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+	failed, _ := q.Get(2)
+	if failed.Error != "b is broken" || failed.Attempts != 1 {
+		t.Errorf("failed task = %+v", failed)
+	}
+
+	// The outcome survives a restart
+	q.Close()
+	q = openTestQueue(t, path)
+	done, _ := q.Get(3)
+	if done.State != StateDone || done.Path != "c.mp4" {
+		t.Errorf("done task after reopening = %+v", done)
+	}
+
+	reset, err := q.Retry()
+	if err != nil || !reflect.DeepEqual(reset, []int{2}) {
+		t.Fatalf("Retry() = %v, %v", reset, err)
+	}
+	if err := q.Run(context.Background(), reset, RunOptions{}, worker()); err != nil {
+		t.Fatalf("Run after retry: %v", err)
+	}
+	retried, _ := q.Get(2)
+	if retried.State != StateDone || retried.Error != "" || retried.Attempts != 2 {
+		t.Errorf("retried task = %+v", retried)
+	}
+
+	if _, err := q.Retry(9); err == nil {
+		t.Error("expected an error retrying an unknown task")
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.jsonl"), "a", "b", "c")
+
+	err := q.Run(context.Background(), nil, RunOptions{
+		Workers: 1,
+		Stop:    func(error) bool { return true },
+	}, worker("a"))
+	if err == nil || err.Error() != "a is broken" {
+		t.Fatalf("Run error = %v, want the failure that stopped it", err)
+	}
+	if got, want := states(q), []State{StateFailed, StatePending, StatePending}; !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.jsonl"), "a", "b")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	err := q.Run(ctx, nil, RunOptions{Workers: 1}, func(ctx context.Context, task Task, state func(State)) (Output, error) {
+		if task.Item.VideoID == "b" {
+			cancel()
+			<-ctx.Done()
+			return Output{}, ctx.Err()
+		}
+		return Output{Path: "a.mp4"}, nil
+	})
+	if err != context.Canceled {
+		t.Fatalf("Run error = %v, want context.Canceled", err)
+	}
+	if got, want := states(q), []State{StateDone, StatePending}; !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+}
+
+func TestClear(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.jsonl")
+	q := openTestQueue(t, path, "a", "b", "c")
+	q.Run(context.Background(), []int{1, 2}, RunOptions{}, worker("b"))
+
+	removed, err := q.Clear(false)
+	if err != nil || removed != 2 {
+		t.Fatalf("Clear(false) = %d, %v; want 2 removed", removed, err)
+	}
+	if tasks := q.Tasks(); len(tasks) != 1 || tasks[0].ID != 3 {
+		t.Errorf("tasks after clearing = %+v", tasks)
+	}
+
+	q.Close()
+	q = openTestQueue(t, path)
+	if removed, err := q.Clear(true); err != nil || removed != 1 {
+		t.Fatalf("Clear(true) = %d, %v; want 1 removed", removed, err)
+	}
+	if len(q.Tasks()) != 0 {
+		t.Errorf("queue not empty after clearing all")
+	}
+}
+
+func TestPauseResumeCancel(t *testing.T) {
+	q := openTestQueue(t, filepath.Join(t.TempDir(), "queue.jsonl"), "a", "b")
+
+	if _, err := q.Pause(1); err != nil {
+		t.Fatalf("Pause: %v", err)
+	}
+	if _, err := q.Pause(1); err == nil {
+		t.Error("expected an error pausing a paused task")
+	}
+
+	// Runs skip paused tasks
+	if err := q.Run(context.Background(), nil, RunOptions{}, worker()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := states(q), []State{StatePaused, StateDone}; !reflect.DeepEqual(got, want) {
+		t.Errorf("states = %v, want %v", got, want)
+	}
+
+	if _, err := q.Resume(1); err != nil {
+		t.Fatalf("Resume: %v", err)
+	}
+	if _, err := q.Cancel(1); err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if err := q.Work(context.Background(), 1, worker()); err == nil {
+		t.Error("expected an error working on a canceled task")
+	}
+
+	// Canceled tasks can be retried by ID
+	if reset, err := q.Retry(1); err != nil || len(reset) != 1 {
+		t.Fatalf("Retry(1) = %v, %v", reset, err)
+	}
+	if err := q.Work(context.Background(), 1, worker()); err != nil {
+		t.Fatalf("Work: %v", err)
+	}
+	if task, _ := q.Get(1); task.State != StateDone {
+		t.Errorf("state after Work = %s, want done", task.State)
+	}
+}
+
+func TestParseState(t *testing.T) {
+	if s, err := ParseState("failed"); err != nil || s != StateFailed {
+		t.Errorf("ParseState(failed) = %q, %v", s, err)
+	}
+	if _, err := ParseState("lost"); err == nil {
+		t.Error("expected an error for an unknown state")
+	}
+}
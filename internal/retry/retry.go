@@ -0,0 +1,301 @@
+// Package retry runs operations again when they fail with errors worth
+// retrying. Backoff policies decide how long to wait between attempts, the
+// waits end as soon as the context is done, and a Budget shared by a batch
+// of downloads bounds how many retries all of them make together.
+package retry
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// Names of the policies that ParsePolicy knows
+const (
+	PolicyConstant     = "constant"
+	PolicyExponential  = "exponential"
+	PolicyDecorrelated = "decorrelated"
+)
+
+// ErrBudgetExhausted is wrapped in the error of an operation that was not
+// retried because its budget ran out
+var ErrBudgetExhausted = stderrors.New("retry budget exhausted")
+
+// Policy decides how long to wait before the next attempt
+type Policy interface {
+	// Delay returns the wait after the failed attempt, counted from 1.
+	// prev is the wait before that attempt, 0 after the first.
+	Delay(attempt int, prev time.Duration) time.Duration
+}
+
+// Constant waits the same interval between all attempts
+type Constant struct {
+	Interval time.Duration
+}
+
+func (c Constant) Delay(int, time.Duration) time.Duration {
+	return c.Interval
+}
+
+// Exponential doubles the wait after each attempt, up to Max, and waits a
+// random time between none and that ("full jitter"), so that downloads
+// that failed together don't all try again at the same moment
+type Exponential struct {
+	Initial time.Duration
+	// Max caps the wait; 0 leaves it uncapped
+	Max time.Duration
+}
+
+func (e Exponential) Delay(attempt int, _ time.Duration) time.Duration {
+	ceiling := e.Initial
+	for i := 1; i < attempt && (e.Max <= 0 || ceiling < e.Max); i++ {
+		ceiling *= 2
+	}
+	if e.Max > 0 {
+		ceiling = min(ceiling, e.Max)
+	}
+	return between(0, ceiling)
+}
+
+// Decorrelated waits a random time between Initial and three times the
+// previous wait, up to Max. The waits grow about as fast as Exponential's
+// but are spread more evenly.
+type Decorrelated struct {
+	Initial time.Duration
+	// Max caps the wait; 0 leaves it uncapped
+	Max time.Duration
+}
+
+func (d Decorrelated) Delay(_ int, prev time.Duration) time.Duration {
+	delay := between(d.Initial, max(prev, d.Initial)*3)
+	if d.Max > 0 {
+		delay = min(delay, d.Max)
+	}
+	return delay
+}
+
+// between returns a random duration from lo up to hi
+func between(lo, hi time.Duration) time.Duration {
+	if hi <= lo {
+		return lo
+	}
+	return lo + rand.N(hi-lo+1)
+}
+
+// ParsePolicy returns the policy of the given name that starts waiting
+// initial and waits at most max
+func ParsePolicy(name string, initial, max time.Duration) (Policy, error) {
+	switch strings.ToLower(name) {
+	case PolicyConstant:
+		return Constant{Interval: initial}, nil
+	case PolicyExponential, "":
+		return Exponential{Initial: initial, Max: max}, nil
+	case PolicyDecorrelated:
+		return Decorrelated{Initial: initial, Max: max}, nil
+	}
+	return nil, errors.NewConfigurationError(fmt.Sprintf("unknown retry policy %q (want %s, %s or %s)",
+		name, PolicyConstant, PolicyExponential, PolicyDecorrelated), nil)
+}
+
+// Attempt describes a failed attempt that is about to be retried
+type Attempt struct {
+	// Number counts the attempts from 1
+	Number int
+	Err    error
+	// Delay is the wait before the next attempt
+	Delay time.Duration
+	// Elapsed is the time since the first attempt started
+	Elapsed time.Duration
+}
+
+// Options control how Do retries
+type Options struct {
+	Policy Policy
+	// MaxAttempts bounds the number of attempts, the first included; 0 or
+	// less means a single attempt
+	MaxAttempts int
+	// MaxElapsed, if set, stops the retries once waiting for the next
+	// attempt would take longer than this since the first attempt
+	MaxElapsed time.Duration
+	// Retryable decides which errors are worth another attempt; it is
+	// errors.IsRetryableError if not set
+	Retryable func(error) bool
+	// OnRetry, if set, is called before each wait for another attempt
+	OnRetry func(Attempt)
+	// Budget, if set, is spent by the retries; otherwise the context's
+	// budget is
+	Budget *Budget
+}
+
+// Do runs op until it succeeds, fails with an error that is not worth
+// retrying, or runs out of attempts, time or budget. op gets the attempt
+// number, counted from 1. The wait before an attempt is at least the
+// Retry-After that the last error asked for, and ends early with the
+// context's error if ctx is done.
+func Do(ctx context.Context, opts Options, op func(ctx context.Context, attempt int) error) error {
+	retryable := opts.Retryable
+	if retryable == nil {
+		retryable = errors.IsRetryableError
+	}
+	budget := opts.Budget
+	if budget == nil {
+		budget = BudgetFrom(ctx)
+	}
+	policy := opts.Policy
+	if policy == nil {
+		policy = Constant{}
+	}
+
+	start := time.Now()
+	var delay time.Duration
+	for attempt := 1; ; attempt++ {
+		err := op(ctx, attempt)
+		if err == nil {
+			budget.succeed()
+			return nil
+		}
+		if ctx.Err() != nil || !retryable(err) {
+			return err
+		}
+		if attempt >= opts.MaxAttempts {
+			if attempt == 1 {
+				return err
+			}
+			return fmt.Errorf("operation failed after %d attempts: %w", attempt, err)
+		}
+
+		delay = policy.Delay(attempt, delay)
+		if after, ok := errors.RetryAfter(err); ok && after > delay {
+			delay = after
+		}
+		elapsed := time.Since(start)
+		if opts.MaxElapsed > 0 && elapsed+delay > opts.MaxElapsed {
+			return fmt.Errorf("operation failed after %d attempts in %s: %w", attempt, elapsed.Round(time.Millisecond), err)
+		}
+		if !budget.spend() {
+			return fmt.Errorf("%w after %d attempts: %w", ErrBudgetExhausted, attempt, err)
+		}
+		if opts.OnRetry != nil {
+			opts.OnRetry(Attempt{Number: attempt, Err: err, Delay: delay, Elapsed: elapsed})
+		}
+
+		if err := wait(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// wait sleeps for d, or until ctx is done
+func wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// refundRate is how many successes earn back a retry, so that a batch
+// which mostly works keeps retrying its odd failure while one that keeps
+// failing soon runs dry
+const refundRate = 10
+
+// Budget bounds the retries of the operations that share it, such as the
+// downloads of a batch. It is safe for concurrent use; a nil Budget is
+// unlimited.
+type Budget struct {
+	mu sync.Mutex
+	// credit and max count in fractions of a retry, 1/refundRate each
+	credit int
+	max    int
+}
+
+// NewBudget returns a budget of the given number of retries
+func NewBudget(retries int) *Budget {
+	return &Budget{credit: retries * refundRate, max: retries * refundRate}
+}
+
+// Remaining returns the number of retries left, or -1 for a nil budget
+func (b *Budget) Remaining() int {
+	if b == nil {
+		return -1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.credit / refundRate
+}
+
+// spend takes a retry from the budget, if one is left
+func (b *Budget) spend() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.credit < refundRate {
+		return false
+	}
+	b.credit -= refundRate
+	return true
+}
+
+// succeed earns back part of a retry
+func (b *Budget) succeed() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.credit = min(b.credit+1, b.max)
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context whose retries spend b
+func WithBudget(ctx context.Context, b *Budget) context.Context {
+	return context.WithValue(ctx, budgetKey{}, b)
+}
+
+// BudgetFrom returns the budget of ctx, or nil if it has none
+func BudgetFrom(ctx context.Context) *Budget {
+	b, _ := ctx.Value(budgetKey{}).(*Budget)
+	return b
+}
+
+type optionsKey struct{}
+
+// WithOptions returns a context whose retries use the policy and limits
+// that opts sets, in place of each caller's own
+func WithOptions(ctx context.Context, opts Options) context.Context {
+	return context.WithValue(ctx, optionsKey{}, opts)
+}
+
+// OptionsFrom returns defaults with the policy and limits that the context
+// sets put in
+func OptionsFrom(ctx context.Context, defaults Options) Options {
+	opts, ok := ctx.Value(optionsKey{}).(Options)
+	if !ok {
+		return defaults
+	}
+	if opts.Policy != nil {
+		defaults.Policy = opts.Policy
+	}
+	if opts.MaxAttempts > 0 {
+		defaults.MaxAttempts = opts.MaxAttempts
+	}
+	if opts.MaxElapsed > 0 {
+		defaults.MaxElapsed = opts.MaxElapsed
+	}
+	return defaults
+}
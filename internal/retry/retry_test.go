@@ -0,0 +1,185 @@
+package retry
+
+import (
+	"context"
+	stderrors "errors"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+func TestPolicies(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		attempt  int
+		prev     time.Duration
+		min, max time.Duration
+	}{
+		{"constant", Constant{Interval: time.Second}, 4, time.Second, time.Second, time.Second},
+		{"exponential first", Exponential{Initial: time.Second, Max: time.Minute}, 1, 0, 0, time.Second},
+		{"exponential third", Exponential{Initial: time.Second, Max: time.Minute}, 3, 0, 0, 4 * time.Second},
+		{"exponential capped", Exponential{Initial: time.Second, Max: 10 * time.Second}, 20, 0, 0, 10 * time.Second},
+		{"decorrelated first", Decorrelated{Initial: time.Second, Max: time.Minute}, 1, 0, time.Second, 3 * time.Second},
+		{"decorrelated grows", Decorrelated{Initial: time.Second, Max: time.Minute}, 2, 5 * time.Second, time.Second, 15 * time.Second},
+		{"decorrelated capped", Decorrelated{Initial: time.Second, Max: 2 * time.Second}, 5, time.Minute, time.Second, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for range 100 {
+				if d := tt.policy.Delay(tt.attempt, tt.prev); d < tt.min || d > tt.max {
+					t.Fatalf("Delay(%d, %s) = %s, want between %s and %s", tt.attempt, tt.prev, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Policy
+		wantErr bool
+	}{
+		{"", Exponential{Initial: time.Second, Max: time.Minute}, false},
+		{"Exponential", Exponential{Initial: time.Second, Max: time.Minute}, false},
+		{"decorrelated", Decorrelated{Initial: time.Second, Max: time.Minute}, false},
+		{"constant", Constant{Interval: time.Second}, false},
+		{"linear", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.name, time.Second, time.Minute)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParsePolicy(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDo(t *testing.T) {
+	errNetwork := errors.NewNetworkError("connection reset", nil)
+	errNotFound := &errors.RedGooseError{Type: errors.ErrorTypeDownload, StatusCode: 404}
+	tests := []struct {
+		name        string
+		opts        Options
+		failures    int
+		err         error
+		wantCalls   int
+		wantErr     bool
+		wantRetries int
+	}{
+		{"succeeds at once", Options{MaxAttempts: 3}, 0, errNetwork, 1, false, 0},
+		{"succeeds on retry", Options{MaxAttempts: 3}, 2, errNetwork, 3, false, 2},
+		{"runs out of attempts", Options{MaxAttempts: 3}, 5, errNetwork, 3, true, 2},
+		{"stops on client errors", Options{MaxAttempts: 3}, 5, errNotFound, 1, true, 0},
+		{"single attempt", Options{}, 5, errNetwork, 1, true, 0},
+		{"runs out of time", Options{MaxAttempts: 5, Policy: Constant{Interval: time.Hour}, MaxElapsed: time.Minute}, 5, errNetwork, 1, true, 0},
+		{"own classification", Options{MaxAttempts: 3, Retryable: func(error) bool { return true }}, 5, errNotFound, 3, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var retries []int
+			tt.opts.OnRetry = func(a Attempt) { retries = append(retries, a.Number) }
+			calls := 0
+			err := Do(context.Background(), tt.opts, func(ctx context.Context, attempt int) error {
+				calls++
+				if attempt != calls {
+					t.Errorf("attempt = %d on call %d", attempt, calls)
+				}
+				if calls <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Do() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !stderrors.Is(err, tt.err) {
+				t.Errorf("Do() error = %v, want it to wrap %v", err, tt.err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("ran %d times, want %d", calls, tt.wantCalls)
+			}
+			if len(retries) != tt.wantRetries {
+				t.Errorf("retried after attempts %v, want %d retries", retries, tt.wantRetries)
+			}
+		})
+	}
+}
+
+func TestDoCancelEndsWait(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	opts := Options{
+		MaxAttempts: 3,
+		Policy:      Constant{Interval: time.Millisecond},
+		// The server asks for a longer wait than the policy's
+		OnRetry: func(a Attempt) {
+			if a.Delay != time.Hour {
+				t.Errorf("delay = %s, want the hour of Retry-After", a.Delay)
+			}
+			cancel()
+		},
+	}
+	start := time.Now()
+	err := Do(ctx, opts, func(ctx context.Context, attempt int) error {
+		return &errors.RedGooseError{Type: errors.ErrorTypeNetwork, StatusCode: 429, RetryAfter: time.Hour}
+	})
+	if !stderrors.Is(err, context.Canceled) {
+		t.Errorf("Do() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Do() returned after %s, want it to stop waiting at once", elapsed)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	b := NewBudget(2)
+	ctx := WithBudget(context.Background(), b)
+	opts := Options{MaxAttempts: 5}
+	calls := 0
+	fail := func(ctx context.Context, attempt int) error {
+		calls++
+		return errors.NewNetworkError("network is unreachable", nil)
+	}
+
+	// The first operation spends the budget, the second gets no retries
+	for range 2 {
+		if err := Do(ctx, opts, fail); !stderrors.Is(err, ErrBudgetExhausted) {
+			t.Errorf("Do() error = %v, want ErrBudgetExhausted", err)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("ran %d times, want 4: three attempts, then one", calls)
+	}
+
+	// Successes earn the budget back, a tenth of a retry at a time
+	for range 10 {
+		Do(ctx, opts, func(ctx context.Context, attempt int) error { return nil })
+	}
+	if got := b.Remaining(); got != 1 {
+		t.Errorf("Remaining() = %d after 10 successes, want 1", got)
+	}
+	for range 100 {
+		Do(ctx, opts, func(ctx context.Context, attempt int) error { return nil })
+	}
+	if got := b.Remaining(); got != 2 {
+		t.Errorf("Remaining() = %d, want the budget to stop at 2", got)
+	}
+}
+
+func TestOptionsFrom(t *testing.T) {
+	defaults := Options{Policy: Constant{Interval: time.Second}, MaxAttempts: 3}
+	if got := OptionsFrom(context.Background(), defaults); got.Policy != defaults.Policy || got.MaxAttempts != 3 {
+		t.Errorf("OptionsFrom() without options = %+v, want the defaults", got)
+	}
+
+	ctx := WithOptions(context.Background(), Options{MaxAttempts: 5, MaxElapsed: time.Minute})
+	got := OptionsFrom(ctx, defaults)
+	if got.Policy != defaults.Policy || got.MaxAttempts != 5 || got.MaxElapsed != time.Minute {
+		t.Errorf("OptionsFrom() = %+v, want the default policy with the context's limits", got)
+	}
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+)
+
+// Event types sent to subscribers
+const (
+	// EventJob carries a job whose state changed
+	EventJob = "job"
+	// EventProgress carries the progress of a running job
+	EventProgress = "progress"
+	// EventLog carries a line a job logged
+	EventLog = "log"
+)
+
+// Event is something that happened to a job
+type Event struct {
+	Type     string    `json:"type"`
+	ID       int       `json:"id"`
+	Job      *Job      `json:"job,omitempty"`
+	Progress *Progress `json:"progress,omitempty"`
+	Line     string    `json:"line,omitempty"`
+}
+
+// subscriberBuffer is how many events a slow subscriber can fall behind
+// before events are dropped for it
+const subscriberBuffer = 256
+
+// hub fans events out to subscribers
+type hub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+func newHub() *hub {
+	return &hub{subs: make(map[chan Event]struct{})}
+}
+
+func (h *hub) subscribe() chan Event {
+	ch := make(chan Event, subscriberBuffer)
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *hub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// publish sends an event to every subscriber without waiting for any
+func (h *hub) publish(e Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// handleEvents streams events as Server-Sent Events, named by their type.
+// A job query parameter limits the stream to one job.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming is not supported"))
+		return
+	}
+	only := 0
+	if v := r.URL.Query().Get("job"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job ID %q", v))
+			return
+		}
+		only = id
+	}
+
+	events := s.events.subscribe()
+	defer s.events.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	// A comment opens the stream so clients know they are subscribed
+	fmt.Fprint(w, ": subscribed\n\n")
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-events:
+			if only != 0 && e.ID != only {
+				continue
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// maxLogLines is how many lines of a job's log are kept
+const maxLogLines = 1000
+
+// jobLog collects the lines a job writes, keeping the latest
+type jobLog struct {
+	mu      sync.Mutex
+	partial []byte
+	kept    []string
+	onLine  func(string)
+}
+
+func newJobLog(onLine func(string)) *jobLog {
+	return &jobLog{onLine: onLine}
+}
+
+// Write splits the output into lines
+func (l *jobLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	l.partial = append(l.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexAny(l.partial, "\r\n")
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			lines = append(lines, string(l.partial[:i]))
+		}
+		l.partial = l.partial[i+1:]
+	}
+	l.keep(lines)
+	l.mu.Unlock()
+
+	for _, line := range lines {
+		l.onLine(line)
+	}
+	return len(p), nil
+}
+
+// flush ends an unterminated last line
+func (l *jobLog) flush() {
+	l.mu.Lock()
+	if len(l.partial) == 0 {
+		l.mu.Unlock()
+		return
+	}
+	line := string(l.partial)
+	l.partial = nil
+	l.keep([]string{line})
+	l.mu.Unlock()
+	l.onLine(line)
+}
+
+// keep adds lines, dropping the oldest past the limit. The caller holds
+// l.mu.
+func (l *jobLog) keep(lines []string) {
+	l.kept = append(l.kept, lines...)
+	if over := len(l.kept) - maxLogLines; over > 0 {
+		l.kept = append(l.kept[:0], l.kept[over:]...)
+	}
+}
+
+func (l *jobLog) lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.kept...)
+}
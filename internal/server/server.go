@@ -0,0 +1,665 @@
+// Package server runs red-goose as a daemon that takes download jobs over a
+// local REST API. Jobs are tasks of the persistent download queue, so they
+// survive restarts of the daemon.
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/pipeline"
+	"github.com/MaVeN-13TTN/red_goose/internal/queue"
+	"github.com/MaVeN-13TTN/red_goose/pkg/youtube"
+)
+
+// Entry is a video a URL resolves to
+type Entry struct {
+	Item  pipeline.Item
+	Title string
+}
+
+// Resolver turns a submitted URL into the videos to download
+type Resolver interface {
+	Resolve(url string) ([]Entry, error)
+}
+
+// Report is how a running download reports back to the server
+type Report struct {
+	// Log receives the download's messages
+	Log io.Writer
+	// State is called as the download moves through its stages
+	State func(queue.State)
+	// Progress receives the bytes downloaded so far
+	Progress downloader.ProgressCallback
+}
+
+// DownloadFunc downloads a job and returns the path of the file it wrote
+type DownloadFunc func(ctx context.Context, task queue.Task, report Report) (string, error)
+
+// Options configure a server
+type Options struct {
+	// Workers is the number of jobs downloaded at once
+	Workers int
+	// Defaults are the download options of jobs that do not set their own
+	Defaults pipeline.Options
+	// Addr is the address the API listens on. Requests must name its host,
+	// localhost or a loopback address in their Host header.
+	Addr string
+}
+
+// Progress is how far a running job has got
+type Progress struct {
+	Downloaded   int64   `json:"downloaded"`
+	Total        int64   `json:"total"`
+	Speed        float64 `json:"speed"`
+	AverageSpeed float64 `json:"average_speed"`
+	// ETA is in seconds, 0 when it is not known
+	ETA float64 `json:"eta"`
+}
+
+// Job is a queued download as the API shows it
+type Job struct {
+	queue.Task
+	Progress *Progress `json:"progress,omitempty"`
+}
+
+// What happens to a running job once its download has stopped
+const (
+	afterNothing = iota
+	afterPause
+	afterCancel
+)
+
+// running is a job being downloaded
+type running struct {
+	cancel context.CancelFunc
+	after  int
+}
+
+// Server schedules the jobs of a queue and serves the API
+type Server struct {
+	queue    *queue.Queue
+	workers  int
+	defaults pipeline.Options
+	addr     string
+
+	// Resolver and Download default to YouTube and the download pipeline;
+	// tests replace them
+	Resolver Resolver
+	Download DownloadFunc
+
+	mu       sync.Mutex
+	running  map[int]*running
+	progress map[int]*Progress
+	logs     map[int]*jobLog
+	events   *hub
+	wake     chan struct{}
+	wg       sync.WaitGroup
+	done     chan struct{}
+}
+
+// New returns a server for the jobs of q
+func New(q *queue.Queue, opts Options) *Server {
+	workers := opts.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	return &Server{
+		queue:    q,
+		workers:  workers,
+		defaults: opts.Defaults,
+		addr:     opts.Addr,
+		Resolver: youtubeResolver{},
+		Download: downloadPipeline,
+		running:  make(map[int]*running),
+		progress: make(map[int]*Progress),
+		logs:     make(map[int]*jobLog),
+		events:   newHub(),
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Run downloads pending jobs until ctx is canceled, then stops the running
+// jobs, which stay pending for the next start, and waits for them
+func (s *Server) Run(ctx context.Context) {
+	defer close(s.done)
+	for {
+		s.dispatch(ctx)
+		select {
+		case <-s.wake:
+		case <-ctx.Done():
+			s.wg.Wait()
+			return
+		}
+	}
+}
+
+// poke makes Run look for jobs to start
+func (s *Server) poke() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch starts pending jobs, oldest first, while workers are free
+func (s *Server) dispatch(ctx context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if ctx.Err() != nil {
+		return
+	}
+	for _, t := range s.queue.Tasks() {
+		if len(s.running) >= s.workers {
+			return
+		}
+		if t.State == queue.StatePending && s.running[t.ID] == nil {
+			s.start(ctx, t.ID)
+		}
+	}
+}
+
+// start downloads a job in the background. The caller holds s.mu.
+func (s *Server) start(ctx context.Context, id int) {
+	jobCtx, cancel := context.WithCancel(ctx)
+	r := &running{cancel: cancel}
+	s.running[id] = r
+	log := s.jobLog(id)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+
+		err := s.queue.Work(jobCtx, id, func(ctx context.Context, task queue.Task, state func(queue.State)) (queue.Output, error) {
+			s.publishJob(id)
+			path, err := s.Download(ctx, task, Report{
+				Log: log,
+				State: func(st queue.State) {
+					state(st)
+					s.publishJob(id)
+				},
+				Progress: func(p downloader.Progress) {
+					s.setProgress(id, &Progress{
+						Downloaded:   p.Downloaded,
+						Total:        p.Total,
+						Speed:        p.Speed,
+						AverageSpeed: p.AverageSpeed,
+						ETA:          p.ETA.Seconds(),
+					})
+				},
+			})
+			return queue.Output{Path: path}, err
+		})
+		if err != nil && jobCtx.Err() == nil {
+			fmt.Fprintf(log, "Error: %v\n", err)
+		}
+		log.flush()
+
+		s.mu.Lock()
+		switch r.after {
+		case afterPause:
+			s.queue.Pause(id)
+		case afterCancel:
+			s.queue.Cancel(id)
+		}
+		delete(s.running, id)
+		delete(s.progress, id)
+		s.mu.Unlock()
+
+		s.publishJob(id)
+		s.poke()
+	}()
+}
+
+func (s *Server) setProgress(id int, p *Progress) {
+	s.mu.Lock()
+	s.progress[id] = p
+	s.mu.Unlock()
+	s.events.publish(Event{Type: EventProgress, ID: id, Progress: p})
+}
+
+// job returns the API view of a task
+func (s *Server) job(id int) (Job, bool) {
+	task, ok := s.queue.Get(id)
+	if !ok {
+		return Job{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job := Job{Task: task}
+	if p := s.progress[id]; p != nil {
+		copied := *p
+		job.Progress = &copied
+	}
+	return job, true
+}
+
+func (s *Server) publishJob(id int) {
+	if job, ok := s.job(id); ok {
+		s.events.publish(Event{Type: EventJob, ID: id, Job: &job})
+	}
+}
+
+// jobLog returns the log of a job, creating it if needed. The caller holds
+// s.mu.
+func (s *Server) jobLog(id int) *jobLog {
+	log, ok := s.logs[id]
+	if !ok {
+		log = newJobLog(func(line string) {
+			s.events.publish(Event{Type: EventLog, ID: id, Line: line})
+		})
+		s.logs[id] = log
+	}
+	return log
+}
+
+// Handler returns the API:
+//
+//	POST /api/jobs               submit a URL, with optional download options
+//	GET  /api/jobs               list jobs
+//	GET  /api/jobs/{id}          show a job
+//	GET  /api/jobs/{id}/logs     the job's messages as text
+//	POST /api/jobs/{id}/pause    hold a job back, stopping it if running
+//	POST /api/jobs/{id}/resume   queue a paused job again
+//	POST /api/jobs/{id}/cancel   give up on a job
+//	POST /api/jobs/{id}/retry    queue a failed or canceled job again
+//	GET  /api/events             job, progress and log events as SSE
+//
+// Everything else is the dashboard.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/jobs", s.handleSubmit)
+	mux.HandleFunc("GET /api/jobs", s.handleList)
+	mux.HandleFunc("GET /api/jobs/{id}", s.withJob(s.handleGet))
+	mux.HandleFunc("GET /api/jobs/{id}/logs", s.withJob(s.handleLogs))
+	mux.HandleFunc("POST /api/jobs/{id}/pause", s.withJob(s.handlePause))
+	mux.HandleFunc("POST /api/jobs/{id}/resume", s.withJob(s.handleResume))
+	mux.HandleFunc("POST /api/jobs/{id}/cancel", s.withJob(s.handleCancel))
+	mux.HandleFunc("POST /api/jobs/{id}/retry", s.withJob(s.handleRetry))
+	mux.HandleFunc("GET /api/events", s.handleEvents)
+	mux.Handle("GET /", uiHandler())
+	return localHost(s.addr, sameOrigin(mux))
+}
+
+// localHost rejects requests whose Host header names neither the listen
+// address nor the loopback interface. A page that points its own domain at
+// 127.0.0.1 (DNS rebinding) is same-origin with itself, but still sends its
+// domain as the Host.
+func localHost(addr string, next http.Handler) http.Handler {
+	listenHost, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		listenHost = addr
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+		if !isLocalHost(host, listenHost) {
+			writeError(w, http.StatusForbidden, fmt.Errorf("host %q is not allowed", r.Host))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLocalHost reports whether host is the listen host, localhost or a
+// loopback address
+func isLocalHost(host, listenHost string) bool {
+	switch {
+	case host == "":
+		return false
+	case strings.EqualFold(host, "localhost"), listenHost != "" && strings.EqualFold(host, listenHost):
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameOrigin rejects requests that a browser sends on behalf of a page of
+// another site, so that web pages can't drive the daemon
+func sameOrigin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || u.Host != r.Host {
+				writeError(w, http.StatusForbidden, fmt.Errorf("cross-origin requests are not allowed"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// submitRequest is the body of POST /api/jobs
+type submitRequest struct {
+	URL     string          `json:"url"`
+	Options json.RawMessage `json:"options,omitempty"`
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	// Browsers send forms and text/plain across sites without asking, but
+	// not JSON
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		writeError(w, http.StatusUnsupportedMediaType, fmt.Errorf("Content-Type must be application/json"))
+		return
+	}
+	var req submitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if req.URL == "" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("url is required"))
+		return
+	}
+	opts, err := s.jobOptions(req.Options)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	entries, err := s.Resolver.Resolve(req.URL)
+	if err != nil {
+		writeError(w, statusOf(err, http.StatusBadGateway), err)
+		return
+	}
+
+	jobs := []Job{}
+	for _, e := range entries {
+		task, err := s.queue.Add(e.Item, opts, e.Title)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		jobs = append(jobs, Job{Task: task})
+		s.publishJob(task.ID)
+	}
+	s.poke()
+	writeJSON(w, http.StatusCreated, jobs)
+}
+
+// jobRequestOptions are the download options a job may set through the
+// API. Paths, endpoints and hooks are left out: they would let any client
+// write files anywhere, fetch any URL or run commands, so they only come
+// from the server's own configuration.
+type jobRequestOptions struct {
+	Quality   string `json:"quality"`
+	AudioOnly bool   `json:"audio_only"`
+
+	WriteSubs     bool     `json:"write_subs"`
+	WriteAutoSubs bool     `json:"write_auto_subs"`
+	SubLangs      []string `json:"sub_langs"`
+	SubFormat     string   `json:"sub_format"`
+
+	WriteThumbnail     bool   `json:"write_thumbnail"`
+	WriteAllThumbnails bool   `json:"write_all_thumbnails"`
+	ConvertThumbnails  string `json:"convert_thumbnails"`
+
+	WriteInfoJSON    bool `json:"write_info_json"`
+	WriteDescription bool `json:"write_description"`
+	WriteNFO         bool `json:"write_nfo"`
+	NFOSeason        int  `json:"nfo_season"`
+
+	EmbedMetadata bool `json:"embed_metadata"`
+	EmbedChapters bool `json:"embed_chapters"`
+	EmbedSubs     bool `json:"embed_subs"`
+	SplitChapters bool `json:"split_chapters"`
+
+	SponsorBlockMark   []string `json:"sponsorblock_mark"`
+	SponsorBlockRemove []string `json:"sponsorblock_remove"`
+
+	Sections []downloader.Section `json:"sections"`
+}
+
+// jobOptions applies the options of a request over the server's defaults.
+// Options the API doesn't take are rejected.
+func (s *Server) jobOptions(raw json.RawMessage) (pipeline.Options, error) {
+	opts := s.defaults
+	if len(raw) == 0 {
+		return opts, nil
+	}
+	req := jobRequestOptions{
+		Quality:            opts.Quality,
+		AudioOnly:          opts.AudioOnly,
+		WriteSubs:          opts.WriteSubs,
+		WriteAutoSubs:      opts.WriteAutoSubs,
+		SubLangs:           opts.SubLangs,
+		SubFormat:          opts.SubFormat,
+		WriteThumbnail:     opts.WriteThumbnail,
+		WriteAllThumbnails: opts.WriteAllThumbnails,
+		ConvertThumbnails:  opts.ConvertThumbnails,
+		WriteInfoJSON:      opts.WriteInfoJSON,
+		WriteDescription:   opts.WriteDescription,
+		WriteNFO:           opts.WriteNFO,
+		NFOSeason:          opts.NFOSeason,
+		EmbedMetadata:      opts.EmbedMetadata,
+		EmbedChapters:      opts.EmbedChapters,
+		EmbedSubs:          opts.EmbedSubs,
+		SplitChapters:      opts.SplitChapters,
+		SponsorBlockMark:   opts.SponsorBlockMark,
+		SponsorBlockRemove: opts.SponsorBlockRemove,
+		Sections:           opts.Sections,
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return opts, errors.NewValidationError("invalid options", err)
+	}
+
+	opts.Quality, opts.AudioOnly = req.Quality, req.AudioOnly
+	opts.WriteSubs, opts.WriteAutoSubs, opts.SubLangs, opts.SubFormat = req.WriteSubs, req.WriteAutoSubs, req.SubLangs, req.SubFormat
+	opts.WriteThumbnail, opts.WriteAllThumbnails, opts.ConvertThumbnails = req.WriteThumbnail, req.WriteAllThumbnails, req.ConvertThumbnails
+	opts.WriteInfoJSON, opts.WriteDescription, opts.WriteNFO, opts.NFOSeason = req.WriteInfoJSON, req.WriteDescription, req.WriteNFO, req.NFOSeason
+	opts.EmbedMetadata, opts.EmbedChapters, opts.EmbedSubs, opts.SplitChapters = req.EmbedMetadata, req.EmbedChapters, req.EmbedSubs, req.SplitChapters
+	opts.SponsorBlockMark, opts.SponsorBlockRemove = req.SponsorBlockMark, req.SponsorBlockRemove
+	opts.Sections = req.Sections
+	// However the request was decoded, hooks are the server's own
+	opts.Hooks = s.defaults.Hooks
+	if err := opts.Validate(); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	var filter queue.State
+	if state := r.URL.Query().Get("state"); state != "" {
+		var err error
+		if filter, err = queue.ParseState(state); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	jobs := []Job{}
+	for _, t := range s.queue.Tasks() {
+		if filter != "" && t.State != filter {
+			continue
+		}
+		if job, ok := s.job(t.ID); ok {
+			jobs = append(jobs, job)
+		}
+	}
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// withJob resolves the {id} of a request to an existing job
+func (s *Server) withJob(handle func(w http.ResponseWriter, r *http.Request, id int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := strconv.Atoi(r.PathValue("id"))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job ID %q", r.PathValue("id")))
+			return
+		}
+		if _, ok := s.queue.Get(id); !ok {
+			writeError(w, http.StatusNotFound, fmt.Errorf("no job %d", id))
+			return
+		}
+		handle(w, r, id)
+	}
+}
+
+func (s *Server) handleGet(w http.ResponseWriter, r *http.Request, id int) {
+	job, _ := s.job(id)
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleLogs(w http.ResponseWriter, r *http.Request, id int) {
+	s.mu.Lock()
+	log := s.logs[id]
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if log == nil {
+		return
+	}
+	for _, line := range log.lines() {
+		fmt.Fprintln(w, line)
+	}
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request, id int) {
+	s.stopOrChange(w, id, afterPause, s.queue.Pause)
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request, id int) {
+	s.stopOrChange(w, id, afterCancel, s.queue.Cancel)
+}
+
+// stopOrChange stops a running job, leaving it in the state after sets once
+// its download has ended, or changes the state of a waiting job
+func (s *Server) stopOrChange(w http.ResponseWriter, id, after int, change func(int) (queue.Task, error)) {
+	s.mu.Lock()
+	if r := s.running[id]; r != nil {
+		r.after = after
+		r.cancel()
+		s.mu.Unlock()
+		job, _ := s.job(id)
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+	_, err := change(id)
+	s.mu.Unlock()
+	s.respondChange(w, id, err)
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request, id int) {
+	_, err := s.queue.Resume(id)
+	s.respondChange(w, id, err)
+}
+
+func (s *Server) handleRetry(w http.ResponseWriter, r *http.Request, id int) {
+	reset, err := s.queue.Retry(id)
+	if err == nil && len(reset) == 0 {
+		task, _ := s.queue.Get(id)
+		err = errors.NewValidationError(fmt.Sprintf("job %d is %s", id, task.State), nil)
+	}
+	s.respondChange(w, id, err)
+}
+
+// respondChange answers a state change, which fails with a conflict when
+// the job is not in a state it applies to
+func (s *Server) respondChange(w http.ResponseWriter, id int, err error) {
+	if err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	s.publishJob(id)
+	s.poke()
+	job, _ := s.job(id)
+	writeJSON(w, http.StatusOK, job)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// statusOf answers invalid input with 400 and other errors with fallback
+func statusOf(err error, fallback int) int {
+	if rgErr, ok := err.(*errors.RedGooseError); ok && rgErr.Type == errors.ErrorTypeValidation {
+		return http.StatusBadRequest
+	}
+	return fallback
+}
+
+// youtubeResolver resolves video and playlist URLs
+type youtubeResolver struct{}
+
+func (youtubeResolver) Resolve(url string) ([]Entry, error) {
+	if youtube.IsPlaylistURL(url) {
+		id := youtube.ExtractPlaylistID(url)
+		if id == "" {
+			return nil, errors.NewValidationError("could not extract playlist ID", nil)
+		}
+		playlist, err := extractor.New().GetPlaylistDetails(id)
+		if err != nil {
+			return nil, errors.NewExtractionError("failed to get playlist info", err)
+		}
+		var entries []Entry
+		for i, video := range playlist.Videos {
+			entries = append(entries, Entry{
+				Item: pipeline.Item{
+					VideoID: video.ID,
+					Playlist: &pipeline.PlaylistRef{
+						ID:    playlist.ID,
+						Title: playlist.Title,
+						Index: i + 1,
+						Total: len(playlist.Videos),
+					},
+				},
+				Title: video.Title,
+			})
+		}
+		return entries, nil
+	}
+
+	video, err := youtube.ParseURL(url)
+	if err != nil {
+		return nil, errors.NewValidationError("failed to parse URL", err)
+	}
+	return []Entry{{Item: pipeline.Item{VideoID: video.ID}}}, nil
+}
+
+// downloadPipeline downloads a job with the download pipeline
+func downloadPipeline(ctx context.Context, task queue.Task, report Report) (string, error) {
+	p, err := pipeline.New(task.Options)
+	if err != nil {
+		return "", err
+	}
+	p.SetOutput(report.Log, report.Log)
+	p.Progress = report.Progress
+
+	result, err := p.Run(ctx, task.Item, func(stage pipeline.Stage) {
+		report.State(queue.State(stage))
+	})
+	if err != nil {
+		return "", err
+	}
+	if !result.Skipped {
+		fmt.Fprintf(report.Log, "Saved %s\n", result.Path)
+	}
+	return result.Path, nil
+}
@@ -0,0 +1,375 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/downloader"
+	"github.com/MaVeN-13TTN/red_goose/internal/pipeline"
+	"github.com/MaVeN-13TTN/red_goose/internal/queue"
+)
+
+// fakeResolver resolves "video:ID" to one video and "playlist:A,B" to a
+// playlist of videos
+type fakeResolver struct{}
+
+func (fakeResolver) Resolve(url string) ([]Entry, error) {
+	kind, ids, _ := strings.Cut(url, ":")
+	switch kind {
+	case "video":
+		return []Entry{{Item: pipeline.Item{VideoID: ids}, Title: "Video " + ids}}, nil
+	case "playlist":
+		var entries []Entry
+		list := strings.Split(ids, ",")
+		for i, id := range list {
+			entries = append(entries, Entry{
+				Item:  pipeline.Item{VideoID: id, Playlist: &pipeline.PlaylistRef{ID: "PL", Title: "List", Index: i + 1, Total: len(list)}},
+				Title: "Video " + id,
+			})
+		}
+		return entries, nil
+	}
+	return nil, fmt.Errorf("cannot resolve %q", url)
+}
+
+// fakeDownload finishes videos at once, fails "bad" and blocks on "slow"
+// until it is stopped
+func fakeDownload(ctx context.Context, task queue.Task, report Report) (string, error) {
+	report.State(queue.StateDownloading)
+	fmt.Fprintf(report.Log, "downloading %s\n", task.Item.VideoID)
+	report.Progress(downloader.Progress{Downloaded: 50, Total: 100, Speed: 10})
+	switch task.Item.VideoID {
+	case "bad":
+		return "", fmt.Errorf("video unavailable")
+	case "slow":
+		<-ctx.Done()
+		return "", ctx.Err()
+	}
+	return task.Item.VideoID + ".mp4", nil
+}
+
+func newTestServer(t *testing.T) (*Server, *httptest.Server) {
+	t.Helper()
+	q, err := queue.Open(filepath.Join(t.TempDir(), "queue.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(q, Options{Workers: 2, Defaults: pipeline.Options{OutputDir: "downloads", Quality: "best"}, Addr: "media.lan:8080"})
+	s.Resolver = fakeResolver{}
+	s.Download = fakeDownload
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go s.Run(ctx)
+	ts := httptest.NewServer(s.Handler())
+	t.Cleanup(func() {
+		ts.Close()
+		cancel()
+		<-s.done
+		q.Close()
+	})
+	return s, ts
+}
+
+func do(t *testing.T, method, url, body string, out interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// waitState polls a job until it reaches the state
+func waitState(t *testing.T, base string, id int, want queue.State) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var job Job
+		do(t, "GET", fmt.Sprintf("%s/api/jobs/%d", base, id), "", &job)
+		if job.State == want {
+			return job
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %d is %s, want %s", id, job.State, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmitJobs(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	var jobs []Job
+	status := do(t, "POST", ts.URL+"/api/jobs", `{"url":"playlist:a,bad","options":{"quality":"720p"}}`, &jobs)
+	if status != http.StatusCreated || len(jobs) != 2 {
+		t.Fatalf("submit = %d with %d jobs, want 201 with 2", status, len(jobs))
+	}
+	if opts := jobs[0].Options; opts.Quality != "720p" || opts.OutputDir != "downloads" {
+		t.Errorf("job options = %+v, want the request's quality over the defaults", opts)
+	}
+
+	done := waitState(t, ts.URL, jobs[0].ID, queue.StateDone)
+	if done.Path != "a.mp4" || done.Progress != nil {
+		t.Errorf("finished job = %+v", done)
+	}
+	failed := waitState(t, ts.URL, jobs[1].ID, queue.StateFailed)
+	if failed.Error != "video unavailable" {
+		t.Errorf("failed job error = %q", failed.Error)
+	}
+
+	var listed []Job
+	do(t, "GET", ts.URL+"/api/jobs?state=failed", "", &listed)
+	if len(listed) != 1 || listed[0].ID != jobs[1].ID {
+		t.Errorf("failed jobs = %+v", listed)
+	}
+
+	resp, err := http.Get(fmt.Sprintf("%s/api/jobs/%d/logs", ts.URL, jobs[1].ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	logs, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if want := "downloading bad\nError: video unavailable\n"; string(logs) != want {
+		t.Errorf("logs = %q, want %q", logs, want)
+	}
+
+	// A failed job can be retried
+	var retried Job
+	if status := do(t, "POST", fmt.Sprintf("%s/api/jobs/%d/retry", ts.URL, jobs[1].ID), "", &retried); status != http.StatusOK {
+		t.Errorf("retry = %d, want 200", status)
+	}
+	if job := waitState(t, ts.URL, jobs[1].ID, queue.StateFailed); job.Attempts != 2 {
+		t.Errorf("attempts after retry = %d, want 2", job.Attempts)
+	}
+}
+
+func TestPauseResumeCancel(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	var jobs []Job
+	do(t, "POST", ts.URL+"/api/jobs", `{"url":"video:slow"}`, &jobs)
+	id := jobs[0].ID
+	jobURL := fmt.Sprintf("%s/api/jobs/%d", ts.URL, id)
+
+	running := waitState(t, ts.URL, id, queue.StateDownloading)
+	if running.Progress == nil || running.Progress.Downloaded != 50 {
+		t.Errorf("running job progress = %+v", running.Progress)
+	}
+
+	if status := do(t, "POST", jobURL+"/pause", "", nil); status != http.StatusAccepted {
+		t.Errorf("pausing a running job = %d, want 202", status)
+	}
+	waitState(t, ts.URL, id, queue.StatePaused)
+	if status := do(t, "POST", jobURL+"/pause", "", nil); status != http.StatusConflict {
+		t.Errorf("pausing a paused job = %d, want 409", status)
+	}
+
+	if status := do(t, "POST", jobURL+"/resume", "", nil); status != http.StatusOK {
+		t.Errorf("resume = %d, want 200", status)
+	}
+	waitState(t, ts.URL, id, queue.StateDownloading)
+
+	do(t, "POST", jobURL+"/cancel", "", nil)
+	canceled := waitState(t, ts.URL, id, queue.StateCanceled)
+	if canceled.Attempts != 2 {
+		t.Errorf("attempts = %d, want 2", canceled.Attempts)
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"invalid body", "POST", "/api/jobs", `{`, http.StatusBadRequest},
+		{"missing url", "POST", "/api/jobs", `{}`, http.StatusBadRequest},
+		{"hooks", "POST", "/api/jobs", `{"url":"video:a","options":{"hooks":[{"stage":"after-download","command":"rm -rf ~"}]}}`, http.StatusBadRequest},
+		{"hooks in other case", "POST", "/api/jobs", `{"url":"video:a","options":{"Hooks":[{"stage":"after-download","command":"rm -rf ~"}]}}`, http.StatusBadRequest},
+		{"output dir", "POST", "/api/jobs", `{"url":"video:a","options":{"output_dir":"/etc"}}`, http.StatusBadRequest},
+		{"naming pattern", "POST", "/api/jobs", `{"url":"video:a","options":{"naming_pattern":"../../.bashrc"}}`, http.StatusBadRequest},
+		{"download archive", "POST", "/api/jobs", `{"url":"video:a","options":{"download_archive":"/home/user/.profile"}}`, http.StatusBadRequest},
+		{"sponsorblock api", "POST", "/api/jobs", `{"url":"video:a","options":{"sponsorblock_api":"http://169.254.169.254"}}`, http.StatusBadRequest},
+		{"invalid options", "POST", "/api/jobs", `{"url":"video:a","options":{"convert_thumbnails":"gif"}}`, http.StatusBadRequest},
+		{"unresolvable url", "POST", "/api/jobs", `{"url":"nothing"}`, http.StatusBadGateway},
+		{"unknown job", "GET", "/api/jobs/99", "", http.StatusNotFound},
+		{"invalid job ID", "POST", "/api/jobs/x/cancel", "", http.StatusBadRequest},
+		{"unknown state", "GET", "/api/jobs?state=lost", "", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]string
+			if got := do(t, tt.method, ts.URL+tt.path, tt.body, &body); got != tt.want {
+				t.Errorf("status = %d, want %d", got, tt.want)
+			}
+			if body["error"] == "" {
+				t.Error("response has no error message")
+			}
+		})
+	}
+}
+
+func TestCrossSiteRequests(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	tests := []struct {
+		name        string
+		contentType string
+		host        string
+		origin      string
+		want        int
+	}{
+		{"plain text", "text/plain", "", "", http.StatusUnsupportedMediaType},
+		{"form", "application/x-www-form-urlencoded", "", "", http.StatusUnsupportedMediaType},
+		{"other origin", "application/json", "", "http://evil.example", http.StatusForbidden},
+		{"null origin", "application/json", "", "null", http.StatusForbidden},
+		{"same origin", "application/json; charset=utf-8", "", ts.URL, http.StatusCreated},
+		{"rebound domain", "application/json", "evil.example:8080", "http://evil.example:8080", http.StatusForbidden},
+		{"other host", "application/json", "evil.example", "", http.StatusForbidden},
+		{"localhost", "application/json", "localhost:8080", "http://localhost:8080", http.StatusCreated},
+		{"listen address", "application/json", "media.lan:8080", "", http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest("POST", ts.URL+"/api/jobs", strings.NewReader(`{"url":"video:a"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			req.Header.Set("Content-Type", tt.contentType)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.want {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvents(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	resp, err := http.Get(ts.URL + "/api/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+	reader := bufio.NewReader(resp.Body)
+	// Wait for the stream to open before submitting
+	if line, err := reader.ReadString('\n'); err != nil || !strings.HasPrefix(line, ":") {
+		t.Fatalf("first line = %q, %v", line, err)
+	}
+
+	var jobs []Job
+	do(t, "POST", ts.URL+"/api/jobs", `{"url":"video:a"}`, &jobs)
+
+	seen := make(map[string]bool)
+	var name string
+	for !seen["done"] {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("reading events: %v (seen %v)", err, seen)
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var e Event
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &e); err != nil {
+				t.Fatalf("bad event data %q: %v", line, err)
+			}
+			if e.Type != name || e.ID != jobs[0].ID {
+				t.Errorf("event %q = %+v", name, e)
+			}
+			seen[e.Type] = true
+			if e.Type == EventJob && e.Job.State == queue.StateDone {
+				seen["done"] = true
+			}
+		}
+	}
+	for _, want := range []string{EventJob, EventProgress, EventLog} {
+		if !seen[want] {
+			t.Errorf("no %s event", want)
+		}
+	}
+}
+
+func TestJobLog(t *testing.T) {
+	var published []string
+	log := newJobLog(func(line string) { published = append(published, line) })
+
+	fmt.Fprint(log, "one\ntw")
+	fmt.Fprint(log, "o\r\nthree")
+	log.flush()
+
+	want := []string{"one", "two", "three"}
+	if got := log.lines(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("lines = %q, want %q", got, want)
+	}
+	if strings.Join(published, "|") != strings.Join(want, "|") {
+		t.Errorf("published = %q, want %q", published, want)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	_, ts := newTestServer(t)
+
+	for path, want := range map[string]string{
+		"/":          "text/html",
+		"/app.js":    "javascript",
+		"/style.css": "text/css",
+	} {
+		resp, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("Content-Type"), want) {
+			t.Errorf("GET %s = %d %s, want 200 %s", path, resp.StatusCode, resp.Header.Get("Content-Type"), want)
+		}
+		// Everything is served locally so the dashboard works offline
+		if strings.Contains(string(body), "http://") || strings.Contains(string(body), "https://") {
+			t.Errorf("%s refers to another host", path)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+"use strict";
+
+// The dashboard lists the jobs once and then follows the event stream.
+
+const rows = new Map();
+const tbody = document.getElementById("jobs");
+const template = document.getElementById("row");
+
+// Actions offered in each state
+const actions = {
+  pending: ["pause", "cancel"],
+  resolving: ["pause", "cancel"],
+  downloading: ["pause", "cancel"],
+  postprocessing: ["pause", "cancel"],
+  paused: ["resume", "cancel"],
+  failed: ["retry"],
+  canceled: ["retry"],
+  done: [],
+};
+
+function formatBytes(n) {
+  const units = ["B", "KB", "MB", "GB", "TB"];
+  let i = 0;
+  while (n >= 1024 && i < units.length - 1) {
+    n /= 1024;
+    i++;
+  }
+  return n.toFixed(i === 0 ? 0 : 1) + " " + units[i];
+}
+
+function rowFor(id) {
+  let row = rows.get(id);
+  if (!row) {
+    row = template.content.firstElementChild.cloneNode(true);
+    row.querySelector(".id").textContent = id;
+    row.querySelector(".logs").href = "api/jobs/" + id + "/logs";
+    row.querySelectorAll("button").forEach((button) => {
+      button.addEventListener("click", () => act(id, button.dataset.action));
+    });
+    rows.set(id, row);
+    tbody.prepend(row);
+    document.getElementById("empty").hidden = true;
+  }
+  return row;
+}
+
+function renderJob(job) {
+  const row = rowFor(job.id);
+  row.className = job.state;
+
+  let name = job.title || job.item.video_id;
+  if (job.item.playlist) {
+    name = job.item.playlist.title + " #" + job.item.playlist.index + ": " + name;
+  }
+  row.querySelector(".name").textContent = name;
+  row.querySelector(".state").textContent = job.state;
+
+  const message = row.querySelector(".message");
+  if (job.state === "failed") {
+    message.textContent = job.error;
+  } else if (job.state === "done") {
+    message.textContent = job.path;
+  } else if (job.state === "pending" || job.state === "paused" || job.state === "canceled") {
+    message.textContent = "";
+  }
+
+  const allowed = actions[job.state] || [];
+  row.querySelectorAll("button").forEach((button) => {
+    button.hidden = !allowed.includes(button.dataset.action);
+  });
+
+  if (job.state === "done") {
+    renderProgress(job.id, { downloaded: 1, total: 1, speed: 0 });
+  } else if (job.progress) {
+    renderProgress(job.id, job.progress);
+  } else {
+    renderProgress(job.id, null);
+  }
+}
+
+function renderProgress(id, progress) {
+  const row = rowFor(id);
+  const bar = row.querySelector("progress");
+  const percent = row.querySelector(".percent");
+  const speed = row.querySelector(".speed");
+
+  if (!progress) {
+    bar.value = 0;
+    percent.textContent = "";
+    speed.textContent = "";
+    return;
+  }
+  if (progress.total > 0) {
+    const fraction = Math.min(progress.downloaded / progress.total, 1);
+    bar.value = fraction;
+    percent.textContent = Math.floor(fraction * 100) + "%";
+  } else {
+    // Unknown size: an indeterminate bar and the bytes so far
+    bar.removeAttribute("value");
+    percent.textContent = formatBytes(progress.downloaded);
+  }
+  speed.textContent = progress.speed > 0 ? formatBytes(progress.speed) + "/s" : "";
+  if (progress.eta > 0) {
+    speed.textContent += ", " + formatDuration(progress.eta) + " left";
+  }
+}
+
+function formatDuration(seconds) {
+  seconds = Math.round(seconds);
+  const h = Math.floor(seconds / 3600);
+  const m = Math.floor((seconds % 3600) / 60);
+  const s = seconds % 60;
+  if (h > 0) {
+    return h + "h" + String(m).padStart(2, "0") + "m";
+  }
+  return m > 0 ? m + "m" + String(s).padStart(2, "0") + "s" : s + "s";
+}
+
+function showError(text) {
+  const error = document.getElementById("error");
+  error.textContent = text;
+  error.hidden = !text;
+}
+
+async function request(method, path, body) {
+  const options = { method: method, headers: {} };
+  if (body !== undefined) {
+    options.headers["Content-Type"] = "application/json";
+    options.body = JSON.stringify(body);
+  }
+  const response = await fetch(path, options);
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(data.error || response.statusText);
+  }
+  return data;
+}
+
+async function act(id, action) {
+  try {
+    showError("");
+    renderJob(await request("POST", "api/jobs/" + id + "/" + action));
+  } catch (err) {
+    showError(err.message);
+  }
+}
+
+async function loadJobs() {
+  try {
+    const jobs = await request("GET", "api/jobs");
+    jobs.forEach(renderJob);
+  } catch (err) {
+    showError(err.message);
+  }
+}
+
+document.getElementById("add").addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const url = document.getElementById("url");
+  const options = {};
+  const quality = document.getElementById("quality").value;
+  if (quality) {
+    options.quality = quality;
+  }
+  if (document.getElementById("audio").checked) {
+    options.audio_only = true;
+  }
+
+  try {
+    showError("");
+    const jobs = await request("POST", "api/jobs", { url: url.value, options: options });
+    jobs.forEach(renderJob);
+    url.value = "";
+  } catch (err) {
+    showError(err.message);
+  }
+});
+
+function connect() {
+  const status = document.getElementById("status");
+  const events = new EventSource("api/events");
+
+  events.addEventListener("open", () => {
+    status.textContent = "connected";
+    // Catch up on anything missed while disconnected
+    loadJobs();
+  });
+  events.addEventListener("error", () => {
+    status.textContent = "reconnecting";
+  });
+  events.addEventListener("job", (e) => {
+    renderJob(JSON.parse(e.data).job);
+  });
+  events.addEventListener("progress", (e) => {
+    const data = JSON.parse(e.data);
+    renderProgress(data.id, data.progress);
+  });
+  events.addEventListener("log", (e) => {
+    const data = JSON.parse(e.data);
+    const row = rows.get(data.id);
+    if (row && !["failed", "done"].includes(row.className)) {
+      row.querySelector(".message").textContent = data.line;
+    }
+  });
+}
+
+connect();
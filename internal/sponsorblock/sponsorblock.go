@@ -0,0 +1,179 @@
+// Package sponsorblock looks up community-submitted video segments such as
+// sponsor reads and intros
+package sponsorblock
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+)
+
+// DefaultAPIURL is the public SponsorBlock server
+const DefaultAPIURL = "https://sponsor.ajay.app"
+
+// Segment categories
+const (
+	CategorySponsor       = "sponsor"
+	CategorySelfPromo     = "selfpromo"
+	CategoryInteraction   = "interaction"
+	CategoryIntro         = "intro"
+	CategoryOutro         = "outro"
+	CategoryPreview       = "preview"
+	CategoryMusicOfftopic = "music_offtopic"
+	CategoryFiller        = "filler"
+)
+
+// Categories lists every category that marks a span of the video
+var Categories = []string{
+	CategorySponsor, CategorySelfPromo, CategoryInteraction, CategoryIntro,
+	CategoryOutro, CategoryPreview, CategoryMusicOfftopic, CategoryFiller,
+}
+
+// categoryTitles are the chapter titles of marked segments
+var categoryTitles = map[string]string{
+	CategorySponsor:       "Sponsor",
+	CategorySelfPromo:     "Self-promotion",
+	CategoryInteraction:   "Interaction reminder",
+	CategoryIntro:         "Intro",
+	CategoryOutro:         "Outro",
+	CategoryPreview:       "Preview",
+	CategoryMusicOfftopic: "Non-music section",
+	CategoryFiller:        "Filler",
+}
+
+// ParseCategories validates a list of category names, expanding "all"
+func ParseCategories(names []string) ([]string, error) {
+	var categories []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			return Categories, nil
+		}
+		if _, ok := categoryTitles[name]; !ok {
+			return nil, errors.NewValidationError(
+				fmt.Sprintf("unknown SponsorBlock category %q (use %s or all)", name, strings.Join(Categories, ", ")), nil)
+		}
+		if !seen[name] {
+			seen[name] = true
+			categories = append(categories, name)
+		}
+	}
+	return categories, nil
+}
+
+// Segment is a span of a video belonging to a category
+type Segment struct {
+	UUID     string
+	Category string
+	Start    time.Duration
+	End      time.Duration
+}
+
+// Title returns a chapter title for the segment, e.g. "Sponsor"
+func (s Segment) Title() string {
+	if title, ok := categoryTitles[s.Category]; ok {
+		return title
+	}
+	return s.Category
+}
+
+// Provider looks up the segments of a video
+type Provider interface {
+	Segments(ctx context.Context, videoID string, categories []string) ([]Segment, error)
+}
+
+// Client queries a SponsorBlock API server
+type Client struct {
+	client  *http.Client
+	baseURL string
+}
+
+// NewClient returns a client for the server at baseURL, or the public
+// server if baseURL is empty
+func NewClient(baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultAPIURL
+	}
+	return &Client{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}
+}
+
+// apiSegment is a segment as returned by /api/skipSegments
+type apiSegment struct {
+	Segment  [2]float64 `json:"segment"`
+	UUID     string     `json:"UUID"`
+	Category string     `json:"category"`
+}
+
+// Segments returns the segments of the given categories, sorted by start.
+// A video nobody has submitted segments for has none.
+func (c *Client) Segments(ctx context.Context, videoID string, categories []string) ([]Segment, error) {
+	if len(categories) == 0 {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(categories)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"videoID": {videoID}, "categories": {string(encoded)}}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/api/skipSegments?"+query.Encode(), nil)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to create request", err)
+	}
+	req.Header.Set("User-Agent", "red-goose/1.0")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to fetch SponsorBlock segments", err)
+	}
+	defer resp.Body.Close()
+
+	// The server answers 404 when there are no segments
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewHTTPError(resp)
+	}
+
+	var raw []apiSegment
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return nil, errors.NewExtractionError("failed to parse SponsorBlock segments", err)
+	}
+
+	var segments []Segment
+	for _, s := range raw {
+		segment := Segment{
+			UUID:     s.UUID,
+			Category: s.Category,
+			Start:    seconds(s.Segment[0]),
+			End:      seconds(s.Segment[1]),
+		}
+		// Points of interest have no length
+		if segment.End > segment.Start {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i].Start < segments[j].Start })
+	return segments, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
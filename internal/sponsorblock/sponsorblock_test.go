@@ -0,0 +1,72 @@
+package sponsorblock
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSegments(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/skipSegments" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.URL.Query().Get("videoID") != "abc" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.URL.Query().Get("categories"); got != `["sponsor","intro"]` {
+			t.Errorf("categories = %s", got)
+		}
+		w.Write([]byte(`[
+			{"segment": [120.5, 180], "UUID": "b", "category": "sponsor", "actionType": "skip"},
+			{"segment": [0, 15.25], "UUID": "a", "category": "intro", "actionType": "skip"},
+			{"segment": [30, 30], "UUID": "c", "category": "poi_highlight", "actionType": "poi"}
+		]`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL + "/")
+	segments, err := client.Segments(context.Background(), "abc", []string{CategorySponsor, CategoryIntro})
+	if err != nil {
+		t.Fatalf("Segments() error = %v", err)
+	}
+
+	want := []Segment{
+		{UUID: "a", Category: "intro", Start: 0, End: 15250 * time.Millisecond},
+		{UUID: "b", Category: "sponsor", Start: 120500 * time.Millisecond, End: 180 * time.Second},
+	}
+	if len(segments) != len(want) {
+		t.Fatalf("Segments() = %+v, want %+v", segments, want)
+	}
+	for i := range want {
+		if segments[i] != want[i] {
+			t.Errorf("segment %d = %+v, want %+v", i, segments[i], want[i])
+		}
+	}
+	if segments[1].Title() != "Sponsor" {
+		t.Errorf("Title() = %q", segments[1].Title())
+	}
+
+	// Videos without submissions answer 404
+	segments, err = client.Segments(context.Background(), "none", []string{CategorySponsor})
+	if err != nil || len(segments) != 0 {
+		t.Errorf("Segments() for unknown video = %v, %v", segments, err)
+	}
+}
+
+func TestParseCategories(t *testing.T) {
+	got, err := ParseCategories([]string{"Sponsor", " intro", "sponsor"})
+	if err != nil || len(got) != 2 || got[0] != "sponsor" || got[1] != "intro" {
+		t.Errorf("ParseCategories() = %v, %v", got, err)
+	}
+	if got, _ := ParseCategories([]string{"all"}); len(got) != len(Categories) {
+		t.Errorf("ParseCategories(all) = %v", got)
+	}
+	if _, err := ParseCategories([]string{"ads"}); err == nil {
+		t.Error("ParseCategories() accepted an unknown category")
+	}
+}
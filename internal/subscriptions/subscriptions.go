@@ -0,0 +1,298 @@
+// Package subscriptions keeps the channels and playlists whose new videos
+// "red-goose sync" downloads.
+package subscriptions
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/feed"
+)
+
+// Subscription is a followed channel or playlist and the settings its
+// videos are downloaded with. Empty settings fall back to the defaults.
+type Subscription struct {
+	ID    int    `json:"id"`
+	URL   string `json:"url"`
+	Title string `json:"title"`
+	// ChannelID is set for channels, whose uploads are in PlaylistID
+	ChannelID  string `json:"channel_id,omitempty"`
+	PlaylistID string `json:"playlist_id"`
+
+	Quality   string `json:"quality,omitempty"`
+	AudioOnly bool   `json:"audio_only,omitempty"`
+	OutputDir string `json:"output_dir,omitempty"`
+	Filter    Filter `json:"filter,omitempty"`
+
+	Added    time.Time `json:"added"`
+	LastSync time.Time `json:"last_sync,omitempty"`
+	// LastFullSync is the last time every video was listed rather than
+	// only the feed
+	LastFullSync time.Time `json:"last_full_sync,omitempty"`
+	// Before are the newest of a channel's uploads left out of its first
+	// sync. They and the uploads older than them are not downloaded.
+	Before []string `json:"before,omitempty"`
+}
+
+// beforeVideos is how many uploads Before records, so that the cut is
+// still found when some of them are deleted
+const beforeVideos = 5
+
+// Filter selects which videos of a subscription are downloaded
+type Filter struct {
+	// MatchTitle and RejectTitle are regular expressions
+	MatchTitle  string        `json:"match_title,omitempty"`
+	RejectTitle string        `json:"reject_title,omitempty"`
+	MinDuration time.Duration `json:"min_duration,omitempty"`
+	MaxDuration time.Duration `json:"max_duration,omitempty"`
+
+	// match and reject are the compiled title patterns
+	compiled      bool
+	match, reject *regexp.Regexp
+}
+
+// Validate checks the filter and compiles its patterns for Allows
+func (f *Filter) Validate() error {
+	if err := f.compile(); err != nil {
+		return err
+	}
+	if f.MaxDuration > 0 && f.MinDuration > f.MaxDuration {
+		return errors.NewValidationError("minimum duration is longer than the maximum", nil)
+	}
+	return nil
+}
+
+func (f *Filter) compile() error {
+	patterns := []*regexp.Regexp{nil, nil}
+	for i, pattern := range []string{f.MatchTitle, f.RejectTitle} {
+		if pattern == "" {
+			continue
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return errors.NewValidationError(fmt.Sprintf("invalid title pattern %q", pattern), err)
+		}
+		patterns[i] = re
+	}
+	f.match, f.reject, f.compiled = patterns[0], patterns[1], true
+	return nil
+}
+
+// Allows reports whether a video passes the filter. Videos of unknown
+// duration pass the duration limits. A filter that was not validated is
+// compiled on first use, and one whose patterns do not compile allows
+// nothing.
+func (f *Filter) Allows(title string, duration time.Duration) bool {
+	if !f.compiled && f.compile() != nil {
+		return false
+	}
+	if f.match != nil && !f.match.MatchString(title) {
+		return false
+	}
+	if f.reject != nil && f.reject.MatchString(title) {
+		return false
+	}
+	if duration > 0 {
+		if f.MinDuration > 0 && duration < f.MinDuration {
+			return false
+		}
+		if f.MaxDuration > 0 && duration > f.MaxDuration {
+			return false
+		}
+	}
+	return true
+}
+
+// Select returns the videos that pass the filter and are not skipped, such
+// as those already downloaded
+func (s *Subscription) Select(videos []extractor.Video, skip func(videoID string) bool) []extractor.Video {
+	var selected []extractor.Video
+	for _, v := range videos {
+		if skip(v.ID) || !s.Filter.Allows(v.Title, v.Duration) {
+			continue
+		}
+		selected = append(selected, v)
+	}
+	return selected
+}
+
+// Latest cuts a full listing of a channel's uploads, newest first, down to
+// the videos uploaded since the subscription was added. The first listing
+// keeps the latest limit videos and records in Before where the older ones
+// start; later listings drop the videos from there on. Playlists, and
+// channels when limit is 0, keep every video.
+func (s *Subscription) Latest(videos []extractor.Video, limit int) []extractor.Video {
+	if s.ChannelID == "" {
+		return videos
+	}
+	if len(s.Before) > 0 {
+		before := make(map[string]bool)
+		for _, id := range s.Before {
+			before[id] = true
+		}
+		for i, v := range videos {
+			if before[v.ID] {
+				return videos[:i]
+			}
+		}
+		return videos
+	}
+	if !s.LastFullSync.IsZero() || limit <= 0 || len(videos) <= limit {
+		return videos
+	}
+	for _, v := range videos[limit:] {
+		if len(s.Before) == beforeVideos {
+			break
+		}
+		s.Before = append(s.Before, v.ID)
+	}
+	return videos[:limit]
+}
+
+// UseFeed reports whether a sync can rely on the feed: the subscription
+// was listed in full before, less than interval ago. An interval of 0
+// never forces a full listing.
+func (s *Subscription) UseFeed(now time.Time, interval time.Duration) bool {
+	if s.LastFullSync.IsZero() {
+		return false
+	}
+	return interval <= 0 || now.Sub(s.LastFullSync) < interval
+}
+
+// FeedCovers reports whether a feed reaches back to the last sync, so that
+// no video published since is missing from it
+func (s *Subscription) FeedCovers(entries []feed.Entry) bool {
+	if len(entries) < feed.Window {
+		return true
+	}
+	for _, e := range entries {
+		if !e.Published.After(s.LastSync) {
+			return true
+		}
+	}
+	return false
+}
+
+// List is the set of subscriptions, saved as a JSON file
+type List struct {
+	path string
+	subs []Subscription
+}
+
+// Load reads the subscriptions at path. A missing file is an empty list.
+// The filters are checked, so that a bad pattern edited into the file is
+// reported before anything is synced.
+func Load(path string) (*List, error) {
+	l := &List{path: path}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, errors.NewFileSystemError(fmt.Sprintf("failed to read subscriptions %s", path), err)
+	}
+	if err := json.Unmarshal(data, &l.subs); err != nil {
+		return nil, errors.NewConfigurationError(fmt.Sprintf("failed to parse subscriptions %s", path), err)
+	}
+	for i := range l.subs {
+		if err := l.subs[i].Filter.Validate(); err != nil {
+			return nil, errors.NewConfigurationError(fmt.Sprintf("subscription %d in %s", l.subs[i].ID, path), err)
+		}
+	}
+	return l, nil
+}
+
+// Save writes the list back to its file
+func (l *List) Save() error {
+	data, err := json.MarshalIndent(l.subs, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return errors.NewFileSystemError("failed to create data directory", err)
+	}
+	// Replace the file in one step so a crash never leaves half a list
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write subscriptions %s", l.path), err)
+	}
+	if err := os.Rename(tmp, l.path); err != nil {
+		return errors.NewFileSystemError(fmt.Sprintf("failed to write subscriptions %s", l.path), err)
+	}
+	return nil
+}
+
+// All returns the subscriptions in the order they were added
+func (l *List) All() []Subscription {
+	return append([]Subscription(nil), l.subs...)
+}
+
+// Add appends a subscription, numbering it after the last one. A channel
+// or playlist can only be subscribed to once.
+func (l *List) Add(sub Subscription) (Subscription, error) {
+	if err := sub.Filter.Validate(); err != nil {
+		return Subscription{}, err
+	}
+	next := 1
+	for _, s := range l.subs {
+		if s.PlaylistID == sub.PlaylistID {
+			return Subscription{}, errors.NewValidationError(fmt.Sprintf("already subscribed to %s (subscription %d)", s.Title, s.ID), nil)
+		}
+		if s.ID >= next {
+			next = s.ID + 1
+		}
+	}
+	sub.ID = next
+	if sub.Added.IsZero() {
+		sub.Added = time.Now()
+	}
+	l.subs = append(l.subs, sub)
+	return sub, nil
+}
+
+// Find looks up a subscription by its ID or URL
+func (l *List) Find(ref string) (Subscription, bool) {
+	i := l.index(ref)
+	if i < 0 {
+		return Subscription{}, false
+	}
+	return l.subs[i], true
+}
+
+// Update replaces the subscription with the same ID
+func (l *List) Update(sub Subscription) {
+	for i := range l.subs {
+		if l.subs[i].ID == sub.ID {
+			l.subs[i] = sub
+			return
+		}
+	}
+}
+
+// Remove deletes a subscription by its ID or URL
+func (l *List) Remove(ref string) (Subscription, error) {
+	i := l.index(ref)
+	if i < 0 {
+		return Subscription{}, errors.NewValidationError(fmt.Sprintf("no subscription %q", ref), nil)
+	}
+	sub := l.subs[i]
+	l.subs = append(l.subs[:i], l.subs[i+1:]...)
+	return sub, nil
+}
+
+func (l *List) index(ref string) int {
+	id, err := strconv.Atoi(ref)
+	for i, s := range l.subs {
+		if (err == nil && s.ID == id) || s.URL == ref {
+			return i
+		}
+	}
+	return -1
+}
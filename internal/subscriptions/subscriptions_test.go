@@ -0,0 +1,194 @@
+package subscriptions
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	"github.com/MaVeN-13TTN/red_goose/internal/feed"
+)
+
+func TestList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "subscriptions.json")
+	l, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load missing file: %v", err)
+	}
+
+	first, err := l.Add(Subscription{URL: "https://www.youtube.com/@first", Title: "First", PlaylistID: "UU1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Add(Subscription{URL: "https://www.youtube.com/playlist?list=PL2", Title: "Second", PlaylistID: "PL2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Add(Subscription{URL: "https://www.youtube.com/channel/x", PlaylistID: "UU1"}); err == nil {
+		t.Error("expected an error subscribing twice")
+	}
+	if _, err := l.Add(Subscription{PlaylistID: "PL3", Filter: Filter{MatchTitle: "("}}); err == nil {
+		t.Error("expected an error for a bad title pattern")
+	}
+	if first.ID != 1 || first.Added.IsZero() {
+		t.Errorf("first subscription = %+v", first)
+	}
+
+	synced := first
+	synced.LastSync = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	l.Update(synced)
+	if err := l.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	l, err = Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub, ok := l.Find("https://www.youtube.com/@first"); !ok || !sub.LastSync.Equal(synced.LastSync) {
+		t.Errorf("Find by URL = %+v, %v", sub, ok)
+	}
+	if removed, err := l.Remove("2"); err != nil || removed.Title != "Second" {
+		t.Errorf("Remove(2) = %+v, %v", removed, err)
+	}
+	if _, err := l.Remove("2"); err == nil {
+		t.Error("expected an error removing a missing subscription")
+	}
+
+	// IDs are not reused
+	third, _ := l.Add(Subscription{PlaylistID: "PL3"})
+	if third.ID != 2 {
+		t.Errorf("next ID = %d, want 2", third.ID)
+	}
+}
+
+func TestLoadBadFilter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subscriptions.json")
+	data := `[{"id": 1, "playlist_id": "PL1", "filter": {"match_title": "("}}]`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("expected an error loading a bad title pattern")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	videos := []extractor.Video{
+		{ID: "a", Title: "Episode 1", Duration: 20 * time.Minute},
+		{ID: "b", Title: "Episode 2", Duration: 30 * time.Minute},
+		{ID: "c", Title: "Episode 3 #shorts", Duration: 40 * time.Second},
+		{ID: "d", Title: "Livestream replay", Duration: 3 * time.Hour},
+		{ID: "e", Title: "Episode 4"},
+	}
+	downloaded := map[string]bool{"a": true}
+	skip := func(id string) bool { return downloaded[id] }
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{"no filter", Filter{}, []string{"b", "c", "d", "e"}},
+		{"match", Filter{MatchTitle: "^Episode"}, []string{"b", "c", "e"}},
+		{"reject", Filter{RejectTitle: "(?i)#shorts"}, []string{"b", "d", "e"}},
+		{"durations", Filter{MinDuration: time.Minute, MaxDuration: time.Hour}, []string{"b", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sub := Subscription{Filter: tt.filter}
+			var got []string
+			for _, v := range sub.Select(videos, skip) {
+				got = append(got, v.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Select = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLatest(t *testing.T) {
+	var uploads []extractor.Video
+	for i := 20; i > 0; i-- {
+		uploads = append(uploads, extractor.Video{ID: fmt.Sprint("v", i)})
+	}
+	ids := func(videos []extractor.Video) []string {
+		var got []string
+		for _, v := range videos {
+			got = append(got, v.ID)
+		}
+		return got
+	}
+
+	playlist := Subscription{PlaylistID: "PL1"}
+	if got := playlist.Latest(uploads, 3); len(got) != len(uploads) {
+		t.Errorf("a playlist kept %d videos, want all %d", len(got), len(uploads))
+	}
+
+	channel := Subscription{ChannelID: "UC1", PlaylistID: "UU1"}
+	if got, want := ids(channel.Latest(uploads, 3)), []string{"v20", "v19", "v18"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("first listing = %v, want %v", got, want)
+	}
+	if want := []string{"v17", "v16", "v15", "v14", "v13"}; !reflect.DeepEqual(channel.Before, want) {
+		t.Errorf("Before = %v, want %v", channel.Before, want)
+	}
+
+	// Two new uploads, and the newest left-out one was deleted
+	channel.LastFullSync = time.Now()
+	later := append([]extractor.Video{{ID: "v22"}, {ID: "v21"}}, uploads[:3]...)
+	later = append(later, uploads[4:]...)
+	if got, want := ids(channel.Latest(later, 3)), []string{"v22", "v21", "v20", "v19", "v18"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("later listing = %v, want %v", got, want)
+	}
+
+	everything := Subscription{ChannelID: "UC1", PlaylistID: "UU1"}
+	if got := everything.Latest(uploads, 0); len(got) != len(uploads) || everything.Before != nil {
+		t.Errorf("a limit of 0 kept %d videos and Before = %v", len(got), everything.Before)
+	}
+}
+
+func TestFeedChecks(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	lastSync := now.Add(-24 * time.Hour)
+	sub := Subscription{LastSync: lastSync}
+
+	if sub.UseFeed(now, 7*24*time.Hour) {
+		t.Error("a subscription never listed in full should not use the feed")
+	}
+	sub.LastFullSync = now.Add(-3 * 24 * time.Hour)
+	if !sub.UseFeed(now, 7*24*time.Hour) {
+		t.Error("expected the feed within the full sync interval")
+	}
+	if sub.UseFeed(now, 48*time.Hour) {
+		t.Error("expected a full listing once the interval has passed")
+	}
+	if !sub.UseFeed(now, 0) {
+		t.Error("an interval of 0 should never force a full listing")
+	}
+
+	// entries returns a full feed whose oldest entry is published at oldest
+	entries := func(n int, oldest time.Time) []feed.Entry {
+		list := make([]feed.Entry, n)
+		for i := range list {
+			list[i] = feed.Entry{VideoID: fmt.Sprint(i), Published: oldest.Add(time.Duration(n-1-i) * time.Minute)}
+		}
+		return list
+	}
+	tests := []struct {
+		name    string
+		entries []feed.Entry
+		want    bool
+	}{
+		{"short feed", entries(3, lastSync.Add(time.Hour)), true},
+		{"full feed reaching the last sync", entries(feed.Window, lastSync.Add(-time.Hour)), true},
+		{"full feed of new videos", entries(feed.Window, lastSync.Add(time.Hour)), false},
+	}
+	for _, tt := range tests {
+		if got := sub.FeedCovers(tt.entries); got != tt.want {
+			t.Errorf("%s: FeedCovers = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+package subtitles
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse reads subtitles in the given format
+func Parse(r io.Reader, format Format) (*Document, error) {
+	switch format {
+	case FormatJSON3:
+		return parseJSON3(r)
+	case FormatSRT:
+		return parseCues(r, false)
+	case FormatVTT:
+		return parseCues(r, true)
+	case FormatTTML:
+		return parseTTML(r)
+	default:
+		return nil, fmt.Errorf("unsupported subtitle format %q", format)
+	}
+}
+
+// Write writes subtitles in the given format
+func Write(w io.Writer, doc *Document, format Format) error {
+	bw := bufio.NewWriter(w)
+
+	var err error
+	switch format {
+	case FormatSRT:
+		err = writeSRT(bw, doc)
+	case FormatVTT:
+		err = writeVTT(bw, doc)
+	case FormatTTML:
+		err = writeTTML(bw, doc)
+	case FormatJSON3:
+		err = writeJSON3(bw, doc)
+	default:
+		return fmt.Errorf("unsupported subtitle format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+type json3Document struct {
+	WireMagic string       `json:"wireMagic,omitempty"`
+	Events    []json3Event `json:"events"`
+}
+
+type json3Event struct {
+	StartMs    int64          `json:"tStartMs"`
+	DurationMs int64          `json:"dDurationMs"`
+	Segs       []json3Segment `json:"segs,omitempty"`
+}
+
+type json3Segment struct {
+	UTF8 string `json:"utf8"`
+}
+
+func parseJSON3(r io.Reader) (*Document, error) {
+	var raw json3Document
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid json3 subtitles: %w", err)
+	}
+
+	doc := &Document{}
+	for _, event := range raw.Events {
+		// Events without segments only define windows and styles
+		var text strings.Builder
+		for _, seg := range event.Segs {
+			text.WriteString(seg.UTF8)
+		}
+		content := strings.TrimSpace(text.String())
+		if content == "" {
+			continue
+		}
+
+		start := time.Duration(event.StartMs) * time.Millisecond
+		doc.Cues = append(doc.Cues, Cue{
+			Start: start,
+			End:   start + time.Duration(event.DurationMs)*time.Millisecond,
+			Text:  content,
+		})
+	}
+
+	return doc, nil
+}
+
+func writeJSON3(w io.Writer, doc *Document) error {
+	raw := json3Document{WireMagic: "pb3", Events: []json3Event{}}
+	for _, cue := range doc.Cues {
+		raw.Events = append(raw.Events, json3Event{
+			StartMs:    cue.Start.Milliseconds(),
+			DurationMs: (cue.End - cue.Start).Milliseconds(),
+			Segs:       []json3Segment{{UTF8: cue.Text}},
+		})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(raw)
+}
+
+var (
+	cueTagRegex    = regexp.MustCompile(`<[^>]*>`)
+	timestampRegex = regexp.MustCompile(`^(?:(\d+):)?(\d{1,2}):(\d{2})[.,](\d{1,3})$`)
+)
+
+// parseCues reads SRT and WebVTT, which share the same block structure: an
+// optional identifier, a "start --> end" timing line and the cue text
+func parseCues(r io.Reader, vtt bool) (*Document, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	doc := &Document{}
+	var (
+		cue     *Cue
+		text    []string
+		skipped bool // inside a WebVTT NOTE, STYLE or REGION block
+	)
+
+	flush := func() {
+		if cue != nil {
+			cue.Text = strings.Join(text, "\n")
+			if cue.Text != "" {
+				doc.Cues = append(doc.Cues, *cue)
+			}
+		}
+		cue, text, skipped = nil, nil, false
+	}
+
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		switch {
+		case strings.TrimSpace(line) == "":
+			flush()
+		case skipped:
+			continue
+		case cue == nil && (strings.HasPrefix(line, "WEBVTT") || strings.HasPrefix(line, "NOTE") ||
+			strings.HasPrefix(line, "STYLE") || strings.HasPrefix(line, "REGION")):
+			skipped = true
+		case cue == nil && strings.Contains(line, "-->"):
+			start, end, err := parseTimingLine(line)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			cue = &Cue{Start: start, End: end}
+		case cue == nil:
+			// Cue identifier or SRT sequence number
+			continue
+		default:
+			text = append(text, cleanCueText(line, vtt))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read subtitles: %w", err)
+	}
+	flush()
+
+	return doc, nil
+}
+
+func parseTimingLine(line string) (time.Duration, time.Duration, error) {
+	startStr, rest, _ := strings.Cut(line, "-->")
+	fields := strings.Fields(rest)
+	if len(fields) == 0 {
+		return 0, 0, fmt.Errorf("missing end time in %q", line)
+	}
+
+	start, err := parseTimestamp(strings.TrimSpace(startStr))
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := parseTimestamp(fields[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	return start, end, nil
+}
+
+func parseTimestamp(ts string) (time.Duration, error) {
+	m := timestampRegex.FindStringSubmatch(ts)
+	if m == nil {
+		return 0, fmt.Errorf("invalid timestamp %q", ts)
+	}
+
+	hours, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi(m[2])
+	secs, _ := strconv.Atoi(m[3])
+	millis, _ := strconv.Atoi((m[4] + "00")[:3])
+
+	return time.Duration(hours)*time.Hour +
+		time.Duration(minutes)*time.Minute +
+		time.Duration(secs)*time.Second +
+		time.Duration(millis)*time.Millisecond, nil
+}
+
+// cleanCueText strips WebVTT markup such as <c> spans and inline karaoke
+// timestamps, leaving plain text
+func cleanCueText(line string, vtt bool) string {
+	if !vtt {
+		return line
+	}
+	return html.UnescapeString(cueTagRegex.ReplaceAllString(line, ""))
+}
+
+func writeSRT(w io.Writer, doc *Document) error {
+	for i, cue := range doc.Cues {
+		_, err := fmt.Fprintf(w, "%d\n%s --> %s\n%s\n\n",
+			i+1, formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","), cue.Text)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func writeVTT(w io.Writer, doc *Document) error {
+	header := "WEBVTT\n\n"
+	if doc.Language != "" {
+		header = fmt.Sprintf("WEBVTT\nLanguage: %s\n\n", doc.Language)
+	}
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	for _, cue := range doc.Cues {
+		_, err := fmt.Fprintf(w, "%s --> %s\n%s\n\n",
+			formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), vttEscaper.Replace(cue.Text))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeTTML(w io.Writer, doc *Document) error {
+	lang := doc.Language
+	if lang == "" {
+		lang = "und"
+	}
+
+	if _, err := fmt.Fprintf(w, "%s<tt xmlns=\"http://www.w3.org/ns/ttml\" xml:lang=\"%s\">\n  <body>\n    <div>\n",
+		xml.Header, html.EscapeString(lang)); err != nil {
+		return err
+	}
+
+	for _, cue := range doc.Cues {
+		lines := strings.Split(cue.Text, "\n")
+		for i, line := range lines {
+			lines[i] = html.EscapeString(line)
+		}
+		_, err := fmt.Fprintf(w, "      <p begin=\"%s\" end=\"%s\">%s</p>\n",
+			formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), strings.Join(lines, "<br/>"))
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(w, "    </div>\n  </body>\n</tt>\n")
+	return err
+}
+
+func parseTTML(r io.Reader) (*Document, error) {
+	decoder := xml.NewDecoder(r)
+	doc := &Document{}
+
+	var (
+		cue  *Cue
+		text strings.Builder
+	)
+
+	for {
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid TTML: %w", err)
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tt":
+				for _, attr := range t.Attr {
+					if attr.Name.Local == "lang" {
+						doc.Language = attr.Value
+					}
+				}
+			case "p":
+				cue = &Cue{}
+				text.Reset()
+				var dur time.Duration
+				hasEnd := false
+				for _, attr := range t.Attr {
+					value, err := parseTTMLTime(attr.Value)
+					switch attr.Name.Local {
+					case "begin":
+						cue.Start = value
+					case "end":
+						cue.End = value
+						hasEnd = true
+					case "dur":
+						dur = value
+					default:
+						continue
+					}
+					if err != nil {
+						return nil, err
+					}
+				}
+				if !hasEnd {
+					cue.End = cue.Start + dur
+				}
+			case "br":
+				if cue != nil {
+					text.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			if cue != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if t.Name.Local == "p" && cue != nil {
+				cue.Text = strings.TrimSpace(text.String())
+				if cue.Text != "" {
+					doc.Cues = append(doc.Cues, *cue)
+				}
+				cue = nil
+			}
+		}
+	}
+
+	return doc, nil
+}
+
+// parseTTMLTime accepts clock times (00:01:02.500) and offset times with a
+// unit (62.5s, 62500ms)
+func parseTTMLTime(value string) (time.Duration, error) {
+	if strings.Contains(value, ":") {
+		return parseTimestamp(value)
+	}
+
+	for _, unit := range []struct {
+		suffix string
+		scale  time.Duration
+	}{{"ms", time.Millisecond}, {"s", time.Second}, {"m", time.Minute}, {"h", time.Hour}} {
+		if num, ok := strings.CutSuffix(value, unit.suffix); ok {
+			n, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid TTML time %q", value)
+			}
+			return time.Duration(n * float64(unit.scale)), nil
+		}
+	}
+
+	return 0, fmt.Errorf("invalid TTML time %q", value)
+}
+
+func formatTimestamp(d time.Duration, fractionSep string) string {
+	if d < 0 {
+		d = 0
+	}
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+		ms/3600000, ms/60000%60, ms/1000%60, fractionSep, ms%1000)
+}
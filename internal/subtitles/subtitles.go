@@ -0,0 +1,181 @@
+package subtitles
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+)
+
+type Format string
+
+const (
+	FormatSRT   Format = "srt"
+	FormatVTT   Format = "vtt"
+	FormatTTML  Format = "ttml"
+	FormatJSON3 Format = "json3"
+)
+
+// ParseFormat validates a subtitle format name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case FormatSRT, FormatVTT, FormatTTML, FormatJSON3:
+		return f, nil
+	default:
+		return "", errors.NewValidationError(
+			fmt.Sprintf("unsupported subtitle format %q (use srt, vtt, ttml or json3)", name), nil)
+	}
+}
+
+// Cue is a single timed piece of text
+type Cue struct {
+	Start time.Duration `json:"start"`
+	End   time.Duration `json:"end"`
+	Text  string        `json:"text"`
+}
+
+// Document is a parsed subtitle track, independent of its file format
+type Document struct {
+	Language string
+	Cues     []Cue
+}
+
+// SelectTracks picks the caption tracks matching the requested languages.
+// Uploaded tracks are used when manual is set and automatic (speech
+// recognition) tracks when auto is set; an uploaded track always wins over
+// an automatic one for the same language. A language of "all" matches every
+// track, and "en" also matches regional variants such as "en-GB".
+func SelectTracks(tracks []extractor.CaptionTrack, langs []string, manual, auto bool) []extractor.CaptionTrack {
+	var selected []extractor.CaptionTrack
+	seen := make(map[string]bool)
+
+	pick := func(automatic bool) {
+		for _, track := range tracks {
+			if track.Automatic != automatic || seen[track.LanguageCode] {
+				continue
+			}
+			if matchesLanguage(track.LanguageCode, langs) {
+				selected = append(selected, track)
+				seen[track.LanguageCode] = true
+			}
+		}
+	}
+
+	if manual {
+		pick(false)
+	}
+	if auto {
+		pick(true)
+	}
+
+	return selected
+}
+
+func matchesLanguage(code string, langs []string) bool {
+	for _, lang := range langs {
+		lang = strings.TrimSpace(lang)
+		if lang == "all" || strings.EqualFold(code, lang) ||
+			strings.HasPrefix(strings.ToLower(code), strings.ToLower(lang)+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+// Filename returns the subtitle filename for a media file, e.g.
+// "Talk.mp4" becomes "Talk.en.srt"
+func Filename(mediaFilename, lang string, format Format) string {
+	base := mediaFilename
+	if ext := strings.LastIndex(base, "."); ext > 0 {
+		base = base[:ext]
+	}
+	return fmt.Sprintf("%s.%s.%s", base, lang, format)
+}
+
+type Client struct {
+	client *http.Client
+}
+
+func NewClient() *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: time.Minute,
+		},
+	}
+}
+
+// Fetch downloads a caption track and parses it. Tracks are always requested
+// as json3, which carries the most precise timing, and converted locally.
+func (c *Client) Fetch(ctx context.Context, track extractor.CaptionTrack) (*Document, error) {
+	trackURL, err := url.Parse(track.URL)
+	if err != nil {
+		return nil, errors.NewValidationError("invalid caption track URL", err)
+	}
+	query := trackURL.Query()
+	query.Set("fmt", string(FormatJSON3))
+	trackURL.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", trackURL.String(), nil)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to create request", err)
+	}
+	req.Header.Set("User-Agent", "red-goose/1.0")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to download subtitles", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewHTTPError(resp)
+	}
+
+	doc, err := Parse(resp.Body, FormatJSON3)
+	if err != nil {
+		return nil, errors.NewExtractionError("failed to parse subtitles", err)
+	}
+	doc.Language = track.LanguageCode
+
+	return doc, nil
+}
+
+// Save fetches a caption track and writes it to path in the given format
+func (c *Client) Save(ctx context.Context, track extractor.CaptionTrack, path string, format Format) error {
+	doc, err := c.Fetch(ctx, track)
+	if err != nil {
+		return err
+	}
+	return WriteFile(path, doc, format)
+}
+
+// WriteFile writes subtitles to path in the given format
+func WriteFile(path string, doc *Document, format Format) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return errors.NewFileSystemError("failed to create subtitle file", err)
+	}
+	defer file.Close()
+
+	if err := Write(file, doc, format); err != nil {
+		return errors.NewFileSystemError("failed to write subtitles", err)
+	}
+
+	return nil
+}
+
+// Convert reads subtitles in one format and writes them in another
+func Convert(r io.Reader, from Format, w io.Writer, to Format) error {
+	doc, err := Parse(r, from)
+	if err != nil {
+		return err
+	}
+	return Write(w, doc, to)
+}
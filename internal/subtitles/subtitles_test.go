@@ -0,0 +1,186 @@
+package subtitles
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+)
+
+const sampleJSON3 = `{
+  "wireMagic": "pb3",
+  "events": [
+    {"tStartMs": 0, "dDurationMs": 60000, "id": 1, "wpWinPosId": 1},
+    {"tStartMs": 1200, "dDurationMs": 2300, "segs": [{"utf8": "Hello"}, {"utf8": " world"}]},
+    {"tStartMs": 3500, "dDurationMs": 10, "aAppend": 1, "segs": [{"utf8": "\n"}]},
+    {"tStartMs": 3661001, "dDurationMs": 1500, "segs": [{"utf8": "Fish & <chips>\nsecond line"}]}
+  ]
+}`
+
+func sampleDocument(t *testing.T) *Document {
+	doc, err := Parse(strings.NewReader(sampleJSON3), FormatJSON3)
+	if err != nil {
+		t.Fatalf("Parse json3 failed: %v", err)
+	}
+	return doc
+}
+
+func TestParseJSON3(t *testing.T) {
+	doc := sampleDocument(t)
+
+	want := []Cue{
+		{Start: 1200 * time.Millisecond, End: 3500 * time.Millisecond, Text: "Hello world"},
+		{Start: 3661001 * time.Millisecond, End: 3662501 * time.Millisecond, Text: "Fish & <chips>\nsecond line"},
+	}
+	if len(doc.Cues) != len(want) {
+		t.Fatalf("got %d cues, want %d", len(doc.Cues), len(want))
+	}
+	for i := range want {
+		if doc.Cues[i] != want[i] {
+			t.Errorf("cue %d = %+v, want %+v", i, doc.Cues[i], want[i])
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		format   Format
+		expected string
+	}{
+		{
+			format: FormatSRT,
+			expected: "1\n00:00:01,200 --> 00:00:03,500\nHello world\n\n" +
+				"2\n01:01:01,001 --> 01:01:02,501\nFish & <chips>\nsecond line\n\n",
+		},
+		{
+			format: FormatVTT,
+			expected: "WEBVTT\n\n00:00:01.200 --> 00:00:03.500\nHello world\n\n" +
+				"01:01:01.001 --> 01:01:02.501\nFish &amp; &lt;chips&gt;\nsecond line\n\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, sampleDocument(t), tt.format); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("Write(%s) = %q, want %q", tt.format, buf.String(), tt.expected)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	original := sampleDocument(t)
+
+	for _, format := range []Format{FormatSRT, FormatVTT, FormatTTML, FormatJSON3} {
+		t.Run(string(format), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, original, format); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			parsed, err := Parse(&buf, format)
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+
+			if len(parsed.Cues) != len(original.Cues) {
+				t.Fatalf("got %d cues, want %d", len(parsed.Cues), len(original.Cues))
+			}
+			for i := range original.Cues {
+				if parsed.Cues[i] != original.Cues[i] {
+					t.Errorf("cue %d = %+v, want %+v", i, parsed.Cues[i], original.Cues[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseVTTMarkup(t *testing.T) {
+	input := "WEBVTT\nKind: captions\nLanguage: en\n\nNOTE generated\n\n" +
+		"intro\n00:01.000 --> 00:02.500 align:start position:0%\n" +
+		"so<00:00:01.500><c> this</c> &amp; that\n"
+
+	doc, err := Parse(strings.NewReader(input), FormatVTT)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	want := Cue{Start: time.Second, End: 2500 * time.Millisecond, Text: "so this & that"}
+	if len(doc.Cues) != 1 || doc.Cues[0] != want {
+		t.Errorf("cues = %+v, want [%+v]", doc.Cues, want)
+	}
+}
+
+func TestSelectTracks(t *testing.T) {
+	tracks := []extractor.CaptionTrack{
+		{LanguageCode: "en", Automatic: true},
+		{LanguageCode: "en-GB"},
+		{LanguageCode: "es"},
+		{LanguageCode: "fr", Automatic: true},
+	}
+
+	tests := []struct {
+		name   string
+		langs  []string
+		manual bool
+		auto   bool
+		want   []string
+	}{
+		{"Uploaded only", []string{"en", "es"}, true, false, []string{"en-GB", "es"}},
+		{"Automatic only", []string{"en", "fr"}, false, true, []string{"en", "fr"}},
+		{"Uploaded preferred", []string{"es", "fr"}, true, true, []string{"es", "fr"}},
+		{"All languages", []string{"all"}, true, false, []string{"en-GB", "es"}},
+		{"No match", []string{"de"}, true, true, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, track := range SelectTracks(tracks, tt.langs, tt.manual, tt.auto) {
+				got = append(got, track.LanguageCode)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("SelectTracks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSave(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fmt") != "json3" {
+			t.Errorf("expected json3 to be requested, got %q", r.URL.RawQuery)
+		}
+		w.Write([]byte(sampleJSON3))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), Filename("Talk.mp4", "en", FormatSRT))
+	if filepath.Base(path) != "Talk.en.srt" {
+		t.Errorf("Filename = %q, want %q", filepath.Base(path), "Talk.en.srt")
+	}
+
+	track := extractor.CaptionTrack{LanguageCode: "en", URL: server.URL + "/api/timedtext?v=abc&lang=en"}
+	if err := NewClient().Save(context.Background(), track, path, FormatSRT); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read subtitles: %v", err)
+	}
+	if !strings.HasPrefix(string(content), "1\n00:00:01,200 --> 00:00:03,500\nHello world\n") {
+		t.Errorf("unexpected subtitle content %q", content)
+	}
+}
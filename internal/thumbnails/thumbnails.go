@@ -0,0 +1,247 @@
+package thumbnails
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/errors"
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+	_ "golang.org/x/image/webp"
+)
+
+// Image is a downloaded thumbnail
+type Image struct {
+	URL    string
+	Data   []byte
+	Format string // jpeg, png or webp
+	Width  int
+	Height int
+}
+
+// Ext returns the file extension for the image format
+func (img *Image) Ext() string {
+	if img.Format == "jpeg" {
+		return ".jpg"
+	}
+	return "." + img.Format
+}
+
+// MimeType returns the MIME type of the image
+func (img *Image) MimeType() string {
+	return "image/" + img.Format
+}
+
+// Size of maxresdefault, which exists for most HD uploads but is often
+// missing from the thumbnail list
+const maxresWidth, maxresHeight = 1280, 720
+
+// Candidates returns the thumbnails to try, largest first, with
+// maxresdefault added ahead of the list when it is not already in it
+func Candidates(thumbs []extractor.Thumbnail) []extractor.Thumbnail {
+	var candidates []extractor.Thumbnail
+	seen := make(map[string]bool)
+
+	add := func(thumb extractor.Thumbnail) {
+		key := strings.SplitN(thumb.URL, "?", 2)[0]
+		if !seen[key] {
+			seen[key] = true
+			candidates = append(candidates, thumb)
+		}
+	}
+
+	for _, thumb := range thumbs {
+		if maxres := maxresURL(thumb.URL); maxres != "" {
+			add(extractor.Thumbnail{URL: maxres, Width: maxresWidth, Height: maxresHeight})
+			break
+		}
+	}
+	for _, thumb := range thumbs {
+		add(thumb)
+	}
+	return candidates
+}
+
+// maxresURL derives the maxresdefault URL from another thumbnail of the same
+// video, e.g. https://i.ytimg.com/vi/ID/hqdefault.jpg
+func maxresURL(thumbURL string) string {
+	u, err := url.Parse(thumbURL)
+	if err != nil {
+		return ""
+	}
+	dir, _ := path.Split(u.Path)
+	parts := strings.Split(strings.Trim(dir, "/"), "/")
+	if len(parts) != 2 {
+		return ""
+	}
+
+	ext := ".jpg"
+	switch parts[0] {
+	case "vi":
+	case "vi_webp":
+		ext = ".webp"
+	default:
+		return ""
+	}
+
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: dir + "maxresdefault" + ext}).String()
+}
+
+// ID returns a short name for a thumbnail taken from its URL, such as
+// "hqdefault", used to tell apart files written by --write-all-thumbnails
+func ID(thumbURL string) string {
+	u, err := url.Parse(thumbURL)
+	if err != nil {
+		return ""
+	}
+	base := path.Base(u.Path)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// Filename returns the thumbnail filename for a media file, e.g. "Talk.mp4"
+// becomes "Talk.jpg", or "Talk.hqdefault.jpg" with an ID
+func Filename(mediaFilename, id, ext string) string {
+	base := mediaFilename
+	if i := strings.LastIndex(base, "."); i > 0 {
+		base = base[:i]
+	}
+	if id != "" {
+		base += "." + id
+	}
+	return base + ext
+}
+
+type Client struct {
+	client *http.Client
+}
+
+func NewClient() *Client {
+	return &Client{
+		client: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Fetch downloads a thumbnail and identifies its format
+func (c *Client) Fetch(ctx context.Context, thumbURL string) (*Image, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", thumbURL, nil)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to create request", err)
+	}
+	req.Header.Set("User-Agent", "red-goose/1.0")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to download thumbnail", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.NewHTTPError(resp)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.NewNetworkError("failed to download thumbnail", err)
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.NewValidationError("unsupported thumbnail image format", err)
+	}
+
+	return &Image{
+		URL:    thumbURL,
+		Data:   data,
+		Format: format,
+		Width:  config.Width,
+		Height: config.Height,
+	}, nil
+}
+
+// Best downloads the highest resolution thumbnail available, trying the
+// candidates in order
+func (c *Client) Best(ctx context.Context, thumbs []extractor.Thumbnail) (*Image, error) {
+	var lastErr error
+	for _, thumb := range Candidates(thumbs) {
+		img, err := c.Fetch(ctx, thumb.URL)
+		if err == nil {
+			return img, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, errors.NewExtractionError("no thumbnails available", nil)
+	}
+	return nil, lastErr
+}
+
+// All downloads every available thumbnail, skipping those that fail. An
+// error is returned only if none could be downloaded.
+func (c *Client) All(ctx context.Context, thumbs []extractor.Thumbnail) ([]*Image, error) {
+	var images []*Image
+	var lastErr error
+	for _, thumb := range Candidates(thumbs) {
+		img, err := c.Fetch(ctx, thumb.URL)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		images = append(images, img)
+	}
+	if len(images) == 0 {
+		if lastErr == nil {
+			lastErr = errors.NewExtractionError("no thumbnails available", nil)
+		}
+		return nil, lastErr
+	}
+	return images, nil
+}
+
+// ToJPEG converts a thumbnail to JPEG for players and taggers that do not
+// read WebP. JPEG images are returned unchanged.
+func ToJPEG(img *Image) (*Image, error) {
+	if img.Format == "jpeg" {
+		return img, nil
+	}
+
+	decoded, _, err := image.Decode(bytes.NewReader(img.Data))
+	if err != nil {
+		return nil, errors.NewValidationError("failed to decode thumbnail", err)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, decoded, &jpeg.Options{Quality: 95}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+
+	converted := *img
+	converted.Data = buf.Bytes()
+	converted.Format = "jpeg"
+	return &converted, nil
+}
+
+// Save writes a thumbnail to path
+func Save(path string, img *Image) error {
+	if err := os.WriteFile(path, img.Data, 0644); err != nil {
+		return errors.NewFileSystemError("failed to write thumbnail", err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package thumbnails
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MaVeN-13TTN/red_goose/internal/extractor"
+)
+
+func TestCandidates(t *testing.T) {
+	thumbs := []extractor.Thumbnail{
+		{URL: "https://i.ytimg.com/vi/abc/hqdefault.jpg?sqp=x", Width: 480, Height: 360},
+		{URL: "https://i.ytimg.com/vi/abc/default.jpg", Width: 120, Height: 90},
+	}
+
+	got := Candidates(thumbs)
+	want := []string{
+		"https://i.ytimg.com/vi/abc/maxresdefault.jpg",
+		"https://i.ytimg.com/vi/abc/hqdefault.jpg?sqp=x",
+		"https://i.ytimg.com/vi/abc/default.jpg",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Candidates() returned %d thumbnails, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i].URL != want[i] {
+			t.Errorf("candidate %d = %q, want %q", i, got[i].URL, want[i])
+		}
+	}
+
+	// A listed maxresdefault is not repeated
+	listed := append([]extractor.Thumbnail{{URL: "https://i.ytimg.com/vi/abc/maxresdefault.jpg"}}, thumbs...)
+	if got := Candidates(listed); len(got) != 3 {
+		t.Errorf("Candidates() with maxresdefault listed returned %d thumbnails, want 3", len(got))
+	}
+}
+
+func TestFilename(t *testing.T) {
+	tests := []struct {
+		media, id, ext string
+		want           string
+	}{
+		{"Talk.mp4", "", ".jpg", "Talk.jpg"},
+		{"Talk.mp4", "hqdefault", ".webp", "Talk.hqdefault.webp"},
+		{"001 - Song.m4a", "", ".jpg", "001 - Song.jpg"},
+	}
+	for _, tt := range tests {
+		if got := Filename(tt.media, tt.id, tt.ext); got != tt.want {
+			t.Errorf("Filename(%q, %q, %q) = %q, want %q", tt.media, tt.id, tt.ext, got, tt.want)
+		}
+	}
+
+	if got := ID("https://i.ytimg.com/vi_webp/abc/hq720.webp?rs=1"); got != "hq720" {
+		t.Errorf("ID() = %q, want hq720", got)
+	}
+}
+
+func testPNG(t *testing.T, w, h int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBest(t *testing.T) {
+	img := testPNG(t, 16, 9)
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = append(requested, r.URL.Path)
+		if strings.HasSuffix(r.URL.Path, "maxresdefault.jpg") {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(img)
+	}))
+	defer server.Close()
+
+	thumbs := []extractor.Thumbnail{{URL: server.URL + "/vi/abc/hqdefault.jpg", Width: 480, Height: 360}}
+	got, err := NewClient().Best(context.Background(), thumbs)
+	if err != nil {
+		t.Fatalf("Best() error = %v", err)
+	}
+
+	if got.Format != "png" || got.Width != 16 || got.Height != 9 {
+		t.Errorf("Best() = %s %dx%d, want png 16x9", got.Format, got.Width, got.Height)
+	}
+	if len(requested) != 2 || requested[0] != "/vi/abc/maxresdefault.jpg" {
+		t.Errorf("requested %v, want maxresdefault first", requested)
+	}
+}
+
+func TestToJPEG(t *testing.T) {
+	img := &Image{Data: testPNG(t, 8, 8), Format: "png", Width: 8, Height: 8}
+
+	converted, err := ToJPEG(img)
+	if err != nil {
+		t.Fatalf("ToJPEG() error = %v", err)
+	}
+	if converted.Format != "jpeg" || converted.Ext() != ".jpg" {
+		t.Errorf("converted format = %q, ext %q", converted.Format, converted.Ext())
+	}
+	if !bytes.HasPrefix(converted.Data, []byte{0xFF, 0xD8}) {
+		t.Error("converted data is not a JPEG")
+	}
+	if img.Format != "png" {
+		t.Error("ToJPEG() modified its input")
+	}
+}
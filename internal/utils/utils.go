@@ -1,68 +1,63 @@
 package utils
 
 import (
+    "context"
     "fmt"
+    "log/slog"
     "os"
     "os/signal"
     "path/filepath"
     "runtime/debug"
+    "sync"
     "syscall"
     "time"
 )
 
-// Logger provides a simple logging interface
-type Logger struct {
-    Verbose bool
-}
-
-// NewLogger creates a new logger
-func NewLogger(verbose bool) *Logger {
-    return &Logger{
-        Verbose: verbose,
-    }
-}
-
-// Info logs an informational message
-func (l *Logger) Info(format string, args ...interface{}) {
-    fmt.Printf(format+"\n", args...)
-}
+// exitInterrupted is the exit code of a process stopped by a signal, as
+// shells report SIGINT
+const exitInterrupted = 130
 
-// Debug logs a debug message if verbose mode is enabled
-func (l *Logger) Debug(format string, args ...interface{}) {
-    if l.Verbose {
-        fmt.Printf("[DEBUG] "+format+"\n", args...)
+// SignalContext returns a context that is canceled on the first SIGINT or
+// SIGTERM, letting long-running writers finish and close their files instead
+// of the process exiting underneath them. A second signal before the
+// returned function is called exits immediately.
+func SignalContext(parent context.Context) (context.Context, context.CancelFunc) {
+    ctx, cancel := context.WithCancel(parent)
+    c := make(chan os.Signal, 2)
+    signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+    done := make(chan struct{})
+    var once sync.Once
+    stop := func() {
+        once.Do(func() {
+            signal.Stop(c)
+            close(done)
+        })
+        cancel()
     }
-}
-
-// Error logs an error message
-func (l *Logger) Error(format string, args ...interface{}) {
-    fmt.Fprintf(os.Stderr, "ERROR: "+format+"\n", args...)
-}
 
-// Fatal logs an error message and exits
-func (l *Logger) Fatal(format string, args ...interface{}) {
-    l.Error(format, args...)
-    os.Exit(1)
-}
-
-// SetupCleanupHandler sets up a handler for SIGINT and SIGTERM signals
-// to perform cleanup before exiting
-func SetupCleanupHandler(cleanup func()) {
-    c := make(chan os.Signal, 1)
-    signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-    
     go func() {
-        <-c
-        fmt.Println("\nReceived termination signal. Cleaning up...")
-        cleanup()
-        os.Exit(1)
+        select {
+        case <-c:
+            fmt.Fprintln(os.Stderr, "\nReceived termination signal. Finishing up (press Ctrl-C again to force quit)...")
+            cancel()
+        case <-ctx.Done():
+            stop()
+            return
+        }
+        select {
+        case <-c:
+            os.Exit(exitInterrupted)
+        case <-done:
+        }
     }()
+
+    return ctx, stop
 }
 
 // RecoverFromPanic recovers from panics and logs the error
-func RecoverFromPanic(logger *Logger) {
+func RecoverFromPanic(logger *slog.Logger) {
     if r := recover(); r != nil {
-        logger.Error("Recovered from panic: %v\n%s", r, debug.Stack())
+        logger.Error("recovered from panic", "panic", r, "stack", string(debug.Stack()))
     }
 }
 
@@ -94,20 +89,16 @@ func DirExists(path string) bool {
     return info.IsDir()
 }
 
-// RetryOperation retries an operation with exponential backoff
-func RetryOperation(operation func() error, maxRetries int, initialDelay time.Duration) error {
-    var err error
-    delay := initialDelay
-    
-    for i := 0; i < maxRetries; i++ {
-        err = operation()
-        if err == nil {
-            return nil
-        }
-        
-        time.Sleep(delay)
-        delay *= 2 // Exponential backoff
+// FormatBytes writes a size in binary units, e.g. "1.5 MiB"
+func FormatBytes(n int64) string {
+    const unit = 1024
+    if n < unit {
+        return fmt.Sprintf("%d B", n)
     }
-    
-    return fmt.Errorf("operation failed after %d retries: %w", maxRetries, err)
-}
\ No newline at end of file
+    div, exp := int64(unit), 0
+    for m := n / unit; m >= unit; m /= unit {
+        div *= unit
+        exp++
+    }
+    return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -4,7 +4,9 @@ import (
     "fmt"
     "net/url"
     "regexp"
+    "strconv"
     "strings"
+    "time"
 )
 
 type VideoType int
@@ -21,6 +23,8 @@ type VideoInfo struct {
     Type     VideoType
     Title    string
     Duration string
+    // StartTime is the position a t= parameter links to, or zero
+    StartTime time.Duration
 }
 
 type PlaylistInfo struct {
@@ -33,6 +37,8 @@ type PlaylistInfo struct {
 var (
     videoIDRegex    = regexp.MustCompile(`(?:youtube\.com\/watch\?v=|youtu\.be\/)([a-zA-Z0-9_-]{11})`)
     playlistIDRegex = regexp.MustCompile(`[?&]list=([a-zA-Z0-9_-]+)`)
+    channelIDRegex  = regexp.MustCompile(`youtube\.com\/channel\/(UC[a-zA-Z0-9_-]{22})`)
+    channelRegex    = regexp.MustCompile(`youtube\.com\/(?:channel\/UC[a-zA-Z0-9_-]{22}|@[^\/?#]+|c\/[^\/?#]+|user\/[^\/?#]+)`)
 )
 
 func ParseURL(inputURL string) (*VideoInfo, error) {
@@ -68,9 +74,34 @@ func ParseURL(inputURL string) (*VideoInfo, error) {
         return nil, fmt.Errorf("could not extract video ID from URL")
     }
     
+    // Links to a moment carry t= in the query or, on older links, the fragment
+    t := parsedURL.Query().Get("t")
+    if t == "" {
+        if fragment, err := url.ParseQuery(parsedURL.Fragment); err == nil {
+            t = fragment.Get("t")
+        }
+    }
+    video.StartTime = parseStartTime(t)
+    
     return video, nil
 }
 
+// parseStartTime parses a t= value such as "90", "90s" or "1h2m3s",
+// returning zero for anything else
+func parseStartTime(t string) time.Duration {
+    if t == "" {
+        return 0
+    }
+    if seconds, err := strconv.Atoi(t); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    d, err := time.ParseDuration(t)
+    if err != nil || d < 0 || strings.ContainsAny(t, ".µnu") {
+        return 0
+    }
+    return d
+}
+
 func IsPlaylistURL(url string) bool {
     return playlistIDRegex.MatchString(url)
 }
@@ -89,4 +120,34 @@ func ExtractPlaylistID(url string) string {
         return matches[1]
     }
     return ""
-}
\ No newline at end of file
+}
+
+// WatchURL returns the canonical watch page URL of a video
+func WatchURL(videoID string) string {
+    return "https://www.youtube.com/watch?v=" + videoID
+}
+
+// IsChannelURL reports whether url links to a channel, by ID, handle or
+// custom name
+func IsChannelURL(url string) bool {
+    return channelRegex.MatchString(url) && !IsPlaylistURL(url)
+}
+
+// ExtractChannelID returns the channel ID of a /channel/ URL. Handles and
+// custom names have to be looked up.
+func ExtractChannelID(url string) string {
+    matches := channelIDRegex.FindStringSubmatch(url)
+    if len(matches) > 1 {
+        return matches[1]
+    }
+    return ""
+}
+
+// UploadsPlaylistID returns the ID of the playlist holding every upload of
+// a channel
+func UploadsPlaylistID(channelID string) string {
+    if !strings.HasPrefix(channelID, "UC") {
+        return ""
+    }
+    return "UU" + channelID[2:]
+}
@@ -2,6 +2,7 @@ package youtube
 
 import (
     "testing"
+    "time"
 )
 
 func TestParseURL(t *testing.T) {
@@ -47,4 +48,82 @@ func TestParseURL(t *testing.T) {
             }
         })
     }
-}
\ No newline at end of file
+}
+
+func TestParseURLStartTime(t *testing.T) {
+    tests := []struct {
+        name string
+        url  string
+        want time.Duration
+    }{
+        {
+            name: "No start time",
+            url:  "https://www.youtube.com/watch?v=dQw4w9WgXcQ",
+        },
+        {
+            name: "Seconds",
+            url:  "https://youtu.be/dQw4w9WgXcQ?t=90",
+            want: 90 * time.Second,
+        },
+        {
+            name: "Seconds with unit",
+            url:  "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=90s",
+            want: 90 * time.Second,
+        },
+        {
+            name: "Hours, minutes and seconds",
+            url:  "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1h2m3s",
+            want: time.Hour + 2*time.Minute + 3*time.Second,
+        },
+        {
+            name: "Fragment",
+            url:  "https://www.youtube.com/watch?v=dQw4w9WgXcQ#t=1m30s",
+            want: 90 * time.Second,
+        },
+        {
+            name: "Invalid value",
+            url:  "https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=soon",
+        },
+    }
+    
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            video, err := ParseURL(tt.url)
+            if err != nil {
+                t.Fatalf("Unexpected error: %v", err)
+            }
+            if video.StartTime != tt.want {
+                t.Errorf("Expected start time %s, got %s", tt.want, video.StartTime)
+            }
+        })
+    }
+}
+
+func TestChannelURLs(t *testing.T) {
+    tests := []struct {
+        url       string
+        isChannel bool
+        id        string
+    }{
+        {"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw", true, "UCuAXFkgsw1L7xaCfnd5JJOw"},
+        {"https://www.youtube.com/channel/UCuAXFkgsw1L7xaCfnd5JJOw/videos", true, "UCuAXFkgsw1L7xaCfnd5JJOw"},
+        {"https://www.youtube.com/@SomeCreator", true, ""},
+        {"https://youtube.com/c/SomeName/videos", true, ""},
+        {"https://www.youtube.com/user/oldname", true, ""},
+        {"https://www.youtube.com/watch?v=dQw4w9WgXcQ", false, ""},
+        {"https://www.youtube.com/playlist?list=PLxxx", false, ""},
+    }
+
+    for _, tt := range tests {
+        if got := IsChannelURL(tt.url); got != tt.isChannel {
+            t.Errorf("IsChannelURL(%q) = %v, want %v", tt.url, got, tt.isChannel)
+        }
+        if got := ExtractChannelID(tt.url); got != tt.id {
+            t.Errorf("ExtractChannelID(%q) = %q, want %q", tt.url, got, tt.id)
+        }
+    }
+
+    if got := UploadsPlaylistID("UCuAXFkgsw1L7xaCfnd5JJOw"); got != "UUuAXFkgsw1L7xaCfnd5JJOw" {
+        t.Errorf("UploadsPlaylistID = %q", got)
+    }
+}